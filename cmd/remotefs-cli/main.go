@@ -2,22 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"example.com/s3rofs/pkg/client"
 	"example.com/s3rofs/pkg/objectstore"
 	"example.com/s3rofs/pkg/remotefs"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 // main wires CLI subcommands to the RemoteFS implementation so that users can
@@ -28,40 +38,113 @@ func main() {
 		prefix    = flag.String("prefix", "", "virtual root prefix")
 		region    = flag.String("region", "us-east-1", "S3 region")
 		endpoint  = flag.String("endpoint", "", "optional S3-compatible endpoint")
+		pathStyle = flag.Bool("path-style", false, "use path-style bucket addressing (required by MinIO and some S3-compatible endpoints)")
 		accessKey = flag.String("access-key", "", "S3 access key")
 		secretKey = flag.String("secret-key", "", "S3 secret key")
+		roleARN   = flag.String("assume-role-arn", "", "IAM role to assume on top of the base credentials")
+		extID     = flag.String("external-id", "", "external ID passed when assuming -assume-role-arn")
 		localRoot = flag.String("local-root", "/remote", "virtual local path that is considered remote backed")
 		cacheDir  = flag.String("cache-dir", "", "directory for the on-disk cache (defaults to temp dir)")
 		cacheSize = flag.Int64("cache-size", 512*1024*1024, "max cache size in bytes")
-		timeout   = flag.Duration("timeout", 30*time.Second, "RPC timeout")
-		socket    = flag.String("socket", "", "Unix socket path for the serve command")
-		listen    = flag.String("listen", "", "TCP listen address for the serve command")
+		fullKeys  = flag.Bool("expose-full-key", false, "include the fully qualified S3 key in not-found errors")
+		noCache   = flag.Bool("no-cache", false, "stream reads straight from the store without the disk cache (handles are not seekable)")
+		delimiter = flag.String("delimiter", "/", "separator used to synthesize directories from keys (empty for flat listings)")
+		mtimeMeta = flag.String("mtime-metadata", "", "user metadata header, such as x-amz-meta-mtime, whose value overrides LastModified")
+		partSize  = flag.Int64("part-size", objectstore.DefaultPartSize, "multipart upload part size in bytes")
+		partConc  = flag.Int("upload-concurrency", objectstore.DefaultUploadConcurrency, "multipart upload parts sent in parallel")
+		bufSize   = flag.Int("download-buffer", objectstore.DefaultDownloadBufferSize, "buffer size in bytes used to copy object bodies")
+		timeout   = flag.Duration("timeout", 30*time.Second, "timeout for one-shot commands and for startup of serve and put")
+		putTO     = flag.Duration("put-timeout", 0, "timeout for the put upload itself (0 for none; SIGINT or SIGTERM cancel it)")
+		connTO    = flag.Duration("connect-timeout", 0, "dial and TLS handshake timeout for object store connections (0 for SDK defaults)")
+		socket    = flag.String("socket", "", "Unix socket path for the serve command, or of the daemon for verify-cache")
+		listen    = flag.String("listen", "", "TCP listen address for the serve command, or of the daemon for verify-cache")
+		ctype     = flag.String("content-type", "", "Content-Type for put (detected from the source file name when empty)")
+		prune     = flag.Bool("prune", false, "evict stale and missing entries found by verify-cache")
+		create    = flag.Bool("create-only", false, "make put fail instead of replacing an existing object")
+		verify    = flag.Bool("checksum", false, "have S3 verify the SHA-256 of every put request and print the digest")
+		condWrite = flag.Bool("conditional-writes", true, "enforce -create-only with If-None-Match; disable for endpoints that ignore it to check with a HEAD first")
 	)
 	flag.Parse()
+	if flag.NArg() < 1 {
+		log.Fatal("expected command: stat|ls|cat|checksum|put|serve|verify-cache|config")
+	}
+	if flag.Arg(0) == "verify-cache" {
+		// The cache index lives in the daemon, so ask it to do the check.
+		addr := *socket
+		if addr == "" {
+			addr = *listen
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		if err := verifyCache(ctx, addr, *prune); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if *bucket == "" {
 		log.Fatal("bucket is required")
 	}
-	if flag.NArg() < 1 {
-		log.Fatal("expected command: stat|ls|cat")
-	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	ctx, cancel := commandContext(flag.Arg(0), *timeout, *putTO)
 	defer cancel()
 
-	awsCfg, err := loadAWSConfig(ctx, *region, *endpoint, *accessKey, *secretKey)
+	setupCtx, cancelSetup := context.WithTimeout(ctx, *timeout)
+	defer cancelSetup()
+	settings := awsSettings{
+		region:     *region,
+		endpoint:   *endpoint,
+		accessKey:  *accessKey,
+		secretKey:  *secretKey,
+		roleARN:    *roleARN,
+		externalID: *extID,
+
+		connectTimeout: *connTO,
+	}
+	awsCfg, err := loadAWSConfig(setupCtx, settings)
 	if err != nil {
 		log.Fatalf("load AWS config: %v", err)
 	}
-	client := s3.NewFromConfig(awsCfg)
-	store := objectstore.NewS3Store(client, *bucket, *prefix)
+	client := s3.NewFromConfig(awsCfg, objectstore.UsePathStyle(*pathStyle))
+	store := objectstore.NewS3Store(client, *bucket, *prefix,
+		objectstore.WithDownloadBufferSize(*bufSize),
+		objectstore.WithDelimiter(*delimiter),
+		objectstore.WithModTimeMetadata(*mtimeMeta),
+		objectstore.WithMultipartUpload(0, *partSize, *partConc),
+		objectstore.WithConditionalWrites(*condWrite),
+		objectstore.WithEndpoint(*endpoint),
+	)
+	if flag.Arg(0) == "config" {
+		// Runs before remotefs.New so it does not take the cache directory
+		// lock away from a daemon that is being debugged.
+		dir := *cacheDir
+		if dir == "" {
+			dir = remotefs.DefaultCacheDir()
+		}
+		resolved := resolvedConfig{
+			bucket:    *bucket,
+			prefix:    *prefix,
+			pathStyle: *pathStyle,
+			localRoot: *localRoot,
+			cacheDir:  dir,
+			cacheSize: *cacheSize,
+			noCache:   *noCache,
+		}
+		if err := printConfig(ctx, os.Stdout, settings, resolved, awsCfg, store); err != nil {
+			fatal(err)
+		}
+		return
+	}
 	fs, err := remotefs.New(store, remotefs.Config{
-		LocalRoot: *localRoot,
-		CacheDir:  *cacheDir,
-		CacheSize: *cacheSize,
+		LocalRoot:     *localRoot,
+		CacheDir:      *cacheDir,
+		CacheSize:     *cacheSize,
+		NoCache:       *noCache,
+		ExposeFullKey: *fullKeys,
 	})
 	if err != nil {
 		log.Fatalf("init RemoteFS: %v", err)
 	}
+	defer fs.Close()
 
 	switch flag.Arg(0) {
 	case "stat":
@@ -70,7 +153,7 @@ func main() {
 		}
 		meta, err := fs.Stat(ctx, flag.Arg(1))
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		fmt.Printf("%s\t%d bytes\t%s\tetag=%s\n", meta.Path, meta.Size, meta.LastModified.Format(time.RFC3339), meta.ETag)
 	case "ls":
@@ -80,7 +163,7 @@ func main() {
 		}
 		items, err := fs.ReadDir(ctx, target)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		for _, item := range items {
 			if item.IsDir {
@@ -95,20 +178,64 @@ func main() {
 		}
 		reader, err := fs.ReadFile(ctx, flag.Arg(1))
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		defer reader.Close()
 		if _, err := io.Copy(os.Stdout, reader); err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	case "checksum":
+		if flag.NArg() < 2 {
+			log.Fatal("checksum needs a path")
 		}
+		algo := "sha256"
+		if flag.NArg() > 2 {
+			algo = flag.Arg(2)
+		}
+		sum, err := fs.Checksum(ctx, flag.Arg(1), algo)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("%s  %s\n", sum, flag.Arg(1))
+	case "put":
+		if flag.NArg() < 2 {
+			log.Fatal("put needs a destination path")
+		}
+		src := io.Reader(os.Stdin)
+		size := int64(-1)
+		opts := remotefs.WriteOptions{ContentType: *ctype, CreateOnly: *create}
+		if flag.NArg() > 2 && flag.Arg(2) != "-" {
+			if opts.ContentType == "" {
+				opts.ContentType = mime.TypeByExtension(filepath.Ext(flag.Arg(2)))
+			}
+			f, err := os.Open(flag.Arg(2))
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				fatal(err)
+			}
+			src, size = f, info.Size()
+		}
+		if !*verify {
+			if err := fs.WriteFileOpts(ctx, flag.Arg(1), src, size, opts); err != nil {
+				fatal(err)
+			}
+			break
+		}
+		sum, err := fs.WriteFileChecksum(ctx, flag.Arg(1), src, size, opts)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("%s  %s\n", sum, flag.Arg(1))
 	case "serve":
 		ipc, err := remotefs.NewIPCServer(fs)
 		if err != nil {
 			log.Fatalf("init IPC server: %v", err)
 		}
-		serveCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-		defer stop()
-		if err := ipc.Serve(serveCtx, *socket, *listen); err != nil && err != context.Canceled {
+		if err := ipc.Serve(ctx, *socket, *listen); err != nil && err != context.Canceled {
 			log.Fatalf("serve: %v", err)
 		}
 	default:
@@ -116,23 +243,219 @@ func main() {
 	}
 }
 
+// Exit statuses for failures a script can act on. Anything else exits 1.
+const (
+	exitNotFound       = 2
+	exitBucketNotFound = 3
+	exitWrongRegion    = 4
+	exitAccessDenied   = 5
+)
+
+// fatal logs err with a hint at the flag to fix, when there is one, and exits
+// with the status exitStatus picks for it.
+func fatal(err error) {
+	msg, code := exitStatus(err)
+	log.Print(msg)
+	os.Exit(code)
+}
+
+// exitStatus maps err to the message and exit status fatal reports.
+func exitStatus(err error) (string, int) {
+	var wrongRegion objectstore.WrongRegionError
+	switch {
+	case objectstore.IsBucketNotFound(err):
+		return fmt.Sprintf("%v, check -bucket", err), exitBucketNotFound
+	case errors.As(err, &wrongRegion) && wrongRegion.Region != "":
+		return fmt.Sprintf("%v, rerun with -region %s", err, wrongRegion.Region), exitWrongRegion
+	case errors.As(err, &wrongRegion):
+		return fmt.Sprintf("%v, check -region", err), exitWrongRegion
+	case objectstore.IsAccessDenied(err):
+		return fmt.Sprintf("%v, check credentials and the bucket policy", err), exitAccessDenied
+	case remotefs.IsNotFound(err), objectstore.IsNotFound(err):
+		return err.Error(), exitNotFound
+	}
+	return err.Error(), 1
+}
+
+// verifyCache runs /cache/verify on the daemon at addr and prints the stale
+// and missing entries followed by a summary.
+func verifyCache(ctx context.Context, addr string, prune bool) error {
+	if addr == "" {
+		return fmt.Errorf("verify-cache needs the daemon address in -socket or -listen")
+	}
+	c, err := client.New(addr)
+	if err != nil {
+		return err
+	}
+	report, err := c.VerifyCache(ctx, prune)
+	if err != nil {
+		return err
+	}
+	for _, m := range report.Stale {
+		fmt.Printf("stale\t%s\tcached %d bytes %s\tremote %d bytes %s\n", m.Path, m.CachedSize, m.CachedETag, m.RemoteSize, m.RemoteETag)
+	}
+	for _, p := range report.Missing {
+		fmt.Printf("missing\t%s\n", p)
+	}
+	fmt.Printf("matched %d, stale %d, missing %d, pruned %d\n", report.Matched, len(report.Stale), len(report.Missing), report.Pruned)
+	return nil
+}
+
+// resolvedConfig carries the non-AWS settings reported by the config command.
+type resolvedConfig struct {
+	bucket    string
+	prefix    string
+	pathStyle bool
+	localRoot string
+	cacheDir  string
+	cacheSize int64
+	noCache   bool
+}
+
+// printConfig writes the effective settings to w with secrets redacted, then
+// checks that credentials resolve and that the bucket answers a Ping. It
+// returns an error when either check fails, after printing every line.
+func printConfig(ctx context.Context, w io.Writer, settings awsSettings, cfg resolvedConfig, awsCfg aws.Config, store objectstore.ObjectStore) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(name string, value interface{}) {
+		fmt.Fprintf(tw, "%s:\t%v\n", name, value)
+	}
+	orNone := func(v string) string {
+		if v == "" {
+			return "(none)"
+		}
+		return v
+	}
+	row("bucket", cfg.bucket)
+	row("prefix", orNone(cfg.prefix))
+	row("region", awsCfg.Region)
+	row("endpoint", orNone(settings.endpoint))
+	row("path-style", cfg.pathStyle)
+	if settings.accessKey != "" && settings.secretKey != "" {
+		row("access-key", redact(settings.accessKey))
+		row("secret-key", "(set, redacted)")
+	} else {
+		row("access-key", "(default credential chain)")
+	}
+	row("assume-role-arn", orNone(settings.roleARN))
+	row("local-root", cfg.localRoot)
+	if cfg.noCache {
+		row("cache-dir", "(disabled by -no-cache)")
+	} else {
+		row("cache-dir", cfg.cacheDir)
+		row("cache-size", fmt.Sprintf("%d bytes", cfg.cacheSize))
+	}
+
+	var failed error
+	var (
+		creds aws.Credentials
+		err   = errors.New("no credential provider configured")
+	)
+	if awsCfg.Credentials != nil {
+		creds, err = awsCfg.Credentials.Retrieve(ctx)
+	}
+	if err != nil {
+		row("credentials", fmt.Sprintf("FAILED: %v", err))
+		failed = fmt.Errorf("credentials did not resolve")
+	} else {
+		row("credentials", fmt.Sprintf("ok (source %s, key %s)", creds.Source, redact(creds.AccessKeyID)))
+		if err := store.Ping(ctx); err != nil {
+			row("bucket access", fmt.Sprintf("FAILED: %v", err))
+			failed = fmt.Errorf("bucket %s is not accessible", cfg.bucket)
+		} else {
+			row("bucket access", "ok")
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return failed
+}
+
+// redact keeps the first and last four characters of an access key.
+func redact(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}
+
+// commandContext returns the context a subcommand runs under. One-shot
+// commands are bounded by timeout; serve runs until SIGINT or SIGTERM, since
+// its requests carry their own contexts. put streams an arbitrarily large
+// upload, so it also runs until a signal unless putTimeout is set.
+func commandContext(cmd string, timeout, putTimeout time.Duration) (context.Context, context.CancelFunc) {
+	switch cmd {
+	case "serve":
+		return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	case "put":
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		if putTimeout <= 0 {
+			return ctx, stop
+		}
+		ctx, cancel := context.WithTimeout(ctx, putTimeout)
+		return ctx, func() {
+			cancel()
+			stop()
+		}
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// awsSettings carries the flag values that shape the AWS configuration.
+type awsSettings struct {
+	region     string
+	endpoint   string
+	accessKey  string
+	secretKey  string
+	roleARN    string
+	externalID string
+	// connectTimeout bounds dialing and the TLS handshake. Zero keeps the
+	// SDK defaults.
+	connectTimeout time.Duration
+}
+
 // loadAWSConfig builds an AWS configuration that optionally overrides the
 // endpoint/credentials for S3-compatible vendors.
-func loadAWSConfig(ctx context.Context, region, endpoint, accessKey, secretKey string) (aws.Config, error) {
+func loadAWSConfig(ctx context.Context, settings awsSettings) (aws.Config, error) {
 	loaders := []func(*config.LoadOptions) error{
-		config.WithRegion(region),
+		config.WithRegion(settings.region),
 	}
-	if endpoint != "" {
+	if settings.endpoint != "" {
 		custom := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 			return aws.Endpoint{
-				URL:           endpoint,
+				URL:           settings.endpoint,
 				SigningRegion: region,
 			}, nil
 		})
 		loaders = append(loaders, config.WithEndpointResolverWithOptions(custom))
 	}
-	if accessKey != "" && secretKey != "" {
-		loaders = append(loaders, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")))
+	if settings.connectTimeout > 0 {
+		client := awshttp.NewBuildableClient().
+			WithDialerOptions(func(d *net.Dialer) {
+				d.Timeout = settings.connectTimeout
+			}).
+			WithTransportOptions(func(t *http.Transport) {
+				t.TLSHandshakeTimeout = settings.connectTimeout
+			})
+		loaders = append(loaders, config.WithHTTPClient(client))
+	}
+	if settings.accessKey != "" && settings.secretKey != "" {
+		loaders = append(loaders, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(settings.accessKey, settings.secretKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loaders...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if settings.roleARN != "" {
+		// The base credentials only sign the STS call; the cache refreshes
+		// the assumed role credentials before they expire.
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), settings.roleARN, func(o *stscreds.AssumeRoleOptions) {
+			if settings.externalID != "" {
+				o.ExternalID = aws.String(settings.externalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
 	}
-	return config.LoadDefaultConfig(ctx, loaders...)
+	return cfg, nil
 }
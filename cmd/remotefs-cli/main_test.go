@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"example.com/s3rofs/pkg/objectstore"
+	"example.com/s3rofs/pkg/remotefs"
+)
+
+func TestExitStatus(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		code int
+		hint string
+	}{
+		{err: fmt.Errorf("list: %w", objectstore.BucketNotFoundError{Bucket: "b"}), code: exitBucketNotFound, hint: "-bucket"},
+		{err: objectstore.WrongRegionError{Bucket: "b", Region: "eu-west-1"}, code: exitWrongRegion, hint: "-region eu-west-1"},
+		{err: objectstore.WrongRegionError{Bucket: "b"}, code: exitWrongRegion, hint: "check -region"},
+		{err: remotefs.NotFoundError{Path: "/data/a.txt"}, code: exitNotFound, hint: "/data/a.txt"},
+		{err: objectstore.NotFoundError{Key: "a.txt"}, code: exitNotFound, hint: "a.txt"},
+		{err: errors.New("boom"), code: 1, hint: "boom"},
+	} {
+		msg, code := exitStatus(tt.err)
+		if code != tt.code || !strings.Contains(msg, tt.hint) {
+			t.Fatalf("exitStatus(%v) = %q, %d, want %d with %q", tt.err, msg, code, tt.code, tt.hint)
+		}
+	}
+}
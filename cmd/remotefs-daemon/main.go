@@ -2,9 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -12,9 +20,12 @@ import (
 	"example.com/s3rofs/pkg/remotefs"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 // main launches the long-lived daemon that exposes the RemoteFS HTTP API over
@@ -23,16 +34,72 @@ func main() {
 	var (
 		bucket    = flag.String("bucket", "", "S3 bucket name (required)")
 		prefix    = flag.String("prefix", "", "virtual root prefix")
+		tierBkt   = flag.String("secondary-bucket", "", "writable bucket used as a faster tier: cache misses read copies from it and store new ones in it")
+		tierPfx   = flag.String("secondary-prefix", "", "key prefix inside -secondary-bucket")
+		tierRgn   = flag.String("secondary-region", "", "region of -secondary-bucket (defaults to -region)")
+		tierEP    = flag.String("secondary-endpoint", "", "S3-compatible endpoint of -secondary-bucket (defaults to -endpoint)")
 		region    = flag.String("region", "us-east-1", "S3 region")
 		endpoint  = flag.String("endpoint", "", "optional S3-compatible endpoint")
+		pathStyle = flag.Bool("path-style", false, "use path-style bucket addressing (required by MinIO and some S3-compatible endpoints)")
 		accessKey = flag.String("access-key", "", "S3 access key")
 		secretKey = flag.String("secret-key", "", "S3 secret key")
+		roleARN   = flag.String("assume-role-arn", "", "IAM role to assume on top of the base credentials")
+		extID     = flag.String("external-id", "", "external ID passed when assuming -assume-role-arn")
 		localRoot = flag.String("local-root", "/remote", "virtual local path exposed by the daemon")
 		cacheDir  = flag.String("cache-dir", "", "directory for the on-disk cache (defaults to temp dir)")
 		cacheSize = flag.Int64("cache-size", 512*1024*1024, "max cache size in bytes")
+		cacheMax  = flag.Int("cache-max-entries", 0, "max number of cached files (0 for unlimited)")
+		staging   = flag.String("cache-staging-dir", "", "directory for downloads in progress (defaults to the cache dir; another filesystem costs a copy per download)")
+		xformRule = flag.String("transform-rules", "", "comma separated pattern=name+name rules applying transforms (gunzip or the -grep-transform name) to matching store paths on read")
+		xformGrep = flag.String("grep-transform", "", "name=regexp registering a transform that keeps only matching lines")
+		xformKeep = flag.Bool("cache-transformed", false, "cache the output of -transform-rules instead of the raw objects")
+		quotas    = flag.String("cache-prefix-quotas", "", "comma separated dir=bytes byte quotas for top-level directories sharing the cache")
+		fullKeys  = flag.Bool("expose-full-key", false, "include the fully qualified S3 key in not-found errors")
+		streamDL  = flag.Bool("stream-downloads", false, "serve uncached files while they download instead of after (handles are not seekable)")
+		headTTL   = flag.Duration("head-cache-ttl", 0, "remember object metadata from HEAD requests for this long (0 disables)")
+		headMax   = flag.Int("head-cache-entries", 10000, "max HEAD results kept by -head-cache-ttl")
+		revalid   = flag.Bool("revalidate", false, "check cached files with an If-None-Match request (If-Modified-Since without a recorded ETag) before serving them")
+		noCache   = flag.Bool("no-cache", false, "stream reads straight from the store without the disk cache (handles are not seekable)")
+		delimiter = flag.String("delimiter", "/", "separator used to synthesize directories from keys (empty for flat listings)")
+		owners    = flag.Bool("fetch-owner", false, "include each object's owner in /ls entries (FetchOwner on ListObjectsV2)")
+		mtimeMeta = flag.String("mtime-metadata", "", "user metadata header, such as x-amz-meta-mtime, whose value overrides LastModified")
+		bufSize   = flag.Int("download-buffer", objectstore.DefaultDownloadBufferSize, "buffer size in bytes used to copy object bodies")
+		resumes   = flag.Int("download-resumes", objectstore.DefaultDownloadResumes, "times an interrupted object download is resumed from where it stopped")
 		timeout   = flag.Duration("timeout", 30*time.Second, "object store RPC timeout")
+		connTO    = flag.Duration("connect-timeout", 0, "dial and TLS handshake timeout for object store connections (0 for SDK defaults)")
 		socket    = flag.String("socket", "", "path to a Unix domain socket for IPC (takes precedence over listen)")
 		listen    = flag.String("listen", "127.0.0.1:8484", "TCP listen address when -socket is empty")
+		maxDL     = flag.Int("max-downloads", 0, "maximum concurrent object downloads (0 for unlimited)")
+		maxQueue  = flag.Int("max-download-queue", 0, "downloads allowed to wait for a slot before /cat returns 503 (0 for unlimited)")
+		maxDir    = flag.Int("max-dir-entries", 0, "maximum entries returned by a single /ls (0 for unlimited)")
+		maxDepth  = flag.Int("max-path-depth", 0, "reject paths with more segments than this and stop walks above that depth (0 for 512, negative for no limit)")
+		slashRoot = flag.Bool("slash-root", false, "use POSIX-style / separated local paths regardless of the host OS")
+		winPaths  = flag.Bool("windows-paths", false, "treat backslashes in request paths as separators for Windows clients")
+		dirSlash  = flag.Bool("dir-trailing-slash", false, "report directory paths from /stat, /ls, and /tree with a trailing slash")
+		idle      = flag.Duration("stream-idle-timeout", 0, "abort /cat, /catmany, and /archive streams after the client accepts no data for this long (0 waits forever)")
+		flushN    = flag.Int64("cat-flush-bytes", 0, "flush /cat responses to the client after this many bytes (0 leaves buffering to net/http)")
+		flushIvl  = flag.Duration("cat-flush-interval", 0, "flush buffered /cat content to the client after this long (0 leaves buffering to net/http)")
+		writeTO   = flag.Duration("write-timeout", 0, "bound the time spent writing any other response (0 for no limit)")
+		ranges    = flag.Bool("range-requests", true, "honour Range requests on /cat and advertise Accept-Ranges for cached files")
+		dirTTL    = flag.Duration("dir-cache-ttl", 0, "cache each directory listing for this long on first /ls and skip the startup tree warm (0 disables)")
+		windowTTL = flag.Duration("listing-window-ttl", 0, "keep the sorted listing sliced by /ls offset and count for this long (0 uses the default, negative disables)")
+		warmPaths = flag.String("warm-prefixes", "", "comma separated local paths to warm concurrently at startup instead of the whole tree")
+		warmBest  = flag.Bool("warm-best-effort", false, "skip prefixes that fail to list during the startup warm instead of exiting")
+		markers   = flag.Bool("directory-markers", false, "detect directories from their \"dir/\" marker objects with one HEAD instead of a LIST (every directory must have one)")
+		skipMarks = flag.Bool("usage-skip-dir-markers", false, "leave zero-byte \"dir/\" marker objects out of /usage object counts")
+		warmIncr  = flag.Bool("warm-incremental", false, "publish metadata into the live cache as warms walk the tree instead of swapping in a full copy at the end")
+		metaMax   = flag.Int("meta-cache-entries", 0, "keep at most this many warmed metadata entries, least recently used evicted first (0 for unlimited)")
+		logDL     = flag.Bool("log-downloads", false, "log time-to-first-byte, duration, and size of every object download")
+		hideStore = flag.Bool("hide-store-location", false, "leave the bucket and prefix out of the / index")
+		inventory = flag.String("inventory", "", "S3 Inventory CSV report used to prime the metadata cache instead of listing the bucket")
+		tokenKey  = flag.String("download-token-key-file", "", "file holding the HMAC key that signs /token download links (enables /token)")
+		tokenReq  = flag.Bool("require-download-token", false, "refuse /cat requests without a valid ?token= link")
+		accessFmt = flag.String("access-log", "", "log every request to standard output in this format: clf, combined, or json (empty disables)")
+		accessOut = flag.String("access-log-file", "", "append the access log to this file instead of standard output")
+		hookURL   = flag.String("webhook-url", "", "POST a JSON event to this URL on cache evictions, misses, and download errors")
+		hookTypes = flag.String("webhook-events", "", "comma separated event types sent to -webhook-url: evict, miss, error (empty for all)")
+		hookQueue = flag.Int("webhook-queue", 0, "events buffered for a slow -webhook-url (0 for the default of 256)")
+		hookBlock = flag.Bool("webhook-block", false, "make requests wait for room when the webhook queue is full instead of dropping events")
 	)
 	flag.Parse()
 	if *bucket == "" {
@@ -42,27 +109,184 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
 	defer cancel()
 
-	awsCfg, err := loadAWSConfig(ctx, *region, *endpoint, *accessKey, *secretKey)
+	awsCfg, err := loadAWSConfig(ctx, awsSettings{
+		region:     *region,
+		endpoint:   *endpoint,
+		accessKey:  *accessKey,
+		secretKey:  *secretKey,
+		roleARN:    *roleARN,
+		externalID: *extID,
+
+		connectTimeout: *connTO,
+	})
 	if err != nil {
 		log.Fatalf("load AWS config: %v", err)
 	}
-	client := s3.NewFromConfig(awsCfg)
-	store := objectstore.NewS3Store(client, *bucket, *prefix)
-	fs, err := remotefs.New(store, remotefs.Config{
-		LocalRoot: *localRoot,
-		CacheDir:  *cacheDir,
-		CacheSize: *cacheSize,
+	client := s3.NewFromConfig(awsCfg, objectstore.UsePathStyle(*pathStyle))
+	storeOpts := []objectstore.S3Option{
+		objectstore.WithDownloadBufferSize(*bufSize),
+		objectstore.WithDelimiter(*delimiter),
+		objectstore.WithModTimeMetadata(*mtimeMeta),
+		objectstore.WithDownloadResumes(*resumes),
+		objectstore.WithFetchOwner(*owners),
+	}
+	if *logDL {
+		storeOpts = append(storeOpts, objectstore.WithDownloadObserver(logDownload))
+	}
+	store := objectstore.NewS3Store(client, *bucket, *prefix, append(storeOpts, objectstore.WithEndpoint(*endpoint))...)
+	if err := store.Ping(ctx); err != nil {
+		var wrongRegion objectstore.WrongRegionError
+		switch {
+		case objectstore.IsBucketNotFound(err):
+			log.Fatalf("%v, check -bucket", err)
+		case errors.As(err, &wrongRegion) && wrongRegion.Region != "":
+			log.Fatalf("%v, restart with -region %s", err, wrongRegion.Region)
+		}
+		log.Fatalf("bucket %s is not accessible, check -bucket, -region, and credentials: %v", *bucket, err)
+	}
+	prefixQuotas, err := parseQuotas(*quotas)
+	if err != nil {
+		log.Fatalf("-cache-prefix-quotas: %v", err)
+	}
+	transforms, err := newTransforms(*xformGrep)
+	if err != nil {
+		log.Fatalf("-grep-transform: %v", err)
+	}
+	rules, err := parseTransformRules(*xformRule)
+	if err != nil {
+		log.Fatalf("-transform-rules: %v", err)
+	}
+	var backend objectstore.ObjectStore = store
+	if *headTTL > 0 {
+		backend = objectstore.WithHeadCache(store, *headTTL, *headMax)
+	}
+	var secondary objectstore.ObjectStore
+	if *tierBkt != "" {
+		tierClient := client
+		if *tierRgn != "" || *tierEP != "" {
+			tierCfg, err := loadAWSConfig(ctx, awsSettings{
+				region:     firstNonEmpty(*tierRgn, *region),
+				endpoint:   firstNonEmpty(*tierEP, *endpoint),
+				accessKey:  *accessKey,
+				secretKey:  *secretKey,
+				roleARN:    *roleARN,
+				externalID: *extID,
+
+				connectTimeout: *connTO,
+			})
+			if err != nil {
+				log.Fatalf("load AWS config for the secondary bucket: %v", err)
+			}
+			tierClient = s3.NewFromConfig(tierCfg, objectstore.UsePathStyle(*pathStyle))
+		}
+		secondary = objectstore.NewS3Store(tierClient, *tierBkt, *tierPfx,
+			append(storeOpts, objectstore.WithEndpoint(firstNonEmpty(*tierEP, *endpoint)))...)
+		if err := secondary.Ping(ctx); err != nil {
+			log.Fatalf("secondary bucket %s is not accessible: %v", *tierBkt, err)
+		}
+	}
+	var onEvent func(remotefs.Event)
+	if *hookURL != "" {
+		sink, err := newWebhookSink(*hookURL, *hookTypes, *hookQueue, *hookBlock)
+		if err != nil {
+			log.Fatalf("init webhook: %v", err)
+		}
+		defer sink.Close()
+		onEvent = sink.Send
+	}
+	fs, err := remotefs.New(backend, remotefs.Config{
+		LocalRoot:              *localRoot,
+		SlashRoot:              *slashRoot,
+		CacheDir:               *cacheDir,
+		CacheSize:              *cacheSize,
+		CacheMaxEntries:        *cacheMax,
+		CachePrefixQuotas:      prefixQuotas,
+		CacheStagingDir:        *staging,
+		NoCache:                *noCache,
+		StreamDownloads:        *streamDL,
+		RevalidateCache:        *revalid,
+		ExposeFullKey:          *fullKeys,
+		MaxConcurrentDownloads: *maxDL,
+		MaxQueuedDownloads:     *maxQueue,
+		MaxDirEntries:          *maxDir,
+		MaxPathDepth:           *maxDepth,
+		DirCacheTTL:            *dirTTL,
+		ListingWindowTTL:       *windowTTL,
+		WarmBestEffort:         *warmBest,
+		MetaCacheMaxEntries:    *metaMax,
+		WarmIncremental:        *warmIncr,
+		UsageSkipDirMarkers:    *skipMarks,
+		DirectoryMarkers:       *markers,
+		SecondaryStore:         secondary,
+		Transforms:             transforms,
+		TransformRules:         rules,
+		CacheTransformed:       *xformKeep,
+		OnEvent:                onEvent,
 	})
 	if err != nil {
 		log.Fatalf("init RemoteFS: %v", err)
 	}
+	defer fs.Close()
 	warmCtx, warmCancel := context.WithTimeout(context.Background(), *timeout)
 	defer warmCancel()
-	if err := fs.WarmMetadataCache(warmCtx); err != nil {
-		log.Fatalf("prime metadata cache: %v", err)
+	if *inventory != "" {
+		if err := loadInventory(warmCtx, fs, *inventory); err != nil {
+			log.Fatalf("load inventory: %v", err)
+		}
+	} else if prefixes := splitList(*warmPaths); len(prefixes) > 0 {
+		if err := skipWarmFailures(fs.WarmMetadataCachePrefixes(warmCtx, prefixes)); err != nil {
+			log.Fatalf("prime metadata cache: %v", err)
+		}
+	} else if *dirTTL <= 0 {
+		// With -dir-cache-ttl listings are cached as directories are visited
+		// instead.
+		if err := skipWarmFailures(fs.WarmMetadataCache(warmCtx)); err != nil {
+			log.Fatalf("prime metadata cache: %v", err)
+		}
+		status := fs.WarmStatus()
+		log.Printf("metadata warm cached %d entries from %d list requests", status.Entries, status.ListPages)
 	}
 
-	ipc, err := remotefs.NewIPCServer(fs)
+	ipcOpts := []remotefs.IPCOption{
+		remotefs.WithRangeRequests(*ranges),
+		remotefs.WithBackslashSeparators(*winPaths),
+		remotefs.WithDirectorySlash(*dirSlash),
+		remotefs.WithStreamIdleTimeout(*idle),
+		remotefs.WithCatFlush(*flushN, *flushIvl),
+		remotefs.WithWriteTimeout(*writeTO),
+	}
+	if !*hideStore {
+		ipcOpts = append(ipcOpts, remotefs.WithStoreLocation(*bucket, *prefix))
+	}
+	if *tokenKey != "" {
+		key, err := os.ReadFile(*tokenKey)
+		if err != nil {
+			log.Fatalf("read download token key: %v", err)
+		}
+		if key = []byte(strings.TrimSpace(string(key))); len(key) == 0 {
+			log.Fatalf("download token key file %s is empty", *tokenKey)
+		}
+		ipcOpts = append(ipcOpts, remotefs.WithDownloadTokens(key, *tokenReq))
+	} else if *tokenReq {
+		log.Fatal("-require-download-token needs -download-token-key-file")
+	}
+	if *accessFmt != "" {
+		format, err := remotefs.ParseAccessLogFormat(*accessFmt)
+		if err != nil {
+			log.Fatalf("-access-log: %v", err)
+		}
+		out := os.Stdout
+		if *accessOut != "" {
+			if out, err = os.OpenFile(*accessOut, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+				log.Fatalf("open access log: %v", err)
+			}
+			defer out.Close()
+		}
+		ipcOpts = append(ipcOpts, remotefs.WithAccessLog(out, format))
+	} else if *accessOut != "" {
+		log.Fatal("-access-log-file needs -access-log")
+	}
+	ipc, err := remotefs.NewIPCServer(fs, ipcOpts...)
 	if err != nil {
 		log.Fatalf("init IPC server: %v", err)
 	}
@@ -75,23 +299,193 @@ func main() {
 	}
 }
 
+// splitList parses a comma separated flag value, dropping empty items.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// firstNonEmpty returns v unless it is empty, and fallback otherwise.
+func firstNonEmpty(v, fallback string) string {
+	if v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newWebhookSink builds the event sink for -webhook-url, logging events it
+// fails to deliver.
+func newWebhookSink(url, types string, queue int, block bool) (*remotefs.WebhookSink, error) {
+	opts := remotefs.WebhookOptions{
+		QueueSize: queue,
+		Block:     block,
+		OnError: func(ev remotefs.Event, err error) {
+			log.Printf("webhook: %s %s: %v", ev.Type, ev.Path, err)
+		},
+	}
+	for _, name := range splitList(types) {
+		t, err := remotefs.ParseEventType(name)
+		if err != nil {
+			return nil, err
+		}
+		opts.Events = append(opts.Events, t)
+	}
+	return remotefs.NewWebhookSink(url, opts)
+}
+
+// parseQuotas parses dir=bytes pairs from a comma separated flag value.
+func parseQuotas(v string) (map[string]int64, error) {
+	items := splitList(v)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	quotas := make(map[string]int64, len(items))
+	for _, item := range items {
+		dir, size, ok := strings.Cut(item, "=")
+		dir = strings.Trim(strings.TrimSpace(dir), "/")
+		if !ok || dir == "" || strings.Contains(dir, "/") {
+			return nil, fmt.Errorf("invalid quota %q, want top-level-dir=bytes", item)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid quota size in %q", item)
+		}
+		quotas[dir] = n
+	}
+	return quotas, nil
+}
+
+// newTransforms returns the transforms the daemon offers: gunzip, and the
+// grep transform described by a name=regexp flag value, if any.
+func newTransforms(grep string) (map[string]remotefs.Transform, error) {
+	transforms := map[string]remotefs.Transform{"gunzip": remotefs.GunzipTransform}
+	if grep == "" {
+		return transforms, nil
+	}
+	name, expr, ok := strings.Cut(grep, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid grep transform %q, want name=regexp", grep)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	transforms[name] = remotefs.GrepTransform(re)
+	return transforms, nil
+}
+
+// parseTransformRules parses pattern=name+name rules from a comma separated
+// flag value.
+func parseTransformRules(v string) ([]remotefs.TransformRule, error) {
+	var rules []remotefs.TransformRule
+	for _, item := range splitList(v) {
+		pattern, names, ok := strings.Cut(item, "=")
+		pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+		if !ok || pattern == "" || strings.TrimSpace(names) == "" {
+			return nil, fmt.Errorf("invalid transform rule %q, want pattern=name+name", item)
+		}
+		rule := remotefs.TransformRule{Pattern: pattern}
+		for _, name := range strings.Split(names, "+") {
+			rule.Transforms = append(rule.Transforms, strings.TrimSpace(name))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// skipWarmFailures logs the prefixes a best-effort warm skipped and lets the
+// daemon start anyway. Other errors, including ones joined with skipped
+// prefixes, are returned unchanged.
+func skipWarmFailures(err error) error {
+	warmErr, ok := err.(*remotefs.WarmError)
+	if !ok {
+		return err
+	}
+	for _, f := range warmErr.Failures {
+		log.Printf("metadata warm skipped %s: %v", f.Path, f.Err)
+	}
+	return nil
+}
+
+// awsSettings carries the flag values that shape the AWS configuration.
+type awsSettings struct {
+	region     string
+	endpoint   string
+	accessKey  string
+	secretKey  string
+	roleARN    string
+	externalID string
+	// connectTimeout bounds dialing and the TLS handshake. Zero keeps the
+	// SDK defaults.
+	connectTimeout time.Duration
+}
+
 // loadAWSConfig mirrors the CLI helper so the daemon can talk to vanilla S3 or
 // compatible vendors.
-func loadAWSConfig(ctx context.Context, region, endpoint, accessKey, secretKey string) (aws.Config, error) {
+func loadAWSConfig(ctx context.Context, settings awsSettings) (aws.Config, error) {
 	loaders := []func(*config.LoadOptions) error{
-		config.WithRegion(region),
+		config.WithRegion(settings.region),
 	}
-	if endpoint != "" {
+	if settings.endpoint != "" {
 		custom := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 			return aws.Endpoint{
-				URL:           endpoint,
+				URL:           settings.endpoint,
 				SigningRegion: region,
 			}, nil
 		})
 		loaders = append(loaders, config.WithEndpointResolverWithOptions(custom))
 	}
-	if accessKey != "" && secretKey != "" {
-		loaders = append(loaders, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")))
+	if settings.connectTimeout > 0 {
+		client := awshttp.NewBuildableClient().
+			WithDialerOptions(func(d *net.Dialer) {
+				d.Timeout = settings.connectTimeout
+			}).
+			WithTransportOptions(func(t *http.Transport) {
+				t.TLSHandshakeTimeout = settings.connectTimeout
+			})
+		loaders = append(loaders, config.WithHTTPClient(client))
+	}
+	if settings.accessKey != "" && settings.secretKey != "" {
+		loaders = append(loaders, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(settings.accessKey, settings.secretKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loaders...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if settings.roleARN != "" {
+		// The base credentials only sign the STS call; the cache refreshes
+		// the assumed role credentials before they expire.
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), settings.roleARN, func(o *stscreds.AssumeRoleOptions) {
+			if settings.externalID != "" {
+				o.ExternalID = aws.String(settings.externalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return cfg, nil
+}
+
+// loadInventory primes the metadata cache from the inventory report at path.
+func loadInventory(ctx context.Context, fs *remotefs.FileSystem, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fs.LoadInventory(ctx, f, "csv")
+}
+
+// logDownload prints the timing of a finished object download.
+func logDownload(stats objectstore.DownloadStats) {
+	if stats.Err != nil {
+		log.Printf("download %s failed after %v: %v", stats.Key, stats.Total, stats.Err)
+		return
 	}
-	return config.LoadDefaultConfig(ctx, loaders...)
+	log.Printf("download %s: %d bytes, ttfb %v, total %v", stats.Key, stats.Bytes, stats.TTFB, stats.Total)
 }
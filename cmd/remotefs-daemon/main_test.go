@@ -3,15 +3,23 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"path"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"example.com/s3rofs/pkg/objectstore"
 	"example.com/s3rofs/pkg/remotefs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 )
 
 func TestIPCServerHandlers(t *testing.T) {
@@ -47,6 +55,14 @@ func TestIPCServerHandlers(t *testing.T) {
 	if meta.Path != "docs/report.txt" {
 		t.Fatalf("stat returned wrong path %q", meta.Path)
 	}
+	if meta.Nlink != 1 || meta.BlkSize != 4096 {
+		t.Fatalf("stat returned nlink=%d blksize=%d", meta.Nlink, meta.BlkSize)
+	}
+	// The fake store reports no modification time, which must stay stable
+	// instead of becoming the time of the request.
+	if !meta.LastModified.Equal(remotefs.UnknownModTime) {
+		t.Fatalf("stat of a file without a modtime returned %v, want %v", meta.LastModified, remotefs.UnknownModTime)
+	}
 
 	resp, err = http.Get(ts.URL + "/ls?path=/data/docs")
 	if err != nil {
@@ -63,6 +79,44 @@ func TestIPCServerHandlers(t *testing.T) {
 	if len(entries) != 1 || entries[0].Path != "docs/report.txt" {
 		t.Fatalf("unexpected ls entries: %+v", entries)
 	}
+	if entries[0].Inode == 0 || entries[0].Inode != meta.Inode {
+		t.Fatalf("ls inode %d does not match stat inode %d", entries[0].Inode, meta.Inode)
+	}
+	resp, err = http.Get(ts.URL + "/stat?path=/data/docs")
+	if err != nil {
+		t.Fatalf("stat request: %v", err)
+	}
+	var dir remotefs.POSIXEntry
+	err = json.NewDecoder(resp.Body).Decode(&dir)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode stat: %v", err)
+	}
+	if dir.Inode == 0 || dir.Inode == meta.Inode || dir.Inode%2 != 1 || meta.Inode%2 != 0 {
+		t.Fatalf("directory inode %d and file inode %d are not distinct kinds", dir.Inode, meta.Inode)
+	}
+
+	resp, err = http.Get(ts.URL + "/tree?path=/data&depth=1")
+	if err != nil {
+		t.Fatalf("tree request: %v", err)
+	}
+	var tree remotefs.TreeEntry
+	err = json.NewDecoder(resp.Body).Decode(&tree)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode tree: %v", err)
+	}
+	if len(tree.Children) != 1 || len(tree.Children[0].Children) != 1 || tree.Children[0].Children[0].Path != "docs/report.txt" {
+		t.Fatalf("tree = %+v, want docs/report.txt nested under docs", tree)
+	}
+	resp, err = http.Get(ts.URL + "/tree?path=/data&depth=-2")
+	if err != nil {
+		t.Fatalf("tree request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("tree with depth=-2 = %d, want 400", resp.StatusCode)
+	}
 
 	resp, err = http.Get(ts.URL + "/cat?path=/data/docs/report.txt")
 	if err != nil {
@@ -76,6 +130,137 @@ func TestIPCServerHandlers(t *testing.T) {
 	if string(body) != "hello world" {
 		t.Fatalf("cat returned %q", string(body))
 	}
+
+	for path, want := range map[string]bool{
+		"/data/docs/report.txt": true,
+		"/data/docs":            true,
+		"/data/missing.txt":     false,
+	} {
+		resp, err = http.Get(ts.URL + "/exists?path=" + path)
+		if err != nil {
+			t.Fatalf("exists request: %v", err)
+		}
+		var exists remotefs.ExistsResponse
+		err = json.NewDecoder(resp.Body).Decode(&exists)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode exists: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || exists.Exists != want {
+			t.Fatalf("exists %s = %d %+v, want %v", path, resp.StatusCode, exists, want)
+		}
+	}
+
+	resp, err = http.Post(ts.URL+"/catmany?skip-missing=true&separator=%0A", "application/json",
+		strings.NewReader(`["/data/docs/report.txt", "/data/missing.txt", "/data/docs/report.txt"]`))
+	if err != nil {
+		t.Fatalf("catmany request: %v", err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read catmany body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "hello world\nhello world" {
+		t.Fatalf("catmany = %d %q", resp.StatusCode, string(body))
+	}
+	resp, err = http.Post(ts.URL+"/catmany", "application/json", strings.NewReader(`["/data/missing.txt"]`))
+	if err != nil {
+		t.Fatalf("catmany request: %v", err)
+	}
+	var apiErr remotefs.ErrorResponse
+	err = json.NewDecoder(resp.Body).Decode(&apiErr)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode catmany error: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound || apiErr.Code != remotefs.ErrorCodeNotFound {
+		t.Fatalf("catmany with a missing path = %d %+v, want 404 not_found", resp.StatusCode, apiErr)
+	}
+
+	resp, err = http.Get(ts.URL + "/select?path=/data/docs/report.txt&query=SELECT+*+FROM+S3Object")
+	if err != nil {
+		t.Fatalf("select request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("select on a store without Select = %d, want 501", resp.StatusCode)
+	}
+}
+
+// rejectingSelectStore fails every Select like S3Store does for options it
+// does not know.
+type rejectingSelectStore struct {
+	*fakeStore
+}
+
+func (rejectingSelectStore) Select(ctx context.Context, key, expression string, opts objectstore.SelectOptions) (io.ReadCloser, error) {
+	return nil, objectstore.InvalidSelectError{Reason: fmt.Sprintf("unknown input format %q", opts.InputFormat)}
+}
+
+func TestIPCServerSelectInvalidOptions(t *testing.T) {
+	fs, err := remotefs.New(rejectingSelectStore{newFakeStore()}, remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/select?path=/data/docs/report.txt&query=SELECT+*+FROM+S3Object&input=xml")
+	if err != nil {
+		t.Fatalf("select request: %v", err)
+	}
+	var apiErr remotefs.ErrorResponse
+	err = json.NewDecoder(resp.Body).Decode(&apiErr)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode select error: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || apiErr.Code != remotefs.ErrorCodeInvalidRequest {
+		t.Fatalf("select with an unknown input = %d %+v, want 400 invalid_request", resp.StatusCode, apiErr)
+	}
+}
+
+func TestIPCServerInvalidBoolQuery(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	for _, query := range []string{
+		"/stat?path=/data/docs/report.txt&locks=maybe",
+		"/ls?path=/data/docs&dirsfirst=maybe",
+		"/ls?path=/data/docs&hidden=maybe",
+		"/ls?path=/data/docs&recursive=maybe",
+		"/cat?path=/data/docs/report.txt&nocache=maybe",
+	} {
+		resp, err := http.Get(ts.URL + query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var apiErr remotefs.ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&apiErr)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: decode error: %v", query, err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || apiErr.Code != remotefs.ErrorCodeInvalidRequest {
+			t.Fatalf("%s = %d %+v, want 400 invalid_request", query, resp.StatusCode, apiErr)
+		}
+		if !strings.Contains(apiErr.Error, `"maybe", want true or false`) {
+			t.Fatalf("%s error = %q, want the accepted values", query, apiErr.Error)
+		}
+	}
 }
 
 type fakeStore struct {
@@ -167,3 +352,921 @@ func (f *fakeStore) Download(ctx context.Context, key string, dst io.WriterAt) e
 	}
 	return nil
 }
+
+func (f *fakeStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func TestIPCServerBackslashSeparators(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs, remotefs.WithBackslashSeparators(true))
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{query: `path=\data\docs\report.txt`, ok: true},
+		{query: `path=/data\docs/report.txt`, ok: true},
+		{query: `path=\data\\docs\.\report.txt`, ok: true},
+		{query: `path=docs\report.txt&rel=true`, ok: true},
+		{query: `path=\data\..\etc\passwd`, ok: false},
+		{query: `path=..\etc&rel=true`, ok: false},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + "/stat?" + strings.ReplaceAll(tt.query, `\`, "%5C"))
+		if err != nil {
+			t.Fatalf("stat %s: %v", tt.query, err)
+		}
+		var entry remotefs.POSIXEntry
+		_ = json.NewDecoder(resp.Body).Decode(&entry)
+		resp.Body.Close()
+		if (resp.StatusCode == http.StatusOK) != tt.ok {
+			t.Fatalf("stat %s = %d, want success %v", tt.query, resp.StatusCode, tt.ok)
+		}
+		if tt.ok && entry.Path != "docs/report.txt" {
+			t.Fatalf("stat %s resolved to %q", tt.query, entry.Path)
+		}
+	}
+}
+
+func TestIPCServerDirectorySlash(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs, remotefs.WithDirectorySlash(true))
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	for _, query := range []string{"path=/data/docs", "path=/data/docs/", "path=docs&rel=true", "path=docs/&rel=true"} {
+		resp, err := http.Get(ts.URL + "/stat?" + query)
+		if err != nil {
+			t.Fatalf("stat %s: %v", query, err)
+		}
+		var entry remotefs.POSIXEntry
+		_ = json.NewDecoder(resp.Body).Decode(&entry)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || entry.Path != "docs/" || !entry.IsDir {
+			t.Fatalf("stat %s = %d %+v, want directory docs/", query, resp.StatusCode, entry)
+		}
+
+		resp, err = http.Get(ts.URL + "/ls?" + query)
+		if err != nil {
+			t.Fatalf("ls %s: %v", query, err)
+		}
+		var entries []remotefs.POSIXEntry
+		_ = json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || len(entries) == 0 {
+			t.Fatalf("ls %s = %d with %d entries", query, resp.StatusCode, len(entries))
+		}
+		for _, e := range entries {
+			if e.IsDir != strings.HasSuffix(e.Path, "/") {
+				t.Fatalf("ls %s listed %q with IsDir %v", query, e.Path, e.IsDir)
+			}
+		}
+	}
+}
+
+// lineWriter hands every write to a channel, so tests can wait for log lines
+// written after the response was sent.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestIPCServerAccessLog(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	get := func(format remotefs.AccessLogFormat, target string) string {
+		t.Helper()
+		lines := make(lineWriter, 1)
+		ipc, err := remotefs.NewIPCServer(fs, remotefs.WithAccessLog(lines, format))
+		if err != nil {
+			t.Fatalf("init IPC server: %v", err)
+		}
+		ts := httptest.NewServer(ipc.Handler())
+		defer ts.Close()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+target, nil)
+		req.Header.Set("User-Agent", "sync/1.0")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get %s: %v", target, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no access log line for %s", target)
+			return ""
+		}
+	}
+
+	clf := regexp.MustCompile(`^127\.0\.0\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /cat\?path=/data/docs/report.txt HTTP/1.1" 200 11\n$`)
+	if line := get(remotefs.AccessLogCommon, "/cat?path=/data/docs/report.txt"); !clf.MatchString(line) {
+		t.Fatalf("clf line = %q", line)
+	}
+	if line := get(remotefs.AccessLogCombined, "/stat?path=/data/missing"); !strings.Contains(line, `" 404 `) || !strings.HasSuffix(line, ` "-" "sync/1.0"`+"\n") {
+		t.Fatalf("combined line = %q", line)
+	}
+	var entry remotefs.AccessLogEntry
+	if err := json.Unmarshal([]byte(get(remotefs.AccessLogJSON, "/cat?path=/data/docs/report.txt")), &entry); err != nil {
+		t.Fatalf("decode json line: %v", err)
+	}
+	if entry.Status != http.StatusOK || entry.Bytes != 11 || entry.Method != http.MethodGet || entry.UserAgent != "sync/1.0" {
+		t.Fatalf("json entry = %+v", entry)
+	}
+	if _, err := remotefs.ParseAccessLogFormat("apache"); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
+
+func TestIPCServerDownloadTokens(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	key := []byte("secret")
+	ipc, err := remotefs.NewIPCServer(fs, remotefs.WithDownloadTokens(key, true))
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/token?path=/data/docs/report.txt&ttl=1m", "", nil)
+	if err != nil {
+		t.Fatalf("token request: %v", err)
+	}
+	var token remotefs.TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("token = %d %+v, %v", resp.StatusCode, token, err)
+	}
+
+	expired := remotefs.SignDownloadToken(key, "/data/docs/report.txt", time.Now().Add(-time.Second))
+	forged := remotefs.SignDownloadToken([]byte("other"), "/data/docs/report.txt", time.Now().Add(time.Minute))
+	for _, tt := range []struct {
+		query  string
+		status int
+	}{
+		{query: "token=" + token.Token, status: http.StatusOK},
+		{query: "path=/data/docs/report.txt", status: http.StatusForbidden},
+		{query: "token=" + expired, status: http.StatusForbidden},
+		{query: "token=" + forged, status: http.StatusForbidden},
+		{query: "token=" + token.Token[1:], status: http.StatusForbidden},
+	} {
+		resp, err := http.Get(ts.URL + "/cat?" + tt.query)
+		if err != nil {
+			t.Fatalf("cat %s: %v", tt.query, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Fatalf("cat %s = %d %s, want %d", tt.query, resp.StatusCode, body, tt.status)
+		}
+		if tt.status == http.StatusOK && string(body) != "hello world" {
+			t.Fatalf("cat with token returned %q", body)
+		}
+	}
+
+	resp, err = http.Post(ts.URL+"/token?path=/data/docs&scope=archive", "", nil)
+	if err != nil {
+		t.Fatalf("archive token request: %v", err)
+	}
+	var archive remotefs.TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&archive)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || archive.Scope != "archive" {
+		t.Fatalf("archive token = %d %+v, %v", resp.StatusCode, archive, err)
+	}
+	fileToken := remotefs.SignDownloadToken(key, "/data/docs", time.Now().Add(time.Minute))
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{path: "/archive?token=" + archive.Token, status: http.StatusOK},
+		{path: "/archive?path=/data/docs", status: http.StatusForbidden},
+		{path: "/archive?token=" + fileToken, status: http.StatusForbidden},
+		{path: "/archive?token=" + token.Token, status: http.StatusForbidden},
+		{path: "/cat?token=" + archive.Token, status: http.StatusForbidden},
+	} {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatalf("get %s: %v", tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Fatalf("get %s = %d %s, want %d", tt.path, resp.StatusCode, body, tt.status)
+		}
+	}
+
+	for _, query := range []string{"path=/etc/passwd", "path=/data/docs/report.txt&ttl=48h", "path=/data/docs&scope=tree"} {
+		resp, err := http.Post(ts.URL+"/token?"+query, "", nil)
+		if err != nil {
+			t.Fatalf("token %s: %v", query, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("token %s = %d, want 400", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestIPCServerRewarm(t *testing.T) {
+	store := newFakeStore()
+	fs, err := remotefs.New(store, remotefs.Config{
+		LocalRoot: "/data",
+		CacheDir:  t.TempDir(),
+		CacheSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/cache/rewarm")
+	if err != nil {
+		t.Fatalf("rewarm GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("rewarm GET status = %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(ts.URL+"/cache/rewarm", "application/json", nil)
+	if err != nil {
+		t.Fatalf("rewarm POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("rewarm status = %d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err = http.Get(ts.URL + "/cache/stats")
+		if err != nil {
+			t.Fatalf("stats request: %v", err)
+		}
+		var stats remotefs.CacheStatsResponse
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode stats: %v", err)
+		}
+		if !stats.Warm.LastCompleted.IsZero() {
+			if stats.Warm.InProgress {
+				t.Fatalf("warm still marked in progress after completion")
+			}
+			if stats.Warm.Entries == 0 {
+				t.Fatalf("warm recorded no entries")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rewarm never completed: %+v", stats.Warm)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadAWSConfigAssumeRole(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", path.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(t.TempDir(), "credentials"))
+	base := awsSettings{
+		region:    "us-east-1",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "secret",
+	}
+
+	cfg, err := loadAWSConfig(context.Background(), base)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	cache, ok := cfg.Credentials.(*aws.CredentialsCache)
+	if ok && cache.IsCredentialsProvider((*stscreds.AssumeRoleProvider)(nil)) {
+		t.Fatalf("assume role provider wired without -assume-role-arn")
+	}
+
+	withRole := base
+	withRole.roleARN = "arn:aws:iam::123456789012:role/reader"
+	withRole.externalID = "ext-123"
+	cfg, err = loadAWSConfig(context.Background(), withRole)
+	if err != nil {
+		t.Fatalf("load config with role: %v", err)
+	}
+	cache, ok = cfg.Credentials.(*aws.CredentialsCache)
+	if !ok {
+		t.Fatalf("expected refreshing credentials cache, got %T", cfg.Credentials)
+	}
+	if !cache.IsCredentialsProvider((*stscreds.AssumeRoleProvider)(nil)) {
+		t.Fatalf("credentials are not backed by an AssumeRoleProvider")
+	}
+}
+
+func TestLoadAWSConfigConnectTimeout(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", path.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(t.TempDir(), "credentials"))
+	cfg, err := loadAWSConfig(context.Background(), awsSettings{
+		region:         "us-east-1",
+		connectTimeout: 3 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	client, ok := cfg.HTTPClient.(*awshttp.BuildableClient)
+	if !ok {
+		t.Fatalf("expected buildable HTTP client, got %T", cfg.HTTPClient)
+	}
+	if got := client.GetDialer().Timeout; got != 3*time.Second {
+		t.Fatalf("dial timeout = %v, want 3s", got)
+	}
+	if got := client.GetTransport().TLSHandshakeTimeout; got != 3*time.Second {
+		t.Fatalf("TLS handshake timeout = %v, want 3s", got)
+	}
+}
+
+func TestIPCServerCatRanges(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		noCache bool
+		accept  string
+	}{
+		{name: "cached", accept: "bytes"},
+		{name: "streaming", noCache: true, accept: "none"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := remotefs.New(newFakeStore(), remotefs.Config{
+				LocalRoot: "/data",
+				CacheDir:  t.TempDir(),
+				CacheSize: 1 << 20,
+				NoCache:   tt.noCache,
+			})
+			if err != nil {
+				t.Fatalf("init remotefs: %v", err)
+			}
+			ipc, err := remotefs.NewIPCServer(fs)
+			if err != nil {
+				t.Fatalf("init IPC server: %v", err)
+			}
+			ts := httptest.NewServer(ipc.Handler())
+			defer ts.Close()
+
+			req, _ := http.NewRequest(http.MethodHead, ts.URL+"/cat?path=/data/docs/report.txt", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("HEAD cat: %v", err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("Accept-Ranges"); got != tt.accept {
+				t.Fatalf("HEAD Accept-Ranges = %q, want %q", got, tt.accept)
+			}
+
+			req, _ = http.NewRequest(http.MethodGet, ts.URL+"/cat?path=/data/docs/report.txt", nil)
+			req.Header.Set("Range", "bytes=6-")
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("GET cat: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got := resp.Header.Get("Accept-Ranges"); got != tt.accept {
+				t.Fatalf("GET Accept-Ranges = %q, want %q", got, tt.accept)
+			}
+			wantStatus, wantBody := http.StatusPartialContent, "world"
+			if tt.noCache {
+				wantStatus, wantBody = http.StatusOK, "hello world"
+			}
+			if resp.StatusCode != wantStatus || string(body) != wantBody {
+				t.Fatalf("range GET = %d %q, want %d %q", resp.StatusCode, body, wantStatus, wantBody)
+			}
+		})
+	}
+}
+
+// downloadCountingStore counts the content downloads it serves.
+type downloadCountingStore struct {
+	*fakeStore
+	downloads int
+}
+
+func (d *downloadCountingStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	d.downloads++
+	return d.fakeStore.Download(ctx, key, dst)
+}
+
+func TestIPCServerCatHeadUsesStat(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := &downloadCountingStore{fakeStore: newFakeStore()}
+	store.files["docs/report.txt"].meta.ETag = `"abc"`
+	store.files["docs/report.txt"].meta.LastModified = modified
+	fs, err := remotefs.New(store, remotefs.Config{LocalRoot: "/data", CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodHead, ts.URL+"/cat?path=/data/docs/report.txt", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("HEAD cat: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 11 {
+		t.Fatalf("HEAD = %d, length %d", resp.StatusCode, resp.ContentLength)
+	}
+	if got := resp.Header.Get("ETag"); got != `"abc"` {
+		t.Fatalf("ETag = %q", got)
+	}
+	if got := resp.Header.Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified = %q", got)
+	}
+	if store.downloads != 0 {
+		t.Fatalf("HEAD downloaded the content %d times", store.downloads)
+	}
+
+	req, _ = http.NewRequest(http.MethodHead, ts.URL+"/cat?path=/data/docs/missing.txt", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("HEAD missing: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("HEAD missing = %d, want 404", resp.StatusCode)
+	}
+}
+
+// endlessStore streams never-ending content from Open and reports when the
+// stream is closed.
+type endlessStore struct {
+	*fakeStore
+	closed chan struct{}
+}
+
+func (e endlessStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return &endlessBody{closed: e.closed}, nil
+}
+
+type endlessBody struct {
+	closed chan struct{}
+}
+
+func (b *endlessBody) Read(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (b *endlessBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestIPCServerCatIdleTimeout(t *testing.T) {
+	store := endlessStore{fakeStore: newFakeStore(), closed: make(chan struct{})}
+	fs, err := remotefs.New(store, remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs, remotefs.WithStreamIdleTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	// Take the response but never read the body, like a stuck client.
+	resp, err := http.Get(ts.URL + "/cat?path=/data/docs/report.txt")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer resp.Body.Close()
+	select {
+	case <-store.closed:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("stream to a stalled client was never aborted")
+	}
+}
+
+func TestIPCServerCatTransform(t *testing.T) {
+	transforms, err := newTransforms("hello=^hello")
+	if err != nil {
+		t.Fatalf("transforms: %v", err)
+	}
+	store := newFakeStore()
+	store.files["docs/report.txt"].data = []byte("hello world\nbye\n")
+	store.files["docs/report.txt"].meta.Size = 16
+	fs, err := remotefs.New(store, remotefs.Config{LocalRoot: "/data", NoCache: true, Transforms: transforms})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/cat?path=/data/docs/report.txt&transform=hello")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello world\n" {
+		t.Fatalf("cat transformed = %d %q", resp.StatusCode, body)
+	}
+	resp, err = http.Get(ts.URL + "/cat?path=/data/docs/report.txt&transform=rot13")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown transform status = %d", resp.StatusCode)
+	}
+}
+
+func TestIPCServerDir(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		path    string
+		isDir   bool
+		entries int
+	}{
+		{path: "/data/docs", isDir: true, entries: 1},
+		{path: "/data/docs/report.txt", isDir: false, entries: 0},
+	} {
+		resp, err := http.Get(ts.URL + "/dir?path=" + tt.path)
+		if err != nil {
+			t.Fatalf("dir %s: %v", tt.path, err)
+		}
+		var out remotefs.DirResponse
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("dir %s = %d, %v", tt.path, resp.StatusCode, err)
+		}
+		if out.Dir.IsDir != tt.isDir || len(out.Entries) != tt.entries || out.Entries == nil {
+			t.Fatalf("dir %s = %+v", tt.path, out)
+		}
+	}
+	resp, err := http.Get(ts.URL + "/dir?path=/data/missing")
+	if err != nil {
+		t.Fatalf("dir missing: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("dir missing = %d", resp.StatusCode)
+	}
+}
+
+func TestIPCServerListWindow(t *testing.T) {
+	store := newFakeStore()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		key := "docs/" + name
+		store.files[key] = &fakeFile{meta: objectstore.FileMeta{Path: key, Size: 1}, data: []byte("x")}
+	}
+	fs, err := remotefs.New(store, remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/ls?path=/data/docs&offset=1&count=1")
+	if err != nil {
+		t.Fatalf("ls request: %v", err)
+	}
+	var entries []remotefs.POSIXEntry
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode ls: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "docs/b.txt" || resp.Header.Get("X-Total-Count") != "3" {
+		t.Fatalf("window = %+v, total %q", entries, resp.Header.Get("X-Total-Count"))
+	}
+}
+
+func TestIPCServerCachePin(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{
+		LocalRoot: "/data",
+		CacheDir:  t.TempDir(),
+		CacheSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	pin := func(method string) (int, remotefs.CacheEntryInfo) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+"/cache/pin?path=/data/docs/report.txt", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s pin: %v", method, err)
+		}
+		defer resp.Body.Close()
+		var info remotefs.CacheEntryInfo
+		_ = json.NewDecoder(resp.Body).Decode(&info)
+		return resp.StatusCode, info
+	}
+	if status, info := pin(http.MethodPost); status != http.StatusOK || !info.Cached || !info.Pinned {
+		t.Fatalf("pin = %d %+v", status, info)
+	}
+	if stats := fs.CacheStats(); stats.Pinned != 1 || stats.PinnedBytes != 11 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if status, info := pin(http.MethodDelete); status != http.StatusOK || !info.Cached || info.Pinned {
+		t.Fatalf("unpin = %d %+v", status, info)
+	}
+	if status, _ := pin(http.MethodGet); status != http.StatusMethodNotAllowed {
+		t.Fatalf("GET pin = %d", status)
+	}
+}
+
+// trickleStore streams "first" from Open, then blocks until more is closed.
+type trickleStore struct {
+	*fakeStore
+	more chan struct{}
+}
+
+func (s trickleStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	go func() {
+		_, _ = w.Write([]byte("first"))
+		<-s.more
+		_, _ = w.Write([]byte(" second"))
+		w.Close()
+	}()
+	return r, nil
+}
+
+func TestIPCServerCatFlushInterval(t *testing.T) {
+	store := trickleStore{fakeStore: newFakeStore(), more: make(chan struct{})}
+	fs, err := remotefs.New(store, remotefs.Config{LocalRoot: "/data", NoCache: true})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs, remotefs.WithCatFlush(0, 10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/cat?path=/data/docs/report.txt")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer resp.Body.Close()
+	// The first bytes arrive while the source is still stalled.
+	got := make(chan string)
+	go func() {
+		buf := make([]byte, len("first"))
+		n, _ := io.ReadFull(resp.Body, buf)
+		got <- string(buf[:n])
+	}()
+	select {
+	case first := <-got:
+		if first != "first" {
+			t.Fatalf("first bytes = %q", first)
+		}
+	case <-time.After(10 * time.Second):
+		close(store.more)
+		t.Fatalf("buffered content was never flushed")
+	}
+	close(store.more)
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil || string(rest) != " second" {
+		t.Fatalf("rest = %q, %v", rest, err)
+	}
+}
+
+// unreachableStore fails Ping as if the bucket were inaccessible.
+type unreachableStore struct {
+	*fakeStore
+}
+
+func (u unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("access denied")
+}
+
+func TestIPCServerReady(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		store  objectstore.ObjectStore
+		status int
+	}{
+		{name: "reachable", store: newFakeStore(), status: http.StatusOK},
+		{name: "unreachable", store: unreachableStore{newFakeStore()}, status: http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := remotefs.New(tt.store, remotefs.Config{CacheDir: t.TempDir()})
+			if err != nil {
+				t.Fatalf("init remotefs: %v", err)
+			}
+			ipc, err := remotefs.NewIPCServer(fs)
+			if err != nil {
+				t.Fatalf("init IPC server: %v", err)
+			}
+			ts := httptest.NewServer(ipc.Handler())
+			defer ts.Close()
+			resp, err := http.Get(ts.URL + "/ready")
+			if err != nil {
+				t.Fatalf("ready request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("ready status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestIPCServerIndex(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{CacheDir: t.TempDir(), LocalRoot: "/data"})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs, remotefs.WithStoreLocation("my-bucket", "team/"))
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("index request: %v", err)
+	}
+	var index remotefs.IndexResponse
+	err = json.NewDecoder(resp.Body).Decode(&index)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode index: %v", err)
+	}
+	if index.Bucket != "my-bucket" || index.Prefix != "team/" || index.LocalRoot != "/data" {
+		t.Fatalf("index = %+v", index)
+	}
+	found := false
+	for _, endpoint := range index.Endpoints {
+		found = found || endpoint == "/cat"
+	}
+	if !found {
+		t.Fatalf("endpoints missing /cat: %v", index.Endpoints)
+	}
+
+	resp, err = http.Get(ts.URL + "/nope")
+	if err != nil {
+		t.Fatalf("unknown path request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown path status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestIPCServerMalformedQuery(t *testing.T) {
+	fs, err := remotefs.New(newFakeStore(), remotefs.Config{
+		LocalRoot: "/data",
+		CacheDir:  t.TempDir(),
+		CacheSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		query string
+		param string // named in the error message
+	}{
+		{"/cat?path=docs/report.txt&rel=abc", "rel"},
+		{"/cat?path=/data/docs/report.txt&nocache=abc", "nocache"},
+		{"/stat?path=/data&locks=abc", "locks"},
+		{"/ls?path=/data&recursive=abc", "recursive"},
+		{"/ls?path=/data&recursive=true&depth=-5", "depth"},
+		{"/ls?path=/data&recursive=true&depth=abc", "depth"},
+		{"/ls?path=/data&max=-1", "max"},
+		{"/ls?path=/data&max=abc", "max"},
+		{"/ls?path=/data&flat=abc", "flat"},
+		{"/ls?path=/data&offset=-1", "offset"},
+		{"/ls?path=/data&count=abc", "count"},
+		{"/tree?path=/data&depth=-5", "depth"},
+		{"/usage?path=/data&top=abc", "top"},
+		{"/tail?path=/data/docs/report.txt&lines=-1", "lines"},
+		{"/cache/verify?prune=abc", "prune"},
+	} {
+		resp, err := http.Get(ts.URL + tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var body remotefs.ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: decode error: %v", tt.query, err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || body.Code != remotefs.ErrorCodeInvalidRequest {
+			t.Fatalf("%s = %d %+v, want 400 %s", tt.query, resp.StatusCode, body, remotefs.ErrorCodeInvalidRequest)
+		}
+		if !strings.Contains(body.Error, tt.param) {
+			t.Fatalf("%s error %q does not name %s", tt.query, body.Error, tt.param)
+		}
+	}
+
+	resp, err := http.Post(ts.URL+"/catmany?rel=abc", "application/json", strings.NewReader(`["docs/report.txt"]`))
+	if err != nil {
+		t.Fatalf("catmany request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("catmany with rel=abc = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := parseQuotas("tenant-a=100, /tenant-b/=2048")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(quotas) != 2 || quotas["tenant-a"] != 100 || quotas["tenant-b"] != 2048 {
+		t.Fatalf("quotas = %v", quotas)
+	}
+	for _, bad := range []string{"tenant-a", "a/b=1", "x=0", "x=lots"} {
+		if _, err := parseQuotas(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestParseTransformRules(t *testing.T) {
+	rules, err := parseTransformRules("*.gz=gunzip, logs/*.log.gz = gunzip+errors")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Pattern != "*.gz" || strings.Join(rules[1].Transforms, "+") != "gunzip+errors" {
+		t.Fatalf("rules = %+v", rules)
+	}
+	for _, bad := range []string{"*.gz", "=gunzip", "*.gz="} {
+		if _, err := parseTransformRules(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+	if _, err := newTransforms("errors=("); err == nil {
+		t.Fatalf("expected error for a bad grep expression")
+	}
+}
@@ -2,137 +2,720 @@ package cache
 
 import (
 	"container/list"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // Cache implements a simple disk backed LRU cache with a hard byte budget.
 type Cache struct {
-	dir      string
-	maxBytes int64
+	dir        string
+	maxBytes   int64
+	maxEntries int
+	quotas     map[string]int64
+	onEvict    func(key string, size int64)
+	stagingDir string
+	// stagePrefix names the scratch files of this cache directory, so
+	// leftovers can be told apart from those of other caches sharing the
+	// staging directory.
+	stagePrefix string
+	namespace   string
+	lock        *os.File
 
 	mu      sync.Mutex
 	entries map[string]*cacheEntry
 	order   *list.List
 	used    int64
+	// removed holds entries dropped from the index while still acquired,
+	// keyed by file path. Their files are deleted on the last release.
+	removed map[string]*cacheEntry
+	// prefixUsed tracks the bytes held by each prefix with a quota.
+	prefixUsed map[string]int64
+	// evicted queues the entries dropped under c.mu for onEvict, which
+	// unlock reports once the mutex is released.
+	evicted []EntryInfo
+	// fills tracks the downloads in progress by key, so concurrent loads of
+	// a missing key wait for one writer instead of writing the same file.
+	fills map[string]*fill
+	// pins holds the keys excluded from eviction. A pin outlives Remove, so
+	// the refetched entry of a pinned key is pinned as well.
+	pins map[string]bool
+}
+
+// fill is a download in progress. done is closed once it finished, with err
+// set when it failed.
+type fill struct {
+	done chan struct{}
+	err  error
 }
 
 type cacheEntry struct {
-	path string
-	size int64
-	elem *list.Element
+	path     string
+	size     int64
+	accessed time.Time
+	etag     string
+	// modified is the LastModified of the remote object, zero when unknown.
+	modified time.Time
+	// remoteSize is the size of the remote object when the content was
+	// stored in another form, and -1 when size is the remote size.
+	remoteSize int64
+	elem       *list.Element
+	// refs counts the Acquire calls not yet released.
+	refs int
+	// prefix is the key's top-level prefix when it has a quota.
+	prefix string
+}
+
+// lockName is the advisory lock file guarding a cache directory.
+const lockName = ".lock"
+
+// ErrLocked is returned by New when another process already uses the cache
+// directory.
+var ErrLocked = errors.New("cache directory is in use by another process")
+
+// Options bounds a cache. A non-positive limit is not enforced.
+type Options struct {
+	// MaxBytes caps the total size of the cached files.
+	MaxBytes int64
+	// MaxEntries caps the number of cached files, for workloads with many
+	// small objects that never reach the byte budget.
+	MaxEntries int
+	// PrefixQuotas caps the bytes cached under a top-level prefix, the part
+	// of a key before the first "/". A new entry in such a prefix first
+	// evicts the prefix's own least recently used entries, so one tenant
+	// cannot push another's files out. Prefixes without a quota share the
+	// rest of MaxBytes under the global LRU.
+	PrefixQuotas map[string]int64
+	// OnEvict is called with the key and size of every entry that eviction
+	// or Remove drops from the cache. It runs after the cache mutex is
+	// released, so it may call back into the cache, but possibly from
+	// several goroutines at once. The file may already be deleted.
+	OnEvict func(key string, size int64)
+	// StagingDir receives downloads in progress, which are moved into the
+	// cache directory once complete. Keeping it on the cache's filesystem
+	// makes the move a rename; on another device the finished file is
+	// copied instead, which reads and writes every byte a second time.
+	// Empty downloads straight into the cache file. Files left behind by a
+	// crash are removed when the cache is next opened, so several cache
+	// directories may share one staging directory.
+	StagingDir string
+	// Namespace is mixed into the hash that names each cache file, so caches
+	// for different backends sharing a directory over time never map the
+	// same key to the same file. Use something identifying the backend,
+	// such as "s3://bucket/prefix".
+	Namespace string
 }
 
-// New creates the cache in the provided directory.
+// New creates the cache in the provided directory. It takes an exclusive
+// advisory lock on the directory so two processes cannot share it; call Close
+// to release it.
 func New(dir string, maxBytes int64) (*Cache, error) {
+	return NewOpts(dir, Options{MaxBytes: maxBytes})
+}
+
+// NewOpts is New with both the byte and the entry limits. The least recently
+// used entry is evicted when either limit would be exceeded.
+func NewOpts(dir string, opts Options) (*Cache, error) {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return nil, fmt.Errorf("make cache dir: %w", err)
 	}
-	return &Cache{
-		dir:      dir,
-		maxBytes: maxBytes,
-		entries:  make(map[string]*cacheEntry),
-		order:    list.New(),
-	}, nil
+	if opts.StagingDir != "" {
+		if err := os.MkdirAll(opts.StagingDir, 0o755); err != nil {
+			return nil, fmt.Errorf("make cache staging dir: %w", err)
+		}
+	}
+	lock, err := lockDir(filepath.Join(dir, lockName))
+	if err != nil {
+		if errors.Is(err, ErrLocked) {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		return nil, err
+	}
+	c := &Cache{
+		dir:         dir,
+		maxBytes:    opts.MaxBytes,
+		maxEntries:  opts.MaxEntries,
+		quotas:      opts.PrefixQuotas,
+		onEvict:     opts.OnEvict,
+		stagingDir:  opts.StagingDir,
+		stagePrefix: stagePrefix(dir),
+		namespace:   opts.Namespace,
+		lock:        lock,
+		entries:     make(map[string]*cacheEntry),
+		order:       list.New(),
+		removed:     make(map[string]*cacheEntry),
+		prefixUsed:  make(map[string]int64),
+		fills:       make(map[string]*fill),
+		pins:        make(map[string]bool),
+	}
+	// The directory lock is held, so nothing else can be writing files
+	// with this cache's prefix.
+	c.removeScratchFiles()
+	return c, nil
+}
+
+// stagePrefix returns the name prefix of scratch files for the cache in dir.
+func stagePrefix(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return "stage-" + hex.EncodeToString(sum[:4]) + "-"
+}
+
+// scratchDir is where downloads in progress and other scratch files go.
+func (c *Cache) scratchDir() string {
+	if c.stagingDir != "" {
+		return c.stagingDir
+	}
+	return c.dir
+}
+
+// removeScratchFiles deletes scratch files a previous process left behind,
+// such as partial downloads interrupted by a crash.
+func (c *Cache) removeScratchFiles() {
+	leftovers, _ := filepath.Glob(filepath.Join(c.scratchDir(), c.stagePrefix+"*"))
+	for _, name := range leftovers {
+		_ = os.Remove(name)
+	}
+}
+
+// CreateScratch creates a temporary file next to the cache's downloads in
+// progress, in the staging directory when one is configured. The caller
+// removes it; one left behind by a crash is removed when the cache is next
+// opened.
+func (c *Cache) CreateScratch() (*os.File, error) {
+	return os.CreateTemp(c.scratchDir(), c.stagePrefix+"*")
+}
+
+// Close releases the cache directory lock. The cached files stay on disk.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lock == nil {
+		return nil
+	}
+	err := unlockDir(c.lock)
+	c.lock = nil
+	return err
 }
 
 func (c *Cache) keyPath(key string) string {
+	if c.namespace != "" {
+		// The NUL cannot occur in a namespace or key, so distinct pairs
+		// never hash the same input.
+		key = c.namespace + "\x00" + key
+	}
 	sum := sha256.Sum256([]byte(key))
 	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
 }
 
+// PathFor returns the absolute cache file path for key and whether the key is
+// currently cached. The path is reported even when the key is absent so it is
+// clear where the entry would be stored.
+func (c *Cache) PathFor(key string) (string, bool) {
+	c.mu.Lock()
+	path := c.keyPath(key)
+	entry, ok := c.entries[key]
+	if ok {
+		path = entry.path
+	}
+	c.mu.Unlock()
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path, ok
+}
+
 // LoadOrCreate ensures the key is present in the cache and returns the absolute
 // path. When the key is missing, the fetch callback is invoked to populate it.
 // The callback receives an *os.File implementing io.WriterAt and must return
 // the final size of the object.
 func (c *Cache) LoadOrCreate(key string, fetch func(f *os.File) (int64, error)) (string, error) {
-	c.mu.Lock()
-	if entry, ok := c.entries[key]; ok {
-		c.order.MoveToFront(entry.elem)
-		path := entry.path
+	entry, err := c.load(key, fetch, false)
+	if err != nil {
+		return "", err
+	}
+	return entry.path, nil
+}
+
+// Acquire behaves like LoadOrCreate and also pins the entry: Remove and
+// eviction drop it from the cache right away but keep its file on disk until
+// release is called, so an open reader is never cut off. A later load of the
+// same key writes to a fresh file. Every successful Acquire must be paired
+// with one call to release.
+func (c *Cache) Acquire(key string, fetch func(f *os.File) (int64, error)) (path string, release func(), err error) {
+	entry, err := c.load(key, fetch, true)
+	if err != nil {
+		return "", nil, err
+	}
+	var once sync.Once
+	return entry.path, func() { once.Do(func() { c.release(entry) }) }, nil
+}
+
+// load returns the entry for key, populating it through fetch when missing.
+// With pin set the entry's reference count is raised under the same lock
+// that found or inserted it. Only one fetch per key runs at a time: other
+// loads wait for it and share its entry, or its error. A fetch that failed
+// because its caller's context ended is retried by the next waiter instead.
+func (c *Cache) load(key string, fetch func(f *os.File) (int64, error), pin bool) (*cacheEntry, error) {
+	for {
+		c.mu.Lock()
+		if entry, ok := c.entries[key]; ok {
+			c.order.MoveToFront(entry.elem)
+			entry.accessed = time.Now()
+			if pin {
+				entry.refs++
+			}
+			c.mu.Unlock()
+			return entry, nil
+		}
+		if running, ok := c.fills[key]; ok {
+			c.mu.Unlock()
+			fillWaiting(key)
+			<-running.done
+			if err := running.err; err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+				return nil, err
+			}
+			continue
+		}
+		f := &fill{done: make(chan struct{})}
+		c.fills[key] = f
+		path := c.freePath(key)
+		c.mu.Unlock()
+
+		entry, err := c.fill(key, path, fetch, pin)
+		c.mu.Lock()
+		delete(c.fills, key)
 		c.mu.Unlock()
-		return path, nil
+		f.err = err
+		close(f.done)
+		return entry, err
 	}
-	path := c.keyPath(key)
-	c.mu.Unlock()
+}
 
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+// fill downloads key into path through fetch and indexes the new entry.
+func (c *Cache) fill(key, path string, fetch func(f *os.File) (int64, error), pin bool) (*cacheEntry, error) {
+	file, err := c.createFile(path)
 	if err != nil {
-		return "", fmt.Errorf("open cache file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	// The download is removed on every way out, a panicking fetch included,
+	// unless it made it into the cache.
+	kept := false
+	defer func() {
+		file.Close()
+		if !kept {
+			_ = os.Remove(file.Name())
+		}
+	}()
 
 	size, err := fetch(file)
 	if err != nil {
-		_ = os.Remove(path)
-		return "", err
+		return nil, err
 	}
 
 	info, err := file.Stat()
 	if err != nil {
-		_ = os.Remove(path)
-		return "", fmt.Errorf("stat cache file: %w", err)
+		return nil, fmt.Errorf("stat cache file: %w", err)
 	}
 	size = info.Size()
+	if file.Name() != path {
+		if err := moveFile(file, path); err != nil {
+			return nil, fmt.Errorf("move staged download: %w", err)
+		}
+	}
+	kept = true
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	prefix, quota := c.quotaFor(key)
+	if quota > 0 {
+		if err := c.ensurePrefixCapacity(prefix, quota, size); err != nil {
+			_ = os.Remove(path)
+			return nil, err
+		}
+	}
 	if err := c.ensureCapacity(size); err != nil {
 		_ = os.Remove(path)
-		return "", err
+		return nil, err
+	}
+	entry := &cacheEntry{
+		path:       path,
+		size:       size,
+		accessed:   time.Now(),
+		remoteSize: -1,
+		elem:       c.order.PushFront(key),
 	}
-	elem := c.order.PushFront(key)
-	c.entries[key] = &cacheEntry{
-		path: path,
-		size: size,
-		elem: elem,
+	if pin {
+		entry.refs = 1
 	}
+	if quota > 0 {
+		entry.prefix = prefix
+		c.prefixUsed[prefix] += size
+	}
+	c.entries[key] = entry
 	c.used += size
-	return path, nil
+	return entry, nil
 }
 
-func (c *Cache) ensureCapacity(need int64) error {
-	if c.maxBytes <= 0 {
-		return nil
+// createFile opens the file a download for path is written to: a fresh file
+// in the staging directory when one is configured, or path itself.
+func (c *Cache) createFile(path string) (*os.File, error) {
+	if c.stagingDir != "" {
+		file, err := c.CreateScratch()
+		if err != nil {
+			return nil, fmt.Errorf("create staging file: %w", err)
+		}
+		return file, nil
 	}
-	for c.used+need > c.maxBytes && c.order.Len() > 0 {
-		last := c.order.Back()
-		key := last.Value.(string)
-		entry := c.entries[key]
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open cache file: %w", err)
+	}
+	return file, nil
+}
+
+// fillWaiting is called when a load starts waiting for another load's fetch
+// of key. Tests replace it to know a waiter is blocked.
+var fillWaiting = func(key string) {}
+
+// renameFile is os.Rename, replaceable in tests to simulate a staging
+// directory on another device.
+var renameFile = os.Rename
+
+// moveFile moves the staged file src to dst. A rename across devices fails
+// with EXDEV, in which case the content is copied and src removed.
+func moveFile(src *os.File, dst string) error {
+	err := renameFile(src.Name(), dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src.Name())
+}
+
+// quotaFor returns the top-level prefix of key and its quota, or zero when
+// the prefix has none. Keys without a "/" belong to no prefix.
+func (c *Cache) quotaFor(key string) (string, int64) {
+	prefix, _, ok := strings.Cut(key, "/")
+	if !ok || len(c.quotas) == 0 {
+		return "", 0
+	}
+	return prefix, c.quotas[prefix]
+}
+
+// ensurePrefixCapacity evicts the least recently used entries of prefix until
+// need more bytes fit within its quota. c.mu must be held.
+func (c *Cache) ensurePrefixCapacity(prefix string, quota, need int64) error {
+	if need > quota {
+		return fmt.Errorf("cache quota %d bytes for prefix %q exceeded by %d", quota, prefix, need)
+	}
+	for elem := c.order.Back(); elem != nil && c.prefixUsed[prefix]+need > quota; {
+		prev := elem.Prev()
+		key := elem.Value.(string)
+		if entry := c.entries[key]; entry.prefix == prefix && !c.pins[key] {
+			c.drop(key, entry)
+		}
+		elem = prev
+	}
+	if c.prefixUsed[prefix]+need > quota {
+		return fmt.Errorf("cache quota %d bytes for prefix %q held by pinned entries", quota, prefix)
+	}
+	return nil
+}
+
+// freePath returns the file path for a new entry of key. It is the key's
+// usual path unless a removed but still acquired entry occupies it.
+func (c *Cache) freePath(key string) string {
+	path := c.keyPath(key)
+	for i := 1; c.removed[path] != nil; i++ {
+		path = fmt.Sprintf("%s.%d", c.keyPath(key), i)
+	}
+	return path
+}
+
+// release drops one reference to entry and deletes its file once a removed
+// entry is no longer acquired.
+func (c *Cache) release(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if entry.refs == 0 && c.removed[entry.path] == entry {
+		delete(c.removed, entry.path)
 		_ = os.Remove(entry.path)
-		c.used -= entry.size
-		delete(c.entries, key)
-		c.order.Remove(last)
 	}
-	if c.used+need > c.maxBytes {
+}
+
+// drop removes key from the index. The file is deleted now or, while the
+// entry is acquired, on its last release. c.mu must be held.
+func (c *Cache) drop(key string, entry *cacheEntry) {
+	c.order.Remove(entry.elem)
+	c.used -= entry.size
+	if entry.prefix != "" {
+		c.prefixUsed[entry.prefix] -= entry.size
+	}
+	delete(c.entries, key)
+	if c.onEvict != nil {
+		c.evicted = append(c.evicted, EntryInfo{Key: key, Size: entry.size})
+	}
+	if entry.refs > 0 {
+		c.removed[entry.path] = entry
+		return
+	}
+	_ = os.Remove(entry.path)
+}
+
+// unlock releases c.mu and then reports the entries dropped while it was held
+// to the OnEvict callback.
+func (c *Cache) unlock() {
+	evicted := c.evicted
+	c.evicted = nil
+	c.mu.Unlock()
+	for _, entry := range evicted {
+		c.onEvict(entry.Key, entry.Size)
+	}
+}
+
+// ensureCapacity evicts least recently used entries until one more entry of
+// need bytes fits within both limits.
+func (c *Cache) ensureCapacity(need int64) error {
+	overBytes := func() bool { return c.maxBytes > 0 && c.used+need > c.maxBytes }
+	overCount := func() bool { return c.maxEntries > 0 && len(c.entries)+1 > c.maxEntries }
+	for elem := c.order.Back(); elem != nil && (overBytes() || overCount()); {
+		prev := elem.Prev()
+		if key := elem.Value.(string); !c.pins[key] {
+			c.drop(key, c.entries[key])
+		}
+		elem = prev
+	}
+	if overBytes() {
 		return fmt.Errorf("cache capacity %d bytes exceeded by %d", c.maxBytes, c.used+need)
 	}
+	if overCount() {
+		return fmt.Errorf("cache entry limit %d reached by pinned entries", c.maxEntries)
+	}
 	return nil
 }
 
+// ErrNotCached is returned by Pin for keys that are not in the cache.
+var ErrNotCached = errors.New("not cached")
+
+// ErrPinBudget is returned by Pin when the pinned entries would take up a
+// whole cache limit.
+var ErrPinBudget = errors.New("pinned entries would fill the cache budget")
+
+// Pin excludes the cached key from eviction until Unpin. Pinned bytes still
+// count toward the limits and cannot be reclaimed, so Pin fails with
+// ErrPinBudget when the pinned entries, key included, would take up all of
+// MaxBytes, MaxEntries, or the quota of the key's prefix and leave no room
+// for anything else. Remove still drops a pinned entry, but the pin stays and
+// applies to the entry that replaces it. Pins are not persisted.
+func (c *Cache) Pin(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return fmt.Errorf("%s: %w", key, ErrNotCached)
+	}
+	if c.pins[key] {
+		return nil
+	}
+	var bytes, prefixBytes int64
+	count := 0
+	for pinned := range c.pins {
+		if e, ok := c.entries[pinned]; ok {
+			bytes += e.size
+			count++
+			if entry.prefix != "" && e.prefix == entry.prefix {
+				prefixBytes += e.size
+			}
+		}
+	}
+	switch {
+	case c.maxBytes > 0 && bytes+entry.size >= c.maxBytes:
+		return fmt.Errorf("pin %s: %w: %d of %d bytes", key, ErrPinBudget, bytes+entry.size, c.maxBytes)
+	case c.maxEntries > 0 && count+1 >= c.maxEntries:
+		return fmt.Errorf("pin %s: %w: %d of %d entries", key, ErrPinBudget, count+1, c.maxEntries)
+	case entry.prefix != "" && prefixBytes+entry.size >= c.quotas[entry.prefix]:
+		return fmt.Errorf("pin %s: %w: %d of %d bytes for prefix %q", key, ErrPinBudget, prefixBytes+entry.size, c.quotas[entry.prefix], entry.prefix)
+	}
+	c.pins[key] = true
+	return nil
+}
+
+// Unpin makes key evictable again. It does nothing for keys that are not
+// pinned.
+func (c *Cache) Unpin(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pins, key)
+}
+
+// Stats summarizes the current cache occupancy.
+type Stats struct {
+	Entries    int   `json:"Entries"`
+	Used       int64 `json:"Used"`
+	MaxBytes   int64 `json:"MaxBytes"`
+	MaxEntries int   `json:"MaxEntries,omitempty"`
+	// PrefixUsed reports the bytes held by each prefix with a quota.
+	PrefixUsed map[string]int64 `json:"PrefixUsed,omitempty"`
+	// Pinned and PinnedBytes count the cached entries excluded from
+	// eviction by Pin.
+	Pinned      int   `json:"Pinned,omitempty"`
+	PinnedBytes int64 `json:"PinnedBytes,omitempty"`
+}
+
+// Stats returns a snapshot of the cache occupancy.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := Stats{
+		Entries:    len(c.entries),
+		Used:       c.used,
+		MaxBytes:   c.maxBytes,
+		MaxEntries: c.maxEntries,
+	}
+	for key := range c.pins {
+		if entry, ok := c.entries[key]; ok {
+			stats.Pinned++
+			stats.PinnedBytes += entry.size
+		}
+	}
+	if len(c.quotas) > 0 {
+		stats.PrefixUsed = make(map[string]int64, len(c.quotas))
+		for prefix := range c.quotas {
+			stats.PrefixUsed[prefix] = c.prefixUsed[prefix]
+		}
+	}
+	return stats
+}
+
+// EntryInfo describes one cached key.
+type EntryInfo struct {
+	Key        string    `json:"Key"`
+	Size       int64     `json:"Size"`
+	LastAccess time.Time `json:"LastAccess"`
+	// ETag is the remote version recorded with SetVersion or SetSource, if
+	// any.
+	ETag string `json:"ETag,omitempty"`
+	// RemoteModified is the LastModified of the remote object recorded with
+	// the ETag, zero when unknown.
+	RemoteModified time.Time `json:"RemoteModified"`
+	// RemoteSize is the size of the remote object the content came from. It
+	// equals Size unless SetSource recorded another one, as for content
+	// stored transformed.
+	RemoteSize int64 `json:"RemoteSize"`
+	// Pinned is set for entries excluded from eviction by Pin.
+	Pinned bool `json:"Pinned,omitempty"`
+}
+
+// Entries lists the cached keys in eviction order, least recently used
+// first.
+func (c *Cache) Entries() []EntryInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]EntryInfo, 0, len(c.entries))
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		key := elem.Value.(string)
+		entry := c.entries[key]
+		out = append(out, c.info(key, entry))
+	}
+	return out
+}
+
+// Lookup returns the index entry of key without marking it used.
+func (c *Cache) Lookup(key string) (EntryInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return EntryInfo{}, false
+	}
+	return c.info(key, entry), true
+}
+
+// info describes entry. c.mu must be held.
+func (c *Cache) info(key string, entry *cacheEntry) EntryInfo {
+	info := EntryInfo{
+		Key:            key,
+		Size:           entry.size,
+		LastAccess:     entry.accessed,
+		ETag:           entry.etag,
+		RemoteModified: entry.modified,
+		RemoteSize:     entry.remoteSize,
+		Pinned:         c.pins[key],
+	}
+	if info.RemoteSize < 0 {
+		info.RemoteSize = entry.size
+	}
+	return info
+}
+
+// SetVersion records the remote ETag and LastModified the cached content of
+// key was fetched at. It does nothing when key is not cached.
+func (c *Cache) SetVersion(key, etag string, modified time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.etag = etag
+		entry.modified = modified
+	}
+}
+
+// SetSource records the size and version of the remote object the cached
+// content of key was derived from, for content stored in another form than
+// the object, such as transformed. It does nothing when key is not cached.
+func (c *Cache) SetSource(key string, size int64, etag string, modified time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.remoteSize = size
+		entry.etag = etag
+		entry.modified = modified
+	}
+}
+
 // Touch marks the key as recently used to avoid premature eviction.
 func (c *Cache) Touch(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if entry, ok := c.entries[key]; ok {
 		c.order.MoveToFront(entry.elem)
+		entry.accessed = time.Now()
 	}
 }
 
-// Remove evicts a key from the cache immediately.
+// Remove evicts a key from the cache immediately. The file of an acquired
+// entry stays on disk until its last release.
 func (c *Cache) Remove(key string) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	entry, ok := c.entries[key]
-	if !ok {
-		return
+	defer c.unlock()
+	if entry, ok := c.entries[key]; ok {
+		c.drop(key, entry)
 	}
-	_ = os.Remove(entry.path)
-	c.order.Remove(entry.elem)
-	c.used -= entry.size
-	delete(c.entries, key)
 }
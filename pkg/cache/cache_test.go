@@ -0,0 +1,420 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+func TestMaxEntriesEvictsUnderByteBudget(t *testing.T) {
+	c, err := NewOpts(t.TempDir(), Options{MaxBytes: 1 << 30, MaxEntries: 3})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("obj-%d", i)
+		_, err := c.LoadOrCreate(key, func(f *os.File) (int64, error) {
+			n, err := f.Write([]byte{'x'})
+			return int64(n), err
+		})
+		if err != nil {
+			t.Fatalf("load %s: %v", key, err)
+		}
+	}
+	stats := c.Stats()
+	if stats.Entries != 3 || stats.Used != 3 {
+		t.Fatalf("stats = %+v, want 3 entries of 1 byte", stats)
+	}
+	for i, entry := range c.Entries() {
+		if want := fmt.Sprintf("obj-%d", 7+i); entry.Key != want {
+			t.Fatalf("entry %d = %s, want %s", i, entry.Key, want)
+		}
+	}
+	if _, ok := c.PathFor("obj-0"); ok {
+		t.Fatalf("oldest entry was not evicted")
+	}
+}
+
+func TestPinnedEntriesSurviveEviction(t *testing.T) {
+	c, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer c.Close()
+	load := func(key string, size int) error {
+		_, err := c.LoadOrCreate(key, func(f *os.File) (int64, error) {
+			n, err := f.Write(make([]byte, size))
+			return int64(n), err
+		})
+		return err
+	}
+	if err := c.Pin("hot"); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("pin before load = %v, want ErrNotCached", err)
+	}
+	if err := load("hot", 4); err != nil {
+		t.Fatalf("load hot: %v", err)
+	}
+	if err := c.Pin("hot"); err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := load(fmt.Sprintf("cold-%d", i), 3); err != nil {
+			t.Fatalf("load cold-%d: %v", i, err)
+		}
+	}
+	if _, ok := c.PathFor("hot"); !ok {
+		t.Fatalf("pinned entry was evicted")
+	}
+	if stats := c.Stats(); stats.Used != 10 || stats.Pinned != 1 || stats.PinnedBytes != 4 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	// An entry that only fits by evicting the pinned one is refused.
+	if err := load("big", 8); err == nil {
+		t.Fatalf("loaded an entry that needs the pinned bytes")
+	}
+
+	// Pinned bytes may not take up the whole budget.
+	if err := load("warm", 6); err != nil {
+		t.Fatalf("load warm: %v", err)
+	}
+	if err := c.Pin("warm"); !errors.Is(err, ErrPinBudget) {
+		t.Fatalf("pin of the last bytes = %v, want ErrPinBudget", err)
+	}
+
+	c.Unpin("hot")
+	if err := load("big", 8); err != nil {
+		t.Fatalf("load after unpin: %v", err)
+	}
+	if _, ok := c.PathFor("hot"); ok {
+		t.Fatalf("unpinned entry survived eviction")
+	}
+}
+
+func TestRemoveWaitsForRelease(t *testing.T) {
+	c, err := New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer c.Close()
+	write := func(content string) func(f *os.File) (int64, error) {
+		return func(f *os.File) (int64, error) {
+			n, err := f.WriteString(content)
+			return int64(n), err
+		}
+	}
+	path, release, err := c.Acquire("k", write("old"))
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	c.Remove("k")
+	if _, ok := c.PathFor("k"); ok {
+		t.Fatalf("removed key is still cached")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("acquired file deleted before release: %v", err)
+	}
+
+	// Reloading the key must not overwrite the file still being read.
+	fresh, err := c.LoadOrCreate("k", write("new"))
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if fresh == path {
+		t.Fatalf("reload reused the pinned file %s", path)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Fatalf("pinned file = %q, want old", data)
+	}
+
+	release()
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file not deleted after release: %v", err)
+	}
+	if data, _ := os.ReadFile(fresh); string(data) != "new" {
+		t.Fatalf("reloaded file = %q, want new", data)
+	}
+}
+
+func TestPrefixQuotasScopeEviction(t *testing.T) {
+	c, err := NewOpts(t.TempDir(), Options{MaxBytes: 100, PrefixQuotas: map[string]int64{"big": 10}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer c.Close()
+	load := func(key string, size int) error {
+		_, err := c.LoadOrCreate(key, func(f *os.File) (int64, error) {
+			n, err := f.Write(make([]byte, size))
+			return int64(n), err
+		})
+		return err
+	}
+	for _, key := range []string{"small/a", "small/b", "top.txt"} {
+		if err := load(key, 5); err != nil {
+			t.Fatalf("load %s: %v", key, err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := load(fmt.Sprintf("big/%d", i), 4); err != nil {
+			t.Fatalf("load big/%d: %v", i, err)
+		}
+	}
+	for _, key := range []string{"small/a", "small/b", "top.txt", "big/3", "big/4"} {
+		if _, ok := c.PathFor(key); !ok {
+			t.Fatalf("%s was evicted", key)
+		}
+	}
+	if _, ok := c.PathFor("big/2"); ok {
+		t.Fatalf("big/2 kept beyond the prefix quota")
+	}
+	if used := c.Stats().PrefixUsed["big"]; used != 8 {
+		t.Fatalf("big prefix uses %d bytes, want 8", used)
+	}
+	if err := load("big/huge", 11); err == nil {
+		t.Fatalf("expected an error for an entry larger than its quota")
+	}
+}
+
+func TestOnEvictRunsOutsideTheLock(t *testing.T) {
+	var (
+		c       *Cache
+		evicted []string
+	)
+	c, err := NewOpts(t.TempDir(), Options{MaxEntries: 2, OnEvict: func(key string, size int64) {
+		// Calling back into the cache would deadlock if the mutex were held.
+		evicted = append(evicted, fmt.Sprintf("%s:%d:%d", key, size, c.Stats().Entries))
+	}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer c.Close()
+	for _, key := range []string{"a", "bb", "ccc"} {
+		content := key
+		if _, err := c.LoadOrCreate(key, func(f *os.File) (int64, error) {
+			n, err := f.WriteString(content)
+			return int64(n), err
+		}); err != nil {
+			t.Fatalf("load %s: %v", key, err)
+		}
+	}
+	c.Remove("bb")
+	c.Remove("missing")
+	if got := fmt.Sprint(evicted); got != "[a:1:2 bb:2:1]" {
+		t.Fatalf("evicted = %s", got)
+	}
+}
+
+func TestStagingDirMovesFinishedDownloads(t *testing.T) {
+	for _, crossDevice := range []bool{false, true} {
+		if crossDevice {
+			renameFile = func(oldpath, newpath string) error {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+			}
+		}
+		staging := t.TempDir()
+		c, err := NewOpts(t.TempDir(), Options{MaxBytes: 1 << 20, StagingDir: staging})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		path, err := c.LoadOrCreate("k", func(f *os.File) (int64, error) {
+			if filepath.Dir(f.Name()) != staging {
+				t.Fatalf("download written to %s, want the staging dir", f.Name())
+			}
+			n, err := f.WriteString("content")
+			return int64(n), err
+		})
+		c.Close()
+		renameFile = os.Rename
+		if err != nil {
+			t.Fatalf("cross device %v: load: %v", crossDevice, err)
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != "content" {
+			t.Fatalf("cross device %v: cache file = %q, %v", crossDevice, data, err)
+		}
+		if left, _ := os.ReadDir(staging); len(left) != 0 {
+			t.Fatalf("cross device %v: staging dir not emptied: %v", crossDevice, left)
+		}
+	}
+}
+
+func TestStagingDirLeftoversRemoved(t *testing.T) {
+	dir, staging := t.TempDir(), t.TempDir()
+	leftover := filepath.Join(staging, stagePrefix(dir)+"12345")
+	foreign := filepath.Join(staging, stagePrefix(t.TempDir())+"12345")
+	for _, name := range []string{leftover, foreign} {
+		if err := os.WriteFile(name, []byte("partial"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	c, err := NewOpts(dir, Options{MaxBytes: 1 << 20, StagingDir: staging})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer c.Close()
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Fatalf("leftover of this cache kept: %v", err)
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Fatalf("file of another cache removed: %v", err)
+	}
+
+	fetchErr := errors.New("connection reset")
+	if _, err := c.LoadOrCreate("k", func(f *os.File) (int64, error) {
+		f.WriteString("part")
+		return 0, fetchErr
+	}); !errors.Is(err, fetchErr) {
+		t.Fatalf("load = %v, want the fetch error", err)
+	}
+	func() {
+		defer func() { _ = recover() }()
+		c.LoadOrCreate("k", func(f *os.File) (int64, error) {
+			f.WriteString("part")
+			panic("fetch panicked")
+		})
+	}()
+	if left, _ := filepath.Glob(filepath.Join(staging, stagePrefix(dir)+"*")); len(left) != 0 {
+		t.Fatalf("failed downloads left in the staging dir: %v", left)
+	}
+}
+
+func TestNamespaceSeparatesCacheFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := make(map[string]string)
+	for _, ns := range []string{"s3://a/", "s3://b/", ""} {
+		c, err := NewOpts(dir, Options{MaxBytes: 1 << 20, Namespace: ns})
+		if err != nil {
+			t.Fatalf("new %q: %v", ns, err)
+		}
+		path, err := c.LoadOrCreate("docs/report.txt", func(f *os.File) (int64, error) {
+			n, err := f.WriteString(ns)
+			return int64(n), err
+		})
+		c.Close()
+		if err != nil {
+			t.Fatalf("namespace %q: load: %v", ns, err)
+		}
+		for other, p := range paths {
+			if p == path {
+				t.Fatalf("namespaces %q and %q share cache file %s", ns, other, path)
+			}
+		}
+		paths[ns] = path
+	}
+}
+
+// waitForWaiters makes fillWaiting report on the returned channel until the
+// test ends.
+func waitForWaiters(t *testing.T) <-chan string {
+	waiting := make(chan string, 16)
+	fillWaiting = func(key string) { waiting <- key }
+	t.Cleanup(func() { fillWaiting = func(string) {} })
+	return waiting
+}
+
+func TestConcurrentLoadsShareOneFetch(t *testing.T) {
+	waiting := waitForWaiters(t)
+	c, err := New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer c.Close()
+	var fetches atomic.Int32
+	started, finish := make(chan struct{}), make(chan struct{})
+	fetch := func(f *os.File) (int64, error) {
+		if fetches.Add(1) == 1 {
+			close(started)
+		}
+		// Write half, then wait, like a download stalled mid-transfer.
+		if _, err := f.Write([]byte("hello ")); err != nil {
+			return 0, err
+		}
+		<-finish
+		n, err := f.Write([]byte("world"))
+		return int64(6 + n), err
+	}
+
+	var wg sync.WaitGroup
+	contents := make([]string, 4)
+	for i := range contents {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i > 0 {
+				<-started
+			}
+			path, release, err := c.Acquire("obj", fetch)
+			if err != nil {
+				t.Errorf("acquire: %v", err)
+				return
+			}
+			defer release()
+			data, _ := os.ReadFile(path)
+			contents[i] = string(data)
+		}(i)
+	}
+	<-started
+	for i := 1; i < len(contents); i++ {
+		<-waiting
+	}
+	close(finish)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("fetched %d times, want 1", n)
+	}
+	for i, got := range contents {
+		if got != "hello world" {
+			t.Fatalf("reader %d saw %q", i, got)
+		}
+	}
+}
+
+func TestLoadWaitersShareErrorsButRetryCancellations(t *testing.T) {
+	waiting := waitForWaiters(t)
+	c, err := New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer c.Close()
+	for _, tt := range []struct {
+		err   error
+		retry bool
+	}{
+		{err: errors.New("boom"), retry: false},
+		{err: context.Canceled, retry: true},
+	} {
+		key := tt.err.Error()
+		started, finish := make(chan struct{}), make(chan struct{})
+		go func() {
+			_, _ = c.LoadOrCreate(key, func(f *os.File) (int64, error) {
+				close(started)
+				<-finish
+				return 0, tt.err
+			})
+		}()
+		<-started
+		done := make(chan error)
+		go func() {
+			_, err := c.LoadOrCreate(key, func(f *os.File) (int64, error) {
+				n, err := f.Write([]byte("x"))
+				return int64(n), err
+			})
+			done <- err
+		}()
+		<-waiting
+		close(finish)
+		err := <-done
+		if tt.retry && err != nil {
+			t.Fatalf("waiter after %v: %v, want its own fetch", tt.err, err)
+		}
+		if !tt.retry && !errors.Is(err, tt.err) {
+			t.Fatalf("waiter after %v got %v", tt.err, err)
+		}
+	}
+}
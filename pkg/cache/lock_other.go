@@ -0,0 +1,22 @@
+//go:build !unix
+
+package cache
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockDir only creates the lock file on platforms without flock, so sharing
+// a cache directory is not detected there.
+func lockDir(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open cache lock: %w", err)
+	}
+	return f, nil
+}
+
+func unlockDir(f *os.File) error {
+	return f.Close()
+}
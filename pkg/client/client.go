@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"example.com/s3rofs/pkg/remotefs"
+)
+
+// Client talks to a RemoteFS daemon over its HTTP IPC API.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// New constructs a Client for the daemon at addr. addr may be a Unix socket
+// path (either "unix:///tmp/remotefs.sock" or a bare "/tmp/remotefs.sock") or
+// an HTTP base URL such as "http://127.0.0.1:8484".
+func New(addr string) (*Client, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return nil, fmt.Errorf("daemon address is required")
+	}
+	socket := ""
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		socket = strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "/"):
+		socket = addr
+	}
+	if socket != "" {
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &Client{
+			baseURL: "http://unix",
+			http:    &http.Client{Transport: transport},
+		}, nil
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if _, err := url.Parse(addr); err != nil {
+		return nil, fmt.Errorf("parse daemon address: %w", err)
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(addr, "/"),
+		http:    http.DefaultClient,
+	}, nil
+}
+
+// Stat returns metadata for the provided local path.
+func (c *Client) Stat(ctx context.Context, path string) (remotefs.POSIXEntry, error) {
+	var entry remotefs.POSIXEntry
+	err := c.getJSON(ctx, "/stat", path, &entry)
+	return entry, err
+}
+
+// List returns the entries beneath the provided local directory.
+func (c *Client) List(ctx context.Context, path string) ([]remotefs.POSIXEntry, error) {
+	var entries []remotefs.POSIXEntry
+	if err := c.getJSON(ctx, "/ls", path, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Cat opens the content of the provided local file. The caller must close the
+// returned reader.
+func (c *Client) Cat(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, "/cat", path)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// VerifyCache asks the daemon to compare its cached files with the remote
+// objects. With prune set, stale and missing entries are evicted.
+func (c *Client) VerifyCache(ctx context.Context, prune bool) (remotefs.CacheVerifyReport, error) {
+	var report remotefs.CacheVerifyReport
+	method := http.MethodGet
+	query := url.Values{}
+	if prune {
+		method = http.MethodPost
+		query.Set("prune", "true")
+	}
+	resp, err := c.do(ctx, method, "/cache/verify", "", query)
+	if err != nil {
+		return report, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return report, fmt.Errorf("decode /cache/verify: %w", err)
+	}
+	return report, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, endpoint, path string, dst interface{}) error {
+	resp, err := c.get(ctx, endpoint, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+		return fmt.Errorf("decode %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// get issues a GET for path and converts non-200 responses into errors. A 404
+// is mapped to remotefs.NotFoundError so callers can keep using
+// remotefs.IsNotFound.
+func (c *Client) get(ctx context.Context, endpoint, path string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, endpoint, path, nil)
+}
+
+// do issues the request with path and any extra query parameters, converting
+// non-200 responses into errors like get.
+func (c *Client) do(ctx context.Context, method, endpoint, path string, query url.Values) (*http.Response, error) {
+	u := c.baseURL + endpoint
+	if query == nil {
+		query = url.Values{}
+	}
+	if path != "" {
+		query.Set("path", path)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", endpoint, path, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	payload := decodeError(resp.Body)
+	if payload.Code == remotefs.ErrorCodeNotFound || resp.StatusCode == http.StatusNotFound {
+		return nil, remotefs.NotFoundError{Path: path}
+	}
+	if payload.Error == "" {
+		payload.Error = resp.Status
+	}
+	return nil, &Error{
+		Endpoint: endpoint,
+		Path:     path,
+		Status:   resp.StatusCode,
+		Code:     payload.Code,
+		Message:  payload.Error,
+	}
+}
+
+// Error is returned for failed requests other than missing paths. Code holds
+// the machine-readable remotefs.ErrorCode value, empty for daemons that
+// predate error codes.
+type Error struct {
+	Endpoint string
+	Path     string
+	Status   int
+	Code     string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Endpoint, e.Path, e.Message)
+}
+
+// ErrorCode returns the daemon's error code carried by err, or "" when err
+// did not come from a daemon response.
+func ErrorCode(err error) string {
+	var target *Error
+	if errors.As(err, &target) {
+		return target.Code
+	}
+	if remotefs.IsNotFound(err) {
+		return remotefs.ErrorCodeNotFound
+	}
+	return ""
+}
+
+func decodeError(r io.Reader) remotefs.ErrorResponse {
+	var payload remotefs.ErrorResponse
+	_ = json.NewDecoder(r).Decode(&payload)
+	return payload
+}
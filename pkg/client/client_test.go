@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"example.com/s3rofs/pkg/objectstore"
+	"example.com/s3rofs/pkg/remotefs"
+)
+
+type memStore struct {
+	files map[string][]byte
+}
+
+func (m *memStore) Head(ctx context.Context, key string) (objectstore.FileMeta, error) {
+	data, ok := m.files[key]
+	if !ok {
+		return objectstore.FileMeta{}, objectstore.NotFoundError{Key: key}
+	}
+	return objectstore.FileMeta{Path: key, Size: int64(len(data))}, nil
+}
+
+func (m *memStore) List(ctx context.Context, key string) ([]objectstore.FileMeta, error) {
+	var out []objectstore.FileMeta
+	for name, data := range m.files {
+		if filepath.Dir(name) == key || (key == "" && filepath.Dir(name) == ".") {
+			out = append(out, objectstore.FileMeta{Path: name, Size: int64(len(data))})
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	data, ok := m.files[key]
+	if !ok {
+		return objectstore.NotFoundError{Key: key}
+	}
+	_, err := dst.WriteAt(data, 0)
+	return err
+}
+
+func (m *memStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	store := &memStore{files: map[string][]byte{"docs/report.txt": []byte("hello world")}}
+	fs, err := remotefs.New(store, remotefs.Config{
+		LocalRoot: "/data",
+		CacheDir:  t.TempDir(),
+		CacheSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	return httptest.NewServer(ipc.Handler())
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	c, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx := context.Background()
+
+	entry, err := c.Stat(ctx, "/data/docs/report.txt")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if entry.Path != "docs/report.txt" || entry.Size != 11 {
+		t.Fatalf("unexpected stat entry: %+v", entry)
+	}
+
+	entries, err := c.List(ctx, "/data/docs")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "docs/report.txt" {
+		t.Fatalf("unexpected list entries: %+v", entries)
+	}
+
+	body, err := c.Cat(ctx, "/data/docs/report.txt")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("read cat body: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Fatalf("cat returned %q", string(data))
+	}
+
+	if _, err := c.Stat(ctx, "/data/missing.txt"); !remotefs.IsNotFound(err) {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+	if _, err := c.Stat(ctx, "/etc/passwd"); ErrorCode(err) != remotefs.ErrorCodeInvalidPath {
+		t.Fatalf("path outside the root: got %v (code %q), want %s", err, ErrorCode(err), remotefs.ErrorCodeInvalidPath)
+	}
+}
+
+func TestClientKeysWithSpecialCharacters(t *testing.T) {
+	keys := []string{
+		"docs/my report (final).txt",
+		"docs/a+b=c.txt",
+		"docs/#1 draft?.txt",
+		"docs/100% done&dusted.txt",
+		"docs/résumé 日本語.txt",
+		"docs/trailing space.txt ",
+	}
+	store := &memStore{files: map[string][]byte{}}
+	for _, key := range keys {
+		store.files[key] = []byte(key)
+	}
+	fs, err := remotefs.New(store, remotefs.Config{LocalRoot: "/data"})
+	if err != nil {
+		t.Fatalf("init remotefs: %v", err)
+	}
+	ipc, err := remotefs.NewIPCServer(fs)
+	if err != nil {
+		t.Fatalf("init IPC server: %v", err)
+	}
+	ts := httptest.NewServer(ipc.Handler())
+	defer ts.Close()
+	c, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, key := range keys {
+		entry, err := c.Stat(ctx, "/data/"+key)
+		if err != nil {
+			t.Fatalf("stat %q: %v", key, err)
+		}
+		if entry.Path != key {
+			t.Fatalf("stat %q returned path %q", key, entry.Path)
+		}
+		body, err := c.Cat(ctx, "/data/"+key)
+		if err != nil {
+			t.Fatalf("cat %q: %v", key, err)
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil || string(data) != key {
+			t.Fatalf("cat %q = %q, %v", key, data, err)
+		}
+	}
+
+	// Hand-built URLs must percent-encode the path: an unescaped "+" is a
+	// space in a query string.
+	resp, err := http.Get(ts.URL + "/cat?path=" + url.QueryEscape("/data/docs/a+b=c.txt"))
+	if err != nil {
+		t.Fatalf("cat request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("escaped plus sign = %d, want 200", resp.StatusCode)
+	}
+	resp, err = http.Get(ts.URL + "/cat?path=/data/docs/a+b%3Dc.txt")
+	if err != nil {
+		t.Fatalf("cat request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unescaped plus sign = %d, want 404 for \"a b=c.txt\"", resp.StatusCode)
+	}
+}
+
+func TestNewAddressForms(t *testing.T) {
+	tests := []struct {
+		addr string
+		base string
+	}{
+		{addr: "unix:///tmp/remotefs.sock", base: "http://unix"},
+		{addr: "/tmp/remotefs.sock", base: "http://unix"},
+		{addr: "127.0.0.1:8484", base: "http://127.0.0.1:8484"},
+		{addr: "http://localhost:8484/", base: "http://localhost:8484"},
+	}
+	for _, tt := range tests {
+		c, err := New(tt.addr)
+		if err != nil {
+			t.Fatalf("New(%q): %v", tt.addr, err)
+		}
+		if c.baseURL != tt.base {
+			t.Fatalf("New(%q) base = %q, want %q", tt.addr, c.baseURL, tt.base)
+		}
+		if tt.base == "http://unix" && c.http == http.DefaultClient {
+			t.Fatalf("New(%q) should use a unix socket transport", tt.addr)
+		}
+	}
+	if _, err := New(""); err == nil {
+		t.Fatalf("expected error for empty address")
+	}
+}
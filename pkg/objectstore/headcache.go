@@ -0,0 +1,120 @@
+package objectstore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// HeadCache is an ObjectStore decorator that remembers successful Head
+// results in memory. List and Download pass through untouched. Apart from
+// Sizer, which it answers from the cached Head results, it implements no
+// optional interface itself: callers reach those of the wrapped store through
+// As and the helpers in this package, which see through Unwrap.
+type HeadCache struct {
+	ObjectStore
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type headCacheEntry struct {
+	key     string
+	meta    FileMeta
+	expires time.Time
+}
+
+// WithHeadCache wraps store so Head answers are reused for ttl. At most
+// maxEntries results are kept, evicting the least recently used one; a
+// non-positive maxEntries leaves the cache unbounded. Uploads made with
+// UploadWith drop the cached entry for the written key.
+func WithHeadCache(store ObjectStore, ttl time.Duration, maxEntries int) *HeadCache {
+	return &HeadCache{
+		ObjectStore: store,
+		ttl:         ttl,
+		maxEntries:  maxEntries,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// Head returns the cached metadata for key when it has not expired and asks
+// the wrapped store otherwise. Errors are not cached.
+func (c *HeadCache) Head(ctx context.Context, key string) (FileMeta, error) {
+	if meta, ok := c.lookup(key); ok {
+		return meta, nil
+	}
+	meta, err := c.ObjectStore.Head(ctx, key)
+	if err != nil {
+		return meta, err
+	}
+	c.store(key, meta)
+	return meta, nil
+}
+
+// Size returns the length of key from the cached Head result, and otherwise
+// through Head, so the answer is cached for later calls.
+func (c *HeadCache) Size(ctx context.Context, key string) (int64, error) {
+	meta, err := c.Head(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return meta.Size, nil
+}
+
+func (c *HeadCache) lookup(key string) (FileMeta, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return FileMeta{}, false
+	}
+	entry := elem.Value.(*headCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return FileMeta{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.meta, true
+}
+
+func (c *HeadCache) store(key string, meta FileMeta) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &headCacheEntry{key: key, meta: meta, expires: time.Now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*headCacheEntry).key)
+	}
+}
+
+// Invalidate drops the cached Head result for key.
+func (c *HeadCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// Unwrap returns the wrapped store, through which As and the helpers in this
+// package find optional interfaces.
+func (c *HeadCache) Unwrap() ObjectStore {
+	return c.ObjectStore
+}
@@ -0,0 +1,171 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// countingStore answers Head from memory and counts the calls.
+type countingStore struct {
+	heads   map[string]int
+	uploads []string
+}
+
+func (s *countingStore) Head(ctx context.Context, key string) (FileMeta, error) {
+	s.heads[key]++
+	if key == "missing" {
+		return FileMeta{}, NotFoundError{Key: key}
+	}
+	return FileMeta{Path: key, Size: int64(s.heads[key])}, nil
+}
+
+func (s *countingStore) List(ctx context.Context, key string) ([]FileMeta, error) {
+	return nil, nil
+}
+
+func (s *countingStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	return io.EOF
+}
+
+func (s *countingStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *countingStore) Upload(ctx context.Context, key string, r io.Reader, size int64) error {
+	s.uploads = append(s.uploads, key)
+	return nil
+}
+
+func TestHeadCache(t *testing.T) {
+	inner := &countingStore{heads: make(map[string]int)}
+	store := WithHeadCache(inner, time.Minute, 2)
+	ctx := context.Background()
+	head := func(key string) FileMeta {
+		t.Helper()
+		meta, err := store.Head(ctx, key)
+		if err != nil {
+			t.Fatalf("head %s: %v", key, err)
+		}
+		return meta
+	}
+
+	head("a")
+	head("a")
+	if inner.heads["a"] != 1 {
+		t.Fatalf("repeated Head reached the store %d times", inner.heads["a"])
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := store.Head(ctx, "missing"); !IsNotFound(err) {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	}
+	if inner.heads["missing"] != 2 {
+		t.Fatalf("errors must not be cached")
+	}
+
+	head("b")
+	head("c")
+	head("a")
+	if inner.heads["a"] != 2 {
+		t.Fatalf("least recently used entry was not evicted")
+	}
+
+	if err := UploadWith(ctx, store, "c", nil, 0, UploadOptions{}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if meta := head("c"); meta.Size != 2 || len(inner.uploads) != 1 {
+		t.Fatalf("upload did not invalidate the cached entry: %+v", meta)
+	}
+
+	store.entries["c"].Value.(*headCacheEntry).expires = time.Now().Add(-time.Second)
+	if meta := head("c"); meta.Size != 3 {
+		t.Fatalf("expired entry was served: %+v", meta)
+	}
+}
+
+// sizingStore answers Size without a Head.
+type sizingStore struct {
+	countingStore
+	sizes int
+}
+
+func (s *sizingStore) Size(ctx context.Context, key string) (int64, error) {
+	s.sizes++
+	return 42, nil
+}
+
+func TestSizeOf(t *testing.T) {
+	ctx := context.Background()
+	plain := &countingStore{heads: make(map[string]int)}
+	if size, err := SizeOf(ctx, plain, "a"); err != nil || size != 1 || plain.heads["a"] != 1 {
+		t.Fatalf("Head fallback = %d, %v", size, err)
+	}
+	if _, err := SizeOf(ctx, plain, "missing"); !IsNotFound(err) {
+		t.Fatalf("missing key = %v, want not found", err)
+	}
+
+	sized := &sizingStore{countingStore: countingStore{heads: make(map[string]int)}}
+	if size, err := SizeOf(ctx, sized, "a"); err != nil || size != 42 || sized.heads["a"] != 0 {
+		t.Fatalf("Sizer = %d, %v with %d heads", size, err, sized.heads["a"])
+	}
+
+	cached := WithHeadCache(sized, time.Minute, 0)
+	if _, err := cached.Head(ctx, "b"); err != nil {
+		t.Fatalf("head: %v", err)
+	}
+	if size, err := SizeOf(ctx, cached, "b"); err != nil || size != 1 || sized.sizes != 1 {
+		t.Fatalf("cached Head was not used for the size: %d, %v", size, err)
+	}
+	// A miss goes through the cache's Head, so the next call is answered
+	// from the cache, also when another wrapper sits in front of it.
+	outer := passthroughStore{cached}
+	for i := 0; i < 2; i++ {
+		if size, err := SizeOf(ctx, outer, "c"); err != nil || size != 1 || sized.heads["c"] != 1 || sized.sizes != 1 {
+			t.Fatalf("call %d: size = %d, %v with %d heads, %d sizes", i, size, err, sized.heads["c"], sized.sizes)
+		}
+	}
+}
+
+// passthroughStore is a Wrapper that adds nothing to the store it wraps.
+type passthroughStore struct {
+	ObjectStore
+}
+
+func (p passthroughStore) Unwrap() ObjectStore { return p.ObjectStore }
+
+// markerStore adds directory markers to countingStore.
+type markerStore struct {
+	countingStore
+}
+
+func (s *markerStore) HeadDirMarker(ctx context.Context, key string) error {
+	return nil
+}
+
+func TestHeadCacheForwardsOnlyInnerCapabilities(t *testing.T) {
+	ctx := context.Background()
+	plain := WithHeadCache(&countingStore{heads: make(map[string]int)}, time.Minute, 0)
+	var store ObjectStore = plain
+	if _, ok := store.(DirMarkerHeader); ok {
+		t.Fatalf("HeadCache claims a capability of its own")
+	}
+	if _, ok := As[LimitedLister](store); ok {
+		t.Fatalf("As found a LimitedLister the wrapped store lacks")
+	}
+	if _, ok := As[Selector](store); ok {
+		t.Fatalf("As found a Selector the wrapped store lacks")
+	}
+	if err := HeadDirMarker(ctx, store, "a"); err != ErrDirMarkersNotSupported {
+		t.Fatalf("HeadDirMarker = %v, want ErrDirMarkersNotSupported", err)
+	}
+
+	marked := WithHeadCache(&markerStore{countingStore{heads: make(map[string]int)}}, time.Minute, 0)
+	if _, ok := As[DirMarkerHeader](marked); !ok {
+		t.Fatalf("As did not see through Unwrap")
+	}
+	if err := HeadDirMarker(ctx, marked, "a"); err != nil {
+		t.Fatalf("HeadDirMarker through the cache = %v", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"sort"
 	"time"
 )
 
@@ -15,6 +17,40 @@ type FileMeta struct {
 	ETag         string
 	LastModified time.Time
 	IsDir        bool
+	// ChildCount is the number of direct children of a directory. It is only
+	// populated for directories enumerated by a metadata warm.
+	ChildCount int
+	// Incomplete marks a directory enumerated by a metadata warm whose Size
+	// and ChildCount leave out subtrees the warm could not list or did not
+	// descend into because of a depth limit.
+	Incomplete bool
+	// ChecksumSHA256 is the hex encoded SHA-256 of the full object when the
+	// backend stores one. It is empty when unknown.
+	ChecksumSHA256 string
+	// Owner is the account owning the object. It is only set by stores asked
+	// to report owners, such as an S3Store built with WithFetchOwner.
+	Owner *Owner
+	// Lock is the Object Lock status of the object. It is only set when
+	// asked for through LockGetter, for example by remotefs StatLocks.
+	Lock *ObjectLock
+}
+
+// ObjectLock is the Object Lock status of an object.
+type ObjectLock struct {
+	// Mode is the retention mode, "GOVERNANCE" or "COMPLIANCE", or empty
+	// when the object has no retention period.
+	Mode string `json:"Mode,omitempty"`
+	// RetainUntil is the end of the retention period. It is zero without
+	// one.
+	RetainUntil time.Time `json:"RetainUntil"`
+	// LegalHold reports whether a legal hold is on.
+	LegalHold bool `json:"LegalHold"`
+}
+
+// Owner identifies the account that owns an object.
+type Owner struct {
+	ID          string `json:"ID"`
+	DisplayName string `json:"DisplayName,omitempty"`
 }
 
 var ErrNotFound = errors.New("object not found")
@@ -22,15 +58,30 @@ var ErrNotFound = errors.New("object not found")
 // NotFoundError conveys that a specific object key was not found in the store.
 type NotFoundError struct {
 	Key string
+	// FullKey is the fully qualified location the store looked up, such as
+	// "s3://bucket/prefix/key", when the backend knows it.
+	FullKey string
 }
 
 func (e NotFoundError) Error() string {
 	if e.Key == "" {
 		return "object not found"
 	}
+	if e.FullKey != "" {
+		return fmt.Sprintf("%s: not found (%s)", e.Key, e.FullKey)
+	}
 	return fmt.Sprintf("%s: not found", e.Key)
 }
 
+// FullKeyOf returns the FullKey carried by a NotFoundError within err.
+func FullKeyOf(err error) string {
+	var nf NotFoundError
+	if errors.As(err, &nf) {
+		return nf.FullKey
+	}
+	return ""
+}
+
 func (e NotFoundError) Unwrap() error {
 	return ErrNotFound
 }
@@ -40,15 +91,558 @@ func IsNotFound(err error) bool {
 	return errors.Is(err, ErrNotFound)
 }
 
+// NotModifiedError is returned by ConditionalDownloader when the object has
+// not changed since the requested time, in which case nothing was written.
+type NotModifiedError struct {
+	Key string
+}
+
+func (e NotModifiedError) Error() string {
+	return fmt.Sprintf("%s: not modified", e.Key)
+}
+
+// IsNotModified reports whether err is a NotModifiedError.
+func IsNotModified(err error) bool {
+	var target NotModifiedError
+	return errors.As(err, &target)
+}
+
+// ObjectChangedError is returned by Download when the object was replaced
+// while a partial transfer was being resumed. The bytes written so far belong
+// to the old version, so callers should discard them and start over.
+type ObjectChangedError struct {
+	Key string
+	// ETag is the version the transfer started with.
+	ETag string
+}
+
+func (e ObjectChangedError) Error() string {
+	return fmt.Sprintf("%s: object changed during download (started at ETag %s)", e.Key, e.ETag)
+}
+
+// IsObjectChanged reports whether err is an ObjectChangedError.
+func IsObjectChanged(err error) bool {
+	var target ObjectChangedError
+	return errors.As(err, &target)
+}
+
+// AlreadyExistsError is returned by uploads with UploadOptions.IfNoneMatch set
+// when an object already exists under the key. Nothing was written.
+type AlreadyExistsError struct {
+	Key string
+}
+
+func (e AlreadyExistsError) Error() string {
+	return fmt.Sprintf("%s: object already exists", e.Key)
+}
+
+// IsAlreadyExists reports whether err is an AlreadyExistsError.
+func IsAlreadyExists(err error) bool {
+	var target AlreadyExistsError
+	return errors.As(err, &target)
+}
+
+// BucketNotFoundError reports that the configured bucket does not exist.
+type BucketNotFoundError struct {
+	Bucket string
+}
+
+func (e BucketNotFoundError) Error() string {
+	return fmt.Sprintf("bucket %s does not exist", e.Bucket)
+}
+
+// IsBucketNotFound reports whether err is a BucketNotFoundError.
+func IsBucketNotFound(err error) bool {
+	var target BucketNotFoundError
+	return errors.As(err, &target)
+}
+
+// WrongRegionError reports that the bucket lives in another region than the
+// client was configured for. Region is the bucket's region when the backend
+// named it and empty otherwise.
+type WrongRegionError struct {
+	Bucket string
+	Region string
+}
+
+func (e WrongRegionError) Error() string {
+	if e.Region == "" {
+		return fmt.Sprintf("bucket %s is in a different region", e.Bucket)
+	}
+	return fmt.Sprintf("bucket %s is in region %s", e.Bucket, e.Region)
+}
+
+// IsWrongRegion reports whether err is a WrongRegionError.
+func IsWrongRegion(err error) bool {
+	var target WrongRegionError
+	return errors.As(err, &target)
+}
+
+// ConditionalDownloader is implemented by stores that can skip the transfer
+// of an object that has not changed since a given time.
+type ConditionalDownloader interface {
+	// DownloadIfModifiedSince behaves like Download but returns a
+	// NotModifiedError without writing to dst when the object was not
+	// modified after since.
+	DownloadIfModifiedSince(ctx context.Context, key string, dst io.WriterAt, since time.Time) error
+}
+
+// ETagDownloader is implemented by stores that can skip the transfer of an
+// object whose ETag still matches one recorded earlier.
+type ETagDownloader interface {
+	// DownloadIfNoneMatch behaves like Download but returns a
+	// NotModifiedError without writing to dst when the object's ETag is
+	// still etag.
+	DownloadIfNoneMatch(ctx context.Context, key string, dst io.WriterAt, etag string) error
+}
+
+// VersionRecorder is implemented by download destinations that keep the
+// version of the object written into them. Stores that learn the ETag and
+// LastModified from the download response report them before the first
+// write.
+type VersionRecorder interface {
+	RecordVersion(etag string, modified time.Time)
+}
+
+// SizeRecorder is implemented by download destinations that want the object
+// size before the content arrives, for example to reserve disk space. Stores
+// that learn it from the download response report it before the first write.
+type SizeRecorder interface {
+	RecordSize(size int64)
+}
+
+// httpStatus returns the HTTP status code carried by an SDK error, or 0.
+func httpStatus(err error) int {
+	var resp interface{ HTTPStatusCode() int }
+	if errors.As(err, &resp) {
+		return resp.HTTPStatusCode()
+	}
+	return 0
+}
+
+// IsAccessDenied reports whether err is a 403 Forbidden answer from the
+// backend, typically a credential or bucket policy problem.
+func IsAccessDenied(err error) bool {
+	return httpStatus(err) == http.StatusForbidden
+}
+
+// PartialListError reports that a listing failed part way through. The entries
+// gathered before the failure are returned alongside it, and Token can be
+// handed to ResumableLister.ListFrom to pick up where the listing stopped.
+type PartialListError struct {
+	Key   string
+	Token string
+	Err   error
+}
+
+func (e *PartialListError) Error() string {
+	return fmt.Sprintf("list %s interrupted (resume token %q): %v", e.Key, e.Token, e.Err)
+}
+
+func (e *PartialListError) Unwrap() error {
+	return e.Err
+}
+
+// AsPartialList extracts a PartialListError from err when present.
+func AsPartialList(err error) (*PartialListError, bool) {
+	var partial *PartialListError
+	if errors.As(err, &partial) {
+		return partial, true
+	}
+	return nil, false
+}
+
+// LimitedLister is implemented by stores that can stop a listing early instead
+// of enumerating every child of a huge prefix.
+type LimitedLister interface {
+	// ListLimit returns at most limit direct children of key and reports
+	// whether more entries exist beyond the cap.
+	ListLimit(ctx context.Context, key string, limit int) ([]FileMeta, bool, error)
+}
+
+// ResumableLister is implemented by stores that can continue a listing from
+// the token carried by a PartialListError.
+type ResumableLister interface {
+	ListFrom(ctx context.Context, key, token string) ([]FileMeta, error)
+}
+
+// Opener is implemented by stores that can stream an object's content
+// sequentially without an io.WriterAt destination.
+type Opener interface {
+	// Open returns the object body. The caller must close it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// SizedBody is implemented by object bodies that know their length up front,
+// such as those S3Store.Open returns with the Content-Length of the GET.
+type SizedBody interface {
+	io.ReadCloser
+	// Size returns the length of the body in bytes.
+	Size() int64
+}
+
+// Uploader is implemented by stores that accept writes.
+type Uploader interface {
+	// Upload stores the content of r under key. size is the content length
+	// in bytes, or -1 when it is not known in advance.
+	Upload(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// UploadOptions carries optional attributes for an upload.
+type UploadOptions struct {
+	// ContentType is stored as the object's Content-Type. Empty leaves the
+	// backend default.
+	ContentType string
+	// IfNoneMatch only creates the object when nothing exists under the key
+	// yet, returning an AlreadyExistsError otherwise.
+	IfNoneMatch bool
+	// ChecksumSHA256 sends the SHA-256 of every request body so the store
+	// verifies the bytes it receives and fails the upload on a mismatch.
+	// Stores that only implement Uploader ignore it.
+	ChecksumSHA256 bool
+}
+
+// OptionsUploader is implemented by uploaders that accept UploadOptions.
+// Implementations must honor IfNoneMatch.
+type OptionsUploader interface {
+	UploadWithOptions(ctx context.Context, key string, r io.Reader, size int64, opts UploadOptions) error
+}
+
+// UploadWith stores r under key through store with the attributes in opts.
+// Stores that only implement Uploader ignore ContentType, and IfNoneMatch is
+// emulated with a Head before the upload; another writer can still create the
+// object between the two requests.
+func UploadWith(ctx context.Context, store ObjectStore, key string, r io.Reader, size int64, opts UploadOptions) error {
+	defer Invalidate(store, key)
+	if withOpts, ok := As[OptionsUploader](store); ok {
+		return withOpts.UploadWithOptions(ctx, key, r, size, opts)
+	}
+	uploader, ok := As[Uploader](store)
+	if !ok {
+		return fmt.Errorf("object store does not support writes")
+	}
+	if opts.IfNoneMatch {
+		if err := checkAbsent(ctx, store, key); err != nil {
+			return err
+		}
+	}
+	return uploader.Upload(ctx, key, r, size)
+}
+
+// Invalidator is implemented by wrappers that remember object metadata and
+// must forget key once it has been written.
+type Invalidator interface {
+	Invalidate(key string)
+}
+
+// Invalidate drops what every Invalidator in the Unwrap chain of store
+// remembers about key, for callers that know the object changed.
+func Invalidate(store ObjectStore, key string) {
+	for store != nil {
+		if inv, ok := store.(Invalidator); ok {
+			inv.Invalidate(key)
+		}
+		wrapper, ok := store.(Wrapper)
+		if !ok {
+			return
+		}
+		store = wrapper.Unwrap()
+	}
+}
+
+// checkAbsent returns an AlreadyExistsError when store has an object at key.
+func checkAbsent(ctx context.Context, store ObjectStore, key string) error {
+	_, err := store.Head(ctx, key)
+	switch {
+	case err == nil:
+		return AlreadyExistsError{Key: key}
+	case IsNotFound(err):
+		return nil
+	default:
+		return err
+	}
+}
+
+// Locator is implemented by stores that can name the backend location they
+// read from, such as "s3://bucket/prefix".
+type Locator interface {
+	Location() string
+}
+
+// KeyMapper is implemented by stores that can translate a full object key,
+// such as one read from an inventory report, back into a relative path.
+type KeyMapper interface {
+	// RelKey returns the relative path for key and false when key lies
+	// outside the store's prefix.
+	RelKey(key string) (rel string, ok bool)
+}
+
+// ErrRangeNotSupported is returned by stores that cannot serve partial reads.
+// Callers should fall back to a full Download.
+var ErrRangeNotSupported = errors.New("range reads not supported")
+
+// RangeStore is implemented by stores that can read part of an object without
+// transferring the rest.
+type RangeStore interface {
+	// RangeReader returns n bytes of key starting at off. A non-positive n
+	// reads through the end of the object. The caller must close the reader.
+	RangeReader(ctx context.Context, key string, off, n int64) (io.ReadCloser, error)
+}
+
+// ReadRange reads part of key through store when it implements RangeStore and
+// returns ErrRangeNotSupported otherwise.
+func ReadRange(ctx context.Context, store ObjectStore, key string, off, n int64) (io.ReadCloser, error) {
+	ranger, ok := As[RangeStore](store)
+	if !ok {
+		return nil, ErrRangeNotSupported
+	}
+	return ranger.RangeReader(ctx, key, off, n)
+}
+
+// Sizer is implemented by stores that can report an object's length more
+// cheaply than a full Head.
+type Sizer interface {
+	// Size returns the length of key in bytes.
+	Size(ctx context.Context, key string) (int64, error)
+}
+
+// SizeOf returns the length of key through store when it implements Sizer,
+// which includes a HeadCache anywhere in the wrapper chain, and from a Head
+// request otherwise.
+func SizeOf(ctx context.Context, store ObjectStore, key string) (int64, error) {
+	if sizer, ok := As[Sizer](store); ok {
+		return sizer.Size(ctx, key)
+	}
+	meta, err := store.Head(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return meta.Size, nil
+}
+
+// ErrOwnerNotSupported is returned by OwnerOf for stores that cannot report
+// object ownership.
+var ErrOwnerNotSupported = errors.New("object owners not supported")
+
+// OwnerGetter is implemented by stores that can report who owns an object.
+type OwnerGetter interface {
+	GetOwner(ctx context.Context, key string) (Owner, error)
+}
+
+// OwnerOf returns the owner of key through store when it implements
+// OwnerGetter and ErrOwnerNotSupported otherwise.
+func OwnerOf(ctx context.Context, store ObjectStore, key string) (Owner, error) {
+	getter, ok := As[OwnerGetter](store)
+	if !ok {
+		return Owner{}, ErrOwnerNotSupported
+	}
+	return getter.GetOwner(ctx, key)
+}
+
+// ErrDirMarkersNotSupported is returned by HeadDirMarker for stores that
+// cannot address directory marker objects.
+var ErrDirMarkersNotSupported = errors.New("directory markers not supported")
+
+// DirMarkerHeader is implemented by stores that can check for the explicit
+// "key/" marker object some tools create for every directory.
+type DirMarkerHeader interface {
+	// HeadDirMarker returns nil when the marker of key exists and a
+	// NotFoundError when it does not.
+	HeadDirMarker(ctx context.Context, key string) error
+}
+
+// HeadDirMarker checks for the directory marker of key through store when it
+// implements DirMarkerHeader and returns ErrDirMarkersNotSupported otherwise.
+func HeadDirMarker(ctx context.Context, store ObjectStore, key string) error {
+	header, ok := As[DirMarkerHeader](store)
+	if !ok {
+		return ErrDirMarkersNotSupported
+	}
+	return header.HeadDirMarker(ctx, key)
+}
+
+// ErrObjectLockNotSupported is returned by ObjectLockOf for stores that
+// cannot report Object Lock status.
+var ErrObjectLockNotSupported = errors.New("object lock status not supported")
+
+// LockGetter is implemented by stores that can report the Object Lock
+// retention and legal hold of an object.
+type LockGetter interface {
+	GetObjectLock(ctx context.Context, key string) (ObjectLock, error)
+}
+
+// ObjectLockOf returns the Object Lock status of key through store when it
+// implements LockGetter and ErrObjectLockNotSupported otherwise.
+func ObjectLockOf(ctx context.Context, store ObjectStore, key string) (ObjectLock, error) {
+	getter, ok := As[LockGetter](store)
+	if !ok {
+		return ObjectLock{}, ErrObjectLockNotSupported
+	}
+	return getter.GetObjectLock(ctx, key)
+}
+
+// ListStats counts the requests behind one listing, for tracking what
+// listings cost.
+type ListStats struct {
+	// Pages is the number of list requests made, one per page of results.
+	Pages int
+	// Objects is the number of entries returned, including directories
+	// synthesized from common prefixes.
+	Objects int
+}
+
+// StatsLister is implemented by stores that report the requests a listing
+// took.
+type StatsLister interface {
+	ListWithStats(ctx context.Context, key string) ([]FileMeta, ListStats, error)
+}
+
+// ListWithStats lists key through store with the stats of the listing. Stores
+// that do not implement StatsLister are counted as a single page.
+func ListWithStats(ctx context.Context, store ObjectStore, key string) ([]FileMeta, ListStats, error) {
+	if lister, ok := As[StatsLister](store); ok {
+		return lister.ListWithStats(ctx, key)
+	}
+	items, err := store.List(ctx, key)
+	return items, ListStats{Pages: 1, Objects: len(items)}, err
+}
+
+// FlatLister is implemented by stores that can list every key below a
+// prefix in one listing, without collapsing nested keys into directories.
+type FlatLister interface {
+	// ListFlat returns at most limit objects below key, or all of them when
+	// limit is not positive, and reports whether more exist beyond the cap.
+	ListFlat(ctx context.Context, key string, limit int) ([]FileMeta, bool, error)
+}
+
+// ListFlat returns the objects below key as file entries whose Path is
+// relative to the store root, at most limit of them when limit is positive,
+// and reports whether the cap cut the listing short. Stores that do not
+// implement FlatLister are walked with List, one request per directory, and
+// the result is sorted by path like an S3 listing before it is capped.
+func ListFlat(ctx context.Context, store ObjectStore, key string, limit int) ([]FileMeta, bool, error) {
+	if lister, ok := As[FlatLister](store); ok {
+		return lister.ListFlat(ctx, key, limit)
+	}
+	var out []FileMeta
+	dirs := []string{key}
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		items, err := store.List(ctx, dir)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, item := range items {
+			if item.IsDir {
+				dirs = append(dirs, item.Path)
+				continue
+			}
+			out = append(out, item)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	if limit > 0 && len(out) > limit {
+		return out[:limit], true, nil
+	}
+	return out, false, nil
+}
+
+// ErrSelectNotSupported is returned when the store cannot evaluate queries
+// server side.
+var ErrSelectNotSupported = errors.New("select not supported")
+
+// SelectOptions describes how a Select query reads and writes records.
+type SelectOptions struct {
+	// InputFormat is "csv" (the default) or "json".
+	InputFormat string
+	// OutputFormat is "csv" or "json"; it defaults to InputFormat.
+	OutputFormat string
+	// CSVHeader controls the first CSV line: "use" to refer to columns by
+	// name, "ignore" to skip it, or "none" (the default) when there is no
+	// header.
+	CSVHeader string
+	// CSVDelimiter separates CSV input and output fields. Defaults to ",".
+	CSVDelimiter string
+	// JSONType is "lines" (the default) for newline-delimited JSON or
+	// "document" for a single JSON document.
+	JSONType string
+	// Compression is "none" (the default), "gzip", or "bzip2".
+	Compression string
+}
+
+// InvalidSelectError reports a Select call rejected before any request was
+// sent, because of a missing expression or an unknown option value.
+type InvalidSelectError struct {
+	Reason string
+}
+
+func (e InvalidSelectError) Error() string {
+	return "invalid select: " + e.Reason
+}
+
+// IsInvalidSelect reports whether err is an InvalidSelectError.
+func IsInvalidSelect(err error) bool {
+	var target InvalidSelectError
+	return errors.As(err, &target)
+}
+
+// Selector is implemented by stores that can filter an object server side
+// with an SQL expression and stream back only the matching records.
+type Selector interface {
+	Select(ctx context.Context, key, expression string, opts SelectOptions) (io.ReadCloser, error)
+}
+
+// DownloadStats is the timing breakdown of a single Download. TTFB covers the
+// request plus the first body read, so comparing it with Total separates
+// backend latency from transfer and local write time.
+type DownloadStats struct {
+	Key   string
+	TTFB  time.Duration
+	Total time.Duration
+	Bytes int64
+	Err   error
+}
+
 // ObjectStore abstracts the object storage provider used by RemoteFS.
 type ObjectStore interface {
 	// Head returns metadata for a single object. The caller is expected to pass
 	// normalized, slash-separated paths relative to the configured root.
 	Head(ctx context.Context, key string) (FileMeta, error)
 	// List returns metadata for all objects that are direct children of the
-	// provided key. The key may be "", representing the virtual root.
+	// provided key. The key may be "", representing the virtual root. When a
+	// listing fails after some entries were fetched, implementations may
+	// return those entries together with a *PartialListError.
 	List(ctx context.Context, key string) ([]FileMeta, error)
 	// Download streams the content of a single object into dst. Implementations
 	// must return io.EOF once the content is drained.
 	Download(ctx context.Context, key string, dst io.WriterAt) error
+	// Ping verifies that the store is reachable and the configured
+	// credentials can access it.
+	Ping(ctx context.Context) error
+}
+
+// Wrapper is implemented by decorators such as HeadCache that add behaviour
+// around another ObjectStore without taking on its optional interfaces.
+type Wrapper interface {
+	Unwrap() ObjectStore
+}
+
+// As reports whether store, or any store it wraps through Wrapper, implements
+// T, and returns the outermost one that does. Optional interfaces are probed
+// with As rather than a type assertion so wrappers expose exactly the
+// capabilities of the store underneath.
+func As[T any](store ObjectStore) (T, bool) {
+	for store != nil {
+		if found, ok := store.(T); ok {
+			return found, true
+		}
+		wrapper, ok := store.(Wrapper)
+		if !ok {
+			break
+		}
+		store = wrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
 }
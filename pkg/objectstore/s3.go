@@ -2,36 +2,224 @@ package objectstore
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// DefaultDownloadBufferSize is the chunk size used to copy object bodies into
+// the destination when no explicit size is configured.
+const DefaultDownloadBufferSize = 2 * 1024 * 1024
+
+// DefaultDownloadResumes is how many times a Download picks up an interrupted
+// body where it stopped before giving up.
+const DefaultDownloadResumes = 3
+
+// s3BucketAPI is the subset of *s3.Client used by Ping.
+type s3BucketAPI interface {
+	HeadBucket(ctx context.Context, in *s3.HeadBucketInput, opts ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// s3GetAPI is the subset of *s3.Client used by Download, split out so
+// interrupted transfers can be exercised without a live endpoint.
+type s3GetAPI interface {
+	GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// s3HeadAPI is the subset of *s3.Client used by GetObjectLock and
+// HeadDirMarker.
+type s3HeadAPI interface {
+	HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// s3ACLAPI is the subset of *s3.Client used by GetOwner.
+type s3ACLAPI interface {
+	GetObjectAcl(ctx context.Context, in *s3.GetObjectAclInput, opts ...func(*s3.Options)) (*s3.GetObjectAclOutput, error)
+}
+
 // S3Store implements the ObjectStore interface using an S3-compatible API.
 type S3Store struct {
 	client *s3.Client
 	bucket string
 	prefix string
+	// endpoint only feeds Location; see WithEndpoint.
+	endpoint string
+	// delimiter separates path segments in object keys. An empty delimiter
+	// lists flat, returning every key beneath a prefix.
+	delimiter string
+
+	bufSize int
+	bufPool *sync.Pool
+	// resumes bounds how often Download continues an interrupted body.
+	resumes int
+	// observe receives timing for every Download when set.
+	observe func(DownloadStats)
+
+	multipartThreshold int64
+	partSize           int64
+	uploadConcurrency  int
+	// headBeforeCreate emulates UploadOptions.IfNoneMatch with a Head for
+	// endpoints that do not support conditional writes.
+	headBeforeCreate bool
+
+	// mtimeMeta names the user metadata key, without the x-amz-meta-
+	// prefix, whose value overrides LastModified. Empty disables it.
+	mtimeMeta string
+	// fetchOwner asks listings to include each object's owner.
+	fetchOwner bool
+}
+
+// S3Option customizes an S3Store during construction.
+type S3Option func(*S3Store)
+
+// WithDownloadBufferSize overrides the buffer used to copy object bodies during
+// Download. Non-positive sizes keep the default.
+func WithDownloadBufferSize(size int) S3Option {
+	return func(s *S3Store) {
+		if size > 0 {
+			s.bufSize = size
+		}
+	}
+}
+
+// WithDownloadResumes sets how many times Download resumes a body that fails
+// mid-transfer. Each resume is a Range request pinned to the ETag of the first
+// response with If-Match, so bytes from two object versions are never
+// stitched together. Zero disables resuming; negative values keep the
+// default.
+func WithDownloadResumes(n int) S3Option {
+	return func(s *S3Store) {
+		if n >= 0 {
+			s.resumes = n
+		}
+	}
+}
+
+// WithDownloadObserver registers fn to receive the timing breakdown of every
+// Download, successful or not. fn runs on the downloading goroutine and should
+// return quickly.
+func WithDownloadObserver(fn func(DownloadStats)) S3Option {
+	return func(s *S3Store) {
+		s.observe = fn
+	}
+}
+
+// WithFetchOwner makes List set FetchOwner on ListObjectsV2 so every listed
+// file carries its Owner. Head does not return owners; use GetOwner for a
+// single object.
+func WithFetchOwner(enabled bool) S3Option {
+	return func(s *S3Store) {
+		s.fetchOwner = enabled
+	}
+}
+
+// UsePathStyle returns an s3.Options modifier for s3.NewFromConfig that
+// switches the client to path-style addressing (https://host/bucket/key).
+// MinIO and several other S3-compatible stores require it; with the default
+// virtual-host style they fail with DNS or TLS errors.
+func UsePathStyle(enabled bool) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.UsePathStyle = enabled
+	}
+}
+
+// WithDelimiter changes the separator used to synthesize directories from
+// object keys (default "/"). Keys are still exposed to callers with "/"
+// separated paths, so "a|b|c" under a "|" delimiter appears as "a/b/c", and
+// keys that themselves contain "/" are left out of listings since no path
+// addresses them. An empty delimiter disables directory collapsing and List
+// returns every key beneath the requested prefix.
+func WithDelimiter(delimiter string) S3Option {
+	return func(s *S3Store) {
+		s.delimiter = delimiter
+	}
+}
+
+// WithModTimeMetadata makes Head and List report the time stored in the named
+// user metadata header, such as "x-amz-meta-mtime", as LastModified. Values
+// may be Unix seconds (fractions allowed), RFC 3339, or an HTTP date; objects
+// without the header or with an unparseable value keep the S3 LastModified.
+// Listings do not carry user metadata, so each listed file costs an extra
+// HEAD request.
+func WithModTimeMetadata(header string) S3Option {
+	return func(s *S3Store) {
+		name := strings.ToLower(strings.TrimSpace(header))
+		s.mtimeMeta = strings.TrimPrefix(name, "x-amz-meta-")
+	}
+}
+
+// modTimeHeadConcurrency bounds the HEAD requests List issues to read
+// modification times from metadata.
+const modTimeHeadConcurrency = 8
+
+// WithEndpoint records the S3-compatible endpoint the client talks to, so
+// Location tells apart buckets of the same name on different endpoints. It
+// does not change where the client sends requests.
+func WithEndpoint(endpoint string) S3Option {
+	return func(s *S3Store) {
+		s.endpoint = endpoint
+	}
 }
 
 // NewS3Store instantiates an ObjectStore backed by an AWS SDK client and the
 // provided bucket/prefix pair.
-func NewS3Store(client *s3.Client, bucket, prefix string) *S3Store {
-	prefix = strings.Trim(prefix, "/")
-	if prefix != "" && !strings.HasSuffix(prefix, "/") {
-		prefix += "/"
+func NewS3Store(client *s3.Client, bucket, prefix string, opts ...S3Option) *S3Store {
+	s := &S3Store{
+		client:    client,
+		bucket:    bucket,
+		delimiter: "/",
+		bufSize:   DefaultDownloadBufferSize,
+		resumes:   DefaultDownloadResumes,
+
+		multipartThreshold: DefaultMultipartThreshold,
+		partSize:           DefaultPartSize,
+		uploadConcurrency:  DefaultUploadConcurrency,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	sep := s.separator()
+	prefix = strings.Trim(prefix, "/"+sep)
+	if prefix != "" {
+		prefix += sep
 	}
-	return &S3Store{
-		client: client,
-		bucket: bucket,
-		prefix: prefix,
+	s.prefix = prefix
+	s.bufPool = newBufferPool(s.bufSize)
+	return s
+}
+
+// newBufferPool returns a pool handing out byte slices of the provided size so
+// concurrent downloads can reuse copy buffers instead of allocating new ones.
+func newBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		},
+	}
+}
+
+// separator returns the string joining path segments inside object keys. Flat
+// listings still join the prefix and relative paths with "/".
+func (s *S3Store) separator() string {
+	if s.delimiter == "" {
+		return "/"
 	}
+	return s.delimiter
 }
 
 // key normalizes relative paths into fully qualified S3 object keys respecting
@@ -43,46 +231,363 @@ func (s *S3Store) key(rel string) string {
 		rel = ""
 	}
 	if rel == "" {
-		return strings.TrimSuffix(s.prefix, "/")
+		// The root is the prefix itself, separator included, so it never
+		// names a sibling object that happens to be called like the prefix
+		// ("data" next to "data/").
+		return s.prefix
 	}
+	rel = strings.ReplaceAll(rel, "/", s.separator())
 	if s.prefix == "" {
 		return rel
 	}
 	return s.prefix + rel
 }
 
+// Location returns the bucket and key prefix the store reads from, as
+// "s3://bucket/prefix", or as a URL below the endpoint recorded with
+// WithEndpoint, such as "http://minio:9000/bucket/prefix".
+func (s *S3Store) Location() string {
+	if s.endpoint == "" {
+		return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+	}
+	loc, err := url.JoinPath(s.endpoint, s.bucket, s.prefix)
+	if err != nil {
+		return strings.TrimSuffix(s.endpoint, "/") + "/" + s.bucket + "/" + s.prefix
+	}
+	return loc
+}
+
+// notFound builds a NotFoundError that records the exact bucket and key that
+// was requested, which makes prefix misconfigurations easy to spot.
+func (s *S3Store) notFound(rel string) NotFoundError {
+	return NotFoundError{
+		Key:     rel,
+		FullKey: fmt.Sprintf("s3://%s/%s", s.bucket, s.key(rel)),
+	}
+}
+
+// relPath converts a fully qualified object key back into the slash separated
+// path relative to the configured prefix.
+func (s *S3Store) relPath(key string) string {
+	name := strings.TrimPrefix(key, s.prefix)
+	name = strings.TrimPrefix(name, s.separator())
+	if s.delimiter == "" {
+		return name
+	}
+	return strings.ReplaceAll(name, s.delimiter, "/")
+}
+
+// unaddressable reports whether key holds a "/" below the prefix while
+// another delimiter separates its segments. Paths always use "/" for the
+// delimiter, so such a key would map onto a different object.
+func (s *S3Store) unaddressable(key string) bool {
+	if s.delimiter == "" || s.delimiter == "/" {
+		return false
+	}
+	return strings.Contains(strings.TrimPrefix(key, s.prefix), "/")
+}
+
+// RelKey maps a full object key onto the relative path used by the other
+// methods. Keys outside the configured prefix are rejected, and so are keys
+// the delimiter makes unaddressable.
+func (s *S3Store) RelKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, s.prefix) || s.unaddressable(key) {
+		return "", false
+	}
+	rel := s.relPath(key)
+	if rel == "" {
+		return "", false
+	}
+	return rel, true
+}
+
+// Ping issues a HeadBucket request so a missing bucket or bad credentials are
+// reported before the first real request. A missing bucket yields a
+// BucketNotFoundError and a bucket in another region a WrongRegionError.
+func (s *S3Store) Ping(ctx context.Context) error {
+	return s.ping(ctx, s.client)
+}
+
+func (s *S3Store) ping(ctx context.Context, api s3BucketAPI) error {
+	_, err := api.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err != nil {
+		// HeadBucket answers carry no body, so a missing bucket is a bare 404.
+		if httpStatus(err) == http.StatusNotFound {
+			return BucketNotFoundError{Bucket: s.bucket}
+		}
+		return fmt.Errorf("access bucket %s: %w", s.bucket, s.bucketError(err))
+	}
+	return nil
+}
+
+// bucketError maps SDK errors about the bucket itself, rather than a key, to a
+// BucketNotFoundError or a WrongRegionError. Other errors are returned as is.
+func (s *S3Store) bucketError(err error) error {
+	var (
+		noBucket *types.NoSuchBucket
+		api      interface{ ErrorCode() string }
+		code     string
+	)
+	if errors.As(err, &api) {
+		code = api.ErrorCode()
+	}
+	switch {
+	case errors.As(err, &noBucket), code == "NoSuchBucket":
+		return BucketNotFoundError{Bucket: s.bucket}
+	case code == "PermanentRedirect", httpStatus(err) == http.StatusMovedPermanently:
+		return WrongRegionError{Bucket: s.bucket, Region: bucketRegion(err)}
+	}
+	return err
+}
+
+// bucketRegion returns the x-amz-bucket-region header of the failed response
+// behind err, which S3 sets when redirecting to the bucket's region.
+func bucketRegion(err error) string {
+	var resp *awshttp.ResponseError
+	if errors.As(err, &resp) && resp.Response != nil && resp.Response.Response != nil {
+		return resp.Response.Header.Get("X-Amz-Bucket-Region")
+	}
+	return ""
+}
+
 // Head returns metadata for a single object by issuing an S3 HEAD request.
 func (s *S3Store) Head(ctx context.Context, rel string) (FileMeta, error) {
 	key := s.key(rel)
 	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
 	})
 	if err != nil {
 		var notFound *types.NotFound
 		if errors.As(err, &notFound) {
-			return FileMeta{}, NotFoundError{Key: rel}
+			return FileMeta{}, s.notFound(rel)
 		}
-		return FileMeta{}, fmt.Errorf("head %s: %w", rel, err)
+		return FileMeta{}, fmt.Errorf("head %s: %w", rel, s.bucketError(err))
 	}
 	return FileMeta{
-		Path:         rel,
-		Size:         aws.ToInt64(head.ContentLength),
-		ETag:         aws.ToString(head.ETag),
-		LastModified: aws.ToTime(head.LastModified),
+		Path:           rel,
+		Size:           aws.ToInt64(head.ContentLength),
+		ETag:           aws.ToString(head.ETag),
+		LastModified:   s.modTime(head.Metadata, aws.ToTime(head.LastModified)),
+		ChecksumSHA256: fullObjectSHA256(aws.ToString(head.ChecksumSHA256)),
 	}, nil
 }
 
+// Size returns the ContentLength of a HEAD request for rel. Unlike Head it
+// does not ask for checksums.
+func (s *S3Store) Size(ctx context.Context, rel string) (int64, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(rel)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return 0, s.notFound(rel)
+		}
+		return 0, fmt.Errorf("head %s: %w", rel, s.bucketError(err))
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+// modTime returns the modification time recorded in the configured metadata
+// key, or fallback when it is unset, missing, or unparseable.
+func (s *S3Store) modTime(metadata map[string]string, fallback time.Time) time.Time {
+	if s.mtimeMeta == "" {
+		return fallback
+	}
+	var value string
+	for k, v := range metadata {
+		if strings.EqualFold(k, s.mtimeMeta) {
+			value = strings.TrimSpace(v)
+			break
+		}
+	}
+	if value == "" {
+		return fallback
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		whole := int64(secs)
+		return time.Unix(whole, int64((secs-float64(whole))*1e9)).UTC()
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t
+	}
+	return fallback
+}
+
+// applyModTimeMetadata replaces the LastModified of every file in entries
+// with the time from its metadata when WithModTimeMetadata is set. Files
+// whose HEAD fails keep the listed time.
+func (s *S3Store) applyModTimeMetadata(ctx context.Context, entries []FileMeta) {
+	if s.mtimeMeta == "" {
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, modTimeHeadConcurrency)
+	for i := range entries {
+		if entries[i].IsDir {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(entry *FileMeta) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    aws.String(s.key(entry.Path)),
+			})
+			if err == nil {
+				entry.LastModified = s.modTime(head.Metadata, entry.LastModified)
+			}
+		}(&entries[i])
+	}
+	wg.Wait()
+}
+
+// fullObjectSHA256 converts the base64 checksum S3 reports into hex. Multipart
+// uploads report a checksum of part checksums ("<b64>-<parts>"), which does not
+// match the object content, so those are discarded.
+func fullObjectSHA256(b64 string) string {
+	if b64 == "" || strings.Contains(b64, "-") {
+		return ""
+	}
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(raw) != 32 {
+		return ""
+	}
+	return hex.EncodeToString(raw)
+}
+
 // List enumerates the immediate children for the provided prefix using the S3
 // ListObjectsV2 paginator.
 func (s *S3Store) List(ctx context.Context, rel string) ([]FileMeta, error) {
-	prefix := s.key(rel)
-	if prefix != "" && !strings.HasSuffix(prefix, "/") {
-		prefix += "/"
+	return s.ListFrom(ctx, rel, "")
+}
+
+// ListFrom behaves like List but starts at the provided continuation token,
+// typically taken from a PartialListError. An empty token starts from the
+// beginning. If a page fails after earlier pages succeeded, the entries
+// gathered so far are returned with a *PartialListError.
+func (s *S3Store) ListFrom(ctx context.Context, rel, token string) ([]FileMeta, error) {
+	out, _, err := s.listFrom(ctx, s.client, rel, token)
+	return out, err
+}
+
+// ListWithStats behaves like List and also reports how many ListObjectsV2
+// pages the listing took, including a failed last page.
+func (s *S3Store) ListWithStats(ctx context.Context, rel string) ([]FileMeta, ListStats, error) {
+	return s.listFrom(ctx, s.client, rel, "")
+}
+
+// ListFlat lists the objects below rel without a delimiter, so nested keys
+// come back as file entries with their full relative path and no directories
+// are synthesized. Each ListObjectsV2 page maps directly onto entries, and
+// pagination stops as soon as more than limit entries were seen.
+func (s *S3Store) ListFlat(ctx context.Context, rel string, limit int) ([]FileMeta, bool, error) {
+	return s.listFlat(ctx, s.client, rel, limit)
+}
+
+// listFlat does the work of ListFlat through api.
+func (s *S3Store) listFlat(ctx context.Context, api s3.ListObjectsV2APIClient, rel string, limit int) ([]FileMeta, bool, error) {
+	prefix := s.listPrefix(rel)
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	}
+	if limit > 0 {
+		input.MaxKeys = aws.Int32(int32(min(limit+1, 1000)))
 	}
+	if s.fetchOwner {
+		input.FetchOwner = aws.Bool(true)
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	var out []FileMeta
+	paginator := s3.NewListObjectsV2Paginator(api, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("list %s: %w", rel, s.bucketError(err))
+		}
+		out = append(out, s.pageEntries(prefix, page, true)...)
+		if limit > 0 && len(out) > limit {
+			out = out[:limit]
+			s.applyModTimeMetadata(ctx, out)
+			return out, true, nil
+		}
+	}
+	s.applyModTimeMetadata(ctx, out)
+	return out, false, nil
+}
+
+// listFrom does the work of ListFrom and ListWithStats through api.
+func (s *S3Store) listFrom(ctx context.Context, api s3.ListObjectsV2APIClient, rel, token string) ([]FileMeta, ListStats, error) {
+	prefix := s.listPrefix(rel)
 	input := &s3.ListObjectsV2Input{
-		Bucket:    aws.String(s.bucket),
-		Delimiter: aws.String("/"),
+		Bucket: aws.String(s.bucket),
+	}
+	if s.delimiter != "" {
+		input.Delimiter = aws.String(s.delimiter)
+	}
+	if s.fetchOwner {
+		input.FetchOwner = aws.Bool(true)
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	var (
+		out   []FileMeta
+		stats ListStats
+	)
+	fetched := false
+	paginator := s3.NewListObjectsV2Paginator(api, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		stats.Pages++
+		if err != nil {
+			stats.Objects = len(out)
+			if fetched || token != "" {
+				return out, stats, &PartialListError{Key: rel, Token: token, Err: s.bucketError(err)}
+			}
+			return nil, stats, fmt.Errorf("list %s: %w", rel, s.bucketError(err))
+		}
+		fetched = true
+		token = aws.ToString(page.NextContinuationToken)
+		out = append(out, s.entriesFromPage(prefix, page)...)
+	}
+	s.applyModTimeMetadata(ctx, out)
+	stats.Objects = len(out)
+	return out, stats, nil
+}
+
+// ListLimit lists at most limit children of rel, stopping the paginator as
+// soon as the cap is reached.
+func (s *S3Store) ListLimit(ctx context.Context, rel string, limit int) ([]FileMeta, bool, error) {
+	if limit <= 0 {
+		items, err := s.List(ctx, rel)
+		return items, false, err
+	}
+	prefix := s.listPrefix(rel)
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		MaxKeys: aws.Int32(int32(min(limit+1, 1000))),
+	}
+	if s.delimiter != "" {
+		input.Delimiter = aws.String(s.delimiter)
+	}
+	if s.fetchOwner {
+		input.FetchOwner = aws.Bool(true)
 	}
 	if prefix != "" {
 		input.Prefix = aws.String(prefix)
@@ -92,80 +597,381 @@ func (s *S3Store) List(ctx context.Context, rel string) ([]FileMeta, error) {
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("list %s: %w", rel, err)
+			return nil, false, fmt.Errorf("list %s: %w", rel, s.bucketError(err))
 		}
-		for _, cp := range page.CommonPrefixes {
-			name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(cp.Prefix), s.prefix), "/")
-			if name == "" {
-				continue
-			}
-			if rel != "" {
-				name = path.Join(rel, name)
-			}
-			out = append(out, FileMeta{
-				Path:  name,
-				IsDir: true,
-			})
+		out = append(out, s.entriesFromPage(prefix, page)...)
+		if len(out) > limit {
+			out = out[:limit]
+			s.applyModTimeMetadata(ctx, out)
+			return out, true, nil
 		}
-		for _, obj := range page.Contents {
-			key := aws.ToString(obj.Key)
-			if prefix != "" && key == prefix {
-				continue
-			}
-			name := strings.TrimPrefix(key, s.prefix)
-			name = strings.TrimPrefix(name, "/")
-			if rel != "" {
-				if !strings.HasPrefix(name, rel+"/") && name != rel {
-					continue
-				}
-				if idx := strings.Index(strings.TrimPrefix(name, rel+"/"), "/"); idx != -1 {
-					continue
-				}
-			} else if strings.Contains(name, "/") {
-				continue
-			}
-			out = append(out, FileMeta{
-				Path:         name,
-				Size:         aws.ToInt64(obj.Size),
-				ETag:         aws.ToString(obj.ETag),
-				LastModified: aws.ToTime(obj.LastModified),
-			})
+		if len(out) == limit {
+			s.applyModTimeMetadata(ctx, out)
+			return out, paginator.HasMorePages(), nil
 		}
 	}
-	return out, nil
+	s.applyModTimeMetadata(ctx, out)
+	return out, false, nil
 }
 
-// Download streams the contents of an S3 object into dst and mirrors io.Copy
-// semantics for the caller.
-func (s *S3Store) Download(ctx context.Context, rel string, dst io.WriterAt) error {
-	key := s.key(rel)
+// listPrefix returns the key prefix whose children List enumerates for rel.
+func (s *S3Store) listPrefix(rel string) string {
+	prefix := s.key(rel)
+	sep := s.separator()
+	if prefix != "" && !strings.HasSuffix(prefix, sep) {
+		prefix += sep
+	}
+	return prefix
+}
+
+// entriesFromPage converts one ListObjectsV2 page for the provided list prefix
+// into FileMeta entries, synthesizing directories from common prefixes.
+func (s *S3Store) entriesFromPage(prefix string, page *s3.ListObjectsV2Output) []FileMeta {
+	return s.pageEntries(prefix, page, false)
+}
+
+// pageEntries is entriesFromPage that, when flat, keeps nested keys.
+func (s *S3Store) pageEntries(prefix string, page *s3.ListObjectsV2Output, flat bool) []FileMeta {
+	var out []FileMeta
+	for _, cp := range page.CommonPrefixes {
+		if s.unaddressable(aws.ToString(cp.Prefix)) {
+			continue
+		}
+		name := strings.TrimSuffix(s.relPath(aws.ToString(cp.Prefix)), "/")
+		if name == "" {
+			continue
+		}
+		out = append(out, FileMeta{
+			Path:  name,
+			IsDir: true,
+		})
+	}
+	for _, obj := range page.Contents {
+		key := aws.ToString(obj.Key)
+		if prefix != "" && key == prefix {
+			continue
+		}
+		if !strings.HasPrefix(key, prefix) || s.unaddressable(key) {
+			continue
+		}
+		// With a delimiter only direct children belong in the listing; flat
+		// listings return every key beneath the prefix.
+		if s.delimiter != "" && !flat && strings.Contains(strings.TrimPrefix(key, prefix), s.delimiter) {
+			continue
+		}
+		out = append(out, FileMeta{
+			Path:         s.relPath(key),
+			Size:         aws.ToInt64(obj.Size),
+			ETag:         aws.ToString(obj.ETag),
+			LastModified: aws.ToTime(obj.LastModified),
+			Owner:        ownerFrom(obj.Owner),
+		})
+	}
+	return out
+}
+
+func ownerFrom(owner *types.Owner) *Owner {
+	if owner == nil {
+		return nil
+	}
+	return &Owner{ID: aws.ToString(owner.ID), DisplayName: aws.ToString(owner.DisplayName)}
+}
+
+// GetOwner returns the owner of rel from its ACL. It needs the
+// s3:GetObjectAcl permission, and buckets with ACLs disabled report the
+// bucket owner for every object.
+func (s *S3Store) GetOwner(ctx context.Context, rel string) (Owner, error) {
+	return s.getOwner(ctx, s.client, rel)
+}
+
+func (s *S3Store) getOwner(ctx context.Context, api s3ACLAPI, rel string) (Owner, error) {
+	out, err := api.GetObjectAcl(ctx, &s3.GetObjectAclInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(rel)),
+	})
+	if err != nil {
+		var (
+			noKey *types.NoSuchKey
+			coded interface{ ErrorCode() string }
+		)
+		if errors.As(err, &noKey) || (errors.As(err, &coded) && coded.ErrorCode() == "NoSuchKey") {
+			return Owner{}, s.notFound(rel)
+		}
+		return Owner{}, fmt.Errorf("get owner %s: %w", rel, s.bucketError(err))
+	}
+	owner := ownerFrom(out.Owner)
+	if owner == nil {
+		return Owner{}, fmt.Errorf("get owner %s: response carries no owner", rel)
+	}
+	return *owner, nil
+}
+
+// HeadDirMarker issues a HeadObject for the "rel/" marker object, which tools
+// such as the S3 console create for every folder.
+func (s *S3Store) HeadDirMarker(ctx context.Context, rel string) error {
+	return s.headDirMarker(ctx, s.client, rel)
+}
+
+func (s *S3Store) headDirMarker(ctx context.Context, api s3HeadAPI, rel string) error {
+	_, err := api.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.listPrefix(rel)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return s.notFound(rel)
+		}
+		return fmt.Errorf("head marker %s: %w", rel, s.bucketError(err))
+	}
+	return nil
+}
+
+// GetObjectLock returns the Object Lock status of rel from a HeadObject
+// request, which carries the retention and legal hold fields when the caller
+// has the s3:GetObjectRetention and s3:GetObjectLegalHold permissions.
+// Without them, or on buckets without Object Lock, the status reads as
+// unlocked.
+func (s *S3Store) GetObjectLock(ctx context.Context, rel string) (ObjectLock, error) {
+	return s.getObjectLock(ctx, s.client, rel)
+}
+
+func (s *S3Store) getObjectLock(ctx context.Context, api s3HeadAPI, rel string) (ObjectLock, error) {
+	head, err := api.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(rel)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return ObjectLock{}, s.notFound(rel)
+		}
+		return ObjectLock{}, fmt.Errorf("get object lock %s: %w", rel, s.bucketError(err))
+	}
+	return ObjectLock{
+		Mode:        string(head.ObjectLockMode),
+		RetainUntil: aws.ToTime(head.ObjectLockRetainUntilDate),
+		LegalHold:   head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn,
+	}, nil
+}
+
+// Open returns the body of an S3 object so it can be consumed sequentially.
+func (s *S3Store) Open(ctx context.Context, rel string) (io.ReadCloser, error) {
 	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
+		Key:    aws.String(s.key(rel)),
 	})
 	if err != nil {
 		var notFound *types.NoSuchKey
 		if errors.As(err, &notFound) {
-			return NotFoundError{Key: rel}
+			return nil, s.notFound(rel)
+		}
+		return nil, fmt.Errorf("open %s: %w", rel, s.bucketError(err))
+	}
+	if obj.ContentLength != nil {
+		return &s3Body{ReadCloser: obj.Body, size: *obj.ContentLength}, nil
+	}
+	return obj.Body, nil
+}
+
+// s3Body is a GetObject body that reports the response's Content-Length.
+type s3Body struct {
+	io.ReadCloser
+	size int64
+}
+
+func (b *s3Body) Size() int64 { return b.size }
+
+// RangeReader fetches part of an S3 object using an HTTP Range request.
+func (s *S3Store) RangeReader(ctx context.Context, rel string, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("range %s: negative offset %d", rel, off)
+	}
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(rel)),
+		Range:  aws.String(byteRange(off, n)),
+	})
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return nil, s.notFound(rel)
+		}
+		return nil, fmt.Errorf("range %s: %w", rel, s.bucketError(err))
+	}
+	return obj.Body, nil
+}
+
+// byteRange formats an HTTP Range header value for n bytes starting at off,
+// reading to the end of the object when n is not positive.
+func byteRange(off, n int64) string {
+	if n <= 0 {
+		return fmt.Sprintf("bytes=%d-", off)
+	}
+	return fmt.Sprintf("bytes=%d-%d", off, off+n-1)
+}
+
+// Download streams the contents of an S3 object into dst and mirrors io.Copy
+// semantics for the caller.
+func (s *S3Store) Download(ctx context.Context, rel string, dst io.WriterAt) error {
+	return s.downloadFrom(ctx, s.client, rel, dst, downloadCondition{})
+}
+
+// DownloadIfModifiedSince sends If-Modified-Since with the GetObject request
+// and returns a NotModifiedError when S3 answers 304 Not Modified.
+func (s *S3Store) DownloadIfModifiedSince(ctx context.Context, rel string, dst io.WriterAt, since time.Time) error {
+	return s.downloadFrom(ctx, s.client, rel, dst, downloadCondition{since: since})
+}
+
+// DownloadIfNoneMatch sends If-None-Match with the GetObject request and
+// returns a NotModifiedError when S3 answers 304 Not Modified.
+func (s *S3Store) DownloadIfNoneMatch(ctx context.Context, rel string, dst io.WriterAt, etag string) error {
+	return s.downloadFrom(ctx, s.client, rel, dst, downloadCondition{etag: etag})
+}
+
+// downloadCondition makes a download conditional on the object having
+// changed since a time or from an ETag. The zero value downloads
+// unconditionally.
+type downloadCondition struct {
+	since time.Time
+	etag  string
+}
+
+// downloadFrom fetches rel through api. A body that fails mid-transfer is
+// resumed with a Range request from the last written byte, pinned with
+// If-Match to the ETag of the first response; a 412 answer means the object
+// changed and yields an ObjectChangedError. A dst implementing
+// VersionRecorder learns the version, and one implementing SizeRecorder the
+// Content-Length, from the first response.
+func (s *S3Store) downloadFrom(ctx context.Context, api s3GetAPI, rel string, dst io.WriterAt, cond downloadCondition) error {
+	start := time.Now()
+	key := s.key(rel)
+	bufp := s.bufPool.Get().(*[]byte)
+	defer s.bufPool.Put(bufp)
+	var (
+		first  *firstByteReader
+		etag   string
+		offset int64
+	)
+	for attempt := 0; ; attempt++ {
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		}
+		if offset > 0 {
+			input.Range = aws.String(byteRange(offset, 0))
+			input.IfMatch = aws.String(etag)
+		} else if cond.etag != "" {
+			input.IfNoneMatch = aws.String(cond.etag)
+		} else if !cond.since.IsZero() {
+			input.IfModifiedSince = aws.Time(cond.since)
+		}
+		obj, err := api.GetObject(ctx, input)
+		if err != nil {
+			var notFound *types.NoSuchKey
+			switch {
+			case errors.As(err, &notFound):
+				err = s.notFound(rel)
+			case httpStatus(err) == http.StatusNotModified:
+				err = NotModifiedError{Key: rel}
+			case offset > 0 && httpStatus(err) == http.StatusPreconditionFailed:
+				err = ObjectChangedError{Key: rel, ETag: etag}
+			default:
+				err = fmt.Errorf("download %s: %w", rel, s.bucketError(err))
+			}
+			s.observeDownload(rel, start, first, offset, err)
+			return err
+		}
+		if offset == 0 {
+			etag = aws.ToString(obj.ETag)
+			if rec, ok := dst.(VersionRecorder); ok {
+				rec.RecordVersion(etag, aws.ToTime(obj.LastModified))
+			}
+			if rec, ok := dst.(SizeRecorder); ok && obj.ContentLength != nil {
+				rec.RecordSize(*obj.ContentLength)
+			}
+		}
+		body := &firstByteReader{r: obj.Body}
+		if first == nil {
+			first = body
+		}
+		out := &offsetWriterAt{w: dst, base: offset}
+		n, err := copyToWriterAt(out, body, *bufp, rel)
+		obj.Body.Close()
+		offset += n
+		// Only read failures are resumed: a failing destination or a
+		// cancelled context would fail again, and without an ETag the
+		// resume could not be pinned to the same version.
+		if err == nil || out.err != nil || ctx.Err() != nil || etag == "" || attempt >= s.resumes {
+			s.observeDownload(rel, start, first, offset, err)
+			return err
 		}
-		return fmt.Errorf("download %s: %w", rel, err)
 	}
-	defer obj.Body.Close()
-	buf := make([]byte, 2*1024*1024)
+}
+
+// offsetWriterAt shifts writes by base and remembers the first write error.
+type offsetWriterAt struct {
+	w    io.WriterAt
+	base int64
+	err  error
+}
+
+func (o *offsetWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := o.w.WriteAt(p, o.base+off)
+	if err != nil && o.err == nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// observeDownload reports a finished Download to the configured observer.
+func (s *S3Store) observeDownload(rel string, start time.Time, body *firstByteReader, n int64, err error) {
+	if s.observe == nil {
+		return
+	}
+	stats := DownloadStats{
+		Key:   rel,
+		Bytes: n,
+		Total: time.Since(start),
+		Err:   err,
+	}
+	if body != nil && !body.first.IsZero() {
+		stats.TTFB = body.first.Sub(start)
+	}
+	s.observe(stats)
+}
+
+// firstByteReader records when the first Read of the wrapped body returns.
+type firstByteReader struct {
+	r     io.Reader
+	first time.Time
+}
+
+func (f *firstByteReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if f.first.IsZero() {
+		f.first = time.Now()
+	}
+	return n, err
+}
+
+// copyToWriterAt drains src into dst at increasing offsets using buf as the
+// intermediate chunk and returns the number of bytes written.
+func copyToWriterAt(dst io.WriterAt, src io.Reader, buf []byte, rel string) (int64, error) {
 	var offset int64
 	for {
-		n, readErr := obj.Body.Read(buf)
+		n, readErr := src.Read(buf)
 		if n > 0 {
 			if _, err := dst.WriteAt(buf[:n], offset); err != nil {
-				return fmt.Errorf("write %s: %w", rel, err)
+				return offset, fmt.Errorf("write %s: %w", rel, err)
 			}
 			offset += int64(n)
 		}
 		if readErr != nil {
 			if errors.Is(readErr, io.EOF) {
-				return nil
+				return offset, nil
 			}
-			return fmt.Errorf("read %s: %w", rel, readErr)
+			return offset, fmt.Errorf("read %s: %w", rel, readErr)
 		}
 	}
 }
@@ -0,0 +1,752 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// writerAtBuffer is a minimal io.WriterAt used to drain copies in tests.
+type writerAtBuffer struct {
+	data []byte
+}
+
+func (w *writerAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	end := int(off) + len(p)
+	if end > len(w.data) {
+		grown := make([]byte, end)
+		copy(grown, w.data)
+		w.data = grown
+	}
+	copy(w.data[off:], p)
+	return len(p), nil
+}
+
+func TestCopyToWriterAtSmallBuffer(t *testing.T) {
+	payload := bytes.Repeat([]byte("abcdefgh"), 100)
+	dst := &writerAtBuffer{}
+	n, err := copyToWriterAt(dst, bytes.NewReader(payload), make([]byte, 7), "obj")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if n != int64(len(payload)) {
+		t.Fatalf("copied %d bytes, want %d", n, len(payload))
+	}
+	if !bytes.Equal(dst.data, payload) {
+		t.Fatalf("copied content mismatch")
+	}
+}
+
+func TestWithDownloadBufferSize(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "", WithDownloadBufferSize(4096))
+	bufp := s.bufPool.Get().(*[]byte)
+	if len(*bufp) != 4096 {
+		t.Fatalf("pooled buffer size = %d, want 4096", len(*bufp))
+	}
+	s = NewS3Store(nil, "bucket", "", WithDownloadBufferSize(0))
+	if s.bufSize != DefaultDownloadBufferSize {
+		t.Fatalf("non-positive size should keep default, got %d", s.bufSize)
+	}
+}
+
+// slowReader delays its first Read to simulate backend latency.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	if s.delay > 0 {
+		time.Sleep(s.delay)
+		s.delay = 0
+	}
+	return s.r.Read(p)
+}
+
+func TestDownloadObserverReportsTiming(t *testing.T) {
+	var got []DownloadStats
+	s := NewS3Store(nil, "bucket", "", WithDownloadObserver(func(stats DownloadStats) {
+		got = append(got, stats)
+	}))
+	start := time.Now()
+	body := &firstByteReader{r: &slowReader{r: bytes.NewReader(smallObject), delay: 20 * time.Millisecond}}
+	n, err := copyToWriterAt(&writerAtBuffer{}, body, make([]byte, 64), "obj")
+	s.observeDownload("obj", start, body, n, err)
+	if len(got) != 1 {
+		t.Fatalf("observer called %d times, want 1", len(got))
+	}
+	stats := got[0]
+	if stats.Key != "obj" || stats.Bytes != int64(len(smallObject)) || stats.Err != nil {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.TTFB < 20*time.Millisecond || stats.Total < stats.TTFB {
+		t.Fatalf("TTFB %v / total %v do not reflect the delayed first read", stats.TTFB, stats.Total)
+	}
+}
+
+// smallObject simulates the body of a tiny object in a burst of downloads.
+var smallObject = bytes.Repeat([]byte("x"), 512)
+
+func BenchmarkDownloadCopyUnpooled(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		dst := &writerAtBuffer{data: make([]byte, len(smallObject))}
+		for pb.Next() {
+			buf := make([]byte, DefaultDownloadBufferSize)
+			if _, err := copyToWriterAt(dst, bytes.NewReader(smallObject), buf, "obj"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDownloadCopyPooled(b *testing.B) {
+	pool := newBufferPool(DefaultDownloadBufferSize)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		dst := &writerAtBuffer{data: make([]byte, len(smallObject))}
+		for pb.Next() {
+			bufp := pool.Get().(*[]byte)
+			if _, err := copyToWriterAt(dst, bytes.NewReader(smallObject), *bufp, "obj"); err != nil {
+				b.Fatal(err)
+			}
+			pool.Put(bufp)
+		}
+	})
+}
+
+func listPage(prefixes []string, keys ...string) *s3.ListObjectsV2Output {
+	page := &s3.ListObjectsV2Output{}
+	for _, p := range prefixes {
+		page.CommonPrefixes = append(page.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(p)})
+	}
+	for _, k := range keys {
+		page.Contents = append(page.Contents, types.Object{Key: aws.String(k), Size: aws.Int64(1)})
+	}
+	return page
+}
+
+func entryPaths(entries []FileMeta) []string {
+	var out []string
+	for _, e := range entries {
+		name := e.Path
+		if e.IsDir {
+			name += "/"
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+func TestListDelimiters(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []S3Option
+		rel        string
+		wantPrefix string
+		page       *s3.ListObjectsV2Output
+		want       []string
+	}{
+		{
+			name:       "slash",
+			rel:        "docs",
+			wantPrefix: "data/docs/",
+			page:       listPage([]string{"data/docs/sub/"}, "data/docs/", "data/docs/a.txt"),
+			want:       []string{"docs/sub/", "docs/a.txt"},
+		},
+		{
+			name:       "pipe",
+			opts:       []S3Option{WithDelimiter("|")},
+			rel:        "docs",
+			wantPrefix: "data|docs|",
+			page:       listPage([]string{"data|docs|sub|", "data|docs|x/y|"}, "data|docs|a.txt", "data|docs|b/c.txt"),
+			want:       []string{"docs/sub/", "docs/a.txt"},
+		},
+		{
+			name:       "flat",
+			opts:       []S3Option{WithDelimiter("")},
+			rel:        "",
+			wantPrefix: "data/",
+			page:       listPage(nil, "data/a.txt", "data/docs/b.txt", "data/docs/deep/c.txt"),
+			want:       []string{"a.txt", "docs/b.txt", "docs/deep/c.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewS3Store(nil, "bucket", "data", tt.opts...)
+			prefix := s.listPrefix(tt.rel)
+			if prefix != tt.wantPrefix {
+				t.Fatalf("listPrefix(%q) = %q, want %q", tt.rel, prefix, tt.wantPrefix)
+			}
+			got := entryPaths(s.entriesFromPage(prefix, tt.page))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsePathStylePropagatesToClient(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		client := s3.NewFromConfig(aws.Config{Region: "us-east-1"}, UsePathStyle(enabled))
+		if got := client.Options().UsePathStyle; got != enabled {
+			t.Fatalf("UsePathStyle(%v): client option = %v", enabled, got)
+		}
+	}
+}
+
+func TestKeyUsesDelimiter(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "/data/", WithDelimiter("|"))
+	if got := s.key("docs/report.txt"); got != "data|docs|report.txt" {
+		t.Fatalf("key = %q", got)
+	}
+	if got := s.key(""); got != "data|" {
+		t.Fatalf("root key = %q", got)
+	}
+}
+
+func TestRootIsDistinctFromObjectNamedLikePrefix(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	if got := s.key(""); got != "data/" {
+		t.Fatalf("root key = %q, want the prefix with its slash", got)
+	}
+	if got := s.listPrefix(""); got != "data/" {
+		t.Fatalf("root list prefix = %q", got)
+	}
+	if got := s.notFound("").FullKey; got != "s3://bucket/data/" {
+		t.Fatalf("root FullKey = %q", got)
+	}
+	if rel, ok := s.RelKey("data"); ok {
+		t.Fatalf("object at the bare prefix mapped to %q", rel)
+	}
+	// A root listing sees only keys below "data/": the directory marker
+	// itself is skipped and a real object "data/data" is a child.
+	items := s.entriesFromPage("data/", &s3.ListObjectsV2Output{Contents: []types.Object{
+		{Key: aws.String("data/")},
+		{Key: aws.String("data/data")},
+	}})
+	if len(items) != 1 || items[0].Path != "data" {
+		t.Fatalf("root listing = %+v", items)
+	}
+
+	flat := NewS3Store(nil, "bucket", "")
+	if got := flat.key(""); got != "" {
+		t.Fatalf("root key without prefix = %q", got)
+	}
+}
+
+func TestByteRange(t *testing.T) {
+	if got := byteRange(10, 5); got != "bytes=10-14" {
+		t.Fatalf("byteRange(10, 5) = %q", got)
+	}
+	if got := byteRange(10, 0); got != "bytes=10-" {
+		t.Fatalf("byteRange(10, 0) = %q", got)
+	}
+}
+
+func TestNotFoundRecordsFullKey(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "project/data")
+	err := s.notFound("docs/report.txt")
+	if err.FullKey != "s3://bucket/project/data/docs/report.txt" {
+		t.Fatalf("FullKey = %q", err.FullKey)
+	}
+	if FullKeyOf(fmt.Errorf("wrapped: %w", err)) != err.FullKey {
+		t.Fatalf("FullKeyOf did not unwrap the error")
+	}
+}
+
+func TestLocation(t *testing.T) {
+	for _, tt := range []struct {
+		endpoint string
+		want     string
+	}{
+		{endpoint: "", want: "s3://bucket/project/data/"},
+		{endpoint: "http://minio:9000", want: "http://minio:9000/bucket/project/data/"},
+		{endpoint: "http://minio:9000/", want: "http://minio:9000/bucket/project/data/"},
+	} {
+		s := NewS3Store(nil, "bucket", "project/data", WithEndpoint(tt.endpoint))
+		if got := s.Location(); got != tt.want {
+			t.Fatalf("endpoint %q: Location = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestSelectInputSerialization(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data")
+	in, err := s.selectInput("logs/a.csv", "SELECT * FROM S3Object s", SelectOptions{CSVHeader: "use", OutputFormat: "json", Compression: "gzip"})
+	if err != nil {
+		t.Fatalf("select input: %v", err)
+	}
+	if aws.ToString(in.Key) != "data/logs/a.csv" || in.ExpressionType != types.ExpressionTypeSql {
+		t.Fatalf("unexpected request: key %q type %q", aws.ToString(in.Key), in.ExpressionType)
+	}
+	if in.InputSerialization.CSV == nil || in.InputSerialization.CSV.FileHeaderInfo != types.FileHeaderInfoUse {
+		t.Fatalf("CSV input not configured: %+v", in.InputSerialization)
+	}
+	if in.InputSerialization.CompressionType != types.CompressionTypeGzip {
+		t.Fatalf("compression = %q", in.InputSerialization.CompressionType)
+	}
+	if in.OutputSerialization.JSON == nil || in.OutputSerialization.CSV != nil {
+		t.Fatalf("expected JSON output: %+v", in.OutputSerialization)
+	}
+
+	in, err = s.selectInput("a.json", "SELECT * FROM S3Object s", SelectOptions{InputFormat: "json", JSONType: "document"})
+	if err != nil {
+		t.Fatalf("select json input: %v", err)
+	}
+	if in.InputSerialization.JSON == nil || in.InputSerialization.JSON.Type != types.JSONTypeDocument || in.OutputSerialization.JSON == nil {
+		t.Fatalf("JSON serialization not configured: %+v %+v", in.InputSerialization, in.OutputSerialization)
+	}
+
+	for _, opts := range []SelectOptions{{InputFormat: "xml"}, {OutputFormat: "xml"}, {CSVHeader: "maybe"}, {Compression: "zip"}} {
+		if _, err := s.selectInput("a.csv", "SELECT 1", opts); !IsInvalidSelect(err) {
+			t.Fatalf("expected InvalidSelectError for %+v, got %v", opts, err)
+		}
+	}
+	if _, err := s.selectInput("a.csv", " ", SelectOptions{}); !IsInvalidSelect(err) {
+		t.Fatalf("expected error for empty expression")
+	}
+}
+
+func TestModTimeMetadata(t *testing.T) {
+	listed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewS3Store(nil, "bucket", "", WithModTimeMetadata("X-Amz-Meta-Mtime"))
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     time.Time
+	}{
+		{name: "unix seconds", metadata: map[string]string{"mtime": "1700000000"}, want: time.Unix(1700000000, 0)},
+		{name: "fractional", metadata: map[string]string{"mtime": "1700000000.5"}, want: time.Unix(1700000000, 5e8)},
+		{name: "rfc3339", metadata: map[string]string{"Mtime": "2023-01-02T03:04:05Z"}, want: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{name: "missing", metadata: map[string]string{"other": "1"}, want: listed},
+		{name: "unparseable", metadata: map[string]string{"mtime": "yesterday"}, want: listed},
+	}
+	for _, tt := range tests {
+		if got := s.modTime(tt.metadata, listed); !got.Equal(tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := NewS3Store(nil, "bucket", "").modTime(map[string]string{"mtime": "1"}, listed); !got.Equal(listed) {
+		t.Fatalf("override applied without WithModTimeMetadata: %v", got)
+	}
+}
+
+// statusError carries an HTTP status like the SDK's response errors.
+type statusError int
+
+func (e statusError) Error() string       { return fmt.Sprintf("http %d", int(e)) }
+func (e statusError) HTTPStatusCode() int { return int(e) }
+
+// errAfterReader fails once the wrapped reader is drained.
+type errAfterReader struct {
+	r io.Reader
+}
+
+func (e *errAfterReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		return n, fmt.Errorf("connection reset")
+	}
+	return n, err
+}
+
+// fakeGetAPI serves one object whose first body breaks after failAt bytes.
+// replace, when set, swaps in a new version right after the first request.
+type fakeGetAPI struct {
+	etag     string
+	modified time.Time
+	data     string
+	failAt   int
+	replace  func(f *fakeGetAPI)
+	inputs   []*s3.GetObjectInput
+}
+
+func (f *fakeGetAPI) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.inputs = append(f.inputs, in)
+	defer func() {
+		if len(f.inputs) == 1 && f.replace != nil {
+			f.replace(f)
+		}
+	}()
+	if in.IfMatch != nil && aws.ToString(in.IfMatch) != f.etag {
+		return nil, statusError(412)
+	}
+	if in.IfNoneMatch != nil && aws.ToString(in.IfNoneMatch) == f.etag {
+		return nil, statusError(304)
+	}
+	var off int
+	if in.Range != nil {
+		fmt.Sscanf(aws.ToString(in.Range), "bytes=%d-", &off)
+	}
+	var body io.Reader = strings.NewReader(f.data[off:])
+	if len(f.inputs) == 1 && f.failAt > 0 {
+		body = &errAfterReader{r: strings.NewReader(f.data[:f.failAt])}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(body),
+		ContentLength: aws.Int64(int64(len(f.data) - off)),
+		ETag:          aws.String(f.etag),
+		LastModified:  aws.Time(f.modified),
+	}, nil
+}
+
+func TestDownloadPassesSpecialKeysVerbatim(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	for _, rel := range []string{
+		"docs/my report (final).txt",
+		"docs/a+b.txt",
+		"docs/#1 draft?.txt",
+		"docs/résumé 日本語.txt",
+		"docs/trailing space.txt ",
+	} {
+		api := &fakeGetAPI{etag: `"v1"`, data: "x"}
+		if err := s.downloadFrom(context.Background(), api, rel, &writerAtBuffer{}, downloadCondition{}); err != nil {
+			t.Fatalf("download %q: %v", rel, err)
+		}
+		if got := aws.ToString(api.inputs[0].Key); got != "data/"+rel {
+			t.Fatalf("GetObject key = %q, want %q", got, "data/"+rel)
+		}
+	}
+}
+
+func TestDownloadResumesPinnedToETag(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "")
+	api := &fakeGetAPI{etag: `"v1"`, data: "hello world", failAt: 5}
+	dst := &writerAtBuffer{}
+	if err := s.downloadFrom(context.Background(), api, "obj", dst, downloadCondition{}); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if string(dst.data) != "hello world" {
+		t.Fatalf("content = %q", dst.data)
+	}
+	if len(api.inputs) != 2 {
+		t.Fatalf("requests = %d, want 2", len(api.inputs))
+	}
+	resume := api.inputs[1]
+	if aws.ToString(resume.Range) != "bytes=5-" || aws.ToString(resume.IfMatch) != `"v1"` {
+		t.Fatalf("resume range=%q if-match=%q", aws.ToString(resume.Range), aws.ToString(resume.IfMatch))
+	}
+}
+
+func TestDownloadDetectsETagChangeBetweenParts(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "")
+	api := &fakeGetAPI{etag: `"v1"`, data: "hello world", failAt: 5, replace: func(f *fakeGetAPI) {
+		f.etag, f.data = `"v2"`, "HELLO THERE, WORLD"
+	}}
+	err := s.downloadFrom(context.Background(), api, "obj", &writerAtBuffer{}, downloadCondition{})
+	if !IsObjectChanged(err) {
+		t.Fatalf("expected ObjectChangedError, got %v", err)
+	}
+	if len(api.inputs) != 2 {
+		t.Fatalf("requests = %d, want 2", len(api.inputs))
+	}
+
+	s = NewS3Store(nil, "bucket", "", WithDownloadResumes(0))
+	api = &fakeGetAPI{etag: `"v1"`, data: "hello world", failAt: 5}
+	if err := s.downloadFrom(context.Background(), api, "obj", &writerAtBuffer{}, downloadCondition{}); err == nil || len(api.inputs) != 1 {
+		t.Fatalf("resume disabled: err=%v requests=%d", err, len(api.inputs))
+	}
+}
+
+// versionBuffer is a writerAtBuffer that keeps the reported version and
+// size.
+type versionBuffer struct {
+	writerAtBuffer
+	etag     string
+	modified time.Time
+	size     int64
+}
+
+func (v *versionBuffer) RecordVersion(etag string, modified time.Time) {
+	v.etag, v.modified = etag, modified
+}
+
+func (v *versionBuffer) RecordSize(size int64) {
+	v.size = size
+}
+
+func TestDownloadIfNoneMatch(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "")
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	api := &fakeGetAPI{etag: `"v2"`, modified: modified, data: "hello"}
+	err := s.downloadFrom(context.Background(), api, "obj", &writerAtBuffer{}, downloadCondition{etag: `"v2"`})
+	if !IsNotModified(err) {
+		t.Fatalf("matching ETag = %v, want NotModifiedError", err)
+	}
+	if got := aws.ToString(api.inputs[0].IfNoneMatch); got != `"v2"` || api.inputs[0].IfModifiedSince != nil {
+		t.Fatalf("If-None-Match = %q, If-Modified-Since = %v", got, api.inputs[0].IfModifiedSince)
+	}
+
+	dst := &versionBuffer{}
+	if err := s.downloadFrom(context.Background(), api, "obj", dst, downloadCondition{etag: `"v1"`}); err != nil {
+		t.Fatalf("changed object: %v", err)
+	}
+	if string(dst.data) != "hello" || dst.etag != `"v2"` || !dst.modified.Equal(modified) || dst.size != 5 {
+		t.Fatalf("content = %q, version = %s %v, size = %d", dst.data, dst.etag, dst.modified, dst.size)
+	}
+}
+
+// fakeBucketAPI answers HeadBucket with a fixed error.
+type fakeBucketAPI struct {
+	err error
+}
+
+func (f fakeBucketAPI) HeadBucket(ctx context.Context, in *s3.HeadBucketInput, _ ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, f.err
+}
+
+type fakeACLAPI struct {
+	owner *types.Owner
+	err   error
+	keys  []string
+}
+
+func (f *fakeACLAPI) GetObjectAcl(ctx context.Context, in *s3.GetObjectAclInput, _ ...func(*s3.Options)) (*s3.GetObjectAclOutput, error) {
+	f.keys = append(f.keys, aws.ToString(in.Key))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &s3.GetObjectAclOutput{Owner: f.owner}, nil
+}
+
+func TestGetOwner(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	ctx := context.Background()
+	api := &fakeACLAPI{owner: &types.Owner{ID: aws.String("abc123"), DisplayName: aws.String("audit")}}
+	owner, err := s.getOwner(ctx, api, "docs/a.txt")
+	if err != nil || owner != (Owner{ID: "abc123", DisplayName: "audit"}) {
+		t.Fatalf("owner = %+v, %v", owner, err)
+	}
+	if api.keys[0] != "data/docs/a.txt" {
+		t.Fatalf("GetObjectAcl key = %q", api.keys[0])
+	}
+	if _, err := s.getOwner(ctx, &fakeACLAPI{err: responseError(404, "NoSuchKey", nil)}, "gone.txt"); !IsNotFound(err) {
+		t.Fatalf("missing object: %v", err)
+	}
+
+	listed := NewS3Store(nil, "bucket", "data/", WithFetchOwner(true))
+	items := listed.entriesFromPage("data/", &s3.ListObjectsV2Output{Contents: []types.Object{
+		{Key: aws.String("data/a.txt"), Owner: &types.Owner{ID: aws.String("abc123")}},
+		{Key: aws.String("data/b.txt")},
+	}})
+	if len(items) != 2 || items[0].Owner == nil || items[0].Owner.ID != "abc123" || items[1].Owner != nil {
+		t.Fatalf("listed owners = %+v", items)
+	}
+}
+
+type fakeHeadAPI struct {
+	out *s3.HeadObjectOutput
+	err error
+}
+
+func (f fakeHeadAPI) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return f.out, f.err
+}
+
+func TestGetObjectLock(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	ctx := context.Background()
+	until := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	lock, err := s.getObjectLock(ctx, fakeHeadAPI{out: &s3.HeadObjectOutput{
+		ObjectLockMode:            types.ObjectLockModeCompliance,
+		ObjectLockRetainUntilDate: aws.Time(until),
+		ObjectLockLegalHoldStatus: types.ObjectLockLegalHoldStatusOn,
+	}}, "ledger.csv")
+	if err != nil || lock != (ObjectLock{Mode: "COMPLIANCE", RetainUntil: until, LegalHold: true}) {
+		t.Fatalf("lock = %+v, %v", lock, err)
+	}
+	lock, err = s.getObjectLock(ctx, fakeHeadAPI{out: &s3.HeadObjectOutput{}}, "free.txt")
+	if err != nil || lock != (ObjectLock{}) {
+		t.Fatalf("unlocked object = %+v, %v", lock, err)
+	}
+	if _, err := s.getObjectLock(ctx, fakeHeadAPI{err: &types.NotFound{}}, "gone.txt"); !IsNotFound(err) {
+		t.Fatalf("missing object: %v", err)
+	}
+}
+
+type keyHeadAPI struct {
+	keys map[string]bool
+}
+
+func (f keyHeadAPI) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if !f.keys[aws.ToString(in.Key)] {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func TestHeadDirMarker(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	// "data/docs" is a file; only "data/logs/" is a directory marker.
+	api := keyHeadAPI{keys: map[string]bool{"data/logs/": true, "data/docs": true}}
+	ctx := context.Background()
+	if err := s.headDirMarker(ctx, api, "logs"); err != nil {
+		t.Fatalf("marker of logs: %v", err)
+	}
+	if err := s.headDirMarker(ctx, api, "docs"); !IsNotFound(err) {
+		t.Fatalf("file docs has no marker, got %v", err)
+	}
+}
+
+// responseError builds an SDK error the way the S3 deserializers do.
+func responseError(status int, code string, header http.Header) error {
+	return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status, Header: header}},
+		Err:      &smithy.GenericAPIError{Code: code},
+	}}
+}
+
+func TestBucketErrors(t *testing.T) {
+	s := NewS3Store(nil, "photos", "")
+	ctx := context.Background()
+
+	if err := s.ping(ctx, fakeBucketAPI{err: responseError(404, "NotFound", nil)}); !IsBucketNotFound(err) {
+		t.Fatalf("ping missing bucket = %v, want BucketNotFoundError", err)
+	}
+	redirect := responseError(301, "PermanentRedirect", http.Header{"X-Amz-Bucket-Region": {"eu-west-1"}})
+	err := s.ping(ctx, fakeBucketAPI{err: redirect})
+	var wrong WrongRegionError
+	if !errors.As(err, &wrong) || wrong.Region != "eu-west-1" {
+		t.Fatalf("ping wrong region = %v, want WrongRegionError for eu-west-1", err)
+	}
+	if !strings.Contains(err.Error(), "bucket photos is in region eu-west-1") {
+		t.Fatalf("error message = %q", err)
+	}
+	if err := s.ping(ctx, fakeBucketAPI{}); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	if err := s.bucketError(responseError(404, "NoSuchBucket", nil)); !IsBucketNotFound(err) {
+		t.Fatalf("NoSuchBucket = %v, want BucketNotFoundError", err)
+	}
+	if err := s.bucketError(&types.NoSuchBucket{}); !IsBucketNotFound(err) {
+		t.Fatalf("types.NoSuchBucket = %v, want BucketNotFoundError", err)
+	}
+	if err := s.bucketError(responseError(301, "PermanentRedirect", nil)); !IsWrongRegion(err) {
+		t.Fatalf("redirect without region = %v, want WrongRegionError", err)
+	}
+	other := responseError(500, "InternalError", nil)
+	if err := s.bucketError(other); err != other {
+		t.Fatalf("unrelated error was rewritten: %v", err)
+	}
+}
+
+// fakeListAPI serves pages in order, chaining them with continuation tokens,
+// and fails the request for page failAt when it is set.
+type fakeListAPI struct {
+	pages  []*s3.ListObjectsV2Output
+	failAt int
+	last   *s3.ListObjectsV2Input
+}
+
+func (f *fakeListAPI) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.last = in
+	i := 0
+	if in.ContinuationToken != nil {
+		fmt.Sscan(*in.ContinuationToken, &i)
+	}
+	if f.failAt > 0 && i == f.failAt {
+		return nil, errors.New("slow down")
+	}
+	page := *f.pages[i]
+	if i+1 < len(f.pages) {
+		page.IsTruncated = aws.Bool(true)
+		page.NextContinuationToken = aws.String(fmt.Sprint(i + 1))
+	}
+	return &page, nil
+}
+
+func TestListWithStatsCountsPages(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	ctx := context.Background()
+	api := &fakeListAPI{pages: []*s3.ListObjectsV2Output{
+		listPage([]string{"data/sub/"}, "data/a.txt"),
+		listPage(nil, "data/b.txt"),
+		listPage(nil, "data/c.txt"),
+	}}
+	items, stats, err := s.listFrom(ctx, api, "", "")
+	if err != nil || len(items) != 4 || stats != (ListStats{Pages: 3, Objects: 4}) {
+		t.Fatalf("list = %v, %+v, %v", entryPaths(items), stats, err)
+	}
+
+	api.failAt = 2
+	items, stats, err = s.listFrom(ctx, api, "", "")
+	var partial *PartialListError
+	if !errors.As(err, &partial) || stats != (ListStats{Pages: 3, Objects: 3}) || len(items) != 3 {
+		t.Fatalf("partial list = %v, %+v, %v", entryPaths(items), stats, err)
+	}
+
+	if _, stats, _ := ListWithStats(ctx, &countingStore{}, ""); stats.Pages != 1 {
+		t.Fatalf("store without stats counted %d pages, want 1", stats.Pages)
+	}
+}
+
+func TestListFlatKeepsNestedKeys(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	api := &fakeListAPI{pages: []*s3.ListObjectsV2Output{
+		listPage(nil, "data/logs/a.log", "data/logs/2024/b.log"),
+		listPage(nil, "data/logs/2024/05/c.log"),
+	}}
+	items, _, err := s.listFlat(context.Background(), api, "logs", 0)
+	if err != nil {
+		t.Fatalf("list flat: %v", err)
+	}
+	if api.last.Delimiter != nil {
+		t.Fatalf("flat listing sent delimiter %q", aws.ToString(api.last.Delimiter))
+	}
+	if got := strings.Join(entryPaths(items), ","); got != "logs/a.log,logs/2024/b.log,logs/2024/05/c.log" {
+		t.Fatalf("flat entries = %s", got)
+	}
+}
+
+func TestDirMarkersOnlyInFlatListings(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	ctx := context.Background()
+	flat := &fakeListAPI{pages: []*s3.ListObjectsV2Output{listPage(nil, "data/logs/", "data/logs/a.log")}}
+	items, _, err := s.listFlat(ctx, flat, "", 0)
+	if err != nil || len(items) != 2 || items[0].Path != "logs/" || items[0].IsDir {
+		t.Fatalf("flat listing = %+v, %v", items, err)
+	}
+	// A delimited listing of the directory returns its marker as the prefix
+	// key itself, which is not a child.
+	delimited := &fakeListAPI{pages: []*s3.ListObjectsV2Output{listPage(nil, "data/logs/", "data/logs/a.log")}}
+	items, _, err = s.listFrom(ctx, delimited, "logs", "")
+	if got := strings.Join(entryPaths(items), ","); err != nil || got != "logs/a.log" {
+		t.Fatalf("delimited listing = %s, %v", got, err)
+	}
+}
+
+func TestListFlatStopsAtLimit(t *testing.T) {
+	s := NewS3Store(nil, "bucket", "data/")
+	api := &fakeListAPI{pages: []*s3.ListObjectsV2Output{
+		listPage(nil, "data/a", "data/b"),
+		listPage(nil, "data/c", "data/d"),
+		listPage(nil, "data/e"),
+	}}
+	items, truncated, err := s.listFlat(context.Background(), api, "", 2)
+	if err != nil || !truncated || strings.Join(entryPaths(items), ",") != "a,b" {
+		t.Fatalf("capped flat listing = %v, %v, %v", entryPaths(items), truncated, err)
+	}
+	if got := aws.ToString(api.last.ContinuationToken); got != "1" || aws.ToInt32(api.last.MaxKeys) != 3 {
+		t.Fatalf("last request token=%q max-keys=%d, want the second page of 3", got, aws.ToInt32(api.last.MaxKeys))
+	}
+
+	items, truncated, err = s.listFlat(context.Background(), api, "", 5)
+	if err != nil || truncated || len(items) != 5 {
+		t.Fatalf("listing within the cap = %v, %v, %v", entryPaths(items), truncated, err)
+	}
+}
@@ -0,0 +1,296 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// DefaultPartSize is the multipart chunk size used when none is set.
+	DefaultPartSize = 16 * 1024 * 1024
+	// minPartSize is the smallest part S3 accepts for all but the last part.
+	minPartSize = 5 * 1024 * 1024
+	// DefaultMultipartThreshold is the known object size above which uploads
+	// switch from a single PutObject to a multipart upload.
+	DefaultMultipartThreshold = 64 * 1024 * 1024
+	// DefaultUploadConcurrency is the number of parts uploaded in parallel.
+	DefaultUploadConcurrency = 4
+)
+
+// s3UploadAPI is the subset of *s3.Client used for uploads, split out so the
+// multipart flow can be exercised without a live endpoint.
+type s3UploadAPI interface {
+	PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, in *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// WithMultipartUpload tunes uploads: objects whose known size exceeds
+// threshold, or whose size is unknown, are sent as multipart uploads of
+// partSize bytes with up to concurrency parts in flight. Non-positive values
+// keep the defaults; part sizes are raised to the 5 MiB S3 minimum.
+func WithMultipartUpload(threshold int64, partSize int64, concurrency int) S3Option {
+	return func(s *S3Store) {
+		if threshold > 0 {
+			s.multipartThreshold = threshold
+		}
+		if partSize > 0 {
+			if partSize < minPartSize {
+				partSize = minPartSize
+			}
+			s.partSize = partSize
+		}
+		if concurrency > 0 {
+			s.uploadConcurrency = concurrency
+		}
+	}
+}
+
+// WithConditionalWrites controls how UploadOptions.IfNoneMatch is enforced.
+// Enabled, the default, sends If-None-Match: * so S3 rejects the write
+// atomically. Disable it for S3-compatible endpoints that ignore the header;
+// the store then issues a Head first, which leaves a window in which another
+// writer can create the object.
+func WithConditionalWrites(enabled bool) S3Option {
+	return func(s *S3Store) {
+		s.headBeforeCreate = !enabled
+	}
+}
+
+// Upload stores the content of r under rel. size is the content length, or -1
+// when unknown. Small objects of known size use a single PutObject; larger or
+// unknown-length content goes through a multipart upload that is aborted if
+// any step fails so no orphaned parts are left behind.
+func (s *S3Store) Upload(ctx context.Context, rel string, r io.Reader, size int64) error {
+	return s.upload(ctx, s.client, rel, r, size, UploadOptions{})
+}
+
+// UploadWithOptions is Upload with the object attributes in opts. With
+// opts.ChecksumSHA256 every request carries the SHA-256 of its body, which S3
+// checks before accepting it: objects up to the part size are buffered and
+// sent with one PutObject, larger ones as a multipart upload with a checksum
+// per part.
+func (s *S3Store) UploadWithOptions(ctx context.Context, rel string, r io.Reader, size int64, opts UploadOptions) error {
+	return s.upload(ctx, s.client, rel, r, size, opts)
+}
+
+func (s *S3Store) upload(ctx context.Context, api s3UploadAPI, rel string, r io.Reader, size int64, opts UploadOptions) error {
+	key := s.key(rel)
+	if opts.IfNoneMatch && s.headBeforeCreate {
+		if err := checkAbsent(ctx, s, rel); err != nil {
+			return err
+		}
+		opts.IfNoneMatch = false
+	}
+	// A checksum has to be sent ahead of the body, so checksummed uploads
+	// always take the buffered path below.
+	if size >= 0 && size <= s.multipartThreshold && !opts.ChecksumSHA256 {
+		_, err := api.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			Body:          r,
+			ContentLength: aws.Int64(size),
+			ContentType:   optionalString(opts.ContentType),
+			IfNoneMatch:   ifNoneMatch(opts),
+		})
+		if err != nil {
+			return s.uploadError(rel, opts, err)
+		}
+		return nil
+	}
+
+	// Read the first part before committing to a multipart upload so short
+	// streams of unknown length still go out as a single PutObject.
+	first := make([]byte, s.partSize)
+	n, err := io.ReadFull(r, first)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read %s: %w", rel, err)
+	}
+	if int64(n) < s.partSize {
+		_, err := api.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:         aws.String(s.bucket),
+			Key:            aws.String(key),
+			Body:           bytes.NewReader(first[:n]),
+			ContentLength:  aws.Int64(int64(n)),
+			ContentType:    optionalString(opts.ContentType),
+			IfNoneMatch:    ifNoneMatch(opts),
+			ChecksumSHA256: bodyChecksum(opts, first[:n]),
+		})
+		if err != nil {
+			return s.uploadError(rel, opts, err)
+		}
+		return nil
+	}
+	return s.multipartUpload(ctx, api, rel, key, io.MultiReader(bytes.NewReader(first), r), opts)
+}
+
+// ifNoneMatch returns the If-None-Match header value requested by opts.
+func ifNoneMatch(opts UploadOptions) *string {
+	if !opts.IfNoneMatch {
+		return nil
+	}
+	return aws.String("*")
+}
+
+// bodyChecksum returns the base64 SHA-256 of body when opts asks for
+// checksums.
+func bodyChecksum(opts UploadOptions, body []byte) *string {
+	if !opts.ChecksumSHA256 {
+		return nil
+	}
+	sum := sha256.Sum256(body)
+	return aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// uploadError wraps a failed PutObject, reporting a precondition failure on a
+// create-only upload as an AlreadyExistsError.
+func (s *S3Store) uploadError(rel string, opts UploadOptions, err error) error {
+	if opts.IfNoneMatch && httpStatus(err) == http.StatusPreconditionFailed {
+		err = AlreadyExistsError{Key: rel}
+	} else {
+		err = s.bucketError(err)
+	}
+	return fmt.Errorf("upload %s: %w", rel, err)
+}
+
+// optionalString returns nil for an empty string so the SDK omits the field.
+func optionalString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
+
+// multipartUpload streams r in partSize chunks, uploading up to
+// uploadConcurrency parts at once. The next part is only read once a slot is
+// about to free up, so at most uploadConcurrency+1 part buffers are alive.
+func (s *S3Store) multipartUpload(ctx context.Context, api s3UploadAPI, rel, key string, r io.Reader, opts UploadOptions) error {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: optionalString(opts.ContentType),
+	}
+	if opts.ChecksumSHA256 {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	created, err := api.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return fmt.Errorf("start multipart upload %s: %w", rel, s.bucketError(err))
+	}
+	uploadID := created.UploadId
+
+	abort := func(cause error) error {
+		// Abort with a fresh context so cancellation of ctx does not leave
+		// the uploaded parts behind.
+		_, abortErr := api.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(key),
+			UploadId: uploadID,
+		})
+		if abortErr != nil {
+			return fmt.Errorf("upload %s: %w (abort failed: %v)", rel, cause, abortErr)
+		}
+		return fmt.Errorf("upload %s: %w", rel, cause)
+	}
+
+	partCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    []types.CompletedPart
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	slots := make(chan struct{}, s.uploadConcurrency)
+	for number := int32(1); ; number++ {
+		buf := make([]byte, s.partSize)
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			select {
+			case slots <- struct{}{}:
+			case <-partCtx.Done():
+			}
+			if partCtx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			go func(number int32, body []byte) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				out, err := api.UploadPart(partCtx, &s3.UploadPartInput{
+					Bucket:         aws.String(s.bucket),
+					Key:            aws.String(key),
+					UploadId:       uploadID,
+					PartNumber:     aws.Int32(number),
+					Body:           bytes.NewReader(body),
+					ContentLength:  aws.Int64(int64(len(body))),
+					ChecksumSHA256: bodyChecksum(opts, body),
+				})
+				if err != nil {
+					fail(fmt.Errorf("part %d: %w", number, err))
+					return
+				}
+				mu.Lock()
+				parts = append(parts, types.CompletedPart{
+					ETag:           out.ETag,
+					PartNumber:     aws.Int32(number),
+					ChecksumSHA256: out.ChecksumSHA256,
+				})
+				mu.Unlock()
+			}(number, buf[:n])
+		}
+		if readErr != nil {
+			if !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+				fail(fmt.Errorf("read: %w", readErr))
+			}
+			break
+		}
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return abort(firstErr)
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+	})
+	_, err = api.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		IfNoneMatch:     ifNoneMatch(opts),
+	})
+	if err != nil {
+		if opts.IfNoneMatch && httpStatus(err) == http.StatusPreconditionFailed {
+			err = AlreadyExistsError{Key: rel}
+		}
+		return abort(fmt.Errorf("complete: %w", err))
+	}
+	return nil
+}
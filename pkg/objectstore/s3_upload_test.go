@@ -0,0 +1,315 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeUploadAPI records the upload calls made by S3Store.upload.
+type fakeUploadAPI struct {
+	mu        sync.Mutex
+	puts      [][]byte
+	types     []string
+	parts     map[int32][]byte
+	completed []int32
+	aborted   bool
+	failPart  int32
+	// existing keys reject writes sent with If-None-Match: *.
+	existing map[string]bool
+	// checksums holds the ChecksumSHA256 sent with each put and part,
+	// which are verified against the body like S3 does.
+	checksums     []string
+	algorithm     string
+	completedSums []string
+}
+
+// verifyChecksum fails like S3 with BadDigest when sum does not match data.
+func (f *fakeUploadAPI) verifyChecksum(sum *string, data []byte) error {
+	if sum == nil {
+		return nil
+	}
+	if want := aws.ToString(bodyChecksum(UploadOptions{ChecksumSHA256: true}, data)); *sum != want {
+		return statusError(400)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checksums = append(f.checksums, *sum)
+	return nil
+}
+
+func (f *fakeUploadAPI) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	if aws.ToString(in.IfNoneMatch) == "*" && f.existing[aws.ToString(in.Key)] {
+		return nil, statusError(412)
+	}
+	if err := f.verifyChecksum(in.ChecksumSHA256, data); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.puts = append(f.puts, data)
+	f.types = append(f.types, aws.ToString(in.ContentType))
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeUploadAPI) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.types = append(f.types, aws.ToString(in.ContentType))
+	f.algorithm = string(in.ChecksumAlgorithm)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (f *fakeUploadAPI) UploadPart(ctx context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	number := aws.ToInt32(in.PartNumber)
+	if number == f.failPart {
+		return nil, errors.New("part rejected")
+	}
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.verifyChecksum(in.ChecksumSHA256, data); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.parts == nil {
+		f.parts = make(map[int32][]byte)
+	}
+	f.parts[number] = data
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", number)), ChecksumSHA256: in.ChecksumSHA256}, nil
+}
+
+func (f *fakeUploadAPI) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	if aws.ToString(in.IfNoneMatch) == "*" && f.existing[aws.ToString(in.Key)] {
+		return nil, statusError(412)
+	}
+	for _, part := range in.MultipartUpload.Parts {
+		f.completed = append(f.completed, aws.ToInt32(part.PartNumber))
+		f.completedSums = append(f.completedSums, aws.ToString(part.ChecksumSHA256))
+	}
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeUploadAPI) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func newUploadTestStore() *S3Store {
+	return NewS3Store(nil, "bucket", "", WithMultipartUpload(minPartSize, minPartSize, 2))
+}
+
+func TestUploadSmallObjectsUsePutObject(t *testing.T) {
+	s := newUploadTestStore()
+	api := &fakeUploadAPI{}
+	if err := s.upload(context.Background(), api, "a.txt", bytes.NewReader([]byte("known")), 5, UploadOptions{}); err != nil {
+		t.Fatalf("upload known size: %v", err)
+	}
+	if err := s.upload(context.Background(), api, "b.txt", bytes.NewReader([]byte("stream")), -1, UploadOptions{}); err != nil {
+		t.Fatalf("upload unknown size: %v", err)
+	}
+	if len(api.puts) != 2 || string(api.puts[1]) != "stream" {
+		t.Fatalf("unexpected puts: %q", api.puts)
+	}
+	if len(api.parts) != 0 {
+		t.Fatalf("small uploads should not use multipart")
+	}
+}
+
+func TestUploadLargeStreamUsesMultipart(t *testing.T) {
+	s := newUploadTestStore()
+	api := &fakeUploadAPI{}
+	payload := bytes.Repeat([]byte("x"), 2*minPartSize+10)
+	if err := s.upload(context.Background(), api, "big.bin", bytes.NewReader(payload), -1, UploadOptions{}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if len(api.completed) != 3 {
+		t.Fatalf("completed parts = %v, want 3", api.completed)
+	}
+	for i, number := range api.completed {
+		if number != int32(i+1) {
+			t.Fatalf("parts completed out of order: %v", api.completed)
+		}
+	}
+	var joined []byte
+	for i := int32(1); i <= 3; i++ {
+		joined = append(joined, api.parts[i]...)
+	}
+	if !bytes.Equal(joined, payload) {
+		t.Fatalf("reassembled parts do not match payload")
+	}
+}
+
+func TestUploadSendsChecksums(t *testing.T) {
+	s := newUploadTestStore()
+	api := &fakeUploadAPI{}
+	opts := UploadOptions{ChecksumSHA256: true}
+	if err := s.upload(context.Background(), api, "a.txt", strings.NewReader("hello"), 5, opts); err != nil {
+		t.Fatalf("upload small: %v", err)
+	}
+	// sha256("hello"), base64 encoded.
+	if len(api.checksums) != 1 || api.checksums[0] != "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=" {
+		t.Fatalf("put checksums = %q", api.checksums)
+	}
+
+	api = &fakeUploadAPI{}
+	payload := bytes.Repeat([]byte("x"), 2*minPartSize+10)
+	if err := s.upload(context.Background(), api, "big.bin", bytes.NewReader(payload), int64(len(payload)), opts); err != nil {
+		t.Fatalf("upload large: %v", err)
+	}
+	if api.algorithm != "SHA256" || len(api.checksums) != 3 || len(api.completedSums) != 3 {
+		t.Fatalf("multipart algorithm %q, part checksums %d, completed checksums %q", api.algorithm, len(api.checksums), api.completedSums)
+	}
+	for i, sum := range api.completedSums {
+		if sum == "" {
+			t.Fatalf("completed part %d carries no checksum", i+1)
+		}
+	}
+}
+
+func TestUploadAbortsOnPartFailure(t *testing.T) {
+	s := newUploadTestStore()
+	api := &fakeUploadAPI{failPart: 2}
+	payload := bytes.Repeat([]byte("x"), 3*minPartSize)
+	err := s.upload(context.Background(), api, "big.bin", bytes.NewReader(payload), int64(len(payload)), UploadOptions{})
+	if err == nil {
+		t.Fatalf("expected upload error")
+	}
+	if !api.aborted {
+		t.Fatalf("multipart upload was not aborted")
+	}
+	if len(api.completed) != 0 {
+		t.Fatalf("failed upload should not be completed")
+	}
+}
+
+func TestUploadSetsContentType(t *testing.T) {
+	s := newUploadTestStore()
+	api := &fakeUploadAPI{}
+	ctx := context.Background()
+	if err := s.upload(ctx, api, "page.html", bytes.NewReader([]byte("<p>")), 3, UploadOptions{ContentType: "text/html"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if err := s.upload(ctx, api, "blob", bytes.NewReader([]byte("raw")), 3, UploadOptions{}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	payload := bytes.Repeat([]byte("x"), minPartSize+1)
+	if err := s.upload(ctx, api, "big.json", bytes.NewReader(payload), -1, UploadOptions{ContentType: "application/json"}); err != nil {
+		t.Fatalf("multipart upload: %v", err)
+	}
+	want := []string{"text/html", "", "application/json"}
+	if fmt.Sprint(api.types) != fmt.Sprint(want) {
+		t.Fatalf("content types = %q, want %q", api.types, want)
+	}
+}
+
+func TestUploadIfNoneMatch(t *testing.T) {
+	s := newUploadTestStore()
+	api := &fakeUploadAPI{existing: map[string]bool{"lock": true, "big.bin": true}}
+	ctx := context.Background()
+	create := UploadOptions{IfNoneMatch: true}
+	if err := s.upload(ctx, api, "fresh", bytes.NewReader([]byte("a")), 1, create); err != nil {
+		t.Fatalf("create fresh: %v", err)
+	}
+	err := s.upload(ctx, api, "lock", bytes.NewReader([]byte("a")), 1, create)
+	if !IsAlreadyExists(err) {
+		t.Fatalf("create existing = %v, want AlreadyExistsError", err)
+	}
+	if err := s.upload(ctx, api, "lock", bytes.NewReader([]byte("a")), 1, UploadOptions{}); err != nil {
+		t.Fatalf("unconditional overwrite: %v", err)
+	}
+	payload := bytes.Repeat([]byte("x"), minPartSize+1)
+	err = s.upload(ctx, api, "big.bin", bytes.NewReader(payload), -1, create)
+	if !IsAlreadyExists(err) {
+		t.Fatalf("multipart create existing = %v, want AlreadyExistsError", err)
+	}
+	if !api.aborted {
+		t.Fatalf("rejected multipart upload was not aborted")
+	}
+}
+
+// inflightUploadAPI tracks how many parts are being uploaded at once.
+type inflightUploadAPI struct {
+	fakeUploadAPI
+	inflight, peak int32
+}
+
+func (f *inflightUploadAPI) UploadPart(ctx context.Context, in *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	f.mu.Lock()
+	f.inflight++
+	if f.inflight > f.peak {
+		f.peak = f.inflight
+	}
+	f.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	defer func() {
+		f.mu.Lock()
+		f.inflight--
+		f.mu.Unlock()
+	}()
+	return f.fakeUploadAPI.UploadPart(ctx, in, opts...)
+}
+
+func TestUploadPipedStreamOfUnknownLength(t *testing.T) {
+	s := newUploadTestStore()
+	api := &inflightUploadAPI{}
+	payload := bytes.Repeat([]byte("0123456789"), (5*minPartSize+17)/10)
+	pr, pw := io.Pipe()
+	go func() {
+		// Write in small pieces the way a shell pipe delivers stdin.
+		for rest := payload; len(rest) > 0; {
+			n := min(len(rest), 64*1024)
+			if _, err := pw.Write(rest[:n]); err != nil {
+				return
+			}
+			rest = rest[n:]
+		}
+		pw.Close()
+	}()
+	if err := s.upload(context.Background(), api, "stdin.bin", pr, -1, UploadOptions{}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if len(api.completed) != 6 || len(api.puts) != 0 {
+		t.Fatalf("completed parts = %v, puts = %d", api.completed, len(api.puts))
+	}
+	if api.peak > int32(s.uploadConcurrency) {
+		t.Fatalf("%d parts in flight, concurrency is %d", api.peak, s.uploadConcurrency)
+	}
+	var joined []byte
+	for i := int32(1); i <= 6; i++ {
+		joined = append(joined, api.parts[i]...)
+	}
+	if !bytes.Equal(joined, payload) {
+		t.Fatalf("reassembled parts do not match the piped stream")
+	}
+}
+
+func TestUploadAbortsWhenStreamFails(t *testing.T) {
+	s := newUploadTestStore()
+	api := &fakeUploadAPI{}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(bytes.Repeat([]byte("x"), 2*minPartSize+1))
+		pw.CloseWithError(errors.New("producer crashed"))
+	}()
+	err := s.upload(context.Background(), api, "stdin.bin", pr, -1, UploadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "producer crashed") {
+		t.Fatalf("upload error = %v", err)
+	}
+	if !api.aborted || len(api.completed) != 0 {
+		t.Fatalf("failed stream was not aborted: aborted=%v completed=%v", api.aborted, api.completed)
+	}
+}
@@ -0,0 +1,172 @@
+package remotefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects the layout of the lines written by WithAccessLog.
+type AccessLogFormat string
+
+const (
+	// AccessLogCommon is the Common Log Format used by Apache and NGINX:
+	// client, identity, user, time, request line, status, and bytes.
+	AccessLogCommon AccessLogFormat = "clf"
+	// AccessLogCombined is AccessLogCommon followed by the quoted Referer
+	// and User-Agent headers.
+	AccessLogCombined AccessLogFormat = "combined"
+	// AccessLogJSON writes one AccessLogEntry JSON object per line.
+	AccessLogJSON AccessLogFormat = "json"
+)
+
+// clfTime is the timestamp layout of the Common Log Format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// ParseAccessLogFormat validates the name of an AccessLogFormat.
+func ParseAccessLogFormat(name string) (AccessLogFormat, error) {
+	switch f := AccessLogFormat(name); f {
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown access log format %q (want clf, combined, or json)", name)
+}
+
+// AccessLogEntry is a request as logged in the AccessLogJSON format.
+type AccessLogEntry struct {
+	Time       time.Time     `json:"Time"`
+	RemoteAddr string        `json:"RemoteAddr"`
+	Method     string        `json:"Method"`
+	URI        string        `json:"URI"`
+	Proto      string        `json:"Proto"`
+	Status     int           `json:"Status"`
+	Bytes      int64         `json:"Bytes"`
+	Duration   time.Duration `json:"Duration"`
+	Referer    string        `json:"Referer,omitempty"`
+	UserAgent  string        `json:"UserAgent,omitempty"`
+}
+
+// accessLogger writes one line per request to w.
+type accessLogger struct {
+	format AccessLogFormat
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// wrap logs every request served by h once its handler returns.
+func (l *accessLogger) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		l.write(AccessLogEntry{
+			Time:       start,
+			RemoteAddr: clientHost(r.RemoteAddr),
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Status:     status,
+			Bytes:      rec.bytes,
+			Duration:   time.Since(start),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		})
+	})
+}
+
+func (l *accessLogger) write(e AccessLogEntry) {
+	var line []byte
+	if l.format == AccessLogJSON {
+		var err error
+		if line, err = json.Marshal(e); err != nil {
+			return
+		}
+	} else {
+		host := e.RemoteAddr
+		if host == "" {
+			host = "-"
+		}
+		size := "-"
+		if e.Bytes > 0 {
+			size = strconv.FormatInt(e.Bytes, 10)
+		}
+		line = fmt.Appendf(nil, "%s - - [%s] %s %d %s", host, e.Time.Format(clfTime),
+			strconv.Quote(e.Method+" "+e.URI+" "+e.Proto), e.Status, size)
+		if l.format == AccessLogCombined {
+			line = fmt.Appendf(line, " %s %s", quoteOrDash(e.Referer), quoteOrDash(e.UserAgent))
+		}
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(line)
+}
+
+// quoteOrDash quotes s for a log line, using "-" for an empty value as
+// Apache does.
+func quoteOrDash(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// clientHost strips the port from a remote address. Unix socket peers have
+// no address and log as empty.
+func clientHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	if addr == "@" {
+		return ""
+	}
+	return addr
+}
+
+// statusRecorder captures the status and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's sendfile path for cached files.
+func (r *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := io.Copy(r.ResponseWriter, src)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,87 @@
+package remotefs
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// archiveBufferSize is the chunk WriteArchive copies file content in. It is
+// the only file data the archive writer holds in memory.
+const archiveBufferSize = 32 << 10
+
+// WriteArchive writes the files below local to w as a tar stream, named by
+// their store-relative paths in breadth-first listing order. Files are opened
+// one at a time, and the next is only opened once the previous one has been
+// written to w, so a slow w stalls the downloads instead of buffering them.
+// Content comes from the cache, or is streamed from the store with
+// Config.NoCache; whole objects are never held in memory.
+//
+// The subtree is listed before anything is written, so a missing directory
+// or a listing cut short by Config.MaxDirEntries fails without output. A
+// failure after that leaves w with a truncated archive.
+func (fs *FileSystem) WriteArchive(ctx context.Context, w io.Writer, local string) error {
+	if _, err := fs.sanitize(local); err != nil {
+		return err
+	}
+	res, err := fs.readDirRecursive(ctx, local, -1, 0)
+	if err != nil {
+		return err
+	}
+	if res.Truncated {
+		return fmt.Errorf("archive %s: listing exceeds the directory entry limit", local)
+	}
+	tw := tar.NewWriter(w)
+	buf := make([]byte, archiveBufferSize)
+	for _, item := range res.Entries {
+		if item.IsDir {
+			continue
+		}
+		if err := fs.archiveFile(ctx, tw, buf, item); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// archiveFile appends one file to tw, copying its content through buf. The
+// header carries the length of the opened content, from the cache file or
+// the GET's Content-Length, so an object replaced since the listing is
+// archived as it is now. When the length is not known up front (transformed
+// or tailing reads) the listed size is used, and content of any other length
+// fails the entry rather than producing a corrupt archive.
+func (fs *FileSystem) archiveFile(ctx context.Context, tw *tar.Writer, buf []byte, item objectstore.FileMeta) error {
+	h, err := fs.ReadFile(ctx, fs.joinLocal(item.Path))
+	if err != nil {
+		return fmt.Errorf("archive %s: %w", item.Path, err)
+	}
+	defer h.Close()
+	size, ok := h.length()
+	if !ok {
+		size = item.Size
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     item.Path,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  item.LastModified,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// Hide ReadFrom/WriteTo so the copy really goes through buf. Reading one
+	// byte past size tells a longer object from an exact one.
+	n, err := io.CopyBuffer(struct{ io.Writer }{tw}, io.LimitReader(h, size+1), buf)
+	if errors.Is(err, tar.ErrWriteTooLong) || err == nil && n != size {
+		return fmt.Errorf("archive %s: content length does not match the %d bytes expected", item.Path, size)
+	}
+	if err != nil {
+		return fmt.Errorf("archive %s: %w", item.Path, err)
+	}
+	return nil
+}
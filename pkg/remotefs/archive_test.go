@@ -0,0 +1,157 @@
+package remotefs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// openTrackingStore records, for every Open, how many bytes the archive had
+// already delivered to the client.
+type openTrackingStore struct {
+	*filesStore
+	out      *throttledWriter
+	openedAt map[string]int64
+}
+
+func (s *openTrackingStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	content, ok := s.data[key]
+	if !ok {
+		return nil, objectstore.NotFoundError{Key: key}
+	}
+	s.openedAt[key] = int64(s.out.buf.Len())
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+// throttledWriter is a slow client: it sleeps on every write and records the
+// largest one.
+type throttledWriter struct {
+	buf      bytes.Buffer
+	maxWrite int
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	if len(p) > w.maxWrite {
+		w.maxWrite = len(p)
+	}
+	return w.buf.Write(p)
+}
+
+func TestWriteArchiveStreamsWithBackpressure(t *testing.T) {
+	big := strings.Repeat("a", 4*archiveBufferSize)
+	files := map[string]string{"logs/a.log": big, "logs/b.log": big, "logs/c.log": "small"}
+	out := &throttledWriter{}
+	store := &openTrackingStore{filesStore: newFilesStore(files), out: out, openedAt: map[string]int64{}}
+	store.listing = map[string][]objectstore.FileMeta{"logs": {
+		{Path: "logs/a.log", Size: int64(len(big))},
+		{Path: "logs/b.log", Size: int64(len(big))},
+		{Path: "logs/c.log", Size: 5},
+	}}
+	fs, err := New(store, Config{NoCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err := fs.WriteArchive(context.Background(), out, "/logs"); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if out.maxWrite > archiveBufferSize {
+		t.Fatalf("largest write = %d bytes, want at most %d", out.maxWrite, archiveBufferSize)
+	}
+	// Each object is only opened once the previous one reached the client.
+	if got := store.openedAt["logs/b.log"]; got < int64(len(big)) {
+		t.Fatalf("b.log opened after %d bytes were written, want at least %d", got, len(big))
+	}
+	if got := store.openedAt["logs/c.log"]; got < int64(2*len(big)) {
+		t.Fatalf("c.log opened after %d bytes were written, want at least %d", got, 2*len(big))
+	}
+
+	tr := tar.NewReader(&out.buf)
+	for _, want := range []string{"logs/a.log", "logs/b.log", "logs/c.log"} {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("next entry: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("read %s: %v", hdr.Name, err)
+		}
+		if hdr.Name != want || string(data) != files[want] {
+			t.Fatalf("entry %s with %d bytes, want %s with %d", hdr.Name, len(data), want, len(files[want]))
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("trailing entry: %v", err)
+	}
+}
+
+// sizedBody is an object body that reports its length, like S3Store.Open.
+type sizedBody struct {
+	io.ReadCloser
+	size int64
+}
+
+func (b sizedBody) Size() int64 { return b.size }
+
+// resizedStore serves objects that changed after they were listed, with or
+// without the body reporting its length.
+type resizedStore struct {
+	*filesStore
+	sized bool
+}
+
+func (s *resizedStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	content, ok := s.data[key]
+	if !ok {
+		return nil, objectstore.NotFoundError{Key: key}
+	}
+	body := io.NopCloser(strings.NewReader(content))
+	if s.sized {
+		return sizedBody{ReadCloser: body, size: int64(len(content))}, nil
+	}
+	return body, nil
+}
+
+func TestWriteArchiveUsesContentLength(t *testing.T) {
+	files := map[string]string{"logs/a.log": "grown since the listing"}
+	listing := map[string][]objectstore.FileMeta{"logs": {{Path: "logs/a.log", Size: 5}}}
+
+	store := &resizedStore{filesStore: newFilesStore(files), sized: true}
+	store.listing = listing
+	fs, err := New(store, Config{NoCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	var out bytes.Buffer
+	if err := fs.WriteArchive(context.Background(), &out, "/logs"); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	tr := tar.NewReader(&out)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("next entry: %v", err)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil || string(data) != files["logs/a.log"] || hdr.Size != int64(len(data)) {
+		t.Fatalf("entry size %d with %q, %v", hdr.Size, data, err)
+	}
+
+	// Without a Content-Length the listed size is all there is, and content
+	// that does not match it fails the entry.
+	store = &resizedStore{filesStore: newFilesStore(files)}
+	store.listing = listing
+	if fs, err = New(store, Config{NoCache: true}); err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	out.Reset()
+	err = fs.WriteArchive(context.Background(), &out, "/logs")
+	if err == nil || !strings.Contains(err.Error(), "logs/a.log") {
+		t.Fatalf("archive of a resized object = %v, want an error", err)
+	}
+}
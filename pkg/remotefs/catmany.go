@@ -0,0 +1,106 @@
+package remotefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// ReadFilesOptions controls how ReadFilesOpts joins several files.
+type ReadFilesOptions struct {
+	// Separator is written between consecutive files.
+	Separator string
+	// Boundaries writes a "==> path <==" line ahead of every file, in the
+	// style of tail(1) and head(1).
+	Boundaries bool
+	// SkipMissing skips paths that do not exist instead of failing.
+	SkipMissing bool
+}
+
+// ReadFiles returns the content of locals concatenated in order. Every path
+// is checked before the stream starts, so a missing file fails the call
+// rather than truncating the output. The caller must close the reader.
+func (fs *FileSystem) ReadFiles(ctx context.Context, locals []string) (io.ReadCloser, error) {
+	return fs.ReadFilesOpts(ctx, locals, ReadFilesOptions{})
+}
+
+// ReadFilesOpts is ReadFiles with separators, boundary markers, and missing
+// file handling controlled by opts. Files are opened one at a time as the
+// stream reaches them, so only one download slot is held at once.
+func (fs *FileSystem) ReadFilesOpts(ctx context.Context, locals []string, opts ReadFilesOptions) (io.ReadCloser, error) {
+	if !opts.SkipMissing {
+		for _, local := range locals {
+			meta, err := fs.Stat(ctx, local)
+			if err != nil {
+				return nil, err
+			}
+			if meta.IsDir {
+				return nil, fmt.Errorf("cannot read directory %s", local)
+			}
+		}
+	}
+	return &multiFileReader{ctx: ctx, fs: fs, locals: locals, opts: opts}, nil
+}
+
+// multiFileReader streams several files back to back.
+type multiFileReader struct {
+	ctx     context.Context
+	fs      *FileSystem
+	locals  []string
+	opts    ReadFilesOptions
+	next    int
+	emitted int
+	cur     io.ReadCloser
+	pending []byte
+}
+
+func (m *multiFileReader) Read(p []byte) (int, error) {
+	for {
+		if len(m.pending) > 0 {
+			n := copy(p, m.pending)
+			m.pending = m.pending[n:]
+			return n, nil
+		}
+		if m.cur != nil {
+			n, err := m.cur.Read(p)
+			if err == io.EOF {
+				m.cur.Close()
+				m.cur = nil
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			return n, err
+		}
+		if m.next >= len(m.locals) {
+			return 0, io.EOF
+		}
+		local := m.locals[m.next]
+		m.next++
+		h, err := m.fs.ReadFile(m.ctx, local)
+		if err != nil {
+			if m.opts.SkipMissing && IsNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		if m.emitted > 0 {
+			m.pending = append(m.pending, m.opts.Separator...)
+		}
+		if m.opts.Boundaries {
+			m.pending = append(m.pending, fmt.Sprintf("==> %s <==\n", local)...)
+		}
+		m.emitted++
+		m.cur = h
+	}
+}
+
+func (m *multiFileReader) Close() error {
+	if m.cur == nil {
+		return nil
+	}
+	err := m.cur.Close()
+	m.cur = nil
+	return err
+}
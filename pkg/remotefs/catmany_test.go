@@ -0,0 +1,69 @@
+package remotefs
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// filesStore serves several small objects from memory.
+type filesStore struct {
+	notFoundStore
+	data map[string]string
+}
+
+func newFilesStore(files map[string]string) *filesStore {
+	s := &filesStore{data: files}
+	s.head = make(map[string]objectstore.FileMeta)
+	for key, content := range files {
+		s.head[key] = objectstore.FileMeta{Path: key, Size: int64(len(content))}
+	}
+	return s
+}
+
+func (s *filesStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	content, ok := s.data[key]
+	if !ok {
+		return objectstore.NotFoundError{Key: key}
+	}
+	_, err := dst.WriteAt([]byte(content), 0)
+	return err
+}
+
+func TestReadFilesOpts(t *testing.T) {
+	store := newFilesStore(map[string]string{"a.log": "alpha\n", "b.log": "beta\n"})
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	readAll := func(r io.ReadCloser, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("read files: %v", err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		return string(data)
+	}
+
+	if got := readAll(fs.ReadFiles(ctx, []string{"/b.log", "/a.log"})); got != "beta\nalpha\n" {
+		t.Fatalf("ReadFiles = %q", got)
+	}
+	got := readAll(fs.ReadFilesOpts(ctx, []string{"/a.log", "/missing.log", "/b.log"}, ReadFilesOptions{
+		Separator:   "--\n",
+		Boundaries:  true,
+		SkipMissing: true,
+	}))
+	if want := "==> /a.log <==\nalpha\n--\n==> /b.log <==\nbeta\n"; got != want {
+		t.Fatalf("ReadFilesOpts = %q, want %q", got, want)
+	}
+	if _, err := fs.ReadFiles(ctx, []string{"/a.log", "/missing.log"}); !IsNotFound(err) {
+		t.Fatalf("expected NotFoundError before streaming, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package remotefs
+
+import (
+	"path"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// dirListing is a directory listing remembered for Config.DirCacheTTL.
+type dirListing struct {
+	entries []objectstore.FileMeta
+	expires time.Time
+}
+
+// cachedListing returns a copy of the remembered listing of rel while it is
+// fresh. Callers may filter the copy in place.
+func (fs *FileSystem) cachedListing(rel string) ([]objectstore.FileMeta, bool) {
+	if fs.cfg.DirCacheTTL <= 0 {
+		return nil, false
+	}
+	fs.dirsMu.Lock()
+	defer fs.dirsMu.Unlock()
+	listing, ok := fs.dirs[rel]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(listing.expires) {
+		delete(fs.dirs, rel)
+		return nil, false
+	}
+	return append([]objectstore.FileMeta(nil), listing.entries...), true
+}
+
+// rememberListing stores the complete listing of rel for Config.DirCacheTTL.
+func (fs *FileSystem) rememberListing(rel string, entries []objectstore.FileMeta) {
+	if fs.cfg.DirCacheTTL <= 0 {
+		return
+	}
+	fs.dirsMu.Lock()
+	defer fs.dirsMu.Unlock()
+	if fs.dirs == nil {
+		fs.dirs = make(map[string]dirListing)
+	}
+	fs.dirs[rel] = dirListing{
+		entries: append([]objectstore.FileMeta(nil), entries...),
+		expires: time.Now().Add(fs.cfg.DirCacheTTL),
+	}
+}
+
+// forgetListing drops the remembered listings of every directory above rel,
+// and the index windows cut from them, so a write shows up in the next
+// ReadDir. Ancestors go too: a write can create the directories between them
+// and rel, and recursive or flat listings of an ancestor include rel itself.
+func (fs *FileSystem) forgetListing(rel string) {
+	ancestors := map[string]bool{"": true}
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		ancestors[dir] = true
+	}
+	if fs.cfg.DirCacheTTL > 0 {
+		fs.dirsMu.Lock()
+		for dir := range ancestors {
+			delete(fs.dirs, dir)
+		}
+		fs.dirsMu.Unlock()
+	}
+	fs.windowsMu.Lock()
+	defer fs.windowsMu.Unlock()
+	for key := range fs.windows {
+		if ancestors[key.rel] {
+			delete(fs.windows, key)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package remotefs
+
+import (
+	"context"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// DefaultListingWindowTTL is used when Config.ListingWindowTTL is zero.
+const DefaultListingWindowTTL = 30 * time.Second
+
+// maxListingWindows bounds the full listings kept for index windows. Each can
+// hold a whole directory, so only a few scrolling views are served from
+// memory at once.
+const maxListingWindows = 16
+
+// windowKey identifies a full listing by directory and by the options that
+// shaped it, with Offset and Count cleared.
+type windowKey struct {
+	rel  string
+	opts ReadDirOptions
+}
+
+// windowListing is a filtered and sorted listing remembered for
+// Config.ListingWindowTTL.
+type windowListing struct {
+	res     ReadDirResult
+	expires time.Time
+}
+
+func (fs *FileSystem) listingWindowTTL() time.Duration {
+	if fs.cfg.ListingWindowTTL == 0 {
+		return DefaultListingWindowTTL
+	}
+	return fs.cfg.ListingWindowTTL
+}
+
+// readDirWindow returns entries opts.Offset onwards of the full listing of
+// local, at most opts.Count of them when positive. opts must already be
+// validated.
+func (fs *FileSystem) readDirWindow(ctx context.Context, local string, opts ReadDirOptions) (ReadDirResult, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return ReadDirResult{}, err
+	}
+	offset, count := opts.Offset, opts.Count
+	opts.Offset, opts.Count = 0, 0
+	if opts.Sort == SortNone {
+		opts.Sort = SortName
+	}
+	key := windowKey{rel: rel, opts: opts}
+	full, ok := fs.cachedWindowListing(key)
+	if !ok {
+		if full, err = fs.ReadDirOpts(ctx, local, opts); err != nil {
+			return ReadDirResult{}, err
+		}
+		fs.rememberWindowListing(key, full)
+	}
+	entries := full.Entries
+	if offset > len(entries) {
+		offset = len(entries)
+	}
+	entries = entries[offset:]
+	if count > 0 && count < len(entries) {
+		entries = entries[:count]
+	}
+	return ReadDirResult{
+		Entries:   append([]objectstore.FileMeta(nil), entries...),
+		Truncated: full.Truncated,
+		Total:     len(full.Entries),
+	}, nil
+}
+
+// cachedWindowListing returns the remembered listing for key while it is
+// fresh. The entries are shared and must not be modified.
+func (fs *FileSystem) cachedWindowListing(key windowKey) (ReadDirResult, bool) {
+	if fs.listingWindowTTL() < 0 {
+		return ReadDirResult{}, false
+	}
+	fs.windowsMu.Lock()
+	defer fs.windowsMu.Unlock()
+	listing, ok := fs.windows[key]
+	if !ok {
+		return ReadDirResult{}, false
+	}
+	if time.Now().After(listing.expires) {
+		delete(fs.windows, key)
+		return ReadDirResult{}, false
+	}
+	return listing.res, true
+}
+
+// rememberWindowListing stores res for Config.ListingWindowTTL, dropping
+// expired listings and then the one closest to expiry to stay within
+// maxListingWindows.
+func (fs *FileSystem) rememberWindowListing(key windowKey, res ReadDirResult) {
+	ttl := fs.listingWindowTTL()
+	if ttl < 0 {
+		return
+	}
+	now := time.Now()
+	fs.windowsMu.Lock()
+	defer fs.windowsMu.Unlock()
+	if fs.windows == nil {
+		fs.windows = make(map[windowKey]windowListing)
+	}
+	for k, listing := range fs.windows {
+		if now.After(listing.expires) {
+			delete(fs.windows, k)
+		}
+	}
+	if _, ok := fs.windows[key]; !ok && len(fs.windows) >= maxListingWindows {
+		var (
+			oldest windowKey
+			first  = true
+		)
+		for k, listing := range fs.windows {
+			if first || listing.expires.Before(fs.windows[oldest].expires) {
+				oldest, first = k, false
+			}
+		}
+		delete(fs.windows, oldest)
+	}
+	fs.windows[key] = windowListing{res: res, expires: now.Add(ttl)}
+}
@@ -0,0 +1,71 @@
+package remotefs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// EventType names a kind of Event.
+type EventType string
+
+const (
+	// EventEvict reports a file dropped from the content cache, by eviction
+	// or by an explicit removal such as ReadFileFresh.
+	EventEvict EventType = "evict"
+	// EventMiss reports a read that found nothing cached and downloaded the
+	// object. It is sent once the download completes.
+	EventMiss EventType = "miss"
+	// EventError reports a download that failed, or a copy to
+	// Config.SecondaryStore that did. Missing objects and cancelled requests
+	// are not reported.
+	EventError EventType = "error"
+)
+
+// EventTypes lists every EventType.
+var EventTypes = []EventType{EventEvict, EventMiss, EventError}
+
+// ParseEventType validates the name of an EventType.
+func ParseEventType(name string) (EventType, error) {
+	for _, t := range EventTypes {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type %q", name)
+}
+
+// Event describes a significant content cache event. Path is store-relative.
+type Event struct {
+	Type  EventType `json:"Type"`
+	Path  string    `json:"Path"`
+	Size  int64     `json:"Size,omitempty"`
+	Error string    `json:"Error,omitempty"`
+	Time  time.Time `json:"Time"`
+}
+
+// emit passes ev to Config.OnEvent, if set.
+func (fs *FileSystem) emit(ev Event) {
+	emitEvent(fs.cfg.OnEvent, ev)
+}
+
+func emitEvent(sink func(Event), ev Event) {
+	if sink == nil {
+		return
+	}
+	ev.Time = time.Now()
+	sink(ev)
+}
+
+// emitDownload reports the outcome of a cache fill of rel.
+func (fs *FileSystem) emitDownload(ctx context.Context, rel string, size int64, err error) {
+	switch {
+	case err == nil:
+		fs.emit(Event{Type: EventMiss, Path: rel, Size: size})
+	case objectstore.IsNotFound(err), ctx.Err() != nil:
+	default:
+		fs.emit(Event{Type: EventError, Path: rel, Error: err.Error()})
+	}
+}
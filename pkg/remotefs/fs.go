@@ -2,13 +2,23 @@ package remotefs
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"mime"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"example.com/s3rofs/pkg/cache"
 	"example.com/s3rofs/pkg/objectstore"
@@ -19,8 +29,169 @@ type Config struct {
 	LocalRoot string
 	CacheDir  string
 	CacheSize int64
+	// CacheMaxEntries caps the number of cached files in addition to
+	// CacheSize. Zero leaves the count unbounded.
+	CacheMaxEntries int
+	// CachePrefixQuotas caps the bytes cached under each listed top-level
+	// directory of the local root, scoping eviction to that directory's own
+	// files. See cache.Options.PrefixQuotas.
+	CachePrefixQuotas map[string]int64
+	// CacheStagingDir holds downloads in progress and revalidation scratch
+	// files instead of CacheDir. See cache.Options.StagingDir for the cost
+	// of putting it on another filesystem.
+	CacheStagingDir string
+	// CacheNamespace identifies the backend in cache file names, so a cache
+	// directory reused for another bucket or prefix never maps a key onto a
+	// file of the previous one. See cache.Options.Namespace. Empty uses the
+	// store's location when it implements objectstore.Locator.
+	CacheNamespace string
+	// NoCache streams ReadFile content straight from the store instead of
+	// staging it on disk. This avoids write amplification for read-once
+	// workloads at the cost of seekability: handles returned in this mode
+	// fail Seek with ErrNotSeekable and every read hits the network.
+	NoCache bool
+	// MaxConcurrentDownloads caps how many object downloads run at once.
+	// Zero leaves downloads unbounded.
+	MaxConcurrentDownloads int
+	// MaxQueuedDownloads caps how many downloads may wait for a slot once
+	// MaxConcurrentDownloads is reached; further requests fail with
+	// ErrDownloadQueueFull. Zero lets callers queue without limit.
+	MaxQueuedDownloads int
+	// MaxDirEntries caps how many entries a single ReadDir returns. Listings
+	// beyond the cap are cut short and reported as truncated. Zero disables
+	// the cap.
+	MaxDirEntries int
+	// ExposeFullKey adds the fully qualified remote key reported by the
+	// store to NotFoundError messages, which helps debug prefix mistakes.
+	// Empty listings, which report no key, get one from the store's
+	// objectstore.Locator.
+	ExposeFullKey bool
+	// WriteVisibilityRetries bounds how many times a NotFound is retried for
+	// a path registered through ExpectWritten. Zero disables the retries so
+	// absent files always fail fast.
+	WriteVisibilityRetries int
+	// WriteVisibilityDelay is the pause between those retries. Defaults to
+	// 200ms when retries are enabled.
+	WriteVisibilityDelay time.Duration
+	// RetryStaleListings extends those retries to directory listings: a
+	// listing that lacks a path registered through ExpectWritten, or the
+	// subdirectory leading to it, is fetched again until the path shows up
+	// or the retries run out. It papers over S3-compatible stores whose
+	// listings lag behind writes and only slows listings of directories
+	// written to within the last minute.
+	RetryStaleListings bool
+	// ReadOnly rejects every write with a ReadOnlyError before the store is
+	// contacted, regardless of what the credentials would allow.
+	ReadOnly bool
+	// StreamDownloads lets ReadFile return as soon as the first bytes of an
+	// uncached object land in the cache instead of after the whole download.
+	// The handle follows the download as it progresses and, like NoCache
+	// handles, is not seekable. Readers of the same object share one
+	// download, which runs detached from their contexts and is cancelled
+	// once the last of them closes its handle. Ignored when NoCache is set.
+	StreamDownloads bool
+	// RevalidateCache makes ReadFile check cached content against the store
+	// with a conditional download: If-None-Match with the ETag recorded when
+	// the file was cached, or If-Modified-Since with the recorded remote
+	// LastModified for stores without objectstore.ETagDownloader. Unchanged
+	// objects are served from the cache after a cheap 304; changed ones are
+	// replaced. Entries without a recorded version, and stores with neither
+	// kind of conditional download, are never revalidated.
+	RevalidateCache bool
+	// SlashRoot makes local paths POSIX style regardless of the runtime OS:
+	// LocalRoot and incoming paths use "/" separators and an empty LocalRoot
+	// is exactly "/". Without it, local paths follow path/filepath rules.
+	SlashRoot bool
+	// PathPolicy replaces the default local path checks, for example to
+	// deny or rewrite prefixes. Nil uses DefaultPathPolicy(LocalRoot), or
+	// SlashPathPolicy(LocalRoot) with SlashRoot.
+	PathPolicy PathPolicy
+	// MaxPathDepth rejects local paths with more segments than this with a
+	// PathTooDeepError, and stops warms and recursive listings from
+	// descending into directories whose children would exceed it, which
+	// bounds their recursion on pathologically nested keys. Zero uses
+	// DefaultMaxPathDepth and a negative value disables the limit.
+	MaxPathDepth int
+	// DirCacheTTL makes ReadDir remember each complete listing it fetches
+	// for this long, so browsing back into a directory does not hit the
+	// store. Unlike WarmMetadataCache nothing is fetched up front. Zero
+	// disables the listing cache.
+	DirCacheTTL time.Duration
+	// ListingWindowTTL is how long ReadDirOptions.Offset and Count keep the
+	// full sorted listing they slice, so scrolling through a directory lists
+	// it once. Zero uses DefaultListingWindowTTL and a negative value
+	// disables the cache, which relists the directory for every window.
+	ListingWindowTTL time.Duration
+	// WarmBestEffort lets metadata warms skip subdirectories whose listing
+	// fails instead of aborting. The rest of the tree is still cached and the
+	// warm returns a *WarmError naming the skipped prefixes. By default the
+	// first error fails the whole warm.
+	WarmBestEffort bool
+	// MetaCacheMaxEntries bounds the metadata cache filled by warms to this
+	// many of the most recently used entries. Evicted paths are answered by
+	// the store again, and Stat adds what it fetches back to the cache, so
+	// buckets too large to hold in memory still benefit from warming. Zero
+	// keeps every warmed entry.
+	MetaCacheMaxEntries int
+	// UsageSkipDirMarkers leaves zero-byte objects whose key ends in "/" out
+	// of Usage figures. Such directory markers show up as files in flat
+	// listings and would otherwise inflate object counts and skew averages.
+	UsageSkipDirMarkers bool
+	// WarmIncremental makes warms write into the live metadata cache as the
+	// walk goes instead of building a complete replacement and swapping it in
+	// at the end, so memory holds one copy of the metadata and entries are
+	// served as soon as they are walked. Every entry is either left from
+	// before the warm or complete from it: directories are published only
+	// once their subtree totals are known. Entries the warm did not see are
+	// removed when it succeeds; a failed warm keeps them and whatever it had
+	// already published.
+	WarmIncremental bool
+	// DirectoryMarkers makes Stat and Exists recognize directories by their
+	// explicit "dir/" marker object, with one HEAD, instead of listing the
+	// prefix when a path is not a file. Only enable it for buckets where
+	// every directory has a marker: a directory without one reads as
+	// missing. Stores without objectstore.DirMarkerHeader still list.
+	DirectoryMarkers bool
+	// SecondaryStore is an optional faster tier in front of the origin
+	// store, such as a bucket in the same region as the daemon. Cache fills
+	// read a file from it when it holds a copy at least as new as the
+	// origin's with the same size, and otherwise download from the origin
+	// and queue a copy for a background upload, so reads never wait for
+	// the tier. A failed or skipped copy only costs the next miss another
+	// origin download; it is reported as an EventError. Close waits for
+	// queued copies. Reads with NoCache bypass the tier.
+	SecondaryStore objectstore.ObjectStore
+	// ContentConcurrency is the number of files WarmContent downloads at
+	// once. Raise it to fill a fast link, lower it to stay under the store's
+	// request rate limits or spare the local disk. MaxConcurrentDownloads
+	// still applies on top. Zero uses DefaultContentConcurrency.
+	ContentConcurrency int
+	// Transforms registers Transform functions by name, for TransformRules
+	// and ReadFileTransformed.
+	Transforms map[string]Transform
+	// TransformRules apply transforms to every read of matching paths,
+	// including /cat, /catmany, archives, ranges, and checksums; the first
+	// matching rule wins. By default the cache holds the raw object and
+	// rules are applied on each read, so handles of transformed files are
+	// not seekable.
+	TransformRules []TransformRule
+	// CacheTransformed makes the cache hold the output of TransformRules
+	// instead of the raw object, so transforms run once per download and
+	// cached handles stay seekable. Such files are not streamed while they
+	// download, are not read from SecondaryStore, and changing the rules
+	// requires clearing the cache.
+	CacheTransformed bool
+	// OnEvent receives content cache evictions, misses, and download errors.
+	// It is called synchronously from the goroutine doing the work, so it
+	// must not block; WebhookSink.Send queues events for asynchronous
+	// delivery.
+	OnEvent func(Event)
 }
 
+// writeVisibilityWindow bounds how long a path registered through
+// ExpectWritten keeps qualifying for NotFound retries.
+const writeVisibilityWindow = time.Minute
+
 // FileSystem translates local style paths into remote object storage calls.
 type FileSystem struct {
 	store     objectstore.ObjectStore
@@ -29,19 +200,92 @@ type FileSystem struct {
 	localRoot string
 
 	metaMu sync.RWMutex
-	meta   map[string]objectstore.FileMeta
+	meta   *metaCache
+
+	warmMu     sync.Mutex
+	warmStatus WarmStatus
+	// warmRun is the warm in flight, or the last one to finish.
+	warmRun *warmRun
+
+	pendingMu sync.Mutex
+	pending   map[string]time.Time
+
+	downloads chan struct{}
+	queued    int64
+
+	inflightMu sync.Mutex
+	inflight   map[string]*downloadProgress
+
+	dirsMu sync.Mutex
+	dirs   map[string]dirListing
+
+	windowsMu sync.Mutex
+	windows   map[windowKey]windowListing
+
+	tierMu     sync.RWMutex
+	tierClosed bool
+	tierQueue  chan secondaryCopy
+	tierDone   chan struct{}
+}
+
+// WarmStatus reports the state of metadata warming so operators can tell
+// whether the cached tree is fresh.
+type WarmStatus struct {
+	InProgress    bool      `json:"InProgress"`
+	LastStarted   time.Time `json:"LastStarted"`
+	LastCompleted time.Time `json:"LastCompleted"`
+	LastError     string    `json:"LastError,omitempty"`
+	Entries       int       `json:"Entries"`
+	// ListPages is the number of list requests the last completed warm
+	// made, for estimating what warms cost.
+	ListPages int `json:"ListPages"`
+}
+
+// WarmFailure is a prefix a best-effort warm could not list.
+type WarmFailure struct {
+	Path string
+	Err  error
+}
+
+// WarmError is returned by best-effort warms that skipped some prefixes. The
+// metadata cache holds everything else that was walked.
+type WarmError struct {
+	Failures []WarmFailure
+}
+
+func (e *WarmError) Error() string {
+	parts := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		parts = append(parts, fmt.Sprintf("%s: %v", f.Path, f.Err))
+	}
+	return fmt.Sprintf("metadata warm skipped %d prefixes: %s", len(e.Failures), strings.Join(parts, "; "))
+}
+
+// AsWarmError extracts a WarmError from err when present.
+func AsWarmError(err error) (*WarmError, bool) {
+	var warmErr *WarmError
+	if errors.As(err, &warmErr) {
+		return warmErr, true
+	}
+	return nil, false
 }
 
 // NotFoundError is returned when the requested local path does not exist in the
 // remote backing store.
 type NotFoundError struct {
 	Path string
+	// Key is the fully qualified object key the store looked up. It is only
+	// filled in when Config.ExposeFullKey is set.
+	Key string
 }
 
 func (e NotFoundError) Error() string {
 	if e.Path == "" {
 		return "No such file or directory"
 	}
+	if e.Key != "" {
+		return fmt.Sprintf("%s: No such file or directory (remote key %s)", e.Path, e.Key)
+	}
 	return fmt.Sprintf("%s: No such file or directory", e.Path)
 }
 
@@ -51,38 +295,134 @@ func IsNotFound(err error) bool {
 	return errors.As(err, &target)
 }
 
+// ReadOnlyError is returned by write operations when Config.ReadOnly is set.
+type ReadOnlyError struct {
+	Op   string
+	Path string
+}
+
+func (e ReadOnlyError) Error() string {
+	return fmt.Sprintf("%s %s: read-only filesystem", e.Op, e.Path)
+}
+
+// IsReadOnly reports whether err is a ReadOnlyError.
+func IsReadOnly(err error) bool {
+	var target ReadOnlyError
+	return errors.As(err, &target)
+}
+
+// AlreadyExistsError is returned by WriteFileOpts with WriteOptions.CreateOnly
+// when the path already exists.
+type AlreadyExistsError struct {
+	Path string
+}
+
+func (e AlreadyExistsError) Error() string {
+	return fmt.Sprintf("%s: file exists", e.Path)
+}
+
+// IsAlreadyExists reports whether err is an AlreadyExistsError.
+func IsAlreadyExists(err error) bool {
+	var target AlreadyExistsError
+	return errors.As(err, &target)
+}
+
+// ErrDownloadQueueFull is returned when MaxConcurrentDownloads slots are busy
+// and MaxQueuedDownloads callers are already waiting.
+var ErrDownloadQueueFull = errors.New("too many concurrent downloads")
+
+// ErrUnsupportedAlgorithm is returned by Checksum for unknown hash names.
+var ErrUnsupportedAlgorithm = errors.New("unsupported checksum algorithm")
+
+// checksumAlgorithms maps the names accepted by Checksum to hash constructors.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+// DefaultCacheDir is the cache directory used when Config.CacheDir is empty.
+func DefaultCacheDir() string {
+	return filepath.Join(os.TempDir(), "remotefs-cache")
+}
+
 // New constructs a RemoteFS facade backed by the provided store and runtime
 // configuration. It also ensures the cache directory and local root are
 // normalized so later path checks remain cheap.
 func New(store objectstore.ObjectStore, cfg Config) (*FileSystem, error) {
-	cacheDir := cfg.CacheDir
-	if cacheDir == "" {
-		cacheDir = filepath.Join(os.TempDir(), "remotefs-cache")
-	}
-	cfg.CacheDir = cacheDir
-	c, err := cache.New(cacheDir, cfg.CacheSize)
-	if err != nil {
+	if err := validateTransforms(cfg); err != nil {
 		return nil, err
 	}
-	root := strings.TrimSpace(cfg.LocalRoot)
-	if root != "" {
-		root = filepath.Clean(root)
-		if root == "." {
-			root = ""
+	var c *cache.Cache
+	if !cfg.NoCache {
+		cacheDir := cfg.CacheDir
+		if cacheDir == "" {
+			cacheDir = DefaultCacheDir()
+		}
+		cfg.CacheDir = cacheDir
+		if cfg.CacheNamespace == "" {
+			if loc, ok := objectstore.As[objectstore.Locator](store); ok {
+				cfg.CacheNamespace = loc.Location()
+			}
+		}
+		var err error
+		c, err = cache.NewOpts(cacheDir, cache.Options{
+			MaxBytes:     cfg.CacheSize,
+			MaxEntries:   cfg.CacheMaxEntries,
+			PrefixQuotas: cfg.CachePrefixQuotas,
+			StagingDir:   cfg.CacheStagingDir,
+			Namespace:    cfg.CacheNamespace,
+			OnEvict: func(key string, size int64) {
+				emitEvent(cfg.OnEvent, Event{Type: EventEvict, Path: key, Size: size})
+			},
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
+	root := normalizeRoot(cfg.LocalRoot)
+	if cfg.SlashRoot {
+		root = normalizeSlashRoot(cfg.LocalRoot)
+	}
 	cfg.LocalRoot = root
+	if cfg.WriteVisibilityRetries > 0 && cfg.WriteVisibilityDelay <= 0 {
+		cfg.WriteVisibilityDelay = 200 * time.Millisecond
+	}
 	fs := &FileSystem{
 		store: store,
 		cfg:   cfg,
 		cache: c,
 	}
+	if cfg.MaxConcurrentDownloads > 0 {
+		fs.downloads = make(chan struct{}, cfg.MaxConcurrentDownloads)
+	}
+	if cfg.SecondaryStore != nil && c != nil {
+		fs.startSecondaryCopies()
+	}
 	fs.localRoot = root
 	return fs, nil
 }
 
+// Close waits for queued copies to Config.SecondaryStore and releases the
+// cache directory so another process may use it.
+func (fs *FileSystem) Close() error {
+	fs.stopSecondaryCopies()
+	if fs.cache == nil {
+		return nil
+	}
+	return fs.cache.Close()
+}
+
+// Ping checks that the backing store is reachable.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	return fs.store.Ping(ctx)
+}
+
 // LocalRoot returns the canonical local root configured for the filesystem.
 func (fs *FileSystem) LocalRoot() string {
+	if fs.cfg.SlashRoot && fs.localRoot == "" {
+		return "/"
+	}
 	if fs.localRoot == "" {
 		return string(os.PathSeparator)
 	}
@@ -92,6 +432,9 @@ func (fs *FileSystem) LocalRoot() string {
 // joinLocal stitches the sanitized relative path back together with the
 // configured local root so errors can surface the path the user expects.
 func (fs *FileSystem) joinLocal(rel string) string {
+	if fs.cfg.SlashRoot {
+		return path.Join("/", fs.localRoot, rel)
+	}
 	if fs.localRoot == "" {
 		if rel == "" {
 			return string(os.PathSeparator)
@@ -104,35 +447,76 @@ func (fs *FileSystem) joinLocal(rel string) string {
 	return filepath.Join(fs.localRoot, filepath.FromSlash(rel))
 }
 
-// sanitize normalizes and ensures the path stays under the configured root.
-func (fs *FileSystem) sanitize(local string) (string, error) {
-	local = strings.TrimSpace(local)
-	if local == "" {
-		return "", fmt.Errorf("empty path")
-	}
-	target := filepath.Clean(local)
-	if fs.localRoot != "" {
-		root := fs.localRoot
-		if target != root {
-			prefix := root + string(os.PathSeparator)
-			if !strings.HasPrefix(target, prefix) {
-				return "", fmt.Errorf("path %s outside of %s", target, root)
-			}
-			target = strings.TrimPrefix(target, prefix)
-		} else {
-			target = ""
+// notFound builds the NotFoundError for rel, attaching the remote key when
+// Config.ExposeFullKey is enabled. The key comes from cause, or, when cause
+// carries none (such as an empty listing), from the store's location.
+func (fs *FileSystem) notFound(rel string, cause error) NotFoundError {
+	nf := NotFoundError{Path: fs.joinLocal(rel)}
+	if !fs.cfg.ExposeFullKey {
+		return nf
+	}
+	if nf.Key = objectstore.FullKeyOf(cause); nf.Key == "" {
+		if loc, ok := objectstore.As[objectstore.Locator](fs.store); ok {
+			nf.Key = strings.TrimSuffix(loc.Location(), "/") + "/" + rel
 		}
+	}
+	return nf
+}
+
+// sanitize resolves local through the configured PathPolicy, falling back to
+// the default root policy.
+func (fs *FileSystem) sanitize(local string) (string, error) {
+	var (
+		rel string
+		err error
+	)
+	if fs.cfg.PathPolicy != nil {
+		rel, err = fs.cfg.PathPolicy.Resolve(local)
 	} else {
-		// The virtual filesystem expects slash separated paths irrespective
-		// of the OS, so strip any leading separators and normalize the rest.
-		target = strings.TrimLeft(target, string(os.PathSeparator))
+		rel, err = rootPolicy{root: fs.localRoot, slash: fs.cfg.SlashRoot}.Resolve(local)
+	}
+	if err != nil {
+		return "", err
+	}
+	if depth, max := pathDepth(rel), fs.maxPathDepth(); max > 0 && depth > max {
+		return "", PathTooDeepError{Path: local, Depth: depth, Max: max}
+	}
+	return rel, nil
+}
+
+// maxPathDepth returns the effective Config.MaxPathDepth, zero meaning no
+// limit.
+func (fs *FileSystem) maxPathDepth() int {
+	switch {
+	case fs.cfg.MaxPathDepth < 0:
+		return 0
+	case fs.cfg.MaxPathDepth == 0:
+		return DefaultMaxPathDepth
+	}
+	return fs.cfg.MaxPathDepth
+}
+
+// expandable reports whether the children of the directory rel are within
+// the path depth limit, so walks may descend into it.
+func (fs *FileSystem) expandable(rel string) bool {
+	max := fs.maxPathDepth()
+	return max <= 0 || pathDepth(rel) < max
+}
+
+// Relative converts a path expressed relative to the local root (for example
+// "docs/report.txt") into the local path the other methods expect. Paths that
+// climb above the root are rejected. As with Stat, trailing spaces are kept
+// as part of the key.
+func (fs *FileSystem) Relative(rel string) (string, error) {
+	rel = strings.TrimLeftFunc(filepath.ToSlash(rel), unicode.IsSpace)
+	rel = path.Clean(strings.TrimLeft(rel, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", PathError{Path: rel, Reason: "outside of " + fs.LocalRoot()}
 	}
-	rel := path.Clean(filepath.ToSlash(target))
-	rel = strings.TrimPrefix(rel, "/")
 	if rel == "." {
 		rel = ""
 	}
-	return rel, nil
+	return fs.joinLocal(rel), nil
 }
 
 // Stat returns file metadata matching os.Stat semantics.
@@ -141,20 +525,34 @@ func (fs *FileSystem) Stat(ctx context.Context, local string) (objectstore.FileM
 	if err != nil {
 		return objectstore.FileMeta{}, err
 	}
-	if rel == "" {
-		return objectstore.FileMeta{Path: "", IsDir: true}, nil
-	}
-	absPath := fs.joinLocal(rel)
 	if meta, ok := fs.cachedMeta(rel); ok {
 		return meta, nil
 	}
-	meta, err := fs.store.Head(ctx, rel)
+	if rel == "" {
+		return objectstore.FileMeta{Path: "", IsDir: true}, nil
+	}
+	var meta objectstore.FileMeta
+	err = fs.retryPending(ctx, rel, func() error {
+		var headErr error
+		meta, headErr = fs.store.Head(ctx, rel)
+		return headErr
+	})
 	if err == nil {
+		fs.refillMeta(meta)
 		return meta, nil
 	}
 	if !objectstore.IsNotFound(err) {
 		return objectstore.FileMeta{}, err
 	}
+	if found, ok, markerErr := fs.dirMarker(ctx, rel); ok {
+		if markerErr != nil {
+			return objectstore.FileMeta{}, markerErr
+		}
+		if found {
+			return objectstore.FileMeta{Path: rel, IsDir: true}, nil
+		}
+		return objectstore.FileMeta{}, fs.notFound(rel, err)
+	}
 	entries, listErr := fs.store.List(ctx, rel)
 	if listErr == nil && len(entries) > 0 {
 		return objectstore.FileMeta{
@@ -165,29 +563,284 @@ func (fs *FileSystem) Stat(ctx context.Context, local string) (objectstore.FileM
 	if listErr != nil && !objectstore.IsNotFound(listErr) {
 		return objectstore.FileMeta{}, listErr
 	}
-	return objectstore.FileMeta{}, NotFoundError{Path: absPath}
+	return objectstore.FileMeta{}, fs.notFound(rel, err)
+}
+
+// StatLocks is Stat with the Object Lock status of a file filled in. It costs
+// an extra request per call and fails with
+// objectstore.ErrObjectLockNotSupported for stores that cannot report it.
+// Directories are returned without a status.
+func (fs *FileSystem) StatLocks(ctx context.Context, local string) (objectstore.FileMeta, error) {
+	meta, err := fs.Stat(ctx, local)
+	if err != nil || meta.IsDir {
+		return meta, err
+	}
+	lock, err := objectstore.ObjectLockOf(ctx, fs.store, meta.Path)
+	if err != nil {
+		if objectstore.IsNotFound(err) {
+			return objectstore.FileMeta{}, fs.notFound(meta.Path, err)
+		}
+		return objectstore.FileMeta{}, err
+	}
+	meta.Lock = &lock
+	return meta, nil
+}
+
+// dirMarker checks for the directory marker of rel when
+// Config.DirectoryMarkers is set. ok is false when the mode is off or the
+// store cannot address markers, and the caller lists the prefix instead.
+func (fs *FileSystem) dirMarker(ctx context.Context, rel string) (found, ok bool, err error) {
+	if !fs.cfg.DirectoryMarkers {
+		return false, false, nil
+	}
+	err = objectstore.HeadDirMarker(ctx, fs.store, rel)
+	switch {
+	case err == nil:
+		return true, true, nil
+	case errors.Is(err, objectstore.ErrDirMarkersNotSupported):
+		return false, false, nil
+	case objectstore.IsNotFound(err):
+		return false, true, nil
+	}
+	return false, true, err
+}
+
+// Size returns the length of the file at local. It serves the metadata cache
+// or asks the store through objectstore.SizeOf, which is cheaper than Stat
+// for stores implementing objectstore.Sizer.
+func (fs *FileSystem) Size(ctx context.Context, local string) (int64, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return 0, err
+	}
+	if rel == "" {
+		return 0, fmt.Errorf("cannot read directory %s", local)
+	}
+	if meta, ok := fs.cachedMeta(rel); ok {
+		if meta.IsDir {
+			return 0, fmt.Errorf("cannot read directory %s", local)
+		}
+		return meta.Size, nil
+	}
+	var size int64
+	err = fs.retryPending(ctx, rel, func() error {
+		var sizeErr error
+		size, sizeErr = objectstore.SizeOf(ctx, fs.store, rel)
+		return sizeErr
+	})
+	if objectstore.IsNotFound(err) {
+		// Prefixes have no object of their own; tell them apart from
+		// missing paths.
+		if meta, statErr := fs.Stat(ctx, local); statErr == nil && meta.IsDir {
+			return 0, fmt.Errorf("cannot read directory %s", local)
+		}
+		return 0, fs.notFound(rel, err)
+	}
+	return size, err
+}
+
+// Exists reports whether local names an object or a non-empty prefix. It is
+// cheaper than Stat when only presence matters: a prefix is probed with a
+// single-entry listing when the store supports it, and missing paths return
+// false rather than a NotFoundError.
+func (fs *FileSystem) Exists(ctx context.Context, local string) (bool, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return false, err
+	}
+	if rel == "" {
+		return true, nil
+	}
+	if _, ok := fs.cachedMeta(rel); ok {
+		return true, nil
+	}
+	err = fs.retryPending(ctx, rel, func() error {
+		_, headErr := fs.store.Head(ctx, rel)
+		return headErr
+	})
+	if err == nil {
+		return true, nil
+	}
+	if !objectstore.IsNotFound(err) {
+		return false, err
+	}
+	if found, ok, err := fs.dirMarker(ctx, rel); ok {
+		return found, err
+	}
+	var entries []objectstore.FileMeta
+	if lister, ok := objectstore.As[objectstore.LimitedLister](fs.store); ok {
+		entries, _, err = lister.ListLimit(ctx, rel, 1)
+	} else {
+		entries, err = fs.store.List(ctx, rel)
+	}
+	if err != nil {
+		if objectstore.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(entries) > 0, nil
 }
 
-// ReadDir fetches directory contents.
+// ReadDir fetches directory contents. If the listing fails part way through,
+// the entries fetched so far are returned together with an error wrapping
+// *objectstore.PartialListError; pass its Token to ReadDirFrom to resume.
+// Listings longer than Config.MaxDirEntries are silently cut short; use
+// ReadDirOpts to learn whether that happened.
 func (fs *FileSystem) ReadDir(ctx context.Context, local string) ([]objectstore.FileMeta, error) {
+	res, err := fs.readDir(ctx, local, 0)
+	return res.Entries, err
+}
+
+// ReadDirResult is a directory listing together with whether it was cut
+// short by an entry cap.
+type ReadDirResult struct {
+	Entries   []objectstore.FileMeta
+	Truncated bool
+	// Total is the length of the full listing when ReadDirOptions selects a
+	// window of it, and zero otherwise.
+	Total int
+}
+
+// readDir lists local, stopping after limit entries when limit is positive
+// and tighter than Config.MaxDirEntries.
+func (fs *FileSystem) readDir(ctx context.Context, local string, limit int) (ReadDirResult, error) {
 	rel, err := fs.sanitize(local)
 	if err != nil {
-		return nil, err
+		return ReadDirResult{}, err
+	}
+	if max := fs.cfg.MaxDirEntries; max > 0 && (limit <= 0 || limit > max) {
+		limit = max
+	}
+	if items, ok := fs.cachedListing(rel); ok {
+		truncated := false
+		if limit > 0 && len(items) > limit {
+			items, truncated = items[:limit], true
+		}
+		return ReadDirResult{Entries: items, Truncated: truncated}, nil
+	}
+	items, truncated, listErr := fs.listDir(ctx, rel, limit)
+	if listErr == nil && !truncated && fs.cfg.RetryStaleListings {
+		items, truncated, listErr = fs.retryStaleListing(ctx, rel, limit, items)
+	}
+	if partial, ok := objectstore.AsPartialList(listErr); ok {
+		return ReadDirResult{Entries: items}, fmt.Errorf("read dir %s: %w", fs.joinLocal(rel), partial)
 	}
-	items, listErr := fs.store.List(ctx, rel)
 	if listErr != nil {
 		if objectstore.IsNotFound(listErr) || rel != "" {
-			return nil, NotFoundError{Path: fs.joinLocal(rel)}
+			return ReadDirResult{}, fs.notFound(rel, listErr)
 		}
-		return nil, listErr
+		return ReadDirResult{}, listErr
 	}
 	if rel != "" && len(items) == 0 {
-		return nil, NotFoundError{Path: fs.joinLocal(rel)}
+		return ReadDirResult{}, fs.notFound(rel, nil)
+	}
+	return ReadDirResult{Entries: items, Truncated: truncated}, nil
+}
+
+// listDir fetches one listing of rel from the store, capped at limit entries
+// when positive, and remembers complete listings for Config.DirCacheTTL.
+func (fs *FileSystem) listDir(ctx context.Context, rel string, limit int) ([]objectstore.FileMeta, bool, error) {
+	if lister, ok := objectstore.As[objectstore.LimitedLister](fs.store); ok && limit > 0 {
+		items, truncated, err := lister.ListLimit(ctx, rel, limit)
+		if err == nil && !truncated && (rel == "" || len(items) > 0) {
+			fs.rememberListing(rel, items)
+		}
+		return items, truncated, err
+	}
+	items, err := fs.store.List(ctx, rel)
+	if err == nil && (rel == "" || len(items) > 0) {
+		fs.rememberListing(rel, items)
+	}
+	truncated := false
+	if limit > 0 && len(items) > limit {
+		items, truncated = items[:limit], true
+	}
+	return items, truncated, err
+}
+
+// retryStaleListing lists rel again, per Config.WriteVisibilityRetries, while
+// items lacks an entry expected from a recent write below rel.
+func (fs *FileSystem) retryStaleListing(ctx context.Context, rel string, limit int, items []objectstore.FileMeta) ([]objectstore.FileMeta, bool, error) {
+	var (
+		truncated bool
+		err       error
+	)
+	for i := 0; i < fs.cfg.WriteVisibilityRetries && fs.missingWrites(rel, items); i++ {
+		timer := time.NewTimer(fs.cfg.WriteVisibilityDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return items, false, ctx.Err()
+		case <-timer.C:
+		}
+		if items, truncated, err = fs.listDir(ctx, rel, limit); err != nil || truncated {
+			return items, truncated, err
+		}
+	}
+	return items, false, nil
+}
+
+// missingWrites reports whether a path registered through ExpectWritten lies
+// below rel without items holding it or the subdirectory leading to it.
+func (fs *FileSystem) missingWrites(rel string, items []objectstore.FileMeta) bool {
+	prefix := ""
+	if rel != "" {
+		prefix = rel + "/"
+	}
+	fs.pendingMu.Lock()
+	var want []string
+	for key, written := range fs.pending {
+		if !strings.HasPrefix(key, prefix) || time.Since(written) > writeVisibilityWindow {
+			continue
+		}
+		child, _, _ := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+		want = append(want, prefix+child)
+	}
+	fs.pendingMu.Unlock()
+	for _, p := range want {
+		found := false
+		for _, item := range items {
+			if item.Path == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadDirFrom resumes a ReadDir that failed with a PartialListError, starting
+// at the provided continuation token. It requires a store implementing
+// objectstore.ResumableLister.
+func (fs *FileSystem) ReadDirFrom(ctx context.Context, local, token string) ([]objectstore.FileMeta, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := objectstore.As[objectstore.ResumableLister](fs.store)
+	if !ok {
+		return nil, fmt.Errorf("object store does not support resumable listings")
+	}
+	items, listErr := lister.ListFrom(ctx, rel, token)
+	if partial, ok := objectstore.AsPartialList(listErr); ok {
+		return items, fmt.Errorf("read dir %s: %w", fs.joinLocal(rel), partial)
+	}
+	if listErr != nil {
+		if objectstore.IsNotFound(listErr) {
+			return nil, fs.notFound(rel, listErr)
+		}
+		return nil, listErr
 	}
 	return items, nil
 }
 
-// ReadFile returns a handle that exposes the remote content as an io.ReadSeekCloser.
+// ReadFile returns a handle that exposes the remote content as an
+// io.ReadSeekCloser. With Config.NoCache the handle streams from the store and
+// is not seekable.
 func (fs *FileSystem) ReadFile(ctx context.Context, local string) (*ReadHandle, error) {
 	rel, err := fs.sanitize(local)
 	if err != nil {
@@ -196,86 +849,1202 @@ func (fs *FileSystem) ReadFile(ctx context.Context, local string) (*ReadHandle,
 	if rel == "" {
 		return nil, fmt.Errorf("cannot read directory %s", local)
 	}
-	absPath := fs.joinLocal(rel)
-	path, err := fs.cache.LoadOrCreate(rel, func(f *os.File) (int64, error) {
-		if err := fs.store.Download(ctx, rel, f); err != nil {
-			return 0, err
-		}
-		info, err := f.Stat()
-		if err != nil {
-			return 0, err
-		}
-		return info.Size(), nil
-	})
+	h, err := fs.open(ctx, rel)
 	if err != nil {
-		if objectstore.IsNotFound(err) {
-			return nil, NotFoundError{Path: absPath}
-		}
 		return nil, err
 	}
-	file, err := os.Open(path)
+	return applyTransforms(h, fs.readTransforms(rel))
+}
+
+// statContent returns the metadata of local for requests that only need to
+// know what ReadFile would return, such as HEAD /cat. ok is false when
+// transform rules rewrite local, since Size then does not describe the
+// content; seekable reports whether ReadFile would return a seekable handle.
+func (fs *FileSystem) statContent(ctx context.Context, local string) (meta objectstore.FileMeta, seekable, ok bool, err error) {
+	rel, err := fs.sanitize(local)
 	if err != nil {
-		return nil, fmt.Errorf("open cache file: %w", err)
+		return meta, false, false, err
+	}
+	if rel == "" {
+		return meta, false, false, fmt.Errorf("cannot read directory %s", local)
+	}
+	if len(fs.ruleTransforms(rel)) > 0 {
+		return meta, false, false, nil
 	}
-	fs.cache.Touch(rel)
-	return &ReadHandle{
-		File: file,
-	}, nil
+	if meta, err = fs.Stat(ctx, local); err != nil {
+		return meta, false, false, err
+	}
+	if meta.IsDir {
+		return meta, false, false, fmt.Errorf("cannot read directory %s", local)
+	}
+	return meta, fs.cache != nil && !fs.cfg.StreamDownloads, true, nil
 }
 
-// ReadHandle exposes cached readers.
-type ReadHandle struct {
-	*os.File
+// open returns a handle on the content of rel as the cache stores it.
+func (fs *FileSystem) open(ctx context.Context, rel string) (*ReadHandle, error) {
+	if fs.cache == nil {
+		return fs.openStream(ctx, rel)
+	}
+	if fs.cfg.StreamDownloads && fs.cachedTransforms(rel) == nil {
+		return fs.openTailing(ctx, rel)
+	}
+	return fs.openCached(ctx, rel)
 }
 
-// WarmMetadataCache walks the entire remote tree and caches metadata locally so
-// subsequent stats can be served without network hops.
-func (fs *FileSystem) WarmMetadataCache(ctx context.Context) error {
-	entries := make(map[string]objectstore.FileMeta)
-	entries[""] = objectstore.FileMeta{Path: "", IsDir: true}
-	if err := fs.populateMetadata(ctx, "", entries); err != nil {
-		return err
+// ReadFileFresh is ReadFile that ignores any cached copy of local: the entry
+// is dropped together with the remembered metadata of local, including Head
+// results cached by the store, and the object downloaded again, so the caches
+// hold the fresh content afterwards. Handles already open on the old copy keep
+// reading it. It is meant for a single suspect file; the rest of the cache is
+// untouched.
+func (fs *FileSystem) ReadFileFresh(ctx context.Context, local string) (*ReadHandle, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return nil, err
 	}
-	fs.metaMu.Lock()
-	fs.meta = entries
-	fs.metaMu.Unlock()
-	return nil
+	if rel != "" {
+		fs.forgetMeta(rel)
+		objectstore.Invalidate(fs.store, rel)
+		if fs.cache != nil {
+			fs.cache.Remove(rel)
+		}
+	}
+	return fs.ReadFile(ctx, local)
 }
 
-// cachedMeta returns the cached metadata entry when WarmMetadataCache has
-// already enumerated the tree.
-func (fs *FileSystem) cachedMeta(rel string) (objectstore.FileMeta, bool) {
-	fs.metaMu.RLock()
-	defer fs.metaMu.RUnlock()
-	if fs.meta == nil {
-		return objectstore.FileMeta{}, false
+// openCached downloads rel into the cache if needed and opens the cached copy.
+func (fs *FileSystem) openCached(ctx context.Context, rel string) (*ReadHandle, error) {
+	if fs.cfg.RevalidateCache {
+		if err := fs.revalidate(ctx, rel); err != nil {
+			if objectstore.IsNotFound(err) {
+				return nil, fs.notFound(rel, err)
+			}
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		var (
+			version objectstore.FileMeta
+			source  *objectstore.FileMeta
+		)
+		path, release, err := fs.cache.Acquire(rel, func(f *os.File) (int64, error) {
+			if chain := fs.cachedTransforms(rel); chain != nil {
+				size, meta, err := fs.fetchTransformed(ctx, rel, f, chain)
+				source = &meta
+				return size, err
+			}
+			var size int64
+			var err error
+			size, version, err = fs.downloadTo(ctx, rel, f, f)
+			return size, err
+		})
+		if err != nil {
+			if objectstore.IsNotFound(err) {
+				return nil, fs.notFound(rel, err)
+			}
+			return nil, err
+		}
+		if source != nil {
+			fs.cache.SetSource(rel, source.Size, source.ETag, source.LastModified)
+		} else {
+			fs.recordVersion(rel, version)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("open cache file: %w", err)
+		}
+		if err := fs.checkCachedSize(rel, file); err != nil {
+			file.Close()
+			release()
+			if attempt > 0 {
+				return nil, err
+			}
+			// The file does not hold what the cache recorded, for example
+			// because it was truncated for a refill. Drop the entry and
+			// fetch again; concurrent readers wait for that one download.
+			fs.cache.Remove(rel)
+			continue
+		}
+		fs.cache.Touch(rel)
+		return &ReadHandle{
+			file:    file,
+			release: release,
+		}, nil
 	}
-	meta, ok := fs.meta[rel]
-	return meta, ok
 }
 
-// populateMetadata recursively walks the remote namespace and stores every
-// object/directory inside dst for later lookups.
-func (fs *FileSystem) populateMetadata(ctx context.Context, rel string, dst map[string]objectstore.FileMeta) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+// checkCachedSize verifies that the opened cache file of rel is as long as
+// the content the cache recorded for it, so a partially written file is
+// never served as if it were complete.
+func (fs *FileSystem) checkCachedSize(rel string, file *os.File) error {
+	entry, ok := fs.cache.Lookup(rel)
+	if !ok {
+		return nil
 	}
-	items, err := fs.store.List(ctx, rel)
+	info, err := file.Stat()
 	if err != nil {
-		if objectstore.IsNotFound(err) {
-			return nil
+		return fmt.Errorf("stat cache file: %w", err)
+	}
+	if info.Size() != entry.Size {
+		return fmt.Errorf("cache file of %s holds %d bytes, want %d", rel, info.Size(), entry.Size)
+	}
+	return nil
+}
+
+// revalidate asks the store whether the remote object behind the cached copy
+// of rel changed since it was fetched: with If-None-Match and the recorded
+// ETag when the store supports it, and otherwise with If-Modified-Since and
+// the recorded remote LastModified. A changed object is downloaded into a
+// scratch file that then replaces the cache entry; an unchanged one is left
+// alone. Entries without a recorded version are not revalidated.
+func (fs *FileSystem) revalidate(ctx context.Context, rel string) error {
+	entry, cached := fs.cache.Lookup(rel)
+	if !cached {
+		return nil
+	}
+	var download func(dst io.WriterAt) error
+	if dl, ok := objectstore.As[objectstore.ETagDownloader](fs.store); ok && entry.ETag != "" {
+		download = func(dst io.WriterAt) error {
+			return dl.DownloadIfNoneMatch(ctx, rel, dst, entry.ETag)
 		}
+	} else if cond, ok := objectstore.As[objectstore.ConditionalDownloader](fs.store); ok && !entry.RemoteModified.IsZero() {
+		download = func(dst io.WriterAt) error {
+			return cond.DownloadIfModifiedSince(ctx, rel, dst, entry.RemoteModified)
+		}
+	} else {
+		return nil
+	}
+	tmp, err := fs.cache.CreateScratch()
+	if err != nil {
+		return fmt.Errorf("create revalidation file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	release, err := fs.acquireDownload(ctx)
+	if err != nil {
 		return err
 	}
-	for _, item := range items {
-		dst[item.Path] = item
-		if item.IsDir {
-			if err := fs.populateMetadata(ctx, item.Path, dst); err != nil {
-				return err
-			}
-		}
+	dst := &versionWriter{w: tmp}
+	err = download(dst)
+	release()
+	if objectstore.IsNotModified(err) {
+		return nil
 	}
-	return nil
+	if err != nil {
+		return err
+	}
+	fs.cache.Remove(rel)
+	_, err = fs.cache.LoadOrCreate(rel, func(f *os.File) (int64, error) {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return io.Copy(f, tmp)
+	})
+	if err == nil {
+		fs.recordVersion(rel, objectstore.FileMeta{ETag: dst.etag, LastModified: dst.modified})
+	}
+	return err
+}
+
+// recordVersion stores the ETag and LastModified of a fresh download of rel
+// with its cache entry. A zero version, as left by a load that found rel
+// already cached, keeps what was recorded.
+func (fs *FileSystem) recordVersion(rel string, version objectstore.FileMeta) {
+	if version.ETag != "" || !version.LastModified.IsZero() {
+		fs.cache.SetVersion(rel, version.ETag, version.LastModified)
+	}
+}
+
+// versionWriter passes writes through to w and keeps the version reported
+// through objectstore.VersionRecorder.
+type versionWriter struct {
+	w        io.WriterAt
+	etag     string
+	modified time.Time
+}
+
+func (v *versionWriter) WriteAt(p []byte, off int64) (int, error) {
+	return v.w.WriteAt(p, off)
+}
+
+func (v *versionWriter) RecordVersion(etag string, modified time.Time) {
+	v.etag, v.modified = etag, modified
+}
+
+// downloadTo fetches rel through dst, which writes into the cache file f,
+// while holding a download slot and returns the resulting file size with the
+// ETag and LastModified of the version fetched. When the metadata cache knows
+// the object size, disk space for it is reserved up front so the file is laid
+// out contiguously, then trimmed to the bytes actually written.
+func (fs *FileSystem) downloadTo(ctx context.Context, rel string, f *os.File, dst io.WriterAt) (int64, objectstore.FileMeta, error) {
+	size, version, err := fs.fetchTo(ctx, rel, f, dst)
+	fs.emitDownload(ctx, rel, size, err)
+	return size, version, err
+}
+
+// fetchTo is downloadTo without the event.
+func (fs *FileSystem) fetchTo(ctx context.Context, rel string, f *os.File, dst io.WriterAt) (int64, objectstore.FileMeta, error) {
+	release, err := fs.acquireDownload(ctx)
+	if err != nil {
+		return 0, objectstore.FileMeta{}, err
+	}
+	defer release()
+	if extent, meta, ok := fs.fetchSecondary(ctx, rel, dst); ok {
+		return extent.end, meta, nil
+	}
+	// f is preallocated once the store reports the size from the download
+	// response, so no Head request is spent on the reservation.
+	extent := &extentWriter{w: dst, file: f}
+	err = fs.retryPending(ctx, rel, func() error {
+		return fs.store.Download(ctx, rel, extent)
+	})
+	if objectstore.IsObjectChanged(err) && dst == io.WriterAt(f) {
+		// The object was replaced mid-transfer, so start over on an empty
+		// file. Streaming readers may already have consumed bytes of the
+		// old version, which is why only plain cache fills restart.
+		if err := f.Truncate(0); err != nil {
+			return 0, objectstore.FileMeta{}, fmt.Errorf("reset cache file: %w", err)
+		}
+		extent = &extentWriter{w: dst, file: f}
+		err = fs.store.Download(ctx, rel, extent)
+	}
+	if err != nil {
+		return 0, objectstore.FileMeta{}, err
+	}
+	if info, err := f.Stat(); err == nil && info.Size() != extent.end {
+		if err := f.Truncate(extent.end); err != nil {
+			return 0, objectstore.FileMeta{}, fmt.Errorf("trim cache file: %w", err)
+		}
+	}
+	fs.copySecondary(rel, f, extent.end)
+	return extent.end, objectstore.FileMeta{ETag: extent.etag, LastModified: extent.modified}, nil
+}
+
+// knownMeta returns the object metadata from the metadata cache or a Head
+// request. Size is -1 when it cannot be determined.
+func (fs *FileSystem) knownMeta(ctx context.Context, rel string) objectstore.FileMeta {
+	if meta, ok := fs.cachedMeta(rel); ok && !meta.IsDir {
+		return meta
+	}
+	meta, err := fs.store.Head(ctx, rel)
+	if err != nil || meta.IsDir {
+		return objectstore.FileMeta{Path: rel, Size: -1}
+	}
+	return meta
+}
+
+// extentWriter records the end of the furthest write so a preallocated file
+// can be trimmed to the real object size.
+type extentWriter struct {
+	w   io.WriterAt
+	end int64
+	// file, when set, is preallocated to the size the store reports; a
+	// failed reservation fails the next write.
+	file     *os.File
+	allocErr error
+	// etag and modified are the version the store reported for the
+	// download, if any.
+	etag     string
+	modified time.Time
+}
+
+func (e *extentWriter) WriteAt(p []byte, off int64) (int, error) {
+	if e.allocErr != nil {
+		return 0, e.allocErr
+	}
+	n, err := e.w.WriteAt(p, off)
+	if end := off + int64(n); end > e.end {
+		e.end = end
+	}
+	return n, err
+}
+
+func (e *extentWriter) RecordVersion(etag string, modified time.Time) {
+	e.etag, e.modified = etag, modified
+}
+
+func (e *extentWriter) RecordSize(size int64) {
+	if e.file != nil && size > 0 {
+		e.allocErr = preallocate(e.file, size)
+	}
+}
+
+// Select filters the object at local server side with an SQL expression and
+// streams the matching records. Stores without objectstore.Selector fail with
+// objectstore.ErrSelectNotSupported.
+func (fs *FileSystem) Select(ctx context.Context, local, expression string, opts objectstore.SelectOptions) (io.ReadCloser, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "" {
+		return nil, fmt.Errorf("cannot select from directory %s", local)
+	}
+	selector, ok := objectstore.As[objectstore.Selector](fs.store)
+	if !ok {
+		return nil, objectstore.ErrSelectNotSupported
+	}
+	release, err := fs.acquireDownload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := selector.Select(ctx, rel, expression, opts)
+	if err != nil {
+		release()
+		if objectstore.IsNotFound(err) {
+			return nil, fs.notFound(rel, err)
+		}
+		return nil, err
+	}
+	return &releaseOnClose{ReadCloser: body, release: release}, nil
+}
+
+// WriteOptions carries optional attributes for WriteFileOpts.
+type WriteOptions struct {
+	// ContentType is stored with the object. When empty it is detected from
+	// the extension of the destination path with mime.TypeByExtension.
+	ContentType string
+	// CreateOnly refuses to replace an existing object, returning an
+	// AlreadyExistsError instead. S3 enforces it atomically with
+	// If-None-Match: *; stores without conditional writes check with a Head
+	// first, so two racing writers may both succeed.
+	CreateOnly bool
+}
+
+// WriteFile uploads r to local. size is the content length, or -1 for streams
+// of unknown length. Any cached content and metadata for the path are
+// invalidated, and the path is registered with ExpectWritten so an immediate
+// read tolerates eventual consistency.
+func (fs *FileSystem) WriteFile(ctx context.Context, local string, r io.Reader, size int64) error {
+	return fs.WriteFileOpts(ctx, local, r, size, WriteOptions{})
+}
+
+// WriteFileOpts is WriteFile with the object attributes in opts. Stores that
+// do not implement objectstore.OptionsUploader receive a plain Upload and
+// apply their own defaults.
+func (fs *FileSystem) WriteFileOpts(ctx context.Context, local string, r io.Reader, size int64, opts WriteOptions) error {
+	return fs.writeFile(ctx, local, r, size, opts, false)
+}
+
+// writeFile is the path every write to the store goes through, so the
+// Config.ReadOnly guard here covers all of them.
+func (fs *FileSystem) writeFile(ctx context.Context, local string, r io.Reader, size int64, opts WriteOptions, checksum bool) error {
+	if fs.cfg.ReadOnly {
+		return ReadOnlyError{Op: "write", Path: local}
+	}
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return err
+	}
+	if rel == "" {
+		return fmt.Errorf("cannot write directory %s", local)
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(rel))
+	}
+	err = objectstore.UploadWith(ctx, fs.store, rel, r, size, objectstore.UploadOptions{
+		ContentType:    contentType,
+		IfNoneMatch:    opts.CreateOnly,
+		ChecksumSHA256: checksum,
+	})
+	if objectstore.IsAlreadyExists(err) {
+		return AlreadyExistsError{Path: local}
+	}
+	if err != nil {
+		return err
+	}
+	if fs.cache != nil {
+		fs.cache.Remove(rel)
+	}
+	fs.forgetMeta(rel)
+	fs.forgetListing(rel)
+	return fs.ExpectWritten(local)
+}
+
+// WriteFileChecksum is WriteFileOpts with end-to-end integrity checking. It
+// hashes the content as it is read from r and has the store verify the
+// SHA-256 of every request it receives (see
+// objectstore.UploadOptions.ChecksumSHA256), so bytes corrupted in transit
+// fail the upload. It returns the hex SHA-256 of the whole content, which
+// matches Checksum(ctx, local, "sha256") afterwards.
+func (fs *FileSystem) WriteFileChecksum(ctx context.Context, local string, r io.Reader, size int64, opts WriteOptions) (string, error) {
+	h := sha256.New()
+	if err := fs.writeFile(ctx, local, io.TeeReader(r, h), size, opts, true); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// forgetMeta drops the cached metadata for rel so the next Stat asks the
+// store again.
+func (fs *FileSystem) forgetMeta(rel string) {
+	fs.metaMu.RLock()
+	defer fs.metaMu.RUnlock()
+	if fs.meta != nil {
+		fs.meta.remove(rel)
+	}
+}
+
+// ExpectWritten records that local was just written so that NotFound results
+// for it are retried per Config.WriteVisibilityRetries instead of failing
+// immediately. Eventually consistent stores may take a moment before a fresh
+// object becomes visible; paths that were never registered keep failing fast.
+func (fs *FileSystem) ExpectWritten(local string) error {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return err
+	}
+	if fs.cfg.WriteVisibilityRetries <= 0 {
+		return nil
+	}
+	fs.pendingMu.Lock()
+	defer fs.pendingMu.Unlock()
+	if fs.pending == nil {
+		fs.pending = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for key, written := range fs.pending {
+		if now.Sub(written) > writeVisibilityWindow {
+			delete(fs.pending, key)
+		}
+	}
+	fs.pending[rel] = now
+	return nil
+}
+
+// expectingWrite reports whether rel was registered through ExpectWritten
+// recently enough to justify retrying a NotFound.
+func (fs *FileSystem) expectingWrite(rel string) bool {
+	fs.pendingMu.Lock()
+	defer fs.pendingMu.Unlock()
+	written, ok := fs.pending[rel]
+	if !ok {
+		return false
+	}
+	if time.Since(written) > writeVisibilityWindow {
+		delete(fs.pending, rel)
+		return false
+	}
+	return true
+}
+
+// retryPending runs op and, when it reports NotFound for a path that was just
+// written, retries it a bounded number of times before giving up.
+func (fs *FileSystem) retryPending(ctx context.Context, rel string, op func() error) error {
+	err := op()
+	if err == nil || !objectstore.IsNotFound(err) || !fs.expectingWrite(rel) {
+		return err
+	}
+	for i := 0; i < fs.cfg.WriteVisibilityRetries; i++ {
+		timer := time.NewTimer(fs.cfg.WriteVisibilityDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		err = op()
+		if err == nil || !objectstore.IsNotFound(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Checksum returns the hex digest of the file at local using algo (sha256 when
+// empty). A SHA-256 reported by the store's Head is returned directly;
+// otherwise the content is read through the cache and hashed.
+func (fs *FileSystem) Checksum(ctx context.Context, local, algo string) (string, error) {
+	algo = strings.ToLower(strings.TrimSpace(algo))
+	if algo == "" {
+		algo = "sha256"
+	}
+	newHash, ok := checksumAlgorithms[algo]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algo)
+	}
+	meta, err := fs.Stat(ctx, local)
+	if err != nil {
+		return "", err
+	}
+	if meta.IsDir {
+		return "", fmt.Errorf("cannot checksum directory %s", local)
+	}
+	if algo == "sha256" && meta.ChecksumSHA256 != "" && fs.ruleTransforms(meta.Path) == nil {
+		return meta.ChecksumSHA256, nil
+	}
+	reader, err := fs.ReadFile(ctx, local)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	h := newHash()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", fmt.Errorf("hash %s: %w", local, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ReadFileRange returns n bytes of local starting at off; a non-positive n
+// reads to the end. Cached files are served from disk. Otherwise stores that
+// implement objectstore.RangeStore fetch only the requested bytes, and other
+// stores fall back to reading the whole file through ReadFile.
+func (fs *FileSystem) ReadFileRange(ctx context.Context, local string, off, n int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("negative offset %d", off)
+	}
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "" {
+		return nil, fmt.Errorf("cannot read directory %s", local)
+	}
+	if fs.cache != nil {
+		if _, cached := fs.cache.PathFor(rel); cached {
+			return fs.readRangeFromFile(ctx, local, off, n)
+		}
+	}
+	if fs.ruleTransforms(rel) != nil {
+		// Offsets refer to the transformed content, which the store
+		// cannot serve ranges of.
+		return fs.readRangeFromFile(ctx, local, off, n)
+	}
+	release, err := fs.acquireDownload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := objectstore.ReadRange(ctx, fs.store, rel, off, n)
+	switch {
+	case err == nil:
+		return &releaseOnClose{ReadCloser: body, release: release}, nil
+	case errors.Is(err, objectstore.ErrRangeNotSupported):
+		release()
+		return fs.readRangeFromFile(ctx, local, off, n)
+	case objectstore.IsNotFound(err):
+		release()
+		return nil, fs.notFound(rel, err)
+	default:
+		release()
+		return nil, err
+	}
+}
+
+// readRangeFromFile serves a range by reading the whole file through ReadFile
+// and skipping to the requested section.
+func (fs *FileSystem) readRangeFromFile(ctx context.Context, local string, off, n int64) (io.ReadCloser, error) {
+	handle, err := fs.ReadFile(ctx, local)
+	if err != nil {
+		return nil, err
+	}
+	if handle.stream != nil {
+		if _, err := io.CopyN(io.Discard, handle.stream, off); err != nil && err != io.EOF {
+			handle.Close()
+			return nil, err
+		}
+	} else if _, err := handle.Seek(off, io.SeekStart); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	if n <= 0 {
+		return handle, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(handle, n), Closer: handle}, nil
+}
+
+// limitedReadCloser pairs a limited reader with the closer of its source.
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// openStream serves ReadFile without the disk cache by handing the caller the
+// store's object body directly.
+func (fs *FileSystem) openStream(ctx context.Context, rel string) (*ReadHandle, error) {
+	release, err := fs.acquireDownload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var body io.ReadCloser
+	err = fs.retryPending(ctx, rel, func() error {
+		var openErr error
+		body, openErr = openObject(ctx, fs.store, rel)
+		return openErr
+	})
+	if err != nil {
+		release()
+		if objectstore.IsNotFound(err) {
+			return nil, fs.notFound(rel, err)
+		}
+		return nil, err
+	}
+	// The stream is the download, so the slot is held until it is closed.
+	return &ReadHandle{stream: &releaseOnClose{ReadCloser: body, release: release}}, nil
+}
+
+// releaseOnClose frees a download slot once the wrapped stream is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// acquireDownload waits for a download slot when MaxConcurrentDownloads is
+// set. The returned func releases the slot.
+func (fs *FileSystem) acquireDownload(ctx context.Context) (func(), error) {
+	if fs.downloads == nil {
+		return func() {}, nil
+	}
+	release := func() { <-fs.downloads }
+	select {
+	case fs.downloads <- struct{}{}:
+		return release, nil
+	default:
+	}
+	waiting := atomic.AddInt64(&fs.queued, 1)
+	defer atomic.AddInt64(&fs.queued, -1)
+	if limit := fs.cfg.MaxQueuedDownloads; limit > 0 && waiting > int64(limit) {
+		return nil, ErrDownloadQueueFull
+	}
+	select {
+	case fs.downloads <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// openObject streams rel from store, using objectstore.Opener when available
+// and otherwise adapting Download onto a pipe.
+func openObject(ctx context.Context, store objectstore.ObjectStore, rel string) (io.ReadCloser, error) {
+	if opener, ok := objectstore.As[objectstore.Opener](store); ok {
+		return opener.Open(ctx, rel)
+	}
+	// Stat first so a missing object surfaces as an error from ReadFile
+	// rather than from the first Read.
+	if _, err := store.Head(ctx, rel); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(store.Download(ctx, rel, &sequentialWriter{w: pw}))
+	}()
+	return pr, nil
+}
+
+// sequentialWriter adapts an io.Writer to io.WriterAt for stores that write
+// their content in order.
+type sequentialWriter struct {
+	w   io.Writer
+	off int64
+}
+
+func (s *sequentialWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off != s.off {
+		return 0, fmt.Errorf("non-sequential write at %d, expected %d", off, s.off)
+	}
+	n, err := s.w.Write(p)
+	s.off += int64(n)
+	return n, err
+}
+
+// ErrNotSeekable is returned by Seek on handles streamed without the cache.
+var ErrNotSeekable = errors.New("handle is not seekable without the cache")
+
+// ReadHandle exposes file content. Cached handles wrap the on-disk copy and
+// can Seek; pass-through handles (Config.NoCache) only support Read and Close.
+type ReadHandle struct {
+	file   *os.File
+	stream io.ReadCloser
+	// release unpins the cache entry behind file so a pending removal can
+	// delete it.
+	release func()
+}
+
+// Read reads from the cached file or the live stream.
+func (h *ReadHandle) Read(p []byte) (int, error) {
+	if h.stream != nil {
+		return h.stream.Read(p)
+	}
+	return h.file.Read(p)
+}
+
+// WriteTo copies the remaining content into w.
+func (h *ReadHandle) WriteTo(w io.Writer) (int64, error) {
+	if h.stream != nil {
+		return io.Copy(w, h.stream)
+	}
+	return io.Copy(w, h.file)
+}
+
+// length returns the size of the content behind h when it is known before
+// reading it: the cache file's size, or the Content-Length of a store body
+// that reports one. Transformed and tailing handles have no known length.
+func (h *ReadHandle) length() (int64, bool) {
+	if h.stream == nil {
+		info, err := h.file.Stat()
+		if err != nil {
+			return 0, false
+		}
+		return info.Size(), true
+	}
+	if r, ok := h.stream.(*releaseOnClose); ok {
+		if body, ok := r.ReadCloser.(objectstore.SizedBody); ok {
+			return body.Size(), true
+		}
+	}
+	return 0, false
+}
+
+// Seekable reports whether Seek is supported, which is the case for handles
+// backed by the cache.
+func (h *ReadHandle) Seekable() bool {
+	return h.stream == nil
+}
+
+// Seek repositions cached handles and fails with ErrNotSeekable otherwise.
+func (h *ReadHandle) Seek(offset int64, whence int) (int64, error) {
+	if h.stream != nil {
+		return 0, ErrNotSeekable
+	}
+	return h.file.Seek(offset, whence)
+}
+
+// Close releases the cached file or the live stream.
+func (h *ReadHandle) Close() error {
+	if h.stream != nil {
+		return h.stream.Close()
+	}
+	err := h.file.Close()
+	if h.release != nil {
+		h.release()
+	}
+	return err
+}
+
+// WarmMetadataCache walks the entire remote tree and caches metadata locally so
+// subsequent stats can be served without network hops. A call made while
+// another warm is in flight does not start a second walk; it waits for the
+// running one and returns its result.
+func (fs *FileSystem) WarmMetadataCache(ctx context.Context) error {
+	run, started := fs.startWarm()
+	if started {
+		return fs.warm(ctx)
+	}
+	select {
+	case <-run.done:
+		return run.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StartRewarm kicks off WarmMetadataCache in the background. It returns false
+// when a warm is already in flight, in which case the request is coalesced
+// into the running one instead of starting another walk.
+func (fs *FileSystem) StartRewarm(ctx context.Context) bool {
+	if !fs.beginWarm() {
+		return false
+	}
+	go func() {
+		_ = fs.warm(ctx)
+	}()
+	return true
+}
+
+// WarmStatus returns a snapshot of the metadata warm progress.
+func (fs *FileSystem) WarmStatus() WarmStatus {
+	fs.warmMu.Lock()
+	defer fs.warmMu.Unlock()
+	return fs.warmStatus
+}
+
+// CacheEntryInfo describes where a remote path lives in the content cache.
+type CacheEntryInfo struct {
+	Path   string `json:"Path"`
+	Key    string `json:"Key"`
+	File   string `json:"File"`
+	Cached bool   `json:"Cached"`
+	// Pinned is set while the entry is excluded from eviction by PinFile.
+	Pinned bool `json:"Pinned"`
+}
+
+// CacheInfo reports the on-disk cache file backing local. It is read-only
+// introspection meant for diagnosing cache problems.
+func (fs *FileSystem) CacheInfo(local string) (CacheEntryInfo, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return CacheEntryInfo{}, err
+	}
+	info := CacheEntryInfo{Path: fs.joinLocal(rel), Key: rel}
+	if fs.cache == nil {
+		return info, nil
+	}
+	info.File, info.Cached = fs.cache.PathFor(rel)
+	entry, _ := fs.cache.Lookup(rel)
+	info.Pinned = entry.Pinned
+	return info, nil
+}
+
+// PinFile downloads local into the content cache if needed and excludes it
+// from eviction until UnpinFile. Pinned files still count toward the cache
+// limits, and pinning fails with cache.ErrPinBudget when they would leave no
+// room for anything else. Pins survive invalidation, such as by WriteFile,
+// but not a restart.
+func (fs *FileSystem) PinFile(ctx context.Context, local string) error {
+	if fs.cache == nil {
+		return fmt.Errorf("content cache is disabled")
+	}
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return err
+	}
+	if rel == "" {
+		return fmt.Errorf("cannot pin directory %s", local)
+	}
+	// Pin while the handle holds the entry, so it cannot be evicted between
+	// the download and the pin.
+	h, err := fs.openCached(ctx, rel)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	return fs.cache.Pin(rel)
+}
+
+// UnpinFile makes local evictable again. Unpinned paths are left alone.
+func (fs *FileSystem) UnpinFile(local string) error {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return err
+	}
+	if fs.cache != nil {
+		fs.cache.Unpin(rel)
+	}
+	return nil
+}
+
+// CacheStats returns the occupancy of the content cache.
+func (fs *FileSystem) CacheStats() cache.Stats {
+	if fs.cache == nil {
+		return cache.Stats{}
+	}
+	return fs.cache.Stats()
+}
+
+// CacheEntries lists the content cache in eviction order, least recently
+// used first. It is empty with Config.NoCache.
+func (fs *FileSystem) CacheEntries() []cache.EntryInfo {
+	if fs.cache == nil {
+		return []cache.EntryInfo{}
+	}
+	return fs.cache.Entries()
+}
+
+// beginWarm marks a warm as in progress, reporting false if one already is.
+func (fs *FileSystem) beginWarm() bool {
+	_, started := fs.startWarm()
+	return started
+}
+
+// warmRun tracks one warm so callers arriving while it runs can wait for it.
+type warmRun struct {
+	done chan struct{}
+	err  error
+}
+
+// startWarm is beginWarm that also returns the warm in flight when one is
+// already running.
+func (fs *FileSystem) startWarm() (*warmRun, bool) {
+	fs.warmMu.Lock()
+	defer fs.warmMu.Unlock()
+	if fs.warmStatus.InProgress {
+		return fs.warmRun, false
+	}
+	fs.warmStatus.InProgress = true
+	fs.warmStatus.LastStarted = time.Now()
+	fs.warmRun = &warmRun{done: make(chan struct{})}
+	return fs.warmRun, true
+}
+
+// warm walks the remote tree and swaps in the new metadata snapshot. The
+// previous snapshot stays in place when the walk fails.
+func (fs *FileSystem) warm(ctx context.Context) error {
+	if fs.cfg.WarmIncremental {
+		live := fs.liveMeta()
+		gen := live.nextGeneration()
+		var pages int
+		err := fs.populateMetadata(ctx, "", live, &pages)
+		if err == nil {
+			live.sweepTree("", gen)
+		}
+		return fs.finishWarm(live, pages, err)
+	}
+	entries := newMetaCache(fs.cfg.MetaCacheMaxEntries)
+	var pages int
+	err := fs.populateMetadata(ctx, "", entries, &pages)
+	return fs.finishWarm(entries, pages, err)
+}
+
+// liveMeta returns the metadata cache, installing one that holds only the
+// root when nothing has been warmed yet.
+func (fs *FileSystem) liveMeta() *metaCache {
+	fs.metaMu.Lock()
+	defer fs.metaMu.Unlock()
+	if fs.meta == nil {
+		fs.meta = newMetaCache(fs.cfg.MetaCacheMaxEntries)
+		fs.meta.put(objectstore.FileMeta{Path: "", IsDir: true})
+	}
+	return fs.meta
+}
+
+// finishWarm installs entries as the metadata snapshot unless err is set and
+// records the outcome, including the pages of listings the warm fetched, in
+// the warm status. A *WarmError from a best-effort warm still installs the
+// entries that were walked.
+func (fs *FileSystem) finishWarm(entries *metaCache, pages int, err error) error {
+	_, partial := AsWarmError(err)
+	if err == nil || partial {
+		fs.metaMu.Lock()
+		fs.meta = entries
+		fs.metaMu.Unlock()
+	}
+
+	fs.warmMu.Lock()
+	defer fs.warmMu.Unlock()
+	if run := fs.warmRun; run != nil {
+		run.err = err
+		close(run.done)
+	}
+	fs.warmStatus.InProgress = false
+	fs.warmStatus.LastError = ""
+	if err != nil {
+		fs.warmStatus.LastError = err.Error()
+	}
+	if err == nil || partial {
+		fs.warmStatus.LastCompleted = time.Now()
+		fs.warmStatus.Entries = entries.len()
+		fs.warmStatus.ListPages = pages
+	}
+	return err
+}
+
+// WarmMetadataCachePrefix walks only the subtree beneath local and merges it
+// into the metadata cache, replacing whatever was cached for that subtree.
+// Entries outside the prefix are left untouched, so hot areas can be warmed
+// without paying for the whole bucket. A prefix with nothing below it and no
+// directory marker fails with a NotFoundError and drops what was cached for
+// it.
+func (fs *FileSystem) WarmMetadataCachePrefix(ctx context.Context, local string) error {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return err
+	}
+	if rel == "" {
+		return fs.WarmMetadataCache(ctx)
+	}
+	if fs.cfg.WarmIncremental {
+		live := fs.liveMeta()
+		gen := live.nextGeneration()
+		err = fs.populateMetadata(ctx, rel, live, nil)
+		if IsNotFound(err) {
+			live.removeTree(rel)
+			return err
+		}
+		if _, partial := AsWarmError(err); err == nil || partial {
+			putAncestors(live, rel)
+		}
+		if err == nil {
+			live.sweepTree(rel, gen)
+		}
+		return err
+	}
+	entries := newMetaCache(fs.cfg.MetaCacheMaxEntries)
+	err = fs.populateMetadata(ctx, rel, entries, nil)
+	if IsNotFound(err) {
+		fs.liveMeta().removeTree(rel)
+		return err
+	}
+	if _, partial := AsWarmError(err); err != nil && !partial {
+		return err
+	}
+
+	live := fs.liveMeta()
+	fs.metaMu.Lock()
+	defer fs.metaMu.Unlock()
+	live.removeTree(rel)
+	putAncestors(live, rel)
+	entries.each(live.put)
+	return err
+}
+
+// putAncestors adds the directories above rel to m unless they are cached.
+func putAncestors(m *metaCache, rel string) {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		m.putIfAbsent(objectstore.FileMeta{Path: dir, IsDir: true})
+	}
+}
+
+// WarmMetadataCachePrefixes warms each prefix concurrently via
+// WarmMetadataCachePrefix. The subdirectories skipped by best-effort warms
+// are merged into one *WarmError, which is returned alone when no prefix
+// failed outright; otherwise every failure is joined into the error.
+func (fs *FileSystem) WarmMetadataCachePrefixes(ctx context.Context, locals []string) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(locals))
+	for i, local := range locals {
+		wg.Add(1)
+		go func(i int, local string) {
+			defer wg.Done()
+			errs[i] = fs.WarmMetadataCachePrefix(ctx, local)
+		}(i, local)
+	}
+	wg.Wait()
+	var (
+		hard    []error
+		skipped WarmError
+	)
+	for i, err := range errs {
+		if warmErr, ok := AsWarmError(err); ok {
+			skipped.Failures = append(skipped.Failures, warmErr.Failures...)
+		} else if err != nil {
+			hard = append(hard, fmt.Errorf("warm %s: %w", locals[i], err))
+		}
+	}
+	if len(skipped.Failures) > 0 {
+		hard = append(hard, &skipped)
+	}
+	if len(hard) == 1 {
+		return hard[0]
+	}
+	return errors.Join(hard...)
+}
+
+// cachedMeta returns the cached metadata entry when WarmMetadataCache has
+// already enumerated the tree. With Config.MetaCacheMaxEntries the entry may
+// have been evicted, and callers fall back to the store.
+func (fs *FileSystem) cachedMeta(rel string) (objectstore.FileMeta, bool) {
+	fs.metaMu.RLock()
+	defer fs.metaMu.RUnlock()
+	if fs.meta == nil {
+		return objectstore.FileMeta{}, false
+	}
+	return fs.meta.get(rel)
+}
+
+// refillMeta adds meta, fetched from the store after a metadata cache miss,
+// back to a bounded cache. Unbounded caches are left as the warm built them.
+func (fs *FileSystem) refillMeta(meta objectstore.FileMeta) {
+	if fs.cfg.MetaCacheMaxEntries <= 0 {
+		return
+	}
+	fs.metaMu.RLock()
+	defer fs.metaMu.RUnlock()
+	if fs.meta != nil {
+		fs.meta.put(meta)
+	}
+}
+
+// populateMetadata recursively walks the remote namespace and stores every
+// object/directory inside dst for later lookups. Each directory entry records
+// the total size of its descendants and its number of direct children, so
+// warmed directory stats are informative without another List. With
+// Config.WarmBestEffort, subdirectories that fail to list are skipped and
+// reported in a *WarmError once the walk is done. When pages is non-nil it
+// is increased by the number of list requests made.
+func (fs *FileSystem) populateMetadata(ctx context.Context, rel string, dst *metaCache, pages *int) error {
+	var failed *WarmError
+	if fs.cfg.WarmBestEffort {
+		failed = &WarmError{}
+	}
+	if pages == nil {
+		pages = new(int)
+	}
+	dir, err := fs.walkMetadata(ctx, objectstore.FileMeta{Path: rel, IsDir: true}, dst, failed, pages)
+	if err != nil {
+		return err
+	}
+	if rel != "" && dir.ChildCount == 0 && fs.expandable(rel) {
+		if err := fs.confirmDir(ctx, rel); err != nil {
+			return err
+		}
+	}
+	dst.put(dir)
+	if failed != nil && len(failed.Failures) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// confirmDir checks that rel, whose listing came back empty, is a directory
+// by looking for its marker object. Without one it is a NotFoundError.
+func (fs *FileSystem) confirmDir(ctx context.Context, rel string) error {
+	err := objectstore.HeadDirMarker(ctx, fs.store, rel)
+	if err == nil {
+		return nil
+	}
+	if objectstore.IsNotFound(err) || errors.Is(err, objectstore.ErrDirMarkersNotSupported) {
+		return fs.notFound(rel, err)
+	}
+	return err
+}
+
+// walkMetadata does the work of populateMetadata. It stores everything below
+// dir in dst and returns dir with its totals filled in, which the caller
+// stores; dst may already have evicted entries of a bounded cache, so totals
+// never come from it. Failed subdirectories are recorded in failed when it is
+// non-nil and abort the walk otherwise.
+func (fs *FileSystem) walkMetadata(ctx context.Context, dir objectstore.FileMeta, dst *metaCache, failed *WarmError, pages *int) (objectstore.FileMeta, error) {
+	select {
+	case <-ctx.Done():
+		return dir, ctx.Err()
+	default:
+	}
+	if !fs.expandable(dir.Path) {
+		// The subtree is not walked, so the totals are unknown rather
+		// than zero.
+		dir.IsDir = true
+		dir.Incomplete = true
+		return dir, nil
+	}
+	items, stats, err := objectstore.ListWithStats(ctx, fs.store, dir.Path)
+	*pages += stats.Pages
+	if err != nil {
+		if objectstore.IsNotFound(err) {
+			return dir, nil
+		}
+		return dir, err
+	}
+	var total int64
+	for _, item := range items {
+		if item.IsDir {
+			sub, err := fs.walkMetadata(ctx, item, dst, failed, pages)
+			if err != nil {
+				if failed == nil || ctx.Err() != nil {
+					return dir, err
+				}
+				failed.Failures = append(failed.Failures, WarmFailure{Path: fs.joinLocal(item.Path), Err: err})
+				sub = item
+				sub.Incomplete = true
+			}
+			dst.put(sub)
+			dir.Incomplete = dir.Incomplete || sub.Incomplete
+			total += sub.Size
+			continue
+		}
+		dst.put(item)
+		total += item.Size
+	}
+	dir.IsDir = true
+	dir.Size = total
+	dir.ChildCount = len(items)
+	return dir, nil
 }
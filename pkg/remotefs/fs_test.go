@@ -1,12 +1,20 @@
 package remotefs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"example.com/s3rofs/pkg/cache"
 	"example.com/s3rofs/pkg/objectstore"
 )
 
@@ -73,14 +81,131 @@ func TestSanitizeNoRoot(t *testing.T) {
 	}
 }
 
+// denyPolicy wraps the default policy, rejecting one prefix and rewriting
+// another.
+type denyPolicy struct {
+	base PathPolicy
+}
+
+func (p denyPolicy) Resolve(local string) (string, error) {
+	rel, err := p.base.Resolve(local)
+	if err != nil {
+		return "", err
+	}
+	if rel == "secret" || strings.HasPrefix(rel, "secret/") {
+		return "", errors.New("denied")
+	}
+	if strings.HasPrefix(rel, "old/") {
+		rel = "new/" + strings.TrimPrefix(rel, "old/")
+	}
+	return rel, nil
+}
+
+func TestPathPolicyOverridesSanitize(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "data")
+	fs, err := New(&statTestStore{}, Config{
+		LocalRoot:  root,
+		NoCache:    true,
+		PathPolicy: denyPolicy{base: DefaultPathPolicy(root)},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := fs.sanitize(filepath.Join(root, "secret", "key")); err == nil {
+		t.Fatalf("expected denied path to fail")
+	}
+	if _, err := fs.sanitize(filepath.Join(root, "..", "etc")); err == nil {
+		t.Fatalf("expected wrapped default policy to reject escape")
+	}
+	got, err := fs.sanitize(filepath.Join(root, "old", "file.txt"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "new/file.txt" {
+		t.Fatalf("sanitize rewrite = %q, want new/file.txt", got)
+	}
+}
+
+func TestSlashRootUsesPOSIXPaths(t *testing.T) {
+	fs, err := New(&statTestStore{}, Config{NoCache: true, SlashRoot: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if got := fs.LocalRoot(); got != "/" {
+		t.Fatalf("LocalRoot = %q, want /", got)
+	}
+	tests := map[string]string{
+		"/":               "",
+		"/alpha/beta":     "alpha/beta",
+		"//alpha/./beta/": "alpha/beta",
+		"/alpha/../beta":  "beta",
+		"/../alpha":       "alpha",
+	}
+	for local, want := range tests {
+		got, err := fs.sanitize(local)
+		if err != nil || got != want {
+			t.Fatalf("sanitize(%q) = %q, %v; want %q", local, got, err, want)
+		}
+	}
+	if _, err := fs.sanitize("../alpha"); err == nil {
+		t.Fatalf("expected relative escape to fail")
+	}
+	if got := fs.joinLocal("alpha/beta"); got != "/alpha/beta" {
+		t.Fatalf("joinLocal = %q, want /alpha/beta", got)
+	}
+
+	fs, err = New(&statTestStore{}, Config{NoCache: true, SlashRoot: true, LocalRoot: "/data/"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if got := fs.LocalRoot(); got != "/data" {
+		t.Fatalf("LocalRoot = %q, want /data", got)
+	}
+	if got, err := fs.sanitize("/data/docs/a.txt"); err != nil || got != "docs/a.txt" {
+		t.Fatalf("sanitize under root = %q, %v", got, err)
+	}
+	if _, err := fs.sanitize("/data-mirror/a.txt"); err == nil {
+		t.Fatalf("expected prefix lookalike to fail")
+	}
+	if got, err := fs.Relative("docs/a.txt"); err != nil || got != "/data/docs/a.txt" {
+		t.Fatalf("Relative = %q, %v", got, err)
+	}
+}
+
+func TestRelativeJoinsLocalRoot(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "data")
+	fs := &FileSystem{localRoot: root}
+
+	got, err := fs.Relative("docs/report.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(root, "docs", "report.txt"); got != want {
+		t.Fatalf("Relative = %q, want %q", got, want)
+	}
+	rel, err := fs.sanitize(got)
+	if err != nil {
+		t.Fatalf("sanitize relative result: %v", err)
+	}
+	if rel != "docs/report.txt" {
+		t.Fatalf("sanitize(%q) = %q", got, rel)
+	}
+	if got, err := fs.Relative(""); err != nil || got != root {
+		t.Fatalf("Relative(\"\") = %q, %v; want root", got, err)
+	}
+	if _, err := fs.Relative("../etc/passwd"); err == nil {
+		t.Fatalf("expected error for path escaping the root")
+	}
+}
+
 func TestStatDetectsDirectoryViaListing(t *testing.T) {
-	store := &statTestStore{
+	store := &notFoundStore{statTestStore{
 		listing: map[string][]objectstore.FileMeta{
 			"docs": {
 				{Path: "docs/report.txt"},
 			},
 		},
-	}
+	}}
 	fs := &FileSystem{
 		store: store,
 	}
@@ -97,6 +222,86 @@ func TestStatDetectsDirectoryViaListing(t *testing.T) {
 	}
 }
 
+func TestStatAndReadDirAcceptTrailingSlash(t *testing.T) {
+	store := &notFoundStore{statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"docs": {{Path: "docs/report.txt"}},
+		},
+	}}
+	for _, slashRoot := range []bool{false, true} {
+		fs, err := New(store, Config{NoCache: true, SlashRoot: slashRoot})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		dir := fs.joinLocal("docs")
+		for _, local := range []string{dir, dir + string(filepath.Separator), dir + "/"} {
+			meta, err := fs.Stat(context.Background(), local)
+			if err != nil || !meta.IsDir || meta.Path != "docs" {
+				t.Fatalf("stat %q (slash root %v) = %+v, %v", local, slashRoot, meta, err)
+			}
+			entries, err := fs.ReadDir(context.Background(), local)
+			if err != nil || len(entries) != 1 || entries[0].Path != "docs/report.txt" {
+				t.Fatalf("readdir %q (slash root %v) = %+v, %v", local, slashRoot, entries, err)
+			}
+		}
+	}
+}
+
+func TestMaxPathDepth(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"":      {{Path: "a", IsDir: true}},
+			"a":     {{Path: "a/b", IsDir: true}},
+			"a/b":   {{Path: "a/b/c", IsDir: true}},
+			"a/b/c": {{Path: "a/b/c/d.txt", Size: 1}},
+		},
+	}
+	fs, err := New(store, Config{NoCache: true, SlashRoot: true, MaxPathDepth: 3})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tests := []struct {
+		local string
+		ok    bool
+	}{
+		{local: "/a/b", ok: true},
+		{local: "/a/b/c", ok: true},
+		{local: "/a/b/c/", ok: true},
+		{local: "/a/b/c/d.txt", ok: false},
+		{local: "/a/b/c/../../x/y", ok: true},
+	}
+	for _, tt := range tests {
+		_, err := fs.sanitize(tt.local)
+		if (err == nil) != tt.ok {
+			t.Fatalf("sanitize(%q) = %v, want success %v", tt.local, err, tt.ok)
+		}
+		if !tt.ok && !IsPathTooDeep(err) {
+			t.Fatalf("sanitize(%q) = %v, want a PathTooDeepError", tt.local, err)
+		}
+	}
+
+	// Walks stop at the directory whose children would be too deep.
+	if err := fs.WarmMetadataCache(context.Background()); err != nil {
+		t.Fatalf("warm: %v", err)
+	}
+	for _, key := range store.listCalls {
+		if key == "a/b/c" {
+			t.Fatalf("warm listed a/b/c, whose children exceed the limit")
+		}
+	}
+	if meta, ok := fs.cachedMeta("a/b/c"); !ok || !meta.Incomplete || meta.ChildCount != 0 {
+		t.Fatalf("directory at the limit = %+v, %v, want it cached as incomplete", meta, ok)
+	}
+	store.listCalls = nil
+	res, err := fs.ReadDirRecursive(context.Background(), "/", -1)
+	if err != nil {
+		t.Fatalf("recursive readdir: %v", err)
+	}
+	if n := len(res); n != 3 || res[n-1].Path != "a/b/c" {
+		t.Fatalf("recursive readdir = %+v, want a, a/b, a/b/c", res)
+	}
+}
+
 type statTestStore struct {
 	head      map[string]objectstore.FileMeta
 	listing   map[string][]objectstore.FileMeta
@@ -133,6 +338,42 @@ func (s *statTestStore) Download(ctx context.Context, key string, dst io.WriterA
 	return nil
 }
 
+func (s *statTestStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// notFoundStore is statTestStore reporting unknown keys as
+// objectstore.NotFoundError, like the real stores do.
+type notFoundStore struct {
+	statTestStore
+}
+
+func (s *notFoundStore) Head(ctx context.Context, key string) (objectstore.FileMeta, error) {
+	meta, err := s.statTestStore.Head(ctx, key)
+	if err != nil && s.headErr == nil {
+		return meta, objectstore.NotFoundError{Key: key}
+	}
+	return meta, err
+}
+
+func TestSizeDistinguishesDirectories(t *testing.T) {
+	store := &notFoundStore{statTestStore{
+		head:    map[string]objectstore.FileMeta{"logs/app.log": {Path: "logs/app.log", Size: 7}},
+		listing: map[string][]objectstore.FileMeta{"logs": {{Path: "logs/app.log", Size: 7}}},
+	}}
+	fs := &FileSystem{store: store}
+	ctx := context.Background()
+	if size, err := fs.Size(ctx, "/logs/app.log"); err != nil || size != 7 {
+		t.Fatalf("size = %d, %v", size, err)
+	}
+	if _, err := fs.Size(ctx, "/logs"); err == nil || IsNotFound(err) {
+		t.Fatalf("directory size = %v, want a directory error", err)
+	}
+	if _, err := fs.Size(ctx, "/nope"); !IsNotFound(err) {
+		t.Fatalf("missing size = %v, want NotFoundError", err)
+	}
+}
+
 func TestWarmMetadataCachePopulatesEntries(t *testing.T) {
 	store := &statTestStore{
 		listing: map[string][]objectstore.FileMeta{
@@ -168,6 +409,383 @@ func TestWarmMetadataCachePopulatesEntries(t *testing.T) {
 	}
 }
 
+func TestBoundedMetadataCacheRefetchesEvictedEntries(t *testing.T) {
+	store := &statTestStore{
+		head: map[string]objectstore.FileMeta{
+			"docs/report.txt": {Path: "docs/report.txt", Size: 42},
+		},
+		listing: map[string][]objectstore.FileMeta{
+			"": {
+				{Path: "docs", IsDir: true},
+				{Path: "readme.txt", Size: 10},
+			},
+			"docs": {
+				{Path: "docs/report.txt", Size: 42},
+				{Path: "docs/archive", IsDir: true},
+			},
+			"docs/archive": {
+				{Path: "docs/archive/old.txt", Size: 5},
+			},
+		},
+	}
+	fs := &FileSystem{store: store, cfg: Config{MetaCacheMaxEntries: 3}}
+	if err := fs.WarmMetadataCache(context.Background()); err != nil {
+		t.Fatalf("warm cache: %v", err)
+	}
+	if n := fs.WarmStatus().Entries; n != 3 {
+		t.Fatalf("warm kept %d entries, want 3", n)
+	}
+	// Totals are complete even though the files below were evicted.
+	if meta, ok := fs.cachedMeta("docs"); !ok || meta.Size != 47 || meta.ChildCount != 2 {
+		t.Fatalf("docs = %+v, %v", meta, ok)
+	}
+	if root, ok := fs.cachedMeta(""); !ok || root.Size != 57 {
+		t.Fatalf("root = %+v, %v", root, ok)
+	}
+	if _, ok := fs.cachedMeta("docs/report.txt"); ok {
+		t.Fatalf("report should have been evicted")
+	}
+	for i := 0; i < 2; i++ {
+		meta, err := fs.Stat(context.Background(), "/docs/report.txt")
+		if err != nil || meta.Size != 42 {
+			t.Fatalf("stat = %+v, %v", meta, err)
+		}
+	}
+	if store.headCalls != 1 {
+		t.Fatalf("head calls = %d, want the refetched entry cached", store.headCalls)
+	}
+}
+
+// markerStore answers HeadDirMarker from a set of directory markers.
+type markerStore struct {
+	notFoundStore
+	markers map[string]bool
+}
+
+func (s *markerStore) HeadDirMarker(ctx context.Context, key string) error {
+	if !s.markers[key] {
+		return objectstore.NotFoundError{Key: key + "/"}
+	}
+	return nil
+}
+
+func TestStatDetectsDirectoryViaMarker(t *testing.T) {
+	store := &markerStore{markers: map[string]bool{"docs": true}}
+	store.listing = map[string][]objectstore.FileMeta{"docs": {{Path: "docs/a.txt"}}}
+	fs := &FileSystem{store: store, cfg: Config{DirectoryMarkers: true}}
+	ctx := context.Background()
+	docs := filepath.Join(string(filepath.Separator), "docs")
+	if meta, err := fs.Stat(ctx, docs); err != nil || !meta.IsDir || meta.Path != "docs" {
+		t.Fatalf("stat docs = %+v, %v", meta, err)
+	}
+	if _, err := fs.Stat(ctx, filepath.Join(string(filepath.Separator), "gone")); !IsNotFound(err) {
+		t.Fatalf("stat without marker: %v", err)
+	}
+	if ok, err := fs.Exists(ctx, docs); err != nil || !ok {
+		t.Fatalf("exists docs = %v, %v", ok, err)
+	}
+	if len(store.listCalls) != 0 {
+		t.Fatalf("marker mode listed %v", store.listCalls)
+	}
+
+	// Without the mode the same directory is found by listing.
+	fs = &FileSystem{store: store}
+	if meta, err := fs.Stat(ctx, docs); err != nil || !meta.IsDir {
+		t.Fatalf("stat by listing = %+v, %v", meta, err)
+	}
+	if len(store.listCalls) != 1 {
+		t.Fatalf("list calls = %v, want one", store.listCalls)
+	}
+}
+
+// lockStore reports a fixed Object Lock status for every object.
+type lockStore struct {
+	statTestStore
+	lock objectstore.ObjectLock
+}
+
+func (s *lockStore) GetObjectLock(ctx context.Context, key string) (objectstore.ObjectLock, error) {
+	return s.lock, nil
+}
+
+func TestStatLocks(t *testing.T) {
+	head := map[string]objectstore.FileMeta{"ledger.csv": {Path: "ledger.csv", Size: 3}}
+	store := &lockStore{lock: objectstore.ObjectLock{Mode: "GOVERNANCE", LegalHold: true}}
+	store.head = head
+	fs := &FileSystem{store: store}
+	local := filepath.Join(string(filepath.Separator), "ledger.csv")
+	meta, err := fs.StatLocks(context.Background(), local)
+	if err != nil || meta.Lock == nil || *meta.Lock != store.lock {
+		t.Fatalf("stat locks = %+v, %v", meta, err)
+	}
+	if meta, err := fs.Stat(context.Background(), local); err != nil || meta.Lock != nil {
+		t.Fatalf("plain stat = %+v, %v, want no lock status", meta, err)
+	}
+
+	fs = &FileSystem{store: &statTestStore{head: head}}
+	if _, err := fs.StatLocks(context.Background(), local); !errors.Is(err, objectstore.ErrObjectLockNotSupported) {
+		t.Fatalf("unsupported store: %v", err)
+	}
+}
+
+// flakyListStore fails List for the prefixes in fail.
+type flakyListStore struct {
+	statTestStore
+	fail map[string]error
+}
+
+func (s *flakyListStore) List(ctx context.Context, key string) ([]objectstore.FileMeta, error) {
+	if err, ok := s.fail[key]; ok {
+		return nil, err
+	}
+	return s.statTestStore.List(ctx, key)
+}
+
+func TestWarmMetadataCacheBestEffort(t *testing.T) {
+	store := &flakyListStore{
+		statTestStore: statTestStore{
+			listing: map[string][]objectstore.FileMeta{
+				"": {
+					{Path: "good", IsDir: true},
+					{Path: "flaky", IsDir: true},
+				},
+				"good": {{Path: "good/a.txt", Size: 1}},
+			},
+		},
+		fail: map[string]error{"flaky": errors.New("slow down")},
+	}
+	fs := &FileSystem{store: store}
+	if err := fs.WarmMetadataCache(context.Background()); err == nil || fs.meta != nil {
+		t.Fatalf("fail-fast warm: err=%v, cache installed=%v", err, fs.meta != nil)
+	}
+
+	fs.cfg.WarmBestEffort = true
+	err := fs.WarmMetadataCache(context.Background())
+	warmErr, ok := AsWarmError(err)
+	if !ok {
+		t.Fatalf("expected WarmError, got %v", err)
+	}
+	if len(warmErr.Failures) != 1 || !strings.HasSuffix(warmErr.Failures[0].Path, "flaky") {
+		t.Fatalf("failures = %+v", warmErr.Failures)
+	}
+	if _, ok := fs.cachedMeta("good/a.txt"); !ok {
+		t.Fatalf("healthy prefix missing from cache")
+	}
+	if status := fs.WarmStatus(); status.LastCompleted.IsZero() || status.LastError == "" {
+		t.Fatalf("status = %+v", status)
+	}
+	// The skipped directory and its ancestors do not pass for complete.
+	for _, rel := range []string{"flaky", ""} {
+		if meta, ok := fs.cachedMeta(rel); !ok || !meta.Incomplete {
+			t.Fatalf("%q = %+v, want incomplete", rel, meta)
+		}
+	}
+	if meta, _ := fs.cachedMeta("good"); meta.Incomplete {
+		t.Fatalf("good = %+v, want complete", meta)
+	}
+}
+
+// lockedFlakyListStore serializes List so concurrent prefix warms can share
+// the flakyListStore bookkeeping.
+type lockedFlakyListStore struct {
+	*flakyListStore
+	mu sync.Mutex
+}
+
+func (s *lockedFlakyListStore) List(ctx context.Context, key string) ([]objectstore.FileMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flakyListStore.List(ctx, key)
+}
+
+func TestWarmMetadataCachePrefixesReportsEveryError(t *testing.T) {
+	store := &lockedFlakyListStore{flakyListStore: &flakyListStore{
+		statTestStore: statTestStore{
+			listing: map[string][]objectstore.FileMeta{
+				"mixed": {{Path: "mixed/a.txt", Size: 1}, {Path: "mixed/flaky", IsDir: true}},
+			},
+		},
+		fail: map[string]error{
+			"mixed/flaky": errors.New("slow down"),
+			"broken":      errors.New("access denied"),
+			"down":        errors.New("connection reset"),
+		},
+	}}
+	fs := &FileSystem{store: store, cfg: Config{WarmBestEffort: true}}
+	sep := string(filepath.Separator)
+	err := fs.WarmMetadataCachePrefixes(context.Background(), []string{sep + "mixed", sep + "broken", sep + "down"})
+	for _, want := range []string{"access denied", "connection reset", "slow down"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("err = %v, want it to report %q", err, want)
+		}
+	}
+	if warmErr, ok := AsWarmError(err); !ok || len(warmErr.Failures) != 1 {
+		t.Fatalf("err = %v, want the skipped directory as a WarmError", err)
+	}
+
+	err = fs.WarmMetadataCachePrefixes(context.Background(), []string{sep + "mixed"})
+	if _, ok := err.(*WarmError); !ok {
+		t.Fatalf("best-effort only err = %T %v, want a bare WarmError", err, err)
+	}
+}
+
+// blockingListStore holds List of one key until release is closed and
+// reports on listing when it starts waiting.
+type blockingListStore struct {
+	statTestStore
+	key     string
+	listing chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingListStore) List(ctx context.Context, key string) ([]objectstore.FileMeta, error) {
+	if key == s.key {
+		close(s.listing)
+		<-s.release
+	}
+	return s.statTestStore.List(ctx, key)
+}
+
+func TestWarmMetadataCacheJoinsRunningWarm(t *testing.T) {
+	store := &blockingListStore{
+		statTestStore: statTestStore{
+			listing: map[string][]objectstore.FileMeta{
+				"":     {{Path: "slow", IsDir: true}},
+				"slow": {{Path: "slow/a.txt", Size: 1}},
+			},
+		},
+		key:     "slow",
+		listing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	fs := &FileSystem{store: store}
+	first := make(chan error)
+	go func() { first <- fs.WarmMetadataCache(context.Background()) }()
+	<-store.listing
+
+	second := make(chan error)
+	go func() { second <- fs.WarmMetadataCache(context.Background()) }()
+	select {
+	case err := <-second:
+		t.Fatalf("second warm returned %v before the first finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(store.release)
+	if err := <-first; err != nil {
+		t.Fatalf("first warm: %v", err)
+	}
+	if err := <-second; err != nil {
+		t.Fatalf("second warm: %v", err)
+	}
+	if n := len(store.listCalls); n != 2 {
+		t.Fatalf("list calls = %v, want a single walk", store.listCalls)
+	}
+	if _, ok := fs.cachedMeta("slow/a.txt"); !ok {
+		t.Fatalf("warmed entry missing after the joined warm returned")
+	}
+}
+
+func TestWarmIncrementalPublishesAsItGoes(t *testing.T) {
+	store := &blockingListStore{
+		statTestStore: statTestStore{
+			listing: map[string][]objectstore.FileMeta{
+				"": {
+					{Path: "fast", IsDir: true},
+					{Path: "slow", IsDir: true},
+				},
+				"fast": {{Path: "fast/a.txt", Size: 1}},
+				"slow": {{Path: "slow/b.txt", Size: 2}},
+			},
+		},
+		key:     "slow",
+		listing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	fs := &FileSystem{store: store, cfg: Config{WarmIncremental: true}}
+	// Left over from an earlier warm; the object has since been deleted.
+	fs.meta = metaCacheFrom(map[string]objectstore.FileMeta{
+		"":         {Path: "", IsDir: true},
+		"gone.txt": {Path: "gone.txt", Size: 9},
+	}, 0)
+	done := make(chan error)
+	go func() { done <- fs.WarmMetadataCache(context.Background()) }()
+
+	<-store.listing
+	if meta, ok := fs.cachedMeta("fast"); !ok || meta.Size != 1 {
+		t.Fatalf("walked directory not published mid-warm: %+v, %v", meta, ok)
+	}
+	if _, ok := fs.cachedMeta("slow"); ok {
+		t.Fatalf("directory published before its subtree was walked")
+	}
+	if _, ok := fs.cachedMeta("gone.txt"); !ok {
+		t.Fatalf("previous entry dropped before the warm finished")
+	}
+	close(store.release)
+	if err := <-done; err != nil {
+		t.Fatalf("warm: %v", err)
+	}
+	if _, ok := fs.cachedMeta("gone.txt"); ok {
+		t.Fatalf("stale entry survived a successful warm")
+	}
+	if meta, ok := fs.cachedMeta(""); !ok || meta.Size != 3 {
+		t.Fatalf("root = %+v, %v; want size 3", meta, ok)
+	}
+	if status := fs.WarmStatus(); status.Entries != 5 {
+		t.Fatalf("status = %+v, want 5 entries", status)
+	}
+}
+
+func TestWarmMetadataCacheAggregatesDirectories(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"": {
+				{Path: "docs", IsDir: true},
+				{Path: "readme.txt", Size: 10},
+			},
+			"docs": {
+				{Path: "docs/report.txt", Size: 42},
+				{Path: "docs/archive", IsDir: true},
+			},
+			"docs/archive": {
+				{Path: "docs/archive/old.txt", Size: 5},
+			},
+		},
+	}
+	fs := &FileSystem{store: store}
+	if err := fs.WarmMetadataCache(context.Background()); err != nil {
+		t.Fatalf("warm cache: %v", err)
+	}
+	// One listing per directory, each a single page for this store.
+	if pages := fs.WarmStatus().ListPages; pages != 3 {
+		t.Fatalf("warm fetched %d list pages, want 3", pages)
+	}
+	tests := []struct {
+		path     string
+		size     int64
+		children int
+	}{
+		{path: "", size: 57, children: 2},
+		{path: "docs", size: 47, children: 2},
+		{path: "docs/archive", size: 5, children: 1},
+	}
+	for _, tt := range tests {
+		meta, ok := fs.cachedMeta(tt.path)
+		if !ok {
+			t.Fatalf("%q missing from cache", tt.path)
+		}
+		if !meta.IsDir || meta.Size != tt.size || meta.ChildCount != tt.children {
+			t.Fatalf("%q aggregate = size %d children %d, want %d/%d", tt.path, meta.Size, meta.ChildCount, tt.size, tt.children)
+		}
+	}
+	meta, err := fs.Stat(context.Background(), filepath.Join(string(filepath.Separator), "docs"))
+	if err != nil {
+		t.Fatalf("stat docs: %v", err)
+	}
+	if meta.Size != 47 {
+		t.Fatalf("stat docs size = %d, want 47", meta.Size)
+	}
+}
+
 func TestStatUsesCachedMetadata(t *testing.T) {
 	store := &statTestStore{
 		listing: map[string][]objectstore.FileMeta{
@@ -198,3 +816,1185 @@ func TestStatUsesCachedMetadata(t *testing.T) {
 		t.Fatalf("expected no head calls, got %d", store.headCalls)
 	}
 }
+
+// lateStore reports NotFound for Head until it has been called hidden times,
+// mimicking an eventually consistent backend right after a write.
+type lateStore struct {
+	statTestStore
+	hidden int
+}
+
+func (s *lateStore) Head(ctx context.Context, key string) (objectstore.FileMeta, error) {
+	s.headCalls++
+	if s.headCalls <= s.hidden {
+		return objectstore.FileMeta{}, objectstore.NotFoundError{Key: key}
+	}
+	return objectstore.FileMeta{Path: key, Size: 7}, nil
+}
+
+func TestStatRetriesNotFoundAfterWrite(t *testing.T) {
+	store := &lateStore{hidden: 2}
+	fs := &FileSystem{
+		store: store,
+		cfg: Config{
+			WriteVisibilityRetries: 3,
+			WriteVisibilityDelay:   time.Millisecond,
+		},
+	}
+	local := filepath.Join(string(filepath.Separator), "fresh.txt")
+	if err := fs.ExpectWritten(local); err != nil {
+		t.Fatalf("expect written: %v", err)
+	}
+	meta, err := fs.Stat(context.Background(), local)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if meta.Size != 7 {
+		t.Fatalf("stat size = %d", meta.Size)
+	}
+	if store.headCalls != 3 {
+		t.Fatalf("head calls = %d, want 3", store.headCalls)
+	}
+}
+
+func TestStatFailsFastWithoutPendingWrite(t *testing.T) {
+	store := &lateStore{hidden: 2}
+	fs := &FileSystem{
+		store: store,
+		cfg: Config{
+			WriteVisibilityRetries: 3,
+			WriteVisibilityDelay:   time.Millisecond,
+		},
+	}
+	local := filepath.Join(string(filepath.Separator), "absent.txt")
+	if _, err := fs.Stat(context.Background(), local); !IsNotFound(err) {
+		t.Fatalf("expected NotFoundError, got %v", err)
+	}
+	if store.headCalls != 1 {
+		t.Fatalf("head calls = %d, want 1", store.headCalls)
+	}
+}
+
+// staleListStore omits fresh.txt from the listing of "docs" until it has been
+// listed hidden times.
+type staleListStore struct {
+	statTestStore
+	hidden int
+	calls  int
+}
+
+func (s *staleListStore) List(ctx context.Context, key string) ([]objectstore.FileMeta, error) {
+	s.calls++
+	items := []objectstore.FileMeta{{Path: "docs/old.txt"}}
+	if s.calls > s.hidden {
+		items = append(items, objectstore.FileMeta{Path: "docs/fresh.txt"})
+	}
+	return items, nil
+}
+
+func TestReadDirRetriesStaleListingAfterWrite(t *testing.T) {
+	for _, tt := range []struct {
+		retry bool
+		calls int
+	}{
+		{retry: false, calls: 1},
+		{retry: true, calls: 3},
+	} {
+		store := &staleListStore{hidden: 2}
+		fs := &FileSystem{
+			store: store,
+			cfg: Config{
+				WriteVisibilityRetries: 3,
+				WriteVisibilityDelay:   time.Millisecond,
+				RetryStaleListings:     tt.retry,
+			},
+		}
+		docs := filepath.Join(string(filepath.Separator), "docs")
+		if err := fs.ExpectWritten(filepath.Join(docs, "fresh.txt")); err != nil {
+			t.Fatalf("expect written: %v", err)
+		}
+		items, err := fs.ReadDir(context.Background(), docs)
+		if err != nil {
+			t.Fatalf("read dir: %v", err)
+		}
+		if store.calls != tt.calls || (len(items) == 2) != tt.retry {
+			t.Fatalf("retry %v: %d list calls, %d entries", tt.retry, store.calls, len(items))
+		}
+	}
+}
+
+// pagedStore fails the first listing after one page and serves the rest when
+// resumed from the reported token.
+type pagedStore struct {
+	statTestStore
+}
+
+func (s *pagedStore) List(ctx context.Context, key string) ([]objectstore.FileMeta, error) {
+	return []objectstore.FileMeta{{Path: "big/a.txt"}}, &objectstore.PartialListError{
+		Key:   key,
+		Token: "page-2",
+		Err:   errors.New("connection reset"),
+	}
+}
+
+func (s *pagedStore) ListFrom(ctx context.Context, key, token string) ([]objectstore.FileMeta, error) {
+	if token != "page-2" {
+		return nil, errors.New("unexpected token " + token)
+	}
+	return []objectstore.FileMeta{{Path: "big/b.txt"}}, nil
+}
+
+func TestReadDirReturnsPartialResults(t *testing.T) {
+	fs := &FileSystem{store: &pagedStore{}}
+	local := filepath.Join(string(filepath.Separator), "big")
+	items, err := fs.ReadDir(context.Background(), local)
+	partial, ok := objectstore.AsPartialList(err)
+	if !ok {
+		t.Fatalf("expected partial list error, got %v", err)
+	}
+	if len(items) != 1 || items[0].Path != "big/a.txt" {
+		t.Fatalf("unexpected partial items: %+v", items)
+	}
+	rest, err := fs.ReadDirFrom(context.Background(), local, partial.Token)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if len(rest) != 1 || rest[0].Path != "big/b.txt" {
+		t.Fatalf("unexpected resumed items: %+v", rest)
+	}
+}
+
+// contentStore serves a single object with real content.
+type contentStore struct {
+	statTestStore
+	data []byte
+}
+
+func (s *contentStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	_, err := dst.WriteAt(s.data, 0)
+	return err
+}
+
+// changingStore reports an object change on its first Download after
+// writing part of an older, longer version.
+type changingStore struct {
+	contentStore
+	calls int
+}
+
+func (s *changingStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	s.calls++
+	if s.calls == 1 {
+		if _, err := dst.WriteAt([]byte("old version, longer"), 0); err != nil {
+			return err
+		}
+		return objectstore.ObjectChangedError{Key: key, ETag: "v1"}
+	}
+	return s.contentStore.Download(ctx, key, dst)
+}
+
+func TestReadFileRestartsWhenObjectChanges(t *testing.T) {
+	store := &changingStore{contentStore: contentStore{data: []byte("new")}}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	h, err := fs.ReadFile(context.Background(), "/obj")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer h.Close()
+	data, err := io.ReadAll(h)
+	if err != nil || string(data) != "new" {
+		t.Fatalf("content = %q, %v", data, err)
+	}
+	if store.calls != 2 {
+		t.Fatalf("downloads = %d, want 2", store.calls)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	store := &contentStore{
+		statTestStore: statTestStore{
+			head: map[string]objectstore.FileMeta{
+				"hello.txt":  {Path: "hello.txt", Size: 5},
+				"server.bin": {Path: "server.bin", Size: 5, ChecksumSHA256: "precomputed"},
+			},
+		},
+		data: []byte("hello"),
+	}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	sep := string(filepath.Separator)
+
+	got, err := fs.Checksum(ctx, sep+"hello.txt", "")
+	if err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	if want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"; got != want {
+		t.Fatalf("sha256 = %s, want %s", got, want)
+	}
+	got, err = fs.Checksum(ctx, sep+"hello.txt", "md5")
+	if err != nil {
+		t.Fatalf("md5 checksum: %v", err)
+	}
+	if want := "5d41402abc4b2a76b9719d911017c592"; got != want {
+		t.Fatalf("md5 = %s, want %s", got, want)
+	}
+	got, err = fs.Checksum(ctx, sep+"server.bin", "sha256")
+	if err != nil {
+		t.Fatalf("head checksum: %v", err)
+	}
+	if got != "precomputed" {
+		t.Fatalf("expected head checksum to be reused, got %s", got)
+	}
+	if _, err := fs.Checksum(ctx, sep+"hello.txt", "crc99"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+func TestReadFileNoCacheStreams(t *testing.T) {
+	store := &contentStore{
+		statTestStore: statTestStore{
+			head: map[string]objectstore.FileMeta{
+				"hello.txt": {Path: "hello.txt", Size: 5},
+			},
+			headErr: objectstore.ErrNotFound,
+		},
+		data: []byte("hello"),
+	}
+	cacheDir := filepath.Join(t.TempDir(), "unused")
+	fs, err := New(store, Config{CacheDir: cacheDir, NoCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	sep := string(filepath.Separator)
+	handle, err := fs.ReadFile(context.Background(), sep+"hello.txt")
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	defer handle.Close()
+	if _, err := handle.Seek(0, io.SeekStart); !errors.Is(err, ErrNotSeekable) {
+		t.Fatalf("expected ErrNotSeekable, got %v", err)
+	}
+	data, err := io.ReadAll(handle)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("stream returned %q", string(data))
+	}
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Fatalf("cache dir should not be created in NoCache mode: %v", err)
+	}
+	if _, err := fs.ReadFile(context.Background(), sep+"missing.txt"); !IsNotFound(err) {
+		t.Fatalf("expected NotFoundError, got %v", err)
+	}
+}
+
+func TestWarmMetadataCachePrefixSkipsOtherSubtrees(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"hot": {
+				{Path: "hot/a.txt", Size: 1},
+				{Path: "hot/nested", IsDir: true},
+			},
+			"hot/nested": {
+				{Path: "hot/nested/b.txt", Size: 2},
+			},
+			"archive": {
+				{Path: "archive/old.txt", Size: 3},
+			},
+		},
+	}
+	fs := &FileSystem{store: store}
+	local := filepath.Join(string(filepath.Separator), "hot")
+	if err := fs.WarmMetadataCachePrefix(context.Background(), local); err != nil {
+		t.Fatalf("warm prefix: %v", err)
+	}
+	if meta, ok := fs.cachedMeta("hot/nested/b.txt"); !ok || meta.Size != 2 {
+		t.Fatalf("nested entry missing from cache: %+v", meta)
+	}
+	if meta, ok := fs.cachedMeta("hot"); !ok || !meta.IsDir {
+		t.Fatalf("prefix directory missing from cache")
+	}
+	if _, ok := fs.cachedMeta("archive/old.txt"); ok {
+		t.Fatalf("archive should not be warmed")
+	}
+	for _, key := range store.listCalls {
+		if key == "" || key == "archive" {
+			t.Fatalf("unexpected listing of %q", key)
+		}
+	}
+
+	// A mistyped prefix is not cached as an empty directory.
+	typo := filepath.Join(string(filepath.Separator), "hto", "nested")
+	if err := fs.WarmMetadataCachePrefix(context.Background(), typo); !IsNotFound(err) {
+		t.Fatalf("warm missing prefix err = %v, want not found", err)
+	}
+	for _, rel := range []string{"hto", "hto/nested"} {
+		if _, ok := fs.cachedMeta(rel); ok {
+			t.Fatalf("missing prefix %s cached", rel)
+		}
+	}
+}
+
+func TestCacheInfoReportsCachedFile(t *testing.T) {
+	store := &contentStore{
+		statTestStore: statTestStore{
+			head: map[string]objectstore.FileMeta{
+				"hello.txt": {Path: "hello.txt", Size: 5},
+			},
+		},
+		data: []byte("hello"),
+	}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	local := filepath.Join(string(filepath.Separator), "hello.txt")
+	info, err := fs.CacheInfo(local)
+	if err != nil {
+		t.Fatalf("cache info: %v", err)
+	}
+	if info.Cached {
+		t.Fatalf("entry should not be cached before a read")
+	}
+	handle, err := fs.ReadFile(context.Background(), local)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	handle.Close()
+	after, err := fs.CacheInfo(local)
+	if err != nil {
+		t.Fatalf("cache info: %v", err)
+	}
+	if !after.Cached || after.File != info.File || after.Key != "hello.txt" {
+		t.Fatalf("unexpected cache info after read: %+v (before %+v)", after, info)
+	}
+	data, err := os.ReadFile(after.File)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("cache file content = %q, %v", data, err)
+	}
+}
+
+func TestCacheEntriesInEvictionOrder(t *testing.T) {
+	store := newFilesStore(map[string]string{"a.txt": "aa", "b.txt": "bbb"})
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"a.txt", "b.txt", "a.txt"} {
+		h, err := fs.ReadFile(ctx, filepath.Join(string(filepath.Separator), name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		h.Close()
+	}
+	entries := fs.CacheEntries()
+	if len(entries) != 2 || entries[0].Key != "b.txt" || entries[1].Key != "a.txt" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if entries[0].Size != 3 || entries[1].LastAccess.Before(entries[0].LastAccess) {
+		t.Fatalf("entries = %+v", entries)
+	}
+}
+
+func TestRemoveWhileHandleOpen(t *testing.T) {
+	store := newFilesStore(map[string]string{"a.txt": "hello"})
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	h, err := fs.ReadFile(ctx, "/a.txt")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	path, _ := fs.cache.PathFor("a.txt")
+	fs.cache.Remove("a.txt")
+	data, err := io.ReadAll(h)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("read after remove = %q, %v", data, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("cache file deleted while open: %v", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("cache file kept after close: %v", err)
+	}
+}
+
+func TestReadFileRefetchesTruncatedCacheFile(t *testing.T) {
+	store := newFilesStore(map[string]string{"a.txt": "hello world"})
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer fs.Close()
+	ctx := context.Background()
+	h, err := fs.ReadFile(ctx, "/a.txt")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	h.Close()
+	path, _ := fs.cache.PathFor("a.txt")
+	if err := os.Truncate(path, 5); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	h, err = fs.ReadFile(ctx, "/a.txt")
+	if err != nil {
+		t.Fatalf("read truncated: %v", err)
+	}
+	defer h.Close()
+	if data, err := io.ReadAll(h); err != nil || string(data) != "hello world" {
+		t.Fatalf("read truncated = %q, %v", data, err)
+	}
+}
+
+func TestVerifyCacheReportsAndPrunes(t *testing.T) {
+	store := newFilesStore(map[string]string{"a.txt": "aa", "b.txt": "bbb", "c.txt": "c"})
+	// The Head cache holds results from before the remote changes, which
+	// VerifyCache must not trust.
+	fs, err := New(objectstore.WithHeadCache(store, time.Minute, 0), Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		local := filepath.Join(string(filepath.Separator), name)
+		h, err := fs.ReadFile(ctx, local)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		h.Close()
+		if _, err := fs.Stat(ctx, local); err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+	}
+	store.head["b.txt"] = objectstore.FileMeta{Path: "b.txt", Size: 4}
+	delete(store.head, "c.txt")
+
+	report, err := fs.VerifyCache(ctx, false)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if report.Matched != 1 || len(report.Stale) != 1 || len(report.Missing) != 1 || report.Pruned != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if m := report.Stale[0]; m.Path != "/b.txt" || m.CachedSize != 3 || m.RemoteSize != 4 {
+		t.Fatalf("stale = %+v", m)
+	}
+	if report.Missing[0] != "/c.txt" {
+		t.Fatalf("missing = %v", report.Missing)
+	}
+
+	report, err = fs.VerifyCache(ctx, true)
+	if err != nil {
+		t.Fatalf("verify prune: %v", err)
+	}
+	if report.Pruned != 2 {
+		t.Fatalf("pruned = %d", report.Pruned)
+	}
+	if entries := fs.CacheEntries(); len(entries) != 1 || entries[0].Key != "a.txt" {
+		t.Fatalf("entries after prune = %+v", entries)
+	}
+}
+
+func TestAcquireDownloadQueueLimit(t *testing.T) {
+	fs := &FileSystem{
+		cfg:       Config{MaxConcurrentDownloads: 1, MaxQueuedDownloads: 1},
+		downloads: make(chan struct{}, 1),
+	}
+	ctx := context.Background()
+	release, err := fs.acquireDownload(ctx)
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		rel, err := fs.acquireDownload(ctx)
+		if err == nil {
+			rel()
+		}
+		acquired <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&fs.queued) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("second download never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := fs.acquireDownload(ctx); !errors.Is(err, ErrDownloadQueueFull) {
+		t.Fatalf("expected ErrDownloadQueueFull, got %v", err)
+	}
+	release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("queued acquire: %v", err)
+	}
+}
+
+// rangeStore serves partial reads and records the ranges requested.
+type rangeStore struct {
+	contentStore
+	ranges [][2]int64
+}
+
+func (s *rangeStore) RangeReader(ctx context.Context, key string, off, n int64) (io.ReadCloser, error) {
+	s.ranges = append(s.ranges, [2]int64{off, n})
+	end := int64(len(s.data))
+	if n > 0 && off+n < end {
+		end = off + n
+	}
+	return io.NopCloser(bytes.NewReader(s.data[off:end])), nil
+}
+
+func TestReadFileRange(t *testing.T) {
+	head := map[string]objectstore.FileMeta{
+		"hello.txt": {Path: "hello.txt", Size: 11},
+	}
+	data := []byte("hello world")
+	local := filepath.Join(string(filepath.Separator), "hello.txt")
+	readRange := func(fs *FileSystem, off, n int64) string {
+		t.Helper()
+		rc, err := fs.ReadFileRange(context.Background(), local, off, n)
+		if err != nil {
+			t.Fatalf("read range: %v", err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("read range body: %v", err)
+		}
+		return string(got)
+	}
+
+	ranged := &rangeStore{contentStore: contentStore{statTestStore: statTestStore{head: head}, data: data}}
+	fs, err := New(ranged, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if got := readRange(fs, 6, 5); got != "world" {
+		t.Fatalf("ranged read = %q", got)
+	}
+	if len(ranged.ranges) != 1 {
+		t.Fatalf("expected a single range request, got %v", ranged.ranges)
+	}
+
+	plain := &contentStore{statTestStore: statTestStore{head: head}, data: data}
+	fs, err = New(plain, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if got := readRange(fs, 0, 5); got != "hello" {
+		t.Fatalf("fallback read = %q", got)
+	}
+	if got := readRange(fs, 6, 0); got != "world" {
+		t.Fatalf("cached read to end = %q", got)
+	}
+}
+
+func TestNotFoundExposesFullKey(t *testing.T) {
+	store := &statTestStore{
+		headErr: objectstore.NotFoundError{Key: "missing.txt", FullKey: "s3://bucket/prefix/missing.txt"},
+	}
+	local := filepath.Join(string(filepath.Separator), "missing.txt")
+
+	fs := &FileSystem{store: store}
+	_, err := fs.Stat(context.Background(), local)
+	if !IsNotFound(err) {
+		t.Fatalf("expected NotFoundError, got %v", err)
+	}
+	if strings.Contains(err.Error(), "s3://") {
+		t.Fatalf("full key leaked without ExposeFullKey: %v", err)
+	}
+
+	fs = &FileSystem{store: store, cfg: Config{ExposeFullKey: true}}
+	_, err = fs.Stat(context.Background(), local)
+	var nf NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("expected NotFoundError, got %v", err)
+	}
+	if nf.Key != "s3://bucket/prefix/missing.txt" {
+		t.Fatalf("NotFoundError.Key = %q", nf.Key)
+	}
+	if !strings.Contains(err.Error(), "s3://bucket/prefix/missing.txt") {
+		t.Fatalf("error message missing full key: %v", err)
+	}
+}
+
+func TestReadDirNotFoundExposesFullKey(t *testing.T) {
+	// An empty listing carries no key, so it comes from the store location.
+	store := &locatedStore{filesStore: newFilesStore(nil), location: "s3://bucket/prefix/"}
+	local := filepath.Join(string(filepath.Separator), "missing")
+	ctx := context.Background()
+
+	fs := &FileSystem{store: store}
+	var nf NotFoundError
+	if _, err := fs.ReadDir(ctx, local); !errors.As(err, &nf) || nf.Key != "" {
+		t.Fatalf("without ExposeFullKey: %v with key %q", err, nf.Key)
+	}
+
+	fs = &FileSystem{store: store, cfg: Config{ExposeFullKey: true}}
+	checks := map[string]func() error{
+		"ReadDir": func() error {
+			_, err := fs.ReadDir(ctx, local)
+			return err
+		},
+		"readDirFlat": func() error {
+			_, err := fs.readDirFlat(ctx, local, 0)
+			return err
+		},
+		"confirmDir": func() error {
+			return fs.confirmDir(ctx, "missing")
+		},
+	}
+	for name, check := range checks {
+		err := check()
+		if !errors.As(err, &nf) || nf.Key != "s3://bucket/prefix/missing" {
+			t.Fatalf("%s: %v with key %q", name, err, nf.Key)
+		}
+	}
+}
+
+// writableStore keeps uploaded content in memory.
+type writableStore struct {
+	contentStore
+	uploads map[string][]byte
+}
+
+func (s *writableStore) Upload(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if s.uploads == nil {
+		s.uploads = make(map[string][]byte)
+	}
+	s.uploads[key] = data
+	s.data = data
+	return nil
+}
+
+func TestWriteFileInvalidatesCache(t *testing.T) {
+	store := &writableStore{
+		contentStore: contentStore{
+			statTestStore: statTestStore{
+				head: map[string]objectstore.FileMeta{
+					"notes.txt": {Path: "notes.txt", Size: 3},
+				},
+			},
+			data: []byte("old"),
+		},
+	}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	local := filepath.Join(string(filepath.Separator), "notes.txt")
+	readAll := func() string {
+		t.Helper()
+		h, err := fs.ReadFile(ctx, local)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		defer h.Close()
+		data, err := io.ReadAll(h)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		return string(data)
+	}
+	if got := readAll(); got != "old" {
+		t.Fatalf("initial read = %q", got)
+	}
+	if err := fs.WriteFile(ctx, local, strings.NewReader("new content"), -1); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if string(store.uploads["notes.txt"]) != "new content" {
+		t.Fatalf("upload not forwarded to store: %q", store.uploads)
+	}
+	if got := readAll(); got != "new content" {
+		t.Fatalf("read after write = %q, cache was not invalidated", got)
+	}
+}
+
+// typedStore records the content type and checksum request of each upload.
+type typedStore struct {
+	writableStore
+	types     map[string]string
+	checksums map[string]bool
+}
+
+func (s *typedStore) UploadWithOptions(ctx context.Context, key string, r io.Reader, size int64, opts objectstore.UploadOptions) error {
+	if s.types == nil {
+		s.types = make(map[string]string)
+		s.checksums = make(map[string]bool)
+	}
+	s.types[key] = opts.ContentType
+	s.checksums[key] = opts.ChecksumSHA256
+	return s.Upload(ctx, key, r, size)
+}
+
+func TestWriteFileContentType(t *testing.T) {
+	store := &typedStore{}
+	fs, err := New(store, Config{NoCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	if err := fs.WriteFile(ctx, "/site/index.html", strings.NewReader("<p>"), 3); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := fs.WriteFileOpts(ctx, "/data.bin", strings.NewReader("{}"), 2, WriteOptions{ContentType: "application/json"}); err != nil {
+		t.Fatalf("write with options: %v", err)
+	}
+	if got := store.types["site/index.html"]; !strings.HasPrefix(got, "text/html") {
+		t.Fatalf("detected content type = %q, want text/html", got)
+	}
+	if got := store.types["data.bin"]; got != "application/json" {
+		t.Fatalf("explicit content type = %q, want application/json", got)
+	}
+}
+
+func TestWriteFileChecksum(t *testing.T) {
+	store := &typedStore{}
+	fs, err := New(store, Config{NoCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	sum, err := fs.WriteFileChecksum(ctx, "/notes.txt", strings.NewReader("hello"), -1, WriteOptions{})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if sum != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Fatalf("checksum = %s", sum)
+	}
+	if !store.checksums["notes.txt"] {
+		t.Fatalf("upload did not ask the store to verify a checksum")
+	}
+	if err := fs.WriteFile(ctx, "/plain.txt", strings.NewReader("x"), 1); err != nil || store.checksums["plain.txt"] {
+		t.Fatalf("plain write: %v, checksum requested = %v", err, store.checksums["plain.txt"])
+	}
+}
+
+func TestWriteFileCreateOnly(t *testing.T) {
+	store := &writableStore{
+		contentStore: contentStore{
+			statTestStore: statTestStore{
+				head: map[string]objectstore.FileMeta{
+					"lock": {Path: "lock", Size: 1},
+				},
+				headErr: objectstore.ErrNotFound,
+			},
+		},
+	}
+	fs, err := New(store, Config{NoCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	create := WriteOptions{CreateOnly: true}
+	err = fs.WriteFileOpts(ctx, "/lock", strings.NewReader("x"), 1, create)
+	if !IsAlreadyExists(err) {
+		t.Fatalf("create existing = %v, want AlreadyExistsError", err)
+	}
+	if len(store.uploads) != 0 {
+		t.Fatalf("refused create reached the store: %q", store.uploads)
+	}
+	if err := fs.WriteFileOpts(ctx, "/other", strings.NewReader("x"), 1, create); err != nil {
+		t.Fatalf("create fresh: %v", err)
+	}
+	if string(store.uploads["other"]) != "x" {
+		t.Fatalf("uploads = %q", store.uploads)
+	}
+}
+
+func TestWriteFileReadOnly(t *testing.T) {
+	store := &writableStore{}
+	fs, err := New(store, Config{NoCache: true, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	writes := map[string]func() error{
+		"WriteFile": func() error {
+			return fs.WriteFile(ctx, "/notes.txt", strings.NewReader("data"), 4)
+		},
+		"WriteFileOpts": func() error {
+			return fs.WriteFileOpts(ctx, "/notes.txt", strings.NewReader("data"), 4, WriteOptions{CreateOnly: true})
+		},
+		"WriteFileChecksum": func() error {
+			_, err := fs.WriteFileChecksum(ctx, "/notes.txt", strings.NewReader("data"), 4, WriteOptions{})
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !IsReadOnly(err) {
+			t.Fatalf("%s: expected ReadOnlyError, got %v", name, err)
+		}
+	}
+	if len(store.uploads) != 0 || store.headCalls != 0 {
+		t.Fatalf("read-only write touched the store")
+	}
+}
+
+// preallocStore reports size before the first write, as S3Store does with
+// the Content-Length of the GET, and records the cache file size right after.
+// On platforms without fallocate nothing is reserved.
+type preallocStore struct {
+	contentStore
+	size        int64
+	sizeAtStart int64
+}
+
+func (s *preallocStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	if rec, ok := dst.(objectstore.SizeRecorder); ok {
+		rec.RecordSize(s.size)
+	}
+	if f, ok := dst.(*extentWriter).w.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			s.sizeAtStart = info.Size()
+		}
+	}
+	return s.contentStore.Download(ctx, key, dst)
+}
+
+func TestReadFilePreallocatesReportedSize(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		size int64
+	}{
+		{name: "cold miss", size: 13},
+		// A reported size beyond the content leaves a preallocated tail
+		// that must be trimmed.
+		{name: "short body", size: 64},
+	} {
+		store := &preallocStore{
+			contentStore: contentStore{data: []byte("short content")},
+			size:         tt.size,
+		}
+		fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		// No warm: the size comes from the download, not the metadata cache.
+		h, err := fs.ReadFile(context.Background(), "/data.bin")
+		if err != nil {
+			t.Fatalf("%s: read: %v", tt.name, err)
+		}
+		want := tt.size
+		if runtime.GOOS != "linux" {
+			want = 0
+		}
+		if store.sizeAtStart != want {
+			t.Fatalf("%s: cache file size at download start = %d, want %d", tt.name, store.sizeAtStart, want)
+		}
+		data, err := io.ReadAll(h)
+		h.Close()
+		if err != nil {
+			t.Fatalf("%s: read body: %v", tt.name, err)
+		}
+		if string(data) != "short content" {
+			t.Fatalf("%s: read %q, preallocated tail was not trimmed", tt.name, data)
+		}
+		if used := fs.CacheStats().Used; used != int64(len(data)) {
+			t.Fatalf("%s: cache accounts %d bytes, want %d", tt.name, used, len(data))
+		}
+		fs.Close()
+	}
+}
+
+func TestCacheDirIsExclusive(t *testing.T) {
+	dir := t.TempDir()
+	first, err := New(&statTestStore{}, Config{CacheDir: dir, CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if _, err := New(&statTestStore{}, Config{CacheDir: dir, CacheSize: 1 << 20}); !errors.Is(err, cache.ErrLocked) {
+		t.Fatalf("second instance on the same cache dir: got %v, want cache.ErrLocked", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	second, err := New(&statTestStore{}, Config{CacheDir: dir, CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new after close: %v", err)
+	}
+	second.Close()
+}
+
+// conditionalStore answers conditional downloads from a modification time.
+type conditionalStore struct {
+	contentStore
+	modified    time.Time
+	conditional int
+}
+
+func (s *conditionalStore) DownloadIfModifiedSince(ctx context.Context, key string, dst io.WriterAt, since time.Time) error {
+	s.conditional++
+	if !s.modified.After(since) {
+		return objectstore.NotModifiedError{Key: key}
+	}
+	return s.Download(ctx, key, dst)
+}
+
+func (s *conditionalStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	if rec, ok := dst.(objectstore.VersionRecorder); ok {
+		rec.RecordVersion("", s.modified)
+	}
+	return s.contentStore.Download(ctx, key, dst)
+}
+
+// etagStore answers conditional downloads from an ETag.
+type etagStore struct {
+	contentStore
+	etag string
+	sent []string
+}
+
+func (s *etagStore) Head(ctx context.Context, key string) (objectstore.FileMeta, error) {
+	return objectstore.FileMeta{Path: key, Size: int64(len(s.data)), ETag: s.etag}, nil
+}
+
+func (s *etagStore) DownloadIfNoneMatch(ctx context.Context, key string, dst io.WriterAt, etag string) error {
+	s.sent = append(s.sent, etag)
+	if etag == s.etag {
+		return objectstore.NotModifiedError{Key: key}
+	}
+	return s.Download(ctx, key, dst)
+}
+
+func (s *etagStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	if rec, ok := dst.(objectstore.VersionRecorder); ok {
+		rec.RecordVersion(s.etag, time.Time{})
+	}
+	return s.contentStore.Download(ctx, key, dst)
+}
+
+func TestReadFileRevalidatesCache(t *testing.T) {
+	store := &conditionalStore{contentStore: contentStore{
+		statTestStore: statTestStore{head: map[string]objectstore.FileMeta{
+			"app.cfg": {Path: "app.cfg", Size: 2, LastModified: time.Now().Add(-time.Hour)},
+		}},
+		data: []byte("v1"),
+	}}
+	store.modified = store.head["app.cfg"].LastModified
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20, RevalidateCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	read := func() string {
+		t.Helper()
+		h, err := fs.ReadFile(context.Background(), "/app.cfg")
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		defer h.Close()
+		data, err := io.ReadAll(h)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		return string(data)
+	}
+
+	if got := read(); got != "v1" {
+		t.Fatalf("first read = %q", got)
+	}
+	store.data = []byte("v2")
+	if got := read(); got != "v1" || store.conditional != 1 {
+		t.Fatalf("unchanged object read = %q after %d conditional requests", got, store.conditional)
+	}
+	store.modified = time.Now().Add(time.Hour)
+	if got := read(); got != "v2" {
+		t.Fatalf("modified object read = %q, want v2", got)
+	}
+	if used := fs.CacheStats().Used; used != 2 {
+		t.Fatalf("cache accounts %d bytes after replacement, want 2", used)
+	}
+	store.data = []byte("v3")
+	if got := read(); got != "v2" || store.conditional != 3 {
+		t.Fatalf("replaced copy read = %q after %d conditional requests", got, store.conditional)
+	}
+}
+
+func TestReadFileRevalidatesByETag(t *testing.T) {
+	store := &etagStore{contentStore: contentStore{data: []byte("v1")}, etag: `"1"`}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20, RevalidateCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	read := func() string {
+		t.Helper()
+		h, err := fs.ReadFile(context.Background(), "/app.cfg")
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		defer h.Close()
+		data, err := io.ReadAll(h)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		return string(data)
+	}
+
+	read()
+	if got := read(); got != "v1" {
+		t.Fatalf("unchanged object read = %q", got)
+	}
+	store.data, store.etag = []byte("v2"), `"2"`
+	if got := read(); got != "v2" {
+		t.Fatalf("changed object read = %q, want v2", got)
+	}
+	if got := read(); got != "v2" {
+		t.Fatalf("read after replacement = %q", got)
+	}
+	if want := []string{`"1"`, `"1"`, `"2"`}; strings.Join(store.sent, ",") != strings.Join(want, ",") {
+		t.Fatalf("If-None-Match sent %v, want %v", store.sent, want)
+	}
+}
+
+func TestReadFileFreshReplacesCachedCopy(t *testing.T) {
+	store := &contentStore{data: []byte("v1")}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	read := func(open func(context.Context, string) (*ReadHandle, error)) string {
+		t.Helper()
+		h, err := open(context.Background(), "/app.cfg")
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		defer h.Close()
+		data, err := io.ReadAll(h)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		return string(data)
+	}
+
+	if got := read(fs.ReadFile); got != "v1" {
+		t.Fatalf("first read = %q", got)
+	}
+	store.data = []byte("v2")
+	if got := read(fs.ReadFile); got != "v1" {
+		t.Fatalf("cached read = %q, want v1", got)
+	}
+	if got := read(fs.ReadFileFresh); got != "v2" {
+		t.Fatalf("fresh read = %q, want v2", got)
+	}
+	if got := read(fs.ReadFile); got != "v2" {
+		t.Fatalf("read after refresh = %q, want v2", got)
+	}
+}
+
+func TestReadFileFreshForgetsMetadata(t *testing.T) {
+	store := &contentStore{
+		statTestStore: statTestStore{head: map[string]objectstore.FileMeta{
+			"app.cfg": {Path: "app.cfg", Size: 2},
+		}},
+		data: []byte("v1"),
+	}
+	fs, err := New(objectstore.WithHeadCache(store, time.Minute, 0), Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	if meta, err := fs.Stat(ctx, "/app.cfg"); err != nil || meta.Size != 2 {
+		t.Fatalf("stat = %+v, %v", meta, err)
+	}
+	store.head["app.cfg"] = objectstore.FileMeta{Path: "app.cfg", Size: 3}
+	store.data = []byte("v22")
+	h, err := fs.ReadFileFresh(ctx, "/app.cfg")
+	if err != nil {
+		t.Fatalf("fresh read: %v", err)
+	}
+	h.Close()
+	if meta, err := fs.Stat(ctx, "/app.cfg"); err != nil || meta.Size != 3 {
+		t.Fatalf("stat after fresh read = %+v, %v; the cached Head was kept", meta, err)
+	}
+}
+
+// reportingStore reports a version from Download that its Head, which
+// answers from older state, does not know yet.
+type reportingStore struct {
+	contentStore
+	etag string
+}
+
+func (s *reportingStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	if rec, ok := dst.(objectstore.VersionRecorder); ok {
+		rec.RecordVersion(s.etag, time.Time{})
+	}
+	return s.contentStore.Download(ctx, key, dst)
+}
+
+func TestCacheRecordsDownloadedVersion(t *testing.T) {
+	store := &reportingStore{contentStore: contentStore{
+		statTestStore: statTestStore{head: map[string]objectstore.FileMeta{
+			"app.cfg": {Path: "app.cfg", Size: 2, ETag: `"old"`},
+		}},
+		data: []byte("v2"),
+	}, etag: `"new"`}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	h, err := fs.ReadFile(context.Background(), "/app.cfg")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	h.Close()
+	if entries := fs.CacheEntries(); len(entries) != 1 || entries[0].ETag != `"new"` {
+		t.Fatalf("entries = %+v, want the ETag of the download", entries)
+	}
+}
+
+// locatedStore is a filesStore that reports a backend location.
+type locatedStore struct {
+	*filesStore
+	location string
+}
+
+func (s *locatedStore) Location() string { return s.location }
+
+func TestCacheNamespaceDefaultsToStoreLocation(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	var paths []string
+	for _, loc := range []string{"s3://a/", "s3://b/"} {
+		store := &locatedStore{filesStore: newFilesStore(map[string]string{"a.txt": loc}), location: loc}
+		fs, err := New(store, Config{CacheDir: dir, CacheSize: 1 << 20})
+		if err != nil {
+			t.Fatalf("new %s: %v", loc, err)
+		}
+		h, err := fs.ReadFile(ctx, "/a.txt")
+		if err != nil {
+			t.Fatalf("read %s: %v", loc, err)
+		}
+		data, _ := io.ReadAll(h)
+		h.Close()
+		if string(data) != loc {
+			t.Fatalf("%s served %q", loc, data)
+		}
+		path, _ := fs.cache.PathFor("a.txt")
+		paths = append(paths, path)
+		fs.Close()
+	}
+	if paths[0] == paths[1] {
+		t.Fatalf("both locations share cache file %s", paths[0])
+	}
+}
@@ -0,0 +1,252 @@
+package remotefs
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// InventoryRecord is one object listed in an inventory report. Key is the
+// full object key as stored in the bucket.
+type InventoryRecord struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// InventoryParser decodes an inventory report from r and calls emit for every
+// record, stopping at the first error emit returns.
+type InventoryParser func(r io.Reader, emit func(InventoryRecord) error) error
+
+var (
+	inventoryMu      sync.RWMutex
+	inventoryParsers = map[string]InventoryParser{
+		"csv": ParseInventoryCSV,
+	}
+)
+
+// RegisterInventoryParser makes parser available to LoadInventory under
+// format, replacing any parser already registered for it. Use it to plug in
+// formats such as ORC or Parquet without adding their dependencies here.
+func RegisterInventoryParser(format string, parser InventoryParser) {
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	inventoryParsers[strings.ToLower(format)] = parser
+}
+
+// ErrUnsupportedInventoryFormat is returned by LoadInventory for formats
+// without a registered parser.
+var ErrUnsupportedInventoryFormat = errors.New("unsupported inventory format")
+
+// inventoryCSVColumns is the default S3 Inventory CSV column order, used when
+// the report has no header row.
+var inventoryCSVColumns = []string{"Bucket", "Key", "Size", "LastModifiedDate", "ETag"}
+
+// ParseInventoryCSV parses an S3 Inventory CSV report. The Key, Size,
+// LastModifiedDate and ETag columns are used; others are ignored. A header row
+// naming the columns is honoured when present, otherwise the default
+// inventory order (Bucket, Key, Size, LastModifiedDate, ETag, ...) is assumed.
+// Keys are URL-decoded as S3 Inventory encodes them.
+func ParseInventoryCSV(r io.Reader, emit func(InventoryRecord) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	columns := make(map[string]int)
+	for i, name := range inventoryCSVColumns {
+		columns[name] = i
+	}
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("inventory line %d: %w", line, err)
+		}
+		if line == 1 && isInventoryHeader(row) {
+			columns = make(map[string]int)
+			for i, name := range row {
+				columns[strings.TrimSpace(name)] = i
+			}
+			if _, ok := columns["Key"]; !ok {
+				return fmt.Errorf("inventory header has no Key column")
+			}
+			continue
+		}
+		rec, err := inventoryRecordFromRow(row, columns)
+		if err != nil {
+			return fmt.Errorf("inventory line %d: %w", line, err)
+		}
+		if err := emit(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func isInventoryHeader(row []string) bool {
+	for _, field := range row {
+		if strings.TrimSpace(field) == "Key" {
+			return true
+		}
+	}
+	return false
+}
+
+func inventoryRecordFromRow(row []string, columns map[string]int) (InventoryRecord, error) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+	key, err := url.QueryUnescape(field("Key"))
+	if err != nil {
+		return InventoryRecord{}, fmt.Errorf("decode key %q: %w", field("Key"), err)
+	}
+	if key == "" {
+		return InventoryRecord{}, fmt.Errorf("missing key")
+	}
+	rec := InventoryRecord{Key: key, ETag: field("ETag")}
+	if raw := field("Size"); raw != "" {
+		rec.Size, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return InventoryRecord{}, fmt.Errorf("parse size %q: %w", raw, err)
+		}
+	}
+	if raw := field("LastModifiedDate"); raw != "" {
+		rec.LastModified, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return InventoryRecord{}, fmt.Errorf("parse last modified %q: %w", raw, err)
+		}
+	}
+	return rec, nil
+}
+
+// LoadInventory replaces the metadata cache with the objects listed in an
+// inventory report instead of walking the store. format selects the parser
+// ("csv" is built in; see RegisterInventoryParser). When the store implements
+// objectstore.KeyMapper, keys outside its prefix are skipped; otherwise keys
+// are taken as relative paths. Directories are inferred from the keys and
+// carry the same aggregate size and child count as a live warm.
+func (fs *FileSystem) LoadInventory(ctx context.Context, r io.Reader, format string) error {
+	inventoryMu.RLock()
+	parser, ok := inventoryParsers[strings.ToLower(format)]
+	inventoryMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedInventoryFormat, format)
+	}
+	if !fs.beginWarm() {
+		return fmt.Errorf("metadata warm already in progress")
+	}
+
+	mapper, _ := objectstore.As[objectstore.KeyMapper](fs.store)
+	entries := map[string]objectstore.FileMeta{
+		"": {Path: "", IsDir: true},
+	}
+	err := parser(r, func(rec InventoryRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel := rec.Key
+		if mapper != nil {
+			var ok bool
+			if rel, ok = mapper.RelKey(rec.Key); !ok {
+				return nil
+			}
+		}
+		isDir := strings.HasSuffix(rel, "/")
+		rel = strings.Trim(path.Clean("/"+rel), "/")
+		if rel == "" {
+			return nil
+		}
+		if isDir {
+			addInventoryDir(entries, rel)
+			return nil
+		}
+		addInventoryDir(entries, parentDir(rel))
+		entries[rel] = objectstore.FileMeta{
+			Path:         rel,
+			Size:         rec.Size,
+			ETag:         quoteETag(rec.ETag),
+			LastModified: rec.LastModified,
+		}
+		return nil
+	})
+	if err == nil {
+		aggregateInventoryDirs(entries)
+	}
+	return fs.finishWarm(metaCacheFrom(entries, fs.cfg.MetaCacheMaxEntries), 0, err)
+}
+
+// quoteETag puts etag in the quoted form S3 returns from HEAD and LIST.
+// Inventory reports list ETags without quotes, and metadata loaded from one
+// must compare equal to a live warm of the same objects.
+func quoteETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// addInventoryDir records rel and its ancestors as directories.
+func addInventoryDir(entries map[string]objectstore.FileMeta, rel string) {
+	for rel != "" {
+		if _, ok := entries[rel]; ok {
+			return
+		}
+		entries[rel] = objectstore.FileMeta{Path: rel, IsDir: true}
+		rel = parentDir(rel)
+	}
+}
+
+// aggregateInventoryDirs fills in directory sizes and child counts the way
+// populateMetadata does for a live walk.
+func aggregateInventoryDirs(entries map[string]objectstore.FileMeta) {
+	sizes := make(map[string]int64)
+	children := make(map[string]int)
+	for rel, meta := range entries {
+		if rel == "" {
+			continue
+		}
+		children[parentDir(rel)]++
+		if meta.IsDir {
+			continue
+		}
+		for dir := parentDir(rel); ; dir = parentDir(dir) {
+			sizes[dir] += meta.Size
+			if dir == "" {
+				break
+			}
+		}
+	}
+	for rel, meta := range entries {
+		if !meta.IsDir {
+			continue
+		}
+		meta.Size = sizes[rel]
+		meta.ChildCount = children[rel]
+		entries[rel] = meta
+	}
+}
+
+// parentDir returns the relative parent of rel, with "" for the root.
+func parentDir(rel string) string {
+	dir := path.Dir(rel)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
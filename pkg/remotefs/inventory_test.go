@@ -0,0 +1,131 @@
+package remotefs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// prefixStore maps inventory keys through a fixed prefix like S3Store does.
+type prefixStore struct {
+	statTestStore
+	prefix string
+}
+
+func (s *prefixStore) RelKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, s.prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key, s.prefix), true
+}
+
+func TestLoadInventoryCSV(t *testing.T) {
+	report := strings.Join([]string{
+		`"bucket","data/readme.txt","10","2024-01-02T03:04:05.000Z","etag-1"`,
+		`"bucket","data/docs/report%20final.txt","42","2024-01-02T03:04:05.000Z","etag-2"`,
+		`"bucket","data/docs/archive/old.txt","5","2024-01-02T03:04:05.000Z","etag-3"`,
+		`"bucket","data/empty/","0","2024-01-02T03:04:05.000Z","etag-4"`,
+		`"bucket","other/skip.txt","99","2024-01-02T03:04:05.000Z","etag-5"`,
+	}, "\n")
+	store := &prefixStore{prefix: "data/"}
+	store.headErr = errors.New("head called")
+	fs := &FileSystem{store: store}
+	if err := fs.LoadInventory(context.Background(), strings.NewReader(report), "csv"); err != nil {
+		t.Fatalf("load inventory: %v", err)
+	}
+	if len(store.listCalls) != 0 {
+		t.Fatalf("inventory load listed the store: %v", store.listCalls)
+	}
+
+	meta, err := fs.Stat(context.Background(), "/docs/report final.txt")
+	if err != nil {
+		t.Fatalf("stat decoded key: %v", err)
+	}
+	if meta.Size != 42 || meta.ETag != `"etag-2"` || meta.LastModified.IsZero() {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+	tests := []struct {
+		path     string
+		size     int64
+		children int
+	}{
+		{path: "", size: 57, children: 3},
+		{path: "docs", size: 47, children: 2},
+		{path: "docs/archive", size: 5, children: 1},
+		{path: "empty", size: 0, children: 0},
+	}
+	for _, tt := range tests {
+		meta, ok := fs.cachedMeta(tt.path)
+		if !ok {
+			t.Fatalf("%q missing from cache", tt.path)
+		}
+		if !meta.IsDir || meta.Size != tt.size || meta.ChildCount != tt.children {
+			t.Fatalf("%q aggregate = size %d children %d, want %d/%d", tt.path, meta.Size, meta.ChildCount, tt.size, tt.children)
+		}
+	}
+	if _, ok := fs.cachedMeta("other/skip.txt"); ok {
+		t.Fatalf("key outside the prefix was loaded")
+	}
+	if status := fs.WarmStatus(); status.Entries != 7 || status.LastCompleted.IsZero() {
+		t.Fatalf("unexpected warm status: %+v", status)
+	}
+}
+
+func TestLoadInventoryHeaderAndErrors(t *testing.T) {
+	fs := &FileSystem{store: &statTestStore{}}
+	report := "ETag,Key,Size\netag-1,a/b.txt,3\n"
+	if err := fs.LoadInventory(context.Background(), strings.NewReader(report), "CSV"); err != nil {
+		t.Fatalf("load inventory: %v", err)
+	}
+	if meta, ok := fs.cachedMeta("a/b.txt"); !ok || meta.Size != 3 || meta.ETag != `"etag-1"` {
+		t.Fatalf("header columns not honoured: %+v", meta)
+	}
+
+	err := fs.LoadInventory(context.Background(), strings.NewReader(""), "orc")
+	if !errors.Is(err, ErrUnsupportedInventoryFormat) {
+		t.Fatalf("expected ErrUnsupportedInventoryFormat, got %v", err)
+	}
+
+	bad := "bucket,a/c.txt,not-a-number,,\n"
+	if err := fs.LoadInventory(context.Background(), strings.NewReader(bad), "csv"); err == nil {
+		t.Fatalf("expected parse error")
+	}
+	if _, ok := fs.cachedMeta("a/b.txt"); !ok {
+		t.Fatalf("failed load replaced the previous snapshot")
+	}
+}
+
+func TestLoadInventoryMatchesLiveWarm(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// The store lists quoted ETags like S3Store; the report has them bare.
+	store := &statTestStore{listing: map[string][]objectstore.FileMeta{
+		"": {{Path: "a.txt", Size: 3, ETag: `"abc"`, LastModified: modified}},
+	}}
+	live := &FileSystem{store: store}
+	if err := live.WarmMetadataCache(context.Background()); err != nil {
+		t.Fatalf("warm: %v", err)
+	}
+	loaded := &FileSystem{store: &statTestStore{}}
+	report := `"bucket","a.txt","3","2024-01-02T03:04:05.000Z","abc"`
+	if err := loaded.LoadInventory(context.Background(), strings.NewReader(report), "csv"); err != nil {
+		t.Fatalf("load inventory: %v", err)
+	}
+	want, _ := live.cachedMeta("a.txt")
+	got, _ := loaded.cachedMeta("a.txt")
+	if got.ETag != want.ETag || got.Size != want.Size || !got.LastModified.Equal(want.LastModified) {
+		t.Fatalf("inventory meta = %+v, live warm = %+v", got, want)
+	}
+	// A snapshot seeded from the inventory sees the live listing as unchanged.
+	items, err := live.ReadDir(context.Background(), string(filepath.Separator))
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if changes := loaded.SnapshotFromCache().Apply("", items); len(changes) != 0 {
+		t.Fatalf("changes against the inventory snapshot: %+v", changes)
+	}
+}
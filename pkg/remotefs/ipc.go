@@ -3,16 +3,22 @@ package remotefs
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"example.com/s3rofs/pkg/cache"
 	"example.com/s3rofs/pkg/objectstore"
 )
 
@@ -21,8 +27,21 @@ const (
 	modeRegBits = 0o100000
 	dirPerms    = 0o550
 	filePerms   = 0o440
+	// blockSize is reported as st_blksize, the preferred I/O size.
+	blockSize = 4096
 )
 
+// UnknownModTime is reported as LastModified when the store gives no
+// modification time, as for directories. It is a fixed instant rather than
+// the current time so the value stays the same across requests and daemon
+// restarts, and conditional requests and sync tools do not see every such
+// entry as freshly modified.
+var UnknownModTime = time.Unix(0, 0).UTC()
+
+// errInvalidQuery wraps malformed query parameters found while resolving a
+// request path, so writeErrorFor answers them with 400 like the rest.
+var errInvalidQuery = errors.New("invalid query parameter")
+
 // POSIXEntry mirrors the metadata callers expect from stat/readdir.
 type POSIXEntry struct {
 	Path         string    `json:"Path"`
@@ -30,13 +49,120 @@ type POSIXEntry struct {
 	ETag         string    `json:"ETag"`
 	LastModified time.Time `json:"LastModified"`
 	IsDir        bool      `json:"IsDir"`
-	Mode         uint32    `json:"Mode"`
-	UID          int       `json:"UID"`
-	GID          int       `json:"GID"`
-	User         string    `json:"User"`
-	Group        string    `json:"Group"`
+	ChildCount   int       `json:"ChildCount,omitempty"`
+	// Incomplete marks a warmed directory whose Size and ChildCount miss
+	// subtrees the warm could not list.
+	Incomplete bool   `json:"Incomplete,omitempty"`
+	Inode      uint64 `json:"Inode"`
+	Mode       uint32 `json:"Mode"`
+	Nlink      uint32 `json:"Nlink"`
+	BlkSize    int64  `json:"BlkSize"`
+	UID        int    `json:"UID"`
+	GID        int    `json:"GID"`
+	User       string `json:"User"`
+	Group      string `json:"Group"`
+	// Owner is the object's owner in the store when the backend reports
+	// it, for example with the daemon's -fetch-owner flag.
+	Owner *objectstore.Owner `json:"Owner,omitempty"`
+	// Lock is the Object Lock status, reported by /stat?locks=true.
+	Lock *objectstore.ObjectLock `json:"Lock,omitempty"`
+}
+
+// TreeEntry is a node of the nested listing returned by /tree.
+type TreeEntry struct {
+	POSIXEntry
+	Children []TreeEntry `json:"Children,omitempty"`
+}
+
+// DirResponse is returned by /dir: the requested path and, for a directory,
+// its entries.
+type DirResponse struct {
+	Dir     POSIXEntry   `json:"Dir"`
+	Entries []POSIXEntry `json:"Entries"`
+}
+
+// CacheStatsResponse is returned by /cache/stats.
+type CacheStatsResponse struct {
+	Cache cache.Stats `json:"Cache"`
+	Warm  WarmStatus  `json:"Warm"`
+}
+
+// IndexResponse is returned by GET / as an overview of the service.
+type IndexResponse struct {
+	Service   string     `json:"Service"`
+	LocalRoot string     `json:"LocalRoot"`
+	Bucket    string     `json:"Bucket,omitempty"`
+	Prefix    string     `json:"Prefix,omitempty"`
+	Endpoints []string   `json:"Endpoints"`
+	Warm      WarmStatus `json:"Warm"`
+}
+
+// RewarmResponse is returned by /cache/rewarm.
+type RewarmResponse struct {
+	Started bool       `json:"Started"`
+	Warm    WarmStatus `json:"Warm"`
+}
+
+// ChecksumResponse is returned by /checksum.
+type ChecksumResponse struct {
+	Path      string `json:"Path"`
+	Algorithm string `json:"Algorithm"`
+	Checksum  string `json:"Checksum"`
 }
 
+// TokenResponse is returned by /token.
+type TokenResponse struct {
+	Token string `json:"Token"`
+	Path  string `json:"Path"`
+	// Scope is "archive" for tokens minted with scope=archive and empty for
+	// /cat download tokens.
+	Scope   string    `json:"Scope,omitempty"`
+	Expires time.Time `json:"Expires"`
+}
+
+// ExistsResponse is returned by /exists.
+type ExistsResponse struct {
+	Path   string `json:"Path"`
+	Exists bool   `json:"Exists"`
+}
+
+// ErrorResponse is the JSON body of every failed request. Error is meant for
+// people; Code is one of the ErrorCode constants and is stable for programs.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// Error codes reported in ErrorResponse.Code.
+const (
+	// ErrorCodeNotFound: the path does not exist (404).
+	ErrorCodeNotFound = "not_found"
+	// ErrorCodeInvalidPath: the path is malformed or outside the local root
+	// (400).
+	ErrorCodeInvalidPath = "invalid_path"
+	// ErrorCodeInvalidRequest: a query parameter or request body is invalid
+	// (400).
+	ErrorCodeInvalidRequest = "invalid_request"
+	// ErrorCodePermissionDenied: a path policy or the backend refused access
+	// (403).
+	ErrorCodePermissionDenied = "permission_denied"
+	// ErrorCodeReadOnly: a write was refused by Config.ReadOnly (405).
+	ErrorCodeReadOnly = "read_only"
+	// ErrorCodeMethodNotAllowed: the endpoint requires another method (405).
+	ErrorCodeMethodNotAllowed = "method_not_allowed"
+	// ErrorCodeBusy: the download queue is full; retry later (503).
+	ErrorCodeBusy = "busy"
+	// ErrorCodeUnavailable: the backend cannot be reached (503).
+	ErrorCodeUnavailable = "unavailable"
+	// ErrorCodeNotImplemented: the store lacks the requested feature (501).
+	ErrorCodeNotImplemented = "not_implemented"
+	// ErrorCodeInsufficientStorage: pinning would exceed the content cache
+	// limits (507).
+	ErrorCodeInsufficientStorage = "insufficient_storage"
+	// ErrorCodeUpstream: the backend or the local cache failed (500).
+	ErrorCodeUpstream = "upstream_error"
+)
+
 // IPCServer exposes RemoteFS through HTTP/IPC so other languages can consume it.
 type IPCServer struct {
 	fs    *FileSystem
@@ -44,17 +170,140 @@ type IPCServer struct {
 	gid   int
 	user  string
 	group string
+
+	ranges      bool
+	backslashes bool
+	dirSlash    bool
+
+	// bucket and prefix are reported by the / index when set.
+	bucket string
+	prefix string
+
+	// tokenKey signs /token download links; tokenRequired makes /cat
+	// refuse requests without one.
+	tokenKey      []byte
+	tokenRequired bool
+
+	// streamIdle aborts streamed file content once the client stops
+	// accepting it for that long; writeTimeout bounds every other response.
+	streamIdle   time.Duration
+	writeTimeout time.Duration
+
+	// accessLog, when set, writes a line for every request.
+	accessLog *accessLogger
+
+	// flushBytes and flushInterval make /cat flush its response after that
+	// much content or that much time; zero leaves buffering to net/http.
+	flushBytes    int64
+	flushInterval time.Duration
+}
+
+// IPCOption customizes an IPCServer during construction.
+type IPCOption func(*IPCServer)
+
+// WithRangeRequests controls whether /cat honours Range requests and
+// advertises "Accept-Ranges: bytes" for seekable handles. It is enabled by
+// default; when disabled /cat always answers with the full content and
+// "Accept-Ranges: none".
+func WithRangeRequests(enabled bool) IPCOption {
+	return func(s *IPCServer) {
+		s.ranges = enabled
+	}
+}
+
+// WithBackslashSeparators makes the server treat backslashes in path
+// parameters as separators, so Windows clients sending paths such as
+// \data\docs\report.txt resolve the same way on every server OS. It is off
+// by default because a backslash is a legal character in object keys.
+func WithBackslashSeparators(enabled bool) IPCOption {
+	return func(s *IPCServer) {
+		s.backslashes = enabled
+	}
+}
+
+// WithDirectorySlash makes /stat, /ls, and /tree report directory paths with
+// a trailing slash, such as docs/, so clients can tell directories apart by
+// name alone. Requests accept a directory with or without the slash either
+// way. Off by default.
+func WithDirectorySlash(enabled bool) IPCOption {
+	return func(s *IPCServer) {
+		s.dirSlash = enabled
+	}
+}
+
+// WithStoreLocation makes the / index report the bucket and key prefix the
+// filesystem is backed by. Without it both are left out.
+func WithStoreLocation(bucket, prefix string) IPCOption {
+	return func(s *IPCServer) {
+		s.bucket = bucket
+		s.prefix = prefix
+	}
+}
+
+// WithDownloadTokens enables /token, which mints short-lived links to a single
+// file signed with key, and makes /cat accept them as ?token=. With required
+// set, /cat refuses requests that do not carry a valid token, so the server
+// can sit behind a proxy that exposes only /cat to browsers.
+func WithDownloadTokens(key []byte, required bool) IPCOption {
+	return func(s *IPCServer) {
+		s.tokenKey = key
+		s.tokenRequired = required
+	}
+}
+
+// WithStreamIdleTimeout aborts /cat, /catmany, and /archive responses once the
+// client has accepted no data for d, which frees the download slot and file
+// handle a stuck client would otherwise hold forever. The deadline is pushed
+// back after every write, so slow but steady clients are unaffected. Zero, the
+// default, lets streams wait indefinitely.
+func WithStreamIdleTimeout(d time.Duration) IPCOption {
+	return func(s *IPCServer) {
+		s.streamIdle = d
+	}
+}
+
+// WithWriteTimeout bounds the time Serve spends writing a response, for every
+// endpoint except the streaming ones covered by WithStreamIdleTimeout, which
+// may legitimately take much longer. Zero, the default, disables it.
+func WithWriteTimeout(d time.Duration) IPCOption {
+	return func(s *IPCServer) {
+		s.writeTimeout = d
+	}
+}
+
+// WithCatFlush makes /cat flush its response to the client once n bytes have
+// been written since the last flush, or interval after unflushed bytes were
+// written, whichever comes first. Clients tailing a growing file or reading
+// from a slow origin then see steady progress instead of whatever net/http
+// happens to buffer. Zero disables either trigger; both are off by default.
+func WithCatFlush(n int64, interval time.Duration) IPCOption {
+	return func(s *IPCServer) {
+		s.flushBytes = n
+		s.flushInterval = interval
+	}
+}
+
+// WithAccessLog writes one line per request to w in the given format, after
+// the response is complete. Lines are written whole, so w may be shared.
+func WithAccessLog(w io.Writer, format AccessLogFormat) IPCOption {
+	return func(s *IPCServer) {
+		s.accessLog = &accessLogger{w: w, format: format}
+	}
 }
 
 // NewIPCServer constructs a server bound to the provided filesystem.
-func NewIPCServer(fs *FileSystem) (*IPCServer, error) {
+func NewIPCServer(fs *FileSystem, opts ...IPCOption) (*IPCServer, error) {
 	if fs == nil {
 		return nil, fmt.Errorf("filesystem is required")
 	}
 	s := &IPCServer{
-		fs:  fs,
-		uid: os.Geteuid(),
-		gid: os.Getegid(),
+		fs:     fs,
+		uid:    os.Geteuid(),
+		gid:    os.Getegid(),
+		ranges: true,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	if u, err := user.LookupId(strconv.Itoa(s.uid)); err == nil {
 		s.user = u.Username
@@ -65,12 +314,41 @@ func NewIPCServer(fs *FileSystem) (*IPCServer, error) {
 	return s, nil
 }
 
-// Handler returns an http.Handler exposing /stat, /ls, and /cat endpoints.
+// Handler returns an http.Handler exposing /stat, /ls, /cat, /checksum, /ready,
+// the /cache maintenance endpoints, and an index of them at /.
 func (s *IPCServer) Handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/stat", s.handleStat)
-	mux.HandleFunc("/ls", s.handleList)
-	mux.HandleFunc("/cat", s.handleCat)
+	var endpoints []string
+	handle := func(pattern string, h http.HandlerFunc) {
+		endpoints = append(endpoints, pattern)
+		mux.HandleFunc(pattern, h)
+	}
+	handle("/stat", s.handleStat)
+	handle("/ls", s.handleList)
+	handle("/tree", s.handleTree)
+	handle("/dir", s.handleDir)
+	handle("/usage", s.handleUsage)
+	handle("/cat", s.handleCat)
+	handle("/token", s.handleToken)
+	handle("/checksum", s.handleChecksum)
+	handle("/exists", s.handleExists)
+	handle("/select", s.handleSelect)
+	handle("/catmany", s.handleCatMany)
+	handle("/archive", s.handleArchive)
+	handle("/tail", s.handleTail)
+	handle("/ready", s.handleReady)
+	handle("/cache/stats", s.handleCacheStats)
+	handle("/cache/rewarm", s.handleRewarm)
+	handle("/cache/lookup", s.handleCacheLookup)
+	handle("/cache/entries", s.handleCacheEntries)
+	handle("/cache/verify", s.handleCacheVerify)
+	handle("/cache/pin", s.handleCachePin)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s.handleIndex(w, r, endpoints)
+	})
+	if s.accessLog != nil {
+		return s.accessLog.wrap(mux)
+	}
 	return mux
 }
 
@@ -85,7 +363,7 @@ func (s *IPCServer) Serve(ctx context.Context, socketPath, listenAddr string) er
 	}
 	defer l.Close()
 
-	server := &http.Server{Handler: s.Handler()}
+	server := &http.Server{Handler: s.Handler(), WriteTimeout: s.writeTimeout}
 	errCh := make(chan error, 1)
 	go func() {
 		if serveErr := server.Serve(l); serveErr != nil && serveErr != http.ErrServerClosed {
@@ -105,12 +383,71 @@ func (s *IPCServer) Serve(ctx context.Context, socketPath, listenAddr string) er
 	}
 }
 
+// requestPath extracts the path query parameter. When rel=true is present the
+// path is interpreted relative to the local root instead of as a full local
+// path. An empty path resolves to the root when allowRoot is set. Backslashes
+// become separators when WithBackslashSeparators is enabled.
+func (s *IPCServer) requestPath(r *http.Request, allowRoot bool) (string, error) {
+	q := r.URL.Query()
+	relative, err := queryBool(q, "rel")
+	if err != nil {
+		return "", err
+	}
+	p := q.Get("path")
+	if p == "" {
+		if !allowRoot {
+			return "", nil
+		}
+		return s.fs.LocalRoot(), nil
+	}
+	return s.localPath(p, relative)
+}
+
+// queryBool parses the boolean query parameter name, false when absent.
+// A malformed value is an errInvalidQuery.
+func queryBool(q url.Values, name string) (bool, error) {
+	v := q.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s %q, want true or false", errInvalidQuery, name, v)
+	}
+	return b, nil
+}
+
+// localPath applies separator normalization and, when relative is set, joins
+// p onto the local root.
+func (s *IPCServer) localPath(p string, relative bool) (string, error) {
+	if s.backslashes {
+		p = strings.ReplaceAll(p, `\`, "/")
+		if !s.fs.cfg.SlashRoot {
+			p = filepath.FromSlash(p)
+		}
+	}
+	if relative {
+		return s.fs.Relative(p)
+	}
+	return p, nil
+}
+
 func (s *IPCServer) handleStat(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Query().Get("path")
-	if path == "" {
-		path = s.fs.LocalRoot()
+	path, err := s.requestPath(r, true)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
 	}
-	meta, err := s.fs.Stat(r.Context(), path)
+	stat := s.fs.Stat
+	locks, err := queryBool(r.URL.Query(), "locks")
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if locks {
+		stat = s.fs.StatLocks
+	}
+	meta, err := stat(r.Context(), path)
 	if err != nil {
 		writeErrorFor(w, err)
 		return
@@ -119,38 +456,787 @@ func (s *IPCServer) handleStat(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *IPCServer) handleList(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Query().Get("path")
-	if path == "" {
-		path = s.fs.LocalRoot()
+	path, err := s.requestPath(r, true)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	opts, err := readDirOptionsFromQuery(r)
+	if errors.Is(err, errInvalidQuery) {
+		writeErrorFor(w, err)
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	items, err := s.fs.ReadDir(r.Context(), path)
+	res, err := s.fs.ReadDirOpts(r.Context(), path, opts)
 	if err != nil {
 		writeErrorFor(w, err)
 		return
 	}
-	out := make([]POSIXEntry, 0, len(items))
-	for _, item := range items {
+	if res.Truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	if opts.windowed() {
+		w.Header().Set("X-Total-Count", strconv.Itoa(res.Total))
+	}
+	out := make([]POSIXEntry, 0, len(res.Entries))
+	for _, item := range res.Entries {
 		out = append(out, s.entryFromMeta(item))
 	}
 	writeJSON(w, out)
 }
 
+// handleDir serves FileSystem.StatAndList.
+func (s *IPCServer) handleDir(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, true)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	meta, res, err := s.fs.statAndList(r.Context(), path)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if res.Truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	out := DirResponse{Dir: s.entryFromMeta(meta), Entries: make([]POSIXEntry, 0, len(res.Entries))}
+	for _, item := range res.Entries {
+		out.Entries = append(out.Entries, s.entryFromMeta(item))
+	}
+	writeJSON(w, out)
+}
+
+// handleTree serves FileSystem.Tree. depth defaults to 0, the direct
+// children, and -1 expands the whole subtree.
+func (s *IPCServer) handleTree(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, true)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	depth := 0
+	if v := r.URL.Query().Get("depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < -1 {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid depth %q, want an integer of -1 or more", v))
+			return
+		}
+	}
+	root, err := s.fs.Tree(r.Context(), path, depth)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	writeJSON(w, s.treeEntry(root))
+}
+
+// treeEntry converts node and its children to their JSON form.
+func (s *IPCServer) treeEntry(node *TreeNode) TreeEntry {
+	out := TreeEntry{POSIXEntry: s.entryFromMeta(node.FileMeta)}
+	if node.Children != nil {
+		out.Children = make([]TreeEntry, 0, len(node.Children))
+		for _, child := range node.Children {
+			out.Children = append(out.Children, s.treeEntry(child))
+		}
+	}
+	return out
+}
+
+// handleUsage serves FileSystem.UsageTop. top defaults to defaultUsageTop.
+func (s *IPCServer) handleUsage(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, true)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	top := defaultUsageTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		if top, err = strconv.Atoi(v); err != nil || top < 0 {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid top %q, want a non-negative integer", v))
+			return
+		}
+	}
+	report, err := s.fs.UsageTop(r.Context(), path, top)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// readDirOptionsFromQuery maps the sort, order, glob, dirsfirst, dirs-only,
+// hidden, max, enrich, recursive, depth, since, since-dirs, offset, and count
+// query parameters of /ls onto ReadDirOptions.
+func readDirOptionsFromQuery(r *http.Request) (ReadDirOptions, error) {
+	q := r.URL.Query()
+	key, err := ParseSortKey(q.Get("sort"))
+	if err != nil {
+		return ReadDirOptions{}, err
+	}
+	opts := ReadDirOptions{Sort: key, Glob: q.Get("glob")}
+	switch order := q.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return ReadDirOptions{}, fmt.Errorf("unknown order %q", order)
+	}
+	for _, flag := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"dirsfirst", &opts.DirsFirst},
+		{"dirs-only", &opts.DirsOnly},
+		{"enrich", &opts.Enrich},
+		{"flat", &opts.Flat},
+		{"since-dirs", &opts.SinceKeepDirs},
+	} {
+		if *flag.dst, err = queryBool(q, flag.name); err != nil {
+			return ReadDirOptions{}, err
+		}
+	}
+	if q.Get("hidden") != "" {
+		// Dotfiles are shown unless hidden=false asks to drop them.
+		hidden, err := queryBool(q, "hidden")
+		if err != nil {
+			return ReadDirOptions{}, err
+		}
+		opts.HideDotfiles = !hidden
+	}
+	if v := q.Get("max"); v != "" {
+		if opts.MaxEntries, err = strconv.Atoi(v); err != nil || opts.MaxEntries < 0 {
+			return ReadDirOptions{}, fmt.Errorf("invalid max %q, want a non-negative integer", v)
+		}
+	}
+	recursive, err := queryBool(q, "recursive")
+	if err != nil {
+		return ReadDirOptions{}, err
+	}
+	if v := q.Get("depth"); v != "" {
+		if !recursive {
+			return ReadDirOptions{}, fmt.Errorf("depth requires recursive=true")
+		}
+		depth, err := strconv.Atoi(v)
+		if err != nil || depth < 0 {
+			return ReadDirOptions{}, fmt.Errorf("invalid depth %q, want a non-negative integer", v)
+		}
+		opts.Depth = depth
+	} else if recursive {
+		opts.Depth = -1
+	}
+	if v := q.Get("since"); v != "" {
+		if opts.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return ReadDirOptions{}, fmt.Errorf("invalid since %q, want RFC 3339", v)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if opts.Offset, err = strconv.Atoi(v); err != nil || opts.Offset < 0 {
+			return ReadDirOptions{}, fmt.Errorf("invalid offset %q, want a non-negative integer", v)
+		}
+	}
+	if v := q.Get("count"); v != "" {
+		if opts.Count, err = strconv.Atoi(v); err != nil || opts.Count < 0 {
+			return ReadDirOptions{}, fmt.Errorf("invalid count %q, want a non-negative integer", v)
+		}
+	}
+	return opts, opts.Validate()
+}
+
 func (s *IPCServer) handleCat(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Query().Get("path")
+	path, err := s.catPath(r)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
 	if path == "" {
 		writeHTTPError(w, http.StatusBadRequest, "path query parameter is required")
 		return
 	}
-	reader, err := s.fs.ReadFile(r.Context(), path)
+	read := s.fs.ReadFile
+	fresh, err := queryBool(r.URL.Query(), "nocache")
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if fresh {
+		read = s.fs.ReadFileFresh
+	}
+	var chain []Transform
+	if v := r.URL.Query().Get("transform"); v != "" {
+		if chain, err = s.fs.cfg.transformChain(strings.Split(v, ",")); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if r.Method == http.MethodHead && len(chain) == 0 && !fresh {
+		if meta, seekable, ok, err := s.fs.statContent(r.Context(), path); err != nil || ok {
+			if err != nil {
+				writeErrorFor(w, err)
+				return
+			}
+			s.headCat(w, meta, seekable)
+			return
+		}
+	}
+	reader, err := read(r.Context(), path)
+	if err == nil {
+		reader, err = applyTransforms(reader, chain)
+	}
 	if err != nil {
 		writeErrorFor(w, err)
 		return
 	}
 	defer reader.Close()
+	w = s.streamWriter(w)
+	if s.flushBytes > 0 || s.flushInterval > 0 {
+		fw := newFlushWriter(w, s.flushBytes, s.flushInterval)
+		defer fw.stop()
+		w = fw
+	}
 	w.Header().Set("Content-Type", "application/octet-stream")
+	if s.ranges && reader.Seekable() {
+		// ServeContent handles Range, HEAD, and advertises
+		// "Accept-Ranges: bytes" for the seekable cache file.
+		http.ServeContent(w, r, "", time.Time{}, reader)
+		return
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Method == http.MethodHead {
+		return
+	}
 	_, _ = io.Copy(w, reader)
 }
 
+// headCat answers a HEAD /cat from the metadata of the file, without fetching
+// its content.
+func (s *IPCServer) headCat(w http.ResponseWriter, meta objectstore.FileMeta, seekable bool) {
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	if s.ranges && seekable {
+		h.Set("Accept-Ranges", "bytes")
+	} else {
+		h.Set("Accept-Ranges", "none")
+	}
+	if meta.ETag != "" {
+		etag := meta.ETag
+		if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, "W/") {
+			etag = strconv.Quote(etag)
+		}
+		h.Set("ETag", etag)
+	}
+	if !meta.LastModified.IsZero() {
+		h.Set("Last-Modified", meta.LastModified.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// catPath returns the path /cat should serve: the one granted by the token
+// parameter when present, or the path parameter unless tokens are required.
+func (s *IPCServer) catPath(r *http.Request) (string, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		if s.tokenRequired {
+			return "", fmt.Errorf("%w: download token required", ErrPermissionDenied)
+		}
+		return s.requestPath(r, false)
+	}
+	if s.tokenKey == nil {
+		return "", fmt.Errorf("%w: download tokens are not enabled", ErrPermissionDenied)
+	}
+	return VerifyDownloadToken(s.tokenKey, token, time.Now())
+}
+
+// archivePath is catPath for /archive: a token must have been minted with
+// scope=archive for the prefix it names, so a /cat link to one file cannot
+// be used to download its directory.
+func (s *IPCServer) archivePath(r *http.Request) (string, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		if s.tokenRequired {
+			return "", fmt.Errorf("%w: archive token required", ErrPermissionDenied)
+		}
+		return s.requestPath(r, false)
+	}
+	if s.tokenKey == nil {
+		return "", fmt.Errorf("%w: download tokens are not enabled", ErrPermissionDenied)
+	}
+	return VerifyArchiveToken(s.tokenKey, token, time.Now())
+}
+
+// handleToken mints a download token for path, valid for ttl (a Go duration,
+// defaultTokenTTL when omitted, at most maxTokenTTL). scope=archive mints a
+// token for /archive of the subtree of path instead.
+func (s *IPCServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, "token requires POST")
+		return
+	}
+	if s.tokenKey == nil {
+		writeHTTPError(w, http.StatusNotImplemented, "download tokens are not enabled")
+		return
+	}
+	path, err := s.requestPath(r, false)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if path == "" {
+		writeHTTPError(w, http.StatusBadRequest, "path query parameter is required")
+		return
+	}
+	if _, err := s.fs.sanitize(path); err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	ttl := defaultTokenTTL
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 || ttl > maxTokenTTL {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid ttl %q, want a duration up to %s", v, maxTokenTTL))
+			return
+		}
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	resp := TokenResponse{Path: path, Expires: expires}
+	switch scope := r.URL.Query().Get("scope"); scope {
+	case "":
+		resp.Token = SignDownloadToken(s.tokenKey, path, expires)
+	case archiveTokenScope:
+		resp.Token = SignArchiveToken(s.tokenKey, path, expires)
+		resp.Scope = scope
+	default:
+		writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid scope %q, want %q or none", scope, archiveTokenScope))
+		return
+	}
+	writeJSON(w, resp)
+}
+
+func (s *IPCServer) handleChecksum(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, false)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if path == "" {
+		writeHTTPError(w, http.StatusBadRequest, "path query parameter is required")
+		return
+	}
+	algo := r.URL.Query().Get("algo")
+	if algo == "" {
+		algo = "sha256"
+	}
+	sum, err := s.fs.Checksum(r.Context(), path, algo)
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedAlgorithm) {
+			writeHTTPError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeErrorFor(w, err)
+		return
+	}
+	writeJSON(w, ChecksumResponse{
+		Path:      path,
+		Algorithm: algo,
+		Checksum:  sum,
+	})
+}
+
+func (s *IPCServer) handleExists(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, false)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if path == "" {
+		writeHTTPError(w, http.StatusBadRequest, "path query parameter is required")
+		return
+	}
+	exists, err := s.fs.Exists(r.Context(), path)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	writeJSON(w, ExistsResponse{Path: path, Exists: exists})
+}
+
+// handleSelect streams the records of path matching the query parameter.
+// input, output, header, delimiter, json, and compression map onto
+// objectstore.SelectOptions.
+func (s *IPCServer) handleSelect(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, false)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	q := r.URL.Query()
+	if path == "" || q.Get("query") == "" {
+		writeHTTPError(w, http.StatusBadRequest, "path and query parameters are required")
+		return
+	}
+	opts := objectstore.SelectOptions{
+		InputFormat:  q.Get("input"),
+		OutputFormat: q.Get("output"),
+		CSVHeader:    q.Get("header"),
+		CSVDelimiter: q.Get("delimiter"),
+		JSONType:     q.Get("json"),
+		Compression:  q.Get("compression"),
+	}
+	body, err := s.fs.Select(r.Context(), path, q.Get("query"), opts)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.Copy(w, body)
+}
+
+// handleCatMany streams the files named by a JSON array in the POST body,
+// concatenated in order. separator, boundaries, skip-missing, and rel tune
+// the output.
+func (s *IPCServer) handleCatMany(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, "catmany requires POST")
+		return
+	}
+	var paths []string
+	if err := json.NewDecoder(r.Body).Decode(&paths); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("decode path list: %v", err))
+		return
+	}
+	q := r.URL.Query()
+	relative, err := queryBool(q, "rel")
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	opts := ReadFilesOptions{Separator: q.Get("separator")}
+	for name, dst := range map[string]*bool{"boundaries": &opts.Boundaries, "skip-missing": &opts.SkipMissing} {
+		if *dst, err = queryBool(q, name); err != nil {
+			writeErrorFor(w, err)
+			return
+		}
+	}
+	locals := make([]string, 0, len(paths))
+	for _, p := range paths {
+		local, err := s.localPath(p, relative)
+		if err != nil {
+			writeErrorFor(w, err)
+			return
+		}
+		locals = append(locals, local)
+	}
+	body, err := s.fs.ReadFilesOpts(r.Context(), locals, opts)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	defer body.Close()
+	w = s.streamWriter(w)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.Copy(w, body)
+}
+
+// handleArchive streams the subtree of path as a tar archive. It accepts an
+// archive token, minted with /token?scope=archive, in place of path.
+func (s *IPCServer) handleArchive(w http.ResponseWriter, r *http.Request) {
+	path, err := s.archivePath(r)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if path == "" {
+		writeHTTPError(w, http.StatusBadRequest, "path query parameter is required")
+		return
+	}
+	// Write the header only once the first bytes are ready, so a failed
+	// listing can still be answered with an error status.
+	out := &lazyHeaderWriter{w: s.streamWriter(w), contentType: "application/x-tar"}
+	if err := s.fs.WriteArchive(r.Context(), out, path); err != nil && !out.started {
+		writeErrorFor(w, err)
+	}
+}
+
+// streamWriter prepares w for streaming file content: it lifts the server's
+// write timeout and, with WithStreamIdleTimeout, applies a deadline that each
+// write pushes back.
+func (s *IPCServer) streamWriter(w http.ResponseWriter) http.ResponseWriter {
+	rc := http.NewResponseController(w)
+	if s.streamIdle <= 0 {
+		if s.writeTimeout > 0 {
+			_ = rc.SetWriteDeadline(time.Time{})
+		}
+		return w
+	}
+	return &idleTimeoutWriter{ResponseWriter: w, rc: rc, timeout: s.streamIdle}
+}
+
+// idleTimeoutWriter moves the connection's write deadline timeout past every
+// write, so a write only fails when the client stops reading.
+type idleTimeoutWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (w *idleTimeoutWriter) Write(p []byte) (int, error) {
+	_ = w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *idleTimeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flushWriter flushes the response after every n bytes, and interval after
+// the first unflushed write, so buffered content never waits on the next
+// write of a stalled source.
+type flushWriter struct {
+	http.ResponseWriter
+	rc       *http.ResponseController
+	n        int64
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending int64
+	timer   *time.Timer
+	stopped bool
+}
+
+func newFlushWriter(w http.ResponseWriter, n int64, interval time.Duration) *flushWriter {
+	return &flushWriter{ResponseWriter: w, rc: http.NewResponseController(w), n: n, interval: interval}
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.ResponseWriter.Write(p)
+	w.pending += int64(n)
+	if err != nil || w.pending == 0 {
+		return n, err
+	}
+	if w.n > 0 && w.pending >= w.n {
+		w.flushLocked()
+	} else if w.interval > 0 && w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.flushTimer)
+	}
+	return n, err
+}
+
+func (w *flushWriter) flushTimer() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopped && w.pending > 0 {
+		w.flushLocked()
+	}
+}
+
+func (w *flushWriter) flushLocked() {
+	_ = w.rc.Flush()
+	w.pending = 0
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
+
+// stop cancels a pending timed flush. The writer must not be flushed once
+// the handler has returned.
+func (w *flushWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *flushWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// lazyHeaderWriter sets Content-Type on the first Write.
+type lazyHeaderWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func (l *lazyHeaderWriter) Write(p []byte) (int, error) {
+	if !l.started {
+		l.started = true
+		l.w.Header().Set("Content-Type", l.contentType)
+	}
+	return l.w.Write(p)
+}
+
+// defaultTailLines matches tail(1) when /tail is called without lines.
+const defaultTailLines = 10
+
+func (s *IPCServer) handleTail(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, false)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if path == "" {
+		writeHTTPError(w, http.StatusBadRequest, "path query parameter is required")
+		return
+	}
+	lines := defaultTailLines
+	if v := r.URL.Query().Get("lines"); v != "" {
+		if lines, err = strconv.Atoi(v); err != nil || lines < 0 {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid lines %q, want a non-negative integer", v))
+			return
+		}
+	}
+	body, err := s.fs.Tail(r.Context(), path, lines)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.Copy(w, body)
+}
+
+func (s *IPCServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := s.fs.Ping(r.Context()); err != nil {
+		writeHTTPError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleIndex describes the service at / and answers 404 for any other
+// unregistered path.
+func (s *IPCServer) handleIndex(w http.ResponseWriter, r *http.Request, endpoints []string) {
+	if r.URL.Path != "/" {
+		writeHTTPError(w, http.StatusNotFound, fmt.Sprintf("no endpoint %s", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeHTTPError(w, http.StatusMethodNotAllowed, "index requires GET")
+		return
+	}
+	writeJSON(w, IndexResponse{
+		Service:   "remotefs",
+		LocalRoot: s.fs.LocalRoot(),
+		Bucket:    s.bucket,
+		Prefix:    s.prefix,
+		Endpoints: endpoints,
+		Warm:      s.fs.WarmStatus(),
+	})
+}
+
+func (s *IPCServer) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, CacheStatsResponse{
+		Cache: s.fs.CacheStats(),
+		Warm:  s.fs.WarmStatus(),
+	})
+}
+
+func (s *IPCServer) handleCacheLookup(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, false)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if path == "" {
+		writeHTTPError(w, http.StatusBadRequest, "path query parameter is required")
+		return
+	}
+	info, err := s.fs.CacheInfo(path)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	writeJSON(w, info)
+}
+
+func (s *IPCServer) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.fs.CacheEntries())
+}
+
+// handleCachePin pins path in the content cache on POST and unpins it on
+// DELETE, answering with the resulting CacheEntryInfo.
+func (s *IPCServer) handleCachePin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		writeHTTPError(w, http.StatusMethodNotAllowed, "pin requires POST or DELETE")
+		return
+	}
+	path, err := s.requestPath(r, false)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if path == "" {
+		writeHTTPError(w, http.StatusBadRequest, "path query parameter is required")
+		return
+	}
+	if r.Method == http.MethodPost {
+		err = s.fs.PinFile(r.Context(), path)
+	} else {
+		err = s.fs.UnpinFile(path)
+	}
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	info, err := s.fs.CacheInfo(path)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	writeJSON(w, info)
+}
+
+func (s *IPCServer) handleCacheVerify(w http.ResponseWriter, r *http.Request) {
+	prune, err := queryBool(r.URL.Query(), "prune")
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	if prune && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, "prune requires POST")
+		return
+	}
+	report, err := s.fs.VerifyCache(r.Context(), prune)
+	if err != nil {
+		writeErrorFor(w, err)
+		return
+	}
+	writeJSON(w, report)
+}
+
+func (s *IPCServer) handleRewarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, "rewarm requires POST")
+		return
+	}
+	// The warm outlives the request, so detach it from the request's
+	// cancellation while keeping its values.
+	started := s.fs.StartRewarm(context.WithoutCancel(r.Context()))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(RewarmResponse{
+		Started: started,
+		Warm:    s.fs.WarmStatus(),
+	})
+}
+
 func (s *IPCServer) entryFromMeta(meta objectstore.FileMeta) POSIXEntry {
 	entry := POSIXEntry{
 		Path:         meta.Path,
@@ -158,15 +1244,25 @@ func (s *IPCServer) entryFromMeta(meta objectstore.FileMeta) POSIXEntry {
 		ETag:         meta.ETag,
 		LastModified: meta.LastModified,
 		IsDir:        meta.IsDir,
+		ChildCount:   meta.ChildCount,
+		Incomplete:   meta.Incomplete,
+		Owner:        meta.Owner,
+		Lock:         meta.Lock,
 		UID:          s.uid,
 		GID:          s.gid,
 		User:         s.user,
 		Group:        s.group,
 	}
 	if entry.LastModified.IsZero() {
-		entry.LastModified = time.Now()
+		entry.LastModified = UnknownModTime
+	}
+	if s.dirSlash && meta.IsDir && meta.Path != "" {
+		entry.Path += "/"
 	}
+	entry.Inode = inodeFor(meta.Path, meta.IsDir)
 	entry.Mode = defaultMode(entry.IsDir)
+	entry.Nlink = defaultNlink(entry.IsDir)
+	entry.BlkSize = blockSize
 	return entry
 }
 
@@ -177,6 +1273,38 @@ func defaultMode(isDir bool) uint32 {
 	return uint32(modeRegBits | filePerms)
 }
 
+// defaultNlink mirrors the link counts of a plain filesystem: directories
+// count "." and their parent's entry, files have a single link.
+func defaultNlink(isDir bool) uint32 {
+	if isDir {
+		return 2
+	}
+	return 1
+}
+
+// rootInode is the inode number of the local root.
+const rootInode = 1
+
+// inodeFor derives a stable inode number from the store relative path with
+// 64-bit FNV-1a, so stat and readdir agree without keeping any state. The low
+// bit is set for directories and clear for files, so the two kinds never
+// collide, and values below 2 are reserved for the root.
+func inodeFor(rel string, isDir bool) uint64 {
+	if rel == "" {
+		return rootInode
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(rel))
+	ino := h.Sum64() &^ 1
+	if isDir {
+		ino |= 1
+	}
+	if ino < 2 {
+		ino += 2
+	}
+	return ino
+}
+
 func createListener(socketPath, listenAddr string) (net.Listener, error) {
 	if socketPath != "" {
 		if err := os.MkdirAll(filepath.Dir(socketPath), 0o755); err != nil {
@@ -203,16 +1331,58 @@ func writeJSON(w http.ResponseWriter, payload interface{}) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
+// writeHTTPError answers with status and an ErrorResponse whose code is the
+// generic one for that status.
 func writeHTTPError(w http.ResponseWriter, status int, msg string) {
+	writeErrorResponse(w, status, ErrorResponse{Error: msg, Code: codeForStatus(status)})
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// codeForStatus returns the error code used when only the status is known.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalidRequest
+	case http.StatusForbidden:
+		return ErrorCodePermissionDenied
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrorCodeMethodNotAllowed
+	case http.StatusNotImplemented:
+		return ErrorCodeNotImplemented
+	case http.StatusServiceUnavailable:
+		return ErrorCodeUnavailable
+	default:
+		return ErrorCodeUpstream
+	}
 }
 
+// writeErrorFor maps a filesystem error onto its status and error code.
 func writeErrorFor(w http.ResponseWriter, err error) {
-	status := http.StatusInternalServerError
-	if IsNotFound(err) {
-		status = http.StatusNotFound
+	status, code := http.StatusInternalServerError, ErrorCodeUpstream
+	switch {
+	case IsNotFound(err):
+		status, code = http.StatusNotFound, ErrorCodeNotFound
+	case errors.Is(err, errInvalidQuery), objectstore.IsInvalidSelect(err):
+		status, code = http.StatusBadRequest, ErrorCodeInvalidRequest
+	case IsInvalidPath(err), IsPathTooDeep(err):
+		status, code = http.StatusBadRequest, ErrorCodeInvalidPath
+	case errors.Is(err, ErrPermissionDenied), objectstore.IsAccessDenied(err):
+		status, code = http.StatusForbidden, ErrorCodePermissionDenied
+	case errors.Is(err, ErrDownloadQueueFull):
+		status, code = http.StatusServiceUnavailable, ErrorCodeBusy
+	case IsReadOnly(err):
+		status, code = http.StatusMethodNotAllowed, ErrorCodeReadOnly
+	case errors.Is(err, objectstore.ErrSelectNotSupported), errors.Is(err, objectstore.ErrObjectLockNotSupported):
+		status, code = http.StatusNotImplemented, ErrorCodeNotImplemented
+	case errors.Is(err, cache.ErrPinBudget):
+		status, code = http.StatusInsufficientStorage, ErrorCodeInsufficientStorage
 	}
-	writeHTTPError(w, status, err.Error())
+	writeErrorResponse(w, status, ErrorResponse{Error: err.Error(), Code: code})
 }
@@ -0,0 +1,141 @@
+package remotefs
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// metaCache holds warmed object metadata keyed by relative path. With a
+// positive max it keeps only the most recently used entries, so a warm of a
+// bucket too large for memory degrades into a cache of its hot paths; lookups
+// that miss fall back to the store.
+type metaCache struct {
+	max int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+	// gen stamps every put, so an incremental warm can tell the entries it
+	// wrote from the ones left over from before it started.
+	gen uint64
+}
+
+// metaEntry is the value of an element in metaCache.order.
+type metaEntry struct {
+	meta objectstore.FileMeta
+	gen  uint64
+}
+
+func newMetaCache(max int) *metaCache {
+	return &metaCache{
+		max:     max,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// metaCacheFrom copies entries into a new cache bounded by max.
+func metaCacheFrom(entries map[string]objectstore.FileMeta, max int) *metaCache {
+	m := newMetaCache(max)
+	for _, meta := range entries {
+		m.put(meta)
+	}
+	return m
+}
+
+// get returns the entry for rel and marks it recently used.
+func (m *metaCache) get(rel string) (objectstore.FileMeta, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[rel]
+	if !ok {
+		return objectstore.FileMeta{}, false
+	}
+	m.order.MoveToFront(el)
+	return el.Value.(*metaEntry).meta, true
+}
+
+// put adds or replaces the entry for meta.Path, evicting the least recently
+// used entries beyond max.
+func (m *metaCache) put(meta objectstore.FileMeta) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[meta.Path]; ok {
+		el.Value = &metaEntry{meta: meta, gen: m.gen}
+		m.order.MoveToFront(el)
+		return
+	}
+	m.entries[meta.Path] = m.order.PushFront(&metaEntry{meta: meta, gen: m.gen})
+	for m.max > 0 && m.order.Len() > m.max {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*metaEntry).meta.Path)
+	}
+}
+
+// putIfAbsent is put for entries that must not overwrite what is cached.
+func (m *metaCache) putIfAbsent(meta objectstore.FileMeta) {
+	if _, ok := m.get(meta.Path); !ok {
+		m.put(meta)
+	}
+}
+
+// remove drops the entry for rel.
+func (m *metaCache) remove(rel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[rel]; ok {
+		m.order.Remove(el)
+		delete(m.entries, rel)
+	}
+}
+
+// removeTree drops rel and every entry below it.
+func (m *metaCache) removeTree(rel string) {
+	m.sweepTree(rel, ^uint64(0))
+}
+
+// nextGeneration starts a new generation and returns it. Entries put from
+// now on carry it.
+func (m *metaCache) nextGeneration() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gen++
+	return m.gen
+}
+
+// sweepTree drops the entries at or below rel that were put before
+// generation gen. An empty rel covers the whole cache.
+func (m *metaCache) sweepTree(rel string, gen uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, el := range m.entries {
+		if rel != "" && key != rel && !strings.HasPrefix(key, rel+"/") {
+			continue
+		}
+		if el.Value.(*metaEntry).gen < gen {
+			m.order.Remove(el)
+			delete(m.entries, key)
+		}
+	}
+}
+
+// len returns the number of cached entries.
+func (m *metaCache) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// each calls fn for every entry, least recently used first. It does not
+// change the recency order.
+func (m *metaCache) each(fn func(meta objectstore.FileMeta)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for el := m.order.Back(); el != nil; el = el.Prev() {
+		fn(el.Value.(*metaEntry).meta)
+	}
+}
@@ -0,0 +1,178 @@
+package remotefs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// PathPolicy maps a caller supplied local path onto the slash separated path
+// relative to the store prefix. Implementations can deny paths by returning
+// an error or rewrite them before they reach the store. The default policy
+// returned by DefaultPathPolicy only enforces the local root; custom policies
+// usually wrap it.
+type PathPolicy interface {
+	Resolve(local string) (rel string, err error)
+}
+
+// PathError reports a local path rejected because it is malformed or falls
+// outside the local root.
+type PathError struct {
+	Path   string
+	Reason string
+}
+
+func (e PathError) Error() string {
+	if e.Path == "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("path %s %s", e.Path, e.Reason)
+}
+
+// IsInvalidPath reports whether err is a PathError.
+func IsInvalidPath(err error) bool {
+	var target PathError
+	return errors.As(err, &target)
+}
+
+// DefaultMaxPathDepth is the path depth limit used when Config.MaxPathDepth is
+// zero. S3 keys are at most 1024 bytes, so no S3 key reaches it.
+const DefaultMaxPathDepth = 512
+
+// PathTooDeepError reports a local path with more segments than
+// Config.MaxPathDepth allows.
+type PathTooDeepError struct {
+	Path  string
+	Depth int
+	Max   int
+}
+
+func (e PathTooDeepError) Error() string {
+	return fmt.Sprintf("path %s has %d segments, more than the limit of %d", e.Path, e.Depth, e.Max)
+}
+
+// IsPathTooDeep reports whether err is a PathTooDeepError.
+func IsPathTooDeep(err error) bool {
+	var target PathTooDeepError
+	return errors.As(err, &target)
+}
+
+// pathDepth returns the number of segments in a relative path; the root has
+// none.
+func pathDepth(rel string) int {
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// ErrPermissionDenied can be wrapped by PathPolicy implementations that deny
+// a path, so the IPC server answers 403 with the permission_denied code.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// DefaultPathPolicy returns the policy FileSystem uses when Config.PathPolicy
+// is nil: paths are cleaned and must stay beneath root. An empty root accepts
+// any absolute path.
+func DefaultPathPolicy(root string) PathPolicy {
+	return rootPolicy{root: normalizeRoot(root)}
+}
+
+// SlashPathPolicy is DefaultPathPolicy for Config.SlashRoot: local paths are
+// POSIX style with "/" separators whatever the runtime OS.
+func SlashPathPolicy(root string) PathPolicy {
+	return rootPolicy{root: normalizeSlashRoot(root), slash: true}
+}
+
+// normalizeRoot cleans root and maps "." to the empty root.
+func normalizeRoot(root string) string {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		return ""
+	}
+	root = filepath.Clean(root)
+	if root == "." {
+		return ""
+	}
+	return root
+}
+
+// normalizeSlashRoot is normalizeRoot for slash separated roots; "/" maps to
+// the empty root.
+func normalizeSlashRoot(root string) string {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		return ""
+	}
+	root = path.Clean("/" + root)
+	if root == "/" {
+		return ""
+	}
+	return root
+}
+
+type rootPolicy struct {
+	root  string
+	slash bool
+}
+
+// Resolve normalizes and ensures the path stays under the configured root.
+// Only leading whitespace is dropped: trailing spaces belong to the name, as
+// object keys may end in one.
+func (p rootPolicy) Resolve(local string) (string, error) {
+	local = strings.TrimLeftFunc(local, unicode.IsSpace)
+	if local == "" {
+		return "", PathError{Reason: "empty path"}
+	}
+	if p.slash {
+		return p.resolveSlash(local)
+	}
+	target := filepath.Clean(local)
+	if p.root != "" {
+		root := p.root
+		if target != root {
+			prefix := root + string(os.PathSeparator)
+			if !strings.HasPrefix(target, prefix) {
+				return "", PathError{Path: target, Reason: "outside of " + root}
+			}
+			target = strings.TrimPrefix(target, prefix)
+		} else {
+			target = ""
+		}
+	} else {
+		// The virtual filesystem expects slash separated paths irrespective
+		// of the OS, so strip any leading separators and normalize the rest.
+		target = strings.TrimLeft(target, string(os.PathSeparator))
+	}
+	rel := path.Clean(filepath.ToSlash(target))
+	rel = strings.TrimPrefix(rel, "/")
+	if rel == "." {
+		rel = ""
+	}
+	return rel, nil
+}
+
+// resolveSlash is Resolve with POSIX path semantics.
+func (p rootPolicy) resolveSlash(local string) (string, error) {
+	target := path.Clean(local)
+	if p.root != "" {
+		if target == p.root {
+			return "", nil
+		}
+		if !strings.HasPrefix(target, p.root+"/") {
+			return "", PathError{Path: target, Reason: "outside of " + p.root}
+		}
+		target = strings.TrimPrefix(target, p.root+"/")
+	}
+	rel := strings.TrimLeft(target, "/")
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", PathError{Path: local, Reason: "outside of /"}
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return rel, nil
+}
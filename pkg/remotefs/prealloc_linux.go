@@ -0,0 +1,21 @@
+//go:build linux
+
+package remotefs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes of disk for f with fallocate, so the
+// download is laid out contiguously and a full disk fails before any byte is
+// fetched. Filesystems without fallocate support are left alone.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == nil || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	return fmt.Errorf("preallocate cache file: %w", err)
+}
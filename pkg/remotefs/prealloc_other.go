@@ -0,0 +1,11 @@
+//go:build !linux
+
+package remotefs
+
+import "os"
+
+// preallocate does nothing on platforms without fallocate; cache files grow
+// as the download writes them.
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,442 @@
+package remotefs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// SortKey selects the field ReadDirOpts orders entries by.
+type SortKey string
+
+const (
+	// SortNone keeps the order returned by the object store.
+	SortNone SortKey = ""
+	// SortName orders entries by path.
+	SortName SortKey = "name"
+	// SortSize orders entries by size.
+	SortSize SortKey = "size"
+	// SortModTime orders entries by last modification time.
+	SortModTime SortKey = "modtime"
+)
+
+// ReadDirOptions post-processes a directory listing in memory.
+type ReadDirOptions struct {
+	Sort       SortKey
+	Descending bool
+	// Glob filters entries whose base name does not match the pattern, using
+	// path.Match syntax.
+	Glob string
+	// DirsFirst lists directories ahead of files, each group keeping the
+	// requested sort order.
+	DirsFirst bool
+	// DirsOnly drops files so only subdirectories are returned.
+	DirsOnly bool
+	// HideDotfiles drops files and directories whose base name starts with
+	// ".", like ls without -a. With Depth set, the contents of hidden
+	// directories are still listed; only the entries themselves are dropped.
+	HideDotfiles bool
+	// MaxEntries stops the listing after this many entries, tightening
+	// Config.MaxDirEntries for callers that only want a bounded peek. Sorting
+	// and filtering apply to the entries that were fetched.
+	MaxEntries int
+	// Depth also lists subdirectories, descending this many levels below the
+	// directory. Zero lists only direct children and a negative depth walks
+	// the whole subtree. Filtering and sorting apply to the combined entries,
+	// and MaxEntries caps the total.
+	Depth int
+	// Enrich fills in full metadata for every returned entry. Entries already
+	// in the metadata cache use it, which gives warmed directories their size
+	// and child count; other files are refreshed with a Head request, up to
+	// enrichConcurrency at a time. Entries whose Head fails keep the listed
+	// metadata. This costs a request per file, so it is off by default.
+	Enrich bool
+	// Since drops files last modified before this time, for incremental
+	// processing. Directories carry no modification time and are dropped as
+	// well unless SinceKeepDirs is set.
+	Since time.Time
+	// SinceKeepDirs keeps the directories that contain a file modified at or
+	// after Since anywhere below them. Each directory is walked until such a
+	// file turns up, which costs a listing request per visited directory.
+	SinceKeepDirs bool
+	// Flat lists every object below the directory as a file entry with its
+	// full path, using a single listing without a delimiter, so no
+	// directories are synthesized. It is the cheapest way to enumerate a
+	// subtree when the hierarchy does not matter and cannot be combined
+	// with Depth. MaxEntries and Config.MaxDirEntries cap the entries.
+	Flat bool
+	// Offset and Count select a window of the listing by index, for UIs that
+	// scroll through huge directories at random. The full listing is
+	// fetched, filtered, and sorted once, kept for Config.ListingWindowTTL,
+	// and sliced; ReadDirResult.Total reports its length. Without a sort key
+	// entries are ordered by name so windows line up across requests. The
+	// first window of a directory costs as much as listing it whole, and the
+	// cached listing holds every entry in memory. A zero Count runs to the
+	// end of the listing.
+	Offset int
+	Count  int
+}
+
+// windowed reports whether o selects an index window.
+func (o ReadDirOptions) windowed() bool {
+	return o.Offset > 0 || o.Count > 0
+}
+
+// enrichConcurrency bounds the Head requests issued by ReadDirOptions.Enrich.
+const enrichConcurrency = 8
+
+// ParseSortKey validates a sort key received from a caller.
+func ParseSortKey(v string) (SortKey, error) {
+	switch key := SortKey(v); key {
+	case SortNone, SortName, SortSize, SortModTime:
+		return key, nil
+	default:
+		return SortNone, fmt.Errorf("unknown sort key %q", v)
+	}
+}
+
+// Validate reports an unknown sort key or a malformed glob pattern.
+func (o ReadDirOptions) Validate() error {
+	if _, err := ParseSortKey(string(o.Sort)); err != nil {
+		return err
+	}
+	if o.MaxEntries < 0 {
+		return fmt.Errorf("negative entry limit %d", o.MaxEntries)
+	}
+	if o.Offset < 0 || o.Count < 0 {
+		return fmt.Errorf("negative window offset %d or count %d", o.Offset, o.Count)
+	}
+	if o.Glob != "" {
+		if _, err := path.Match(o.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", o.Glob, err)
+		}
+	}
+	if o.SinceKeepDirs && o.Since.IsZero() {
+		return fmt.Errorf("SinceKeepDirs requires Since")
+	}
+	if o.Flat && o.Depth != 0 {
+		return fmt.Errorf("Flat cannot be combined with Depth")
+	}
+	return nil
+}
+
+// ReadDirOpts behaves like ReadDir and then filters and sorts the entries
+// according to opts. The result reports whether the listing was truncated by
+// opts.MaxEntries or Config.MaxDirEntries.
+func (fs *FileSystem) ReadDirOpts(ctx context.Context, local string, opts ReadDirOptions) (ReadDirResult, error) {
+	if err := opts.Validate(); err != nil {
+		return ReadDirResult{}, err
+	}
+	if opts.windowed() {
+		return fs.readDirWindow(ctx, local, opts)
+	}
+	var (
+		res ReadDirResult
+		err error
+	)
+	switch {
+	case opts.Flat:
+		res, err = fs.readDirFlat(ctx, local, opts.MaxEntries)
+	case opts.Depth != 0:
+		res, err = fs.readDirRecursive(ctx, local, opts.Depth, opts.MaxEntries)
+	default:
+		res, err = fs.readDir(ctx, local, opts.MaxEntries)
+	}
+	// Partial results that come with an error are filtered like complete
+	// ones, so callers never see entries opts excludes.
+	res.Entries = filterEntries(res.Entries, opts)
+	if err != nil {
+		res.Entries = sortEntries(res.Entries, opts)
+		return res, err
+	}
+	if opts.SinceKeepDirs {
+		if res.Entries, err = fs.dropUnmodifiedDirs(ctx, res.Entries, opts.Since); err != nil {
+			return res, err
+		}
+	}
+	if opts.Enrich {
+		fs.enrichEntries(ctx, res.Entries)
+	}
+	res.Entries = sortEntries(res.Entries, opts)
+	return res, nil
+}
+
+// ReadDirSince lists the files directly under local that were modified at or
+// after since. Use ReadDirOpts with SinceKeepDirs to also get the directories
+// holding such files.
+func (fs *FileSystem) ReadDirSince(ctx context.Context, local string, since time.Time) ([]objectstore.FileMeta, error) {
+	res, err := fs.ReadDirOpts(ctx, local, ReadDirOptions{Since: since})
+	return res.Entries, err
+}
+
+// dropUnmodifiedDirs removes the directories in items without a file modified
+// at or after since below them. Files are kept as they are.
+func (fs *FileSystem) dropUnmodifiedDirs(ctx context.Context, items []objectstore.FileMeta, since time.Time) ([]objectstore.FileMeta, error) {
+	kept := make([]objectstore.FileMeta, 0, len(items))
+	for _, item := range items {
+		if item.IsDir {
+			ok, err := fs.modifiedBelow(ctx, fs.joinLocal(item.Path), since)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		kept = append(kept, item)
+	}
+	return kept, nil
+}
+
+// modifiedBelow reports whether any file in the subtree of local was modified
+// at or after since, stopping at the first one found. A directory that
+// vanished counts as unmodified.
+func (fs *FileSystem) modifiedBelow(ctx context.Context, local string, since time.Time) (bool, error) {
+	res, err := fs.readDir(ctx, local, 0)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, item := range res.Entries {
+		if !item.IsDir && !item.LastModified.Before(since) {
+			return true, nil
+		}
+	}
+	for _, item := range res.Entries {
+		if !item.IsDir {
+			continue
+		}
+		if ok, err := fs.modifiedBelow(ctx, fs.joinLocal(item.Path), since); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// enrichEntries replaces each entry with its cached metadata or, for files
+// that are not cached, the result of a Head request.
+func (fs *FileSystem) enrichEntries(ctx context.Context, items []objectstore.FileMeta) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, enrichConcurrency)
+	for i := range items {
+		if meta, ok := fs.cachedMeta(items[i].Path); ok {
+			items[i] = meta
+			continue
+		}
+		if items[i].IsDir {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item *objectstore.FileMeta) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if meta, err := fs.store.Head(ctx, item.Path); err == nil {
+				*item = meta
+			}
+		}(&items[i])
+	}
+	wg.Wait()
+}
+
+// ReadDirRecursive lists local and its subdirectories down to depth levels
+// below it, in breadth-first order. A depth of 0 behaves like ReadDir and a
+// negative depth walks the whole subtree.
+func (fs *FileSystem) ReadDirRecursive(ctx context.Context, local string, depth int) ([]objectstore.FileMeta, error) {
+	res, err := fs.readDirRecursive(ctx, local, depth, 0)
+	return res.Entries, err
+}
+
+// readDirRecursive lists local level by level. limit caps the total number of
+// entries when positive; Config.MaxDirEntries still caps each directory.
+func (fs *FileSystem) readDirRecursive(ctx context.Context, local string, depth, limit int) (ReadDirResult, error) {
+	var out ReadDirResult
+	level := []string{local}
+	for d := 0; len(level) > 0; d++ {
+		var next []string
+		for i, dir := range level {
+			remaining := 0
+			if limit > 0 {
+				remaining = limit - len(out.Entries)
+			}
+			res, err := fs.readDir(ctx, dir, remaining)
+			if err != nil {
+				// A subdirectory that vanished since its parent was listed is
+				// skipped; anything else ends the walk.
+				if d > 0 && IsNotFound(err) {
+					continue
+				}
+				out.Entries = append(out.Entries, res.Entries...)
+				return out, err
+			}
+			out.Entries = append(out.Entries, res.Entries...)
+			out.Truncated = out.Truncated || res.Truncated
+			if depth < 0 || d < depth {
+				for _, item := range res.Entries {
+					if item.IsDir && fs.expandable(item.Path) {
+						next = append(next, fs.joinLocal(item.Path))
+					}
+				}
+			}
+			if limit > 0 && len(out.Entries) >= limit {
+				out.Truncated = out.Truncated || i < len(level)-1 || len(next) > 0
+				return out, nil
+			}
+		}
+		level = next
+	}
+	return out, nil
+}
+
+// readDirFlat lists every object below local through objectstore.ListFlat,
+// stopping at limit entries, or Config.MaxDirEntries if tighter, and marking
+// the result truncated when more exist.
+func (fs *FileSystem) readDirFlat(ctx context.Context, local string, limit int) (ReadDirResult, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return ReadDirResult{}, err
+	}
+	if max := fs.cfg.MaxDirEntries; max > 0 && (limit <= 0 || limit > max) {
+		limit = max
+	}
+	items, truncated, err := objectstore.ListFlat(ctx, fs.store, rel, limit)
+	if err != nil {
+		if objectstore.IsNotFound(err) {
+			return ReadDirResult{}, fs.notFound(rel, err)
+		}
+		return ReadDirResult{}, err
+	}
+	if rel != "" && len(items) == 0 {
+		return ReadDirResult{}, fs.notFound(rel, nil)
+	}
+	return ReadDirResult{Entries: items, Truncated: truncated}, nil
+}
+
+// ReadDirs returns only the subdirectories of local, which keeps payloads
+// small for directory tree navigators.
+func (fs *FileSystem) ReadDirs(ctx context.Context, local string) ([]objectstore.FileMeta, error) {
+	res, err := fs.ReadDirOpts(ctx, local, ReadDirOptions{DirsOnly: true})
+	return res.Entries, err
+}
+
+// StatAndList returns the metadata of the directory local together with its
+// entries, for views such as breadcrumbs over a listing that need both. The
+// listing doubles as the existence check, so a directory costs a single
+// listing rather than a Stat and a ReadDir. Warmed directories report their
+// cached metadata. A file is returned with no entries.
+func (fs *FileSystem) StatAndList(ctx context.Context, local string) (objectstore.FileMeta, []objectstore.FileMeta, error) {
+	meta, res, err := fs.statAndList(ctx, local)
+	return meta, res.Entries, err
+}
+
+// statAndList is StatAndList that also reports whether the listing was cut
+// short by Config.MaxDirEntries.
+func (fs *FileSystem) statAndList(ctx context.Context, local string) (objectstore.FileMeta, ReadDirResult, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return objectstore.FileMeta{}, ReadDirResult{}, err
+	}
+	if meta, ok := fs.cachedMeta(rel); ok && !meta.IsDir {
+		return meta, ReadDirResult{}, nil
+	}
+	res, err := fs.readDir(ctx, local, 0)
+	if err == nil || !IsNotFound(err) {
+		meta, ok := fs.cachedMeta(rel)
+		if !ok {
+			meta = objectstore.FileMeta{Path: rel, IsDir: true}
+		}
+		if err != nil && len(res.Entries) == 0 {
+			return objectstore.FileMeta{}, res, err
+		}
+		return meta, res, err
+	}
+	// Nothing below rel: it may be a file or an empty directory with a
+	// marker object.
+	meta, err := fs.Stat(ctx, local)
+	if err != nil {
+		return objectstore.FileMeta{}, ReadDirResult{}, err
+	}
+	return meta, ReadDirResult{}, nil
+}
+
+// applyReadDirOptions filters and sorts items. opts must already be
+// validated.
+func applyReadDirOptions(items []objectstore.FileMeta, opts ReadDirOptions) []objectstore.FileMeta {
+	return sortEntries(filterEntries(items, opts), opts)
+}
+
+// filterEntries drops the items excluded by opts.Glob, opts.DirsOnly,
+// opts.HideDotfiles, and opts.Since. Directories are kept for SinceKeepDirs to
+// check.
+func filterEntries(items []objectstore.FileMeta, opts ReadDirOptions) []objectstore.FileMeta {
+	if opts.Glob != "" || opts.DirsOnly || opts.HideDotfiles || !opts.Since.IsZero() {
+		kept := make([]objectstore.FileMeta, 0, len(items))
+		for _, item := range items {
+			if opts.DirsOnly && !item.IsDir {
+				continue
+			}
+			if opts.HideDotfiles && strings.HasPrefix(path.Base(item.Path), ".") {
+				continue
+			}
+			if !opts.Since.IsZero() {
+				if item.IsDir && !opts.SinceKeepDirs {
+					continue
+				}
+				if !item.IsDir && item.LastModified.Before(opts.Since) {
+					continue
+				}
+			}
+			if opts.Glob != "" {
+				if ok, _ := path.Match(opts.Glob, path.Base(item.Path)); !ok {
+					continue
+				}
+			}
+			kept = append(kept, item)
+		}
+		items = kept
+	}
+	return items
+}
+
+// sortEntries orders items per opts.Sort, opts.Descending, and opts.DirsFirst.
+func sortEntries(items []objectstore.FileMeta, opts ReadDirOptions) []objectstore.FileMeta {
+	less := sortLess(opts.Sort)
+	if less == nil && !opts.DirsFirst {
+		return items
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if opts.DirsFirst && a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if less == nil {
+			return false
+		}
+		if opts.Descending {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return items
+}
+
+func sortLess(key SortKey) func(a, b objectstore.FileMeta) bool {
+	switch key {
+	case SortName:
+		return func(a, b objectstore.FileMeta) bool { return a.Path < b.Path }
+	case SortSize:
+		return func(a, b objectstore.FileMeta) bool { return a.Size < b.Size }
+	case SortModTime:
+		return func(a, b objectstore.FileMeta) bool { return a.LastModified.Before(b.LastModified) }
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,619 @@
+package remotefs
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestApplyReadDirOptions(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	listing := func() []objectstore.FileMeta {
+		return []objectstore.FileMeta{
+			{Path: "d/b.csv", Size: 30, LastModified: base.Add(time.Hour)},
+			{Path: "d/sub", IsDir: true},
+			{Path: "d/a.txt", Size: 10, LastModified: base.Add(3 * time.Hour)},
+			{Path: "d/c.csv", Size: 20, LastModified: base.Add(2 * time.Hour)},
+		}
+	}
+	tests := []struct {
+		name string
+		opts ReadDirOptions
+		want string
+	}{
+		{name: "unchanged", want: "d/b.csv,d/sub,d/a.txt,d/c.csv"},
+		{name: "name", opts: ReadDirOptions{Sort: SortName}, want: "d/a.txt,d/b.csv,d/c.csv,d/sub"},
+		{name: "size desc", opts: ReadDirOptions{Sort: SortSize, Descending: true}, want: "d/b.csv,d/c.csv,d/a.txt,d/sub"},
+		{name: "modtime dirs first", opts: ReadDirOptions{Sort: SortModTime, DirsFirst: true}, want: "d/sub,d/b.csv,d/c.csv,d/a.txt"},
+		{name: "glob", opts: ReadDirOptions{Sort: SortName, Glob: "*.csv"}, want: "d/b.csv,d/c.csv"},
+		{name: "dirs only", opts: ReadDirOptions{DirsOnly: true}, want: "d/sub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			var got []string
+			for _, item := range applyReadDirOptions(listing(), tt.opts) {
+				got = append(got, item.Path)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Fatalf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDirOptsHideDotfiles(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"d":      {{Path: "d/.env"}, {Path: "d/.git", IsDir: true}, {Path: "d/a.txt"}, {Path: "d/sub", IsDir: true}},
+			"d/.git": {{Path: "d/.git/config"}},
+			"d/sub":  {{Path: "d/sub/.keep"}, {Path: "d/sub/b.txt"}},
+		},
+	}
+	fs := &FileSystem{store: store}
+	ctx := context.Background()
+	local := filepath.Join(string(filepath.Separator), "d")
+
+	tests := []struct {
+		opts ReadDirOptions
+		want string
+	}{
+		{opts: ReadDirOptions{}, want: "d/.env,d/.git,d/a.txt,d/sub"},
+		{opts: ReadDirOptions{HideDotfiles: true}, want: "d/a.txt,d/sub"},
+		{opts: ReadDirOptions{HideDotfiles: true, Depth: -1}, want: "d/a.txt,d/sub,d/.git/config,d/sub/b.txt"},
+	}
+	for _, tt := range tests {
+		res, err := fs.ReadDirOpts(ctx, local, tt.opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.opts, err)
+		}
+		var got []string
+		for _, item := range res.Entries {
+			got = append(got, item.Path)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Fatalf("%+v: got %v, want %s", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestReadDirOptionsValidate(t *testing.T) {
+	if err := (ReadDirOptions{Sort: "owner"}).Validate(); err == nil {
+		t.Fatalf("expected error for unknown sort key")
+	}
+	if err := (ReadDirOptions{Glob: "["}).Validate(); err == nil {
+		t.Fatalf("expected error for malformed glob")
+	}
+	if err := (ReadDirOptions{Flat: true, Depth: 1}).Validate(); err == nil {
+		t.Fatalf("expected error for Flat with Depth")
+	}
+}
+
+func TestReadDirOptsTruncates(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"big": {
+				{Path: "big/a"}, {Path: "big/b"}, {Path: "big/c"}, {Path: "big/d"},
+			},
+		},
+	}
+	fs := &FileSystem{store: store, cfg: Config{MaxDirEntries: 3}}
+	local := filepath.Join(string(filepath.Separator), "big")
+
+	res, err := fs.ReadDirOpts(context.Background(), local, ReadDirOptions{})
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if !res.Truncated || len(res.Entries) != 3 {
+		t.Fatalf("config cap: truncated=%v entries=%d", res.Truncated, len(res.Entries))
+	}
+	res, err = fs.ReadDirOpts(context.Background(), local, ReadDirOptions{MaxEntries: 2})
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if !res.Truncated || len(res.Entries) != 2 {
+		t.Fatalf("request cap: truncated=%v entries=%d", res.Truncated, len(res.Entries))
+	}
+	fs.cfg.MaxDirEntries = 0
+	res, err = fs.ReadDirOpts(context.Background(), local, ReadDirOptions{})
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if res.Truncated || len(res.Entries) != 4 {
+		t.Fatalf("uncapped: truncated=%v entries=%d", res.Truncated, len(res.Entries))
+	}
+}
+
+func TestReadDirCachesListingsForTTL(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"logs": {{Path: "logs/a.log"}, {Path: "logs/b.log"}},
+		},
+	}
+	fs := &FileSystem{store: store, cfg: Config{DirCacheTTL: time.Minute}}
+	local := filepath.Join(string(filepath.Separator), "logs")
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		res, err := fs.ReadDirOpts(ctx, local, ReadDirOptions{Glob: "a*"})
+		if err != nil {
+			t.Fatalf("read dir: %v", err)
+		}
+		if len(res.Entries) != 1 {
+			t.Fatalf("pass %d: entries = %+v", i, res.Entries)
+		}
+	}
+	if len(store.listCalls) != 1 {
+		t.Fatalf("expected one List call, got %v", store.listCalls)
+	}
+	if items, _ := fs.ReadDir(ctx, local); len(items) != 2 {
+		t.Fatalf("filtering leaked into the cached listing: %+v", items)
+	}
+
+	fs.dirs["logs"] = dirListing{entries: fs.dirs["logs"].entries, expires: time.Now().Add(-time.Second)}
+	if _, err := fs.ReadDir(ctx, local); err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(store.listCalls) != 2 {
+		t.Fatalf("expired listing was not refetched: %v", store.listCalls)
+	}
+}
+
+func TestForgetListingDropsAncestors(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"":     {{Path: "logs", IsDir: true}},
+			"logs": {{Path: "logs/a.log"}},
+		},
+	}
+	fs := &FileSystem{store: store, cfg: Config{DirCacheTTL: time.Minute}}
+	ctx := context.Background()
+	root := string(filepath.Separator)
+	for _, local := range []string{root, filepath.Join(root, "logs")} {
+		if _, err := fs.ReadDir(ctx, local); err != nil {
+			t.Fatalf("read dir %s: %v", local, err)
+		}
+	}
+	if _, err := fs.ReadDirOpts(ctx, root, ReadDirOptions{Depth: -1, Count: 10}); err != nil {
+		t.Fatalf("recursive window: %v", err)
+	}
+
+	// Writing logs/2024/b.log creates logs/2024, which the cached listing
+	// of logs lacks, and changes the recursive window of the root.
+	store.listing["logs"] = append(store.listing["logs"], objectstore.FileMeta{Path: "logs/2024", IsDir: true})
+	store.listing["logs/2024"] = []objectstore.FileMeta{{Path: "logs/2024/b.log"}}
+	fs.forgetListing("logs/2024/b.log")
+
+	items, err := fs.ReadDir(ctx, filepath.Join(root, "logs"))
+	if err != nil || len(items) != 2 {
+		t.Fatalf("logs after write = %+v, %v", items, err)
+	}
+	res, err := fs.ReadDirOpts(ctx, root, ReadDirOptions{Depth: -1, Count: 10})
+	if err != nil || res.Total != 4 {
+		t.Fatalf("recursive window after write = %+v, %v", res, err)
+	}
+	if _, ok := fs.dirs[""]; !ok {
+		t.Fatalf("root listing was not cached again")
+	}
+}
+
+func TestReadDirRecursiveDepth(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"":      {{Path: "a", IsDir: true}, {Path: "top.txt"}},
+			"a":     {{Path: "a/b", IsDir: true}, {Path: "a/one.txt"}},
+			"a/b":   {{Path: "a/b/c", IsDir: true}, {Path: "a/b/two.txt"}},
+			"a/b/c": {{Path: "a/b/c/three.txt"}},
+		},
+	}
+	fs := &FileSystem{store: store}
+	ctx := context.Background()
+	root := string(filepath.Separator)
+
+	paths := func(items []objectstore.FileMeta) string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Path)
+		}
+		return strings.Join(out, ",")
+	}
+	tests := []struct {
+		depth int
+		want  string
+	}{
+		{depth: 0, want: "a,top.txt"},
+		{depth: 1, want: "a,top.txt,a/b,a/one.txt"},
+		{depth: -1, want: "a,top.txt,a/b,a/one.txt,a/b/c,a/b/two.txt,a/b/c/three.txt"},
+	}
+	for _, tt := range tests {
+		items, err := fs.ReadDirRecursive(ctx, root, tt.depth)
+		if err != nil {
+			t.Fatalf("depth %d: %v", tt.depth, err)
+		}
+		if got := paths(items); got != tt.want {
+			t.Fatalf("depth %d: got %s, want %s", tt.depth, got, tt.want)
+		}
+	}
+
+	res, err := fs.ReadDirOpts(ctx, root, ReadDirOptions{Depth: -1, MaxEntries: 3})
+	if err != nil {
+		t.Fatalf("capped walk: %v", err)
+	}
+	if !res.Truncated || len(res.Entries) != 3 {
+		t.Fatalf("capped walk: truncated=%v entries=%s", res.Truncated, paths(res.Entries))
+	}
+}
+
+// flatStore serves ListFlat from a fixed set of keys.
+type flatStore struct {
+	statTestStore
+	keys []objectstore.FileMeta
+}
+
+func (s *flatStore) ListFlat(ctx context.Context, key string, limit int) ([]objectstore.FileMeta, bool, error) {
+	if limit > 0 && len(s.keys) > limit {
+		return s.keys[:limit], true, nil
+	}
+	return s.keys, false, nil
+}
+
+func TestReadDirOptsFlat(t *testing.T) {
+	ctx := context.Background()
+	root := string(filepath.Separator)
+	paths := func(items []objectstore.FileMeta) string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Path)
+		}
+		return strings.Join(out, ",")
+	}
+
+	store := &flatStore{keys: []objectstore.FileMeta{{Path: "a/b/c/three.txt"}, {Path: "a/one.txt"}, {Path: "top.txt"}}}
+	fs := &FileSystem{store: store}
+	res, err := fs.ReadDirOpts(ctx, root, ReadDirOptions{Flat: true, MaxEntries: 2})
+	if err != nil {
+		t.Fatalf("flat: %v", err)
+	}
+	if got := paths(res.Entries); got != "a/b/c/three.txt,a/one.txt" || !res.Truncated || len(store.listCalls) != 0 {
+		t.Fatalf("flat = %s truncated=%v, lists %v", got, res.Truncated, store.listCalls)
+	}
+
+	// Stores without ListFlat are walked, keeping only the files.
+	walked := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"":    {{Path: "a", IsDir: true}, {Path: "top.txt"}},
+			"a":   {{Path: "a/b", IsDir: true}, {Path: "a/one.txt"}},
+			"a/b": {{Path: "a/b/two.txt"}},
+		},
+	}
+	fs = &FileSystem{store: walked}
+	res, err = fs.ReadDirOpts(ctx, root, ReadDirOptions{Flat: true})
+	if err != nil {
+		t.Fatalf("walked flat: %v", err)
+	}
+	if got := paths(res.Entries); got != "a/b/two.txt,a/one.txt,top.txt" || res.Truncated {
+		t.Fatalf("walked flat = %s truncated=%v", got, res.Truncated)
+	}
+}
+
+func TestReadDirOptsWindow(t *testing.T) {
+	ctx := context.Background()
+	root := string(filepath.Separator)
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"": {{Path: "d.txt"}, {Path: "b.txt"}, {Path: ".hidden"}, {Path: "e.txt"}, {Path: "a.txt"}, {Path: "c.txt"}},
+		},
+	}
+	fs := &FileSystem{store: store}
+	total := 5
+	window := func(offset, count int) string {
+		t.Helper()
+		res, err := fs.ReadDirOpts(ctx, root, ReadDirOptions{HideDotfiles: true, Offset: offset, Count: count})
+		if err != nil {
+			t.Fatalf("window %d+%d: %v", offset, count, err)
+		}
+		if res.Total != total {
+			t.Fatalf("window %d+%d total = %d, want %d", offset, count, res.Total, total)
+		}
+		var out []string
+		for _, item := range res.Entries {
+			out = append(out, item.Path)
+		}
+		return strings.Join(out, ",")
+	}
+
+	// Windows slice the filtered listing in name order and share one fetch.
+	if got := window(1, 2); got != "b.txt,c.txt" {
+		t.Fatalf("window 1+2 = %s", got)
+	}
+	if got := window(3, 0); got != "d.txt,e.txt" {
+		t.Fatalf("window 3+ = %s", got)
+	}
+	if got := window(9, 2); got != "" {
+		t.Fatalf("window past the end = %s", got)
+	}
+	if len(store.listCalls) != 1 {
+		t.Fatalf("list calls = %v, want one", store.listCalls)
+	}
+
+	// Other options are a separate listing.
+	res, err := fs.ReadDirOpts(ctx, root, ReadDirOptions{Sort: SortName, Descending: true, Count: 1})
+	if err != nil || len(res.Entries) != 1 || res.Entries[0].Path != "e.txt" || res.Total != 6 {
+		t.Fatalf("descending window = %+v, %v", res, err)
+	}
+	if len(store.listCalls) != 2 {
+		t.Fatalf("list calls = %v, want two", store.listCalls)
+	}
+
+	// A write to the directory drops its windows.
+	store.listing[""] = append(store.listing[""], objectstore.FileMeta{Path: "f.txt"})
+	fs.forgetListing("f.txt")
+	total = 6
+	if got := window(4, 0); got != "e.txt,f.txt" {
+		t.Fatalf("window after write = %s", got)
+	}
+	if len(store.listCalls) != 3 {
+		t.Fatalf("list calls = %v, want three", store.listCalls)
+	}
+
+	if _, err := fs.ReadDirOpts(ctx, root, ReadDirOptions{Offset: -1}); err == nil {
+		t.Fatalf("expected an error for a negative offset")
+	}
+}
+
+func TestStatAndList(t *testing.T) {
+	store := &notFoundStore{statTestStore{
+		head: map[string]objectstore.FileMeta{"docs/a.txt": {Path: "docs/a.txt", Size: 3}},
+		listing: map[string][]objectstore.FileMeta{
+			"docs": {{Path: "docs/a.txt", Size: 3}, {Path: "docs/sub", IsDir: true}},
+		},
+	}}
+	fs := &FileSystem{store: store}
+	ctx := context.Background()
+	root := string(filepath.Separator)
+
+	dir, entries, err := fs.StatAndList(ctx, root+"docs")
+	if err != nil {
+		t.Fatalf("stat and list dir: %v", err)
+	}
+	if !dir.IsDir || dir.Path != "docs" || len(entries) != 2 || store.headCalls != 0 {
+		t.Fatalf("dir = %+v, %d entries, %d heads", dir, len(entries), store.headCalls)
+	}
+	file, entries, err := fs.StatAndList(ctx, root+filepath.Join("docs", "a.txt"))
+	if err != nil || file.IsDir || file.Size != 3 || entries != nil {
+		t.Fatalf("file = %+v, %v, %v", file, entries, err)
+	}
+	if _, _, err := fs.StatAndList(ctx, root+"missing"); !IsNotFound(err) {
+		t.Fatalf("missing err = %v", err)
+	}
+}
+
+func TestTree(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"":      {{Path: "a", IsDir: true}, {Path: "top.txt"}},
+			"a":     {{Path: "a/b", IsDir: true}, {Path: "a/one.txt"}},
+			"a/b":   {{Path: "a/b/c", IsDir: true}, {Path: "a/b/two.txt"}},
+			"a/b/c": {{Path: "a/b/c/three.txt"}},
+		},
+	}
+	fs := &FileSystem{store: store}
+	ctx := context.Background()
+
+	tests := []struct {
+		local string
+		depth int
+		want  string
+	}{
+		{local: "/", depth: 0, want: ".(a top.txt)"},
+		{local: "/", depth: 1, want: ".(a(b one.txt) top.txt)"},
+		{local: "/", depth: -1, want: ".(a(b(c(three.txt) two.txt) one.txt) top.txt)"},
+		{local: "/a", depth: 0, want: "a(b one.txt)"},
+	}
+	for _, tt := range tests {
+		tree, err := fs.Tree(ctx, tt.local, tt.depth)
+		if err != nil {
+			t.Fatalf("%s depth %d: %v", tt.local, tt.depth, err)
+		}
+		if got := renderTree(tree); got != tt.want {
+			t.Fatalf("%s depth %d: got %s, want %s", tt.local, tt.depth, got, tt.want)
+		}
+	}
+}
+
+// renderTree prints a tree as names with the children of expanded
+// directories in parentheses.
+func renderTree(n *TreeNode) string {
+	out := path.Base(n.Path)
+	if n.Children != nil {
+		var parts []string
+		for _, child := range n.Children {
+			parts = append(parts, renderTree(child))
+		}
+		out += "(" + strings.Join(parts, " ") + ")"
+	}
+	return out
+}
+
+// listServer answers ListObjectsV2 requests for keys like S3 would, grouping
+// keys on the requested delimiter.
+func listServer(t *testing.T, keys ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, delimiter := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+		var contents, prefixes []string
+		seen := map[string]bool{}
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			rest := key[len(prefix):]
+			if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+				if cp := prefix + rest[:i+len(delimiter)]; !seen[cp] {
+					seen[cp] = true
+					prefixes = append(prefixes, cp)
+				}
+				continue
+			}
+			contents = append(contents, key)
+		}
+		var b strings.Builder
+		b.WriteString(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><IsTruncated>false</IsTruncated>`)
+		for _, key := range contents {
+			b.WriteString("<Contents><Key>")
+			_ = xml.EscapeText(&b, []byte(key))
+			b.WriteString("</Key><Size>1</Size></Contents>")
+		}
+		for _, cp := range prefixes {
+			b.WriteString("<CommonPrefixes><Prefix>")
+			_ = xml.EscapeText(&b, []byte(cp))
+			b.WriteString("</Prefix></CommonPrefixes>")
+		}
+		b.WriteString("</ListBucketResult>")
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = io.WriteString(w, b.String())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTreeCustomDelimiter(t *testing.T) {
+	srv := listServer(t, "data|a|b|two.txt", "data|a|odd/name.txt", "data|a|one.txt", "data|top.txt")
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	store := objectstore.NewS3Store(client, "bucket", "data", objectstore.WithDelimiter("|"))
+	fs := &FileSystem{store: store}
+
+	tree, err := fs.Tree(context.Background(), "/", -1)
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	// "odd/name.txt" holds a "/" that paths would map onto "|", so it is
+	// left out rather than shown as a directory.
+	if got, want := renderTree(tree), ".(a(b(two.txt) one.txt) top.txt)"; got != want {
+		t.Fatalf("tree = %s, want %s", got, want)
+	}
+	items, err := fs.ReadDir(context.Background(), "/a")
+	if err != nil || len(items) != 2 || items[0].Path != "a/b" || items[1].Path != "a/one.txt" {
+		t.Fatalf("read dir = %+v, %v", items, err)
+	}
+}
+
+// lockedHeadStore serializes Head so concurrent callers can share the
+// statTestStore counters.
+type lockedHeadStore struct {
+	statTestStore
+	mu sync.Mutex
+}
+
+func (s *lockedHeadStore) Head(ctx context.Context, key string) (objectstore.FileMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statTestStore.Head(ctx, key)
+}
+
+func TestReadDirOptsEnrich(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	store := &lockedHeadStore{statTestStore: statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"docs": {{Path: "docs/a.txt", Size: 1}, {Path: "docs/b.txt", Size: 2}, {Path: "docs/sub", IsDir: true}},
+		},
+		head: map[string]objectstore.FileMeta{
+			"docs/a.txt": {Path: "docs/a.txt", Size: 1, ETag: "etag-a", LastModified: modified},
+		},
+	}}
+	fs := &FileSystem{store: store}
+	fs.meta = metaCacheFrom(map[string]objectstore.FileMeta{
+		"docs/sub": {Path: "docs/sub", IsDir: true, Size: 40, ChildCount: 4},
+	}, 0)
+	local := filepath.Join(string(filepath.Separator), "docs")
+
+	res, err := fs.ReadDirOpts(context.Background(), local, ReadDirOptions{Enrich: true, Sort: SortName})
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(res.Entries) != 3 {
+		t.Fatalf("entries = %+v", res.Entries)
+	}
+	if a := res.Entries[0]; a.ETag != "etag-a" || !a.LastModified.Equal(modified) {
+		t.Fatalf("file not enriched from Head: %+v", a)
+	}
+	if b := res.Entries[1]; b.Size != 2 {
+		t.Fatalf("failed Head should keep the listed entry: %+v", b)
+	}
+	if sub := res.Entries[2]; sub.Size != 40 || sub.ChildCount != 4 {
+		t.Fatalf("directory not enriched from the metadata cache: %+v", sub)
+	}
+	if store.headCalls != 2 {
+		t.Fatalf("head calls = %d, want 2", store.headCalls)
+	}
+}
+
+func TestReadDirSince(t *testing.T) {
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	old, fresh := since.Add(-time.Hour), since.Add(time.Hour)
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"in":          {{Path: "in/a.csv", LastModified: old}, {Path: "in/b.csv", LastModified: since}, {Path: "in/stale", IsDir: true}, {Path: "in/new", IsDir: true}},
+			"in/stale":    {{Path: "in/stale/x.csv", LastModified: old}},
+			"in/new":      {{Path: "in/new/deep", IsDir: true}},
+			"in/new/deep": {{Path: "in/new/deep/y.csv", LastModified: fresh}},
+		},
+	}
+	fs := &FileSystem{store: store}
+	ctx := context.Background()
+	local := filepath.Join(string(filepath.Separator), "in")
+
+	items, err := fs.ReadDirSince(ctx, local, since)
+	if err != nil {
+		t.Fatalf("read dir since: %v", err)
+	}
+	if len(items) != 1 || items[0].Path != "in/b.csv" {
+		t.Fatalf("files since = %+v", items)
+	}
+	res, err := fs.ReadDirOpts(ctx, local, ReadDirOptions{Since: since, SinceKeepDirs: true})
+	if err != nil {
+		t.Fatalf("read dir since with dirs: %v", err)
+	}
+	if len(res.Entries) != 2 || res.Entries[0].Path != "in/b.csv" || res.Entries[1].Path != "in/new" {
+		t.Fatalf("entries since with dirs = %+v", res.Entries)
+	}
+	if err := (ReadDirOptions{SinceKeepDirs: true}).Validate(); err == nil {
+		t.Fatalf("expected error for SinceKeepDirs without Since")
+	}
+}
+
+func TestReadDirOptsFiltersPartialResults(t *testing.T) {
+	fs := &FileSystem{store: &pagedStore{}}
+	local := filepath.Join(string(filepath.Separator), "big")
+	res, err := fs.ReadDirOpts(context.Background(), local, ReadDirOptions{Glob: "b*"})
+	if _, ok := objectstore.AsPartialList(err); !ok {
+		t.Fatalf("expected partial list error, got %v", err)
+	}
+	if len(res.Entries) != 0 {
+		t.Fatalf("partial entries = %+v, want the glob applied", res.Entries)
+	}
+	res, err = fs.ReadDirOpts(context.Background(), local, ReadDirOptions{DirsOnly: true})
+	if err == nil || len(res.Entries) != 0 {
+		t.Fatalf("dirs-only partial = %+v, %v", res.Entries, err)
+	}
+}
@@ -0,0 +1,160 @@
+package remotefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// ChangeKind classifies a difference between two listings.
+type ChangeKind string
+
+const (
+	ChangeCreated  ChangeKind = "Created"
+	ChangeModified ChangeKind = "Modified"
+	ChangeDeleted  ChangeKind = "Deleted"
+)
+
+// Change is a single difference reported by Snapshot.Apply.
+type Change struct {
+	Kind ChangeKind           `json:"Kind"`
+	Path string               `json:"Path"`
+	Meta objectstore.FileMeta `json:"Meta"`
+}
+
+// snapshotEntry is the per-path state used to detect modifications.
+type snapshotEntry struct {
+	ETag    string    `json:"ETag"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	IsDir   bool      `json:"IsDir"`
+}
+
+func entryFromMeta(meta objectstore.FileMeta) snapshotEntry {
+	return snapshotEntry{ETag: meta.ETag, Size: meta.Size, ModTime: meta.LastModified, IsDir: meta.IsDir}
+}
+
+// Snapshot remembers the last observed state of every path, keyed by the
+// store relative path, so polling can diff one directory listing at a time
+// instead of rescanning the whole tree. It is safe for concurrent use.
+type Snapshot struct {
+	mu      sync.Mutex
+	entries map[string]snapshotEntry
+}
+
+// NewSnapshot returns an empty snapshot. Applying a listing to it reports
+// every entry as created.
+func NewSnapshot() *Snapshot {
+	return &Snapshot{entries: make(map[string]snapshotEntry)}
+}
+
+// Len returns the number of paths tracked by the snapshot.
+func (s *Snapshot) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Apply records items as the current direct children of dir and returns the
+// changes relative to the previous state of that directory. Only dir's
+// children are compared, so the cost is proportional to the listing rather
+// than to the whole snapshot. Children that disappeared are reported as
+// deleted along with everything tracked beneath them.
+func (s *Snapshot) Apply(dir string, items []objectstore.FileMeta) []Change {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]struct{}, len(items))
+	var changes []Change
+	for _, item := range items {
+		seen[item.Path] = struct{}{}
+		next := entryFromMeta(item)
+		prev, ok := s.entries[item.Path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: ChangeCreated, Path: item.Path, Meta: item})
+		case !item.IsDir && !prev.same(next):
+			changes = append(changes, Change{Kind: ChangeModified, Path: item.Path, Meta: item})
+		}
+		s.entries[item.Path] = next
+	}
+	for rel, prev := range s.entries {
+		if parentDir(rel) != dir || rel == dir {
+			continue
+		}
+		if _, ok := seen[rel]; ok {
+			continue
+		}
+		changes = append(changes, s.removeLocked(rel, prev)...)
+	}
+	return changes
+}
+
+// removeLocked drops rel and its descendants, returning deletion changes.
+func (s *Snapshot) removeLocked(rel string, prev snapshotEntry) []Change {
+	changes := []Change{{Kind: ChangeDeleted, Path: rel, Meta: prev.meta(rel)}}
+	delete(s.entries, rel)
+	if !prev.IsDir {
+		return changes
+	}
+	for child, entry := range s.entries {
+		if strings.HasPrefix(child, rel+"/") {
+			changes = append(changes, Change{Kind: ChangeDeleted, Path: child, Meta: entry.meta(child)})
+			delete(s.entries, child)
+		}
+	}
+	return changes
+}
+
+// same reports whether e and other describe the same object version. Times
+// are compared with Equal so entries restored from JSON still match.
+func (e snapshotEntry) same(other snapshotEntry) bool {
+	return e.ETag == other.ETag && e.Size == other.Size && e.ModTime.Equal(other.ModTime)
+}
+
+func (e snapshotEntry) meta(rel string) objectstore.FileMeta {
+	return objectstore.FileMeta{Path: rel, Size: e.Size, ETag: e.ETag, LastModified: e.ModTime, IsDir: e.IsDir}
+}
+
+// Save writes the snapshot as JSON so it can be restored after a restart
+// without re-emitting every object as created.
+func (s *Snapshot) Save(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := json.NewEncoder(w).Encode(s.entries); err != nil {
+		return fmt.Errorf("save snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot restores a snapshot written by Save.
+func LoadSnapshot(r io.Reader) (*Snapshot, error) {
+	entries := make(map[string]snapshotEntry)
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("load snapshot: %w", err)
+	}
+	return &Snapshot{entries: entries}, nil
+}
+
+// SnapshotFromCache seeds a snapshot from the warmed metadata cache so the
+// first poll after a warm only reports real changes. The snapshot is empty
+// when no warm has completed yet.
+func (fs *FileSystem) SnapshotFromCache() *Snapshot {
+	snap := NewSnapshot()
+	fs.metaMu.RLock()
+	defer fs.metaMu.RUnlock()
+	if fs.meta == nil {
+		return snap
+	}
+	fs.meta.each(func(meta objectstore.FileMeta) {
+		if meta.Path != "" {
+			snap.entries[meta.Path] = entryFromMeta(meta)
+		}
+	})
+	return snap
+}
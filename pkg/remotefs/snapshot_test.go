@@ -0,0 +1,76 @@
+package remotefs
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+func changeSummary(changes []Change) []string {
+	out := make([]string, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, string(c.Kind)+" "+c.Path)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestSnapshotApplyDiffsOneDirectory(t *testing.T) {
+	snap := NewSnapshot()
+	root := []objectstore.FileMeta{
+		{Path: "docs", IsDir: true},
+		{Path: "readme.txt", Size: 10, ETag: "a"},
+	}
+	if got := changeSummary(snap.Apply("", root)); len(got) != 2 {
+		t.Fatalf("initial apply = %v, want two creations", got)
+	}
+	snap.Apply("docs", []objectstore.FileMeta{{Path: "docs/report.txt", Size: 1, ETag: "r"}})
+
+	if got := snap.Apply("", root); len(got) != 0 {
+		t.Fatalf("unchanged listing reported %v", got)
+	}
+	got := changeSummary(snap.Apply("", []objectstore.FileMeta{
+		{Path: "readme.txt", Size: 12, ETag: "b"},
+		{Path: "new.txt", Size: 1},
+	}))
+	want := []string{"Created new.txt", "Deleted docs", "Deleted docs/report.txt", "Modified readme.txt"}
+	if len(got) != len(want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("changes = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSnapshotPersistsAndSeedsFromCache(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"": {{Path: "readme.txt", Size: 10, ETag: "a", LastModified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		},
+	}
+	fs := &FileSystem{store: store}
+	if err := fs.WarmMetadataCache(context.Background()); err != nil {
+		t.Fatalf("warm: %v", err)
+	}
+	seeded := fs.SnapshotFromCache()
+	var buf bytes.Buffer
+	if err := seeded.Save(&buf); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	restored, err := LoadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if restored.Len() != 1 {
+		t.Fatalf("restored %d entries, want 1", restored.Len())
+	}
+	if got := restored.Apply("", store.listing[""]); len(got) != 0 {
+		t.Fatalf("restored snapshot re-emitted %v", got)
+	}
+}
@@ -0,0 +1,260 @@
+package remotefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// downloadProgress tracks a download into a cache file so readers can follow
+// it. Only the contiguous prefix written so far is exposed; bytes written out
+// of order become visible once the gap is filled or the download ends.
+type downloadProgress struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	file    *os.File
+	path    string
+	written int64
+	done    bool
+	err     error
+	// readers counts the handles following the download. The download is
+	// cancelled when the last one leaves before it finished.
+	readers int
+	cancel  context.CancelFunc
+}
+
+func newDownloadProgress(cancel context.CancelFunc) *downloadProgress {
+	p := &downloadProgress{cancel: cancel}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// wake rouses the readers waiting on p so they can check their contexts.
+func (p *downloadProgress) wake() {
+	p.mu.Lock()
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// attach records the cache file the download writes to.
+func (p *downloadProgress) attach(f *os.File) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.file = f
+	p.path = f.Name()
+}
+
+// WriteAt forwards to the cache file and advances the readable watermark.
+func (p *downloadProgress) WriteAt(b []byte, off int64) (int, error) {
+	n, err := p.file.WriteAt(b, off)
+	p.mu.Lock()
+	if off <= p.written && off+int64(n) > p.written {
+		p.written = off + int64(n)
+	}
+	p.mu.Unlock()
+	p.cond.Broadcast()
+	return n, err
+}
+
+// finish marks the download as complete. size is the final file size and
+// err the download error, if any.
+func (p *downloadProgress) finish(size int64, err error) {
+	p.mu.Lock()
+	p.done = true
+	p.err = err
+	if err == nil {
+		p.written = size
+	}
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// waitReady blocks until the first byte is readable, the download ended, or
+// ctx is done, so a missing object is reported by ReadFile rather than by the
+// first Read.
+func (p *downloadProgress) waitReady(ctx context.Context) (path string, done bool, err error) {
+	stop := context.AfterFunc(ctx, p.wake)
+	defer stop()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.written == 0 && !p.done && ctx.Err() == nil {
+		p.cond.Wait()
+	}
+	if p.written == 0 && !p.done {
+		return "", false, ctx.Err()
+	}
+	return p.path, p.done, p.err
+}
+
+// tailReader reads a cache file while it is being downloaded, blocking until
+// more bytes arrive and returning io.EOF only once the writer has finished.
+// A Read blocked on the download returns when ctx is done or the reader is
+// closed.
+type tailReader struct {
+	ctx      context.Context
+	progress *downloadProgress
+	file     *os.File
+	off      int64
+	closed   bool // guarded by progress.mu
+	stop     func() bool
+	leave    func()
+}
+
+func (t *tailReader) Read(b []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	p := t.progress
+	p.mu.Lock()
+	for t.off >= p.written && !p.done && !t.closed && t.ctx.Err() == nil {
+		p.cond.Wait()
+	}
+	avail, done, err, closed := p.written-t.off, p.done, p.err, t.closed
+	p.mu.Unlock()
+	if closed {
+		return 0, os.ErrClosed
+	}
+	if avail <= 0 {
+		if !done {
+			return 0, t.ctx.Err()
+		}
+		if err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	if int64(len(b)) > avail {
+		b = b[:avail]
+	}
+	n, readErr := t.file.ReadAt(b, t.off)
+	t.off += int64(n)
+	if readErr == io.EOF && n > 0 {
+		readErr = nil
+	}
+	return n, readErr
+}
+
+// Close wakes a blocked Read and leaves the download, cancelling it when no
+// other reader follows it.
+func (t *tailReader) Close() error {
+	p := t.progress
+	p.mu.Lock()
+	if t.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	t.stop()
+	t.leave()
+	return t.file.Close()
+}
+
+// openTailing serves ReadFile for Config.StreamDownloads. Cached objects are
+// opened as usual; otherwise the download is started (or joined, when another
+// reader already started it) and the caller gets a handle that follows it.
+// The download runs detached from ctx, so a reader that goes away does not
+// cut it short for the others; it is cancelled once no reader is left.
+func (fs *FileSystem) openTailing(ctx context.Context, rel string) (*ReadHandle, error) {
+	if _, cached := fs.cache.PathFor(rel); cached {
+		return fs.openCached(ctx, rel)
+	}
+	fs.inflightMu.Lock()
+	progress, ok := fs.inflight[rel]
+	if !ok {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		progress = newDownloadProgress(cancel)
+		if fs.inflight == nil {
+			fs.inflight = make(map[string]*downloadProgress)
+		}
+		fs.inflight[rel] = progress
+		go fs.fetchTailing(fetchCtx, rel, progress)
+	}
+	progress.mu.Lock()
+	progress.readers++
+	progress.mu.Unlock()
+	fs.inflightMu.Unlock()
+	leave := func() { fs.leaveTailing(rel, progress) }
+
+	path, done, err := progress.waitReady(ctx)
+	if err != nil || path == "" || done {
+		leave()
+	}
+	if err != nil {
+		if objectstore.IsNotFound(err) {
+			return nil, fs.notFound(rel, err)
+		}
+		return nil, err
+	}
+	if path == "" || done {
+		// Either the object was cached by someone else before the fetch
+		// started or the download already finished: read the cache copy.
+		return fs.openCached(ctx, rel)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		leave()
+	}
+	if os.IsNotExist(err) {
+		// A staged download was moved into the cache directory between
+		// waitReady and the open.
+		return fs.openCached(ctx, rel)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open cache file: %w", err)
+	}
+	return &ReadHandle{stream: &tailReader{
+		ctx:      ctx,
+		progress: progress,
+		file:     file,
+		stop:     context.AfterFunc(ctx, progress.wake),
+		leave:    leave,
+	}}, nil
+}
+
+// leaveTailing drops a reader of the download of rel. When it was the last
+// one and the download is still running, the download is cancelled and
+// forgotten so the next reader starts afresh.
+func (fs *FileSystem) leaveTailing(rel string, progress *downloadProgress) {
+	fs.inflightMu.Lock()
+	defer fs.inflightMu.Unlock()
+	progress.mu.Lock()
+	progress.readers--
+	idle := progress.readers == 0 && !progress.done
+	progress.mu.Unlock()
+	if idle {
+		progress.cancel()
+		if fs.inflight[rel] == progress {
+			delete(fs.inflight, rel)
+		}
+	}
+}
+
+// fetchTailing downloads rel into the cache, publishing progress for readers.
+func (fs *FileSystem) fetchTailing(ctx context.Context, rel string, progress *downloadProgress) {
+	defer progress.cancel()
+	var (
+		size    int64
+		version objectstore.FileMeta
+	)
+	_, err := fs.cache.LoadOrCreate(rel, func(f *os.File) (int64, error) {
+		progress.attach(f)
+		var err error
+		size, version, err = fs.downloadTo(ctx, rel, f, progress)
+		return size, err
+	})
+	if err == nil {
+		fs.recordVersion(rel, version)
+	}
+	fs.inflightMu.Lock()
+	if fs.inflight[rel] == progress {
+		delete(fs.inflight, rel)
+	}
+	fs.inflightMu.Unlock()
+	progress.finish(size, err)
+}
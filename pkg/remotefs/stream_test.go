@@ -0,0 +1,176 @@
+package remotefs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// slowStore writes its content in chunks, pausing between them, so readers
+// can observe a download in progress.
+type slowStore struct {
+	statTestStore
+	data  []byte
+	chunk int
+	delay time.Duration
+}
+
+func (s *slowStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	if _, ok := s.head[key]; !ok {
+		return objectstore.NotFoundError{Key: key}
+	}
+	for off := 0; off < len(s.data); off += s.chunk {
+		end := off + s.chunk
+		if end > len(s.data) {
+			end = len(s.data)
+		}
+		if _, err := dst.WriteAt(s.data[off:end], int64(off)); err != nil {
+			return err
+		}
+		time.Sleep(s.delay)
+	}
+	return nil
+}
+
+func TestReadFileStreamsDuringDownload(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 50)
+	store := &slowStore{
+		statTestStore: statTestStore{head: map[string]objectstore.FileMeta{
+			"big.bin": {Path: "big.bin", Size: int64(len(payload))},
+		}},
+		data:  payload,
+		chunk: 50,
+		delay: 10 * time.Millisecond,
+	}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20, StreamDownloads: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+
+	start := time.Now()
+	h, err := fs.ReadFile(ctx, "/big.bin")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatalf("ReadFile waited %v, expected it to return after the first chunk", elapsed)
+	}
+	if h.Seekable() {
+		t.Fatalf("streaming handle should not be seekable")
+	}
+	// A second reader joins the same download instead of starting another.
+	h2, err := fs.ReadFile(ctx, "/big.bin")
+	if err != nil {
+		t.Fatalf("second read: %v", err)
+	}
+	for _, handle := range []*ReadHandle{h, h2} {
+		got, err := io.ReadAll(handle)
+		handle.Close()
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("streamed %d bytes, want %d matching bytes", len(got), len(payload))
+		}
+	}
+
+	// Once complete, the object is served from the cache.
+	h, err = fs.ReadFile(ctx, "/big.bin")
+	if err != nil {
+		t.Fatalf("cached read: %v", err)
+	}
+	defer h.Close()
+	if !h.Seekable() {
+		t.Fatalf("cached handle should be seekable")
+	}
+
+	if _, err := fs.ReadFile(ctx, "/missing.bin"); !IsNotFound(err) {
+		t.Fatalf("expected NotFoundError, got %v", err)
+	}
+}
+
+// cancelableSlowStore is slowStore that stops when ctx is done.
+type cancelableSlowStore struct {
+	slowStore
+}
+
+func (s *cancelableSlowStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	return s.slowStore.Download(ctx, key, writerAtFunc(func(p []byte, off int64) (int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return dst.WriteAt(p, off)
+	}))
+}
+
+type writerAtFunc func(p []byte, off int64) (int, error)
+
+func (f writerAtFunc) WriteAt(p []byte, off int64) (int, error) { return f(p, off) }
+
+func TestReadFileStreamOutlivesFirstReader(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 50)
+	store := &cancelableSlowStore{slowStore{
+		statTestStore: statTestStore{head: map[string]objectstore.FileMeta{
+			"big.bin": {Path: "big.bin", Size: int64(len(payload))},
+		}},
+		data:  payload,
+		chunk: 50,
+		delay: 10 * time.Millisecond,
+	}}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20, StreamDownloads: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	first, cancel := context.WithCancel(context.Background())
+	h, err := fs.ReadFile(first, "/big.bin")
+	if err != nil {
+		t.Fatalf("first read: %v", err)
+	}
+	h2, err := fs.ReadFile(context.Background(), "/big.bin")
+	if err != nil {
+		t.Fatalf("second read: %v", err)
+	}
+	defer h2.Close()
+
+	// The first client goes away: its blocked Read returns, and so does a
+	// Read blocked when the handle is closed.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := io.ReadAll(h); !errors.Is(err, context.Canceled) {
+		t.Fatalf("first reader err = %v, want context.Canceled", err)
+	}
+	h.Close()
+
+	got, err := io.ReadAll(h2)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("second reader got %d bytes, %v; want the whole object", len(got), err)
+	}
+
+	h3, err := fs.ReadFileFresh(context.Background(), "/big.bin")
+	if err != nil {
+		t.Fatalf("fresh read: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(h3)
+		done <- err
+	}()
+	time.Sleep(15 * time.Millisecond)
+	h3.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("read of a closed handle succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Close did not wake a blocked Read")
+	}
+}
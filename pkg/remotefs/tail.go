@@ -0,0 +1,91 @@
+package remotefs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// tailChunkSize is how much Tail reads per step while walking backwards.
+const tailChunkSize = 64 * 1024
+
+// Tail returns the last lines newline-delimited lines of local, like tail -n.
+// The object is range-read backwards in chunks until enough lines are found,
+// so only the end of a large log is transferred. Lines longer than a chunk
+// simply take more steps; objects with fewer lines are returned whole.
+func (fs *FileSystem) Tail(ctx context.Context, local string, lines int) (io.ReadCloser, error) {
+	if lines < 0 {
+		return nil, fmt.Errorf("negative line count %d", lines)
+	}
+	size, err := fs.Size(ctx, local)
+	if err != nil {
+		return nil, err
+	}
+	if lines == 0 || size == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	// Lines are collected last first, each as soon as the newline before it
+	// is seen, so every chunk is scanned once and nothing is re-copied.
+	// pending holds the pieces, also last first, of the line whose start
+	// has not been read yet.
+	var found, pending [][]byte
+	for end := size; end > 0 && len(found) < lines; {
+		start := end - tailChunkSize
+		if start < 0 {
+			start = 0
+		}
+		chunk, err := fs.readChunk(ctx, local, start, end-start)
+		if err != nil {
+			return nil, err
+		}
+		cut := len(chunk)
+		for i := len(chunk) - 1; i >= 0 && len(found) < lines; i-- {
+			// A trailing newline terminates the last line rather than
+			// starting an empty one.
+			if chunk[i] != '\n' || (end == size && i == len(chunk)-1) {
+				continue
+			}
+			found = append(found, joinReversed(append(pending, chunk[i+1:cut])))
+			pending = pending[:0]
+			cut = i + 1
+		}
+		if len(found) < lines {
+			pending = append(pending, chunk[:cut])
+		}
+		end = start
+	}
+	if len(found) < lines && len(pending) > 0 {
+		// The first line of the object has no newline before it.
+		found = append(found, joinReversed(pending))
+	}
+	return io.NopCloser(bytes.NewReader(joinReversed(found))), nil
+}
+
+// joinReversed concatenates pieces in reverse order.
+func joinReversed(pieces [][]byte) []byte {
+	n := 0
+	for _, p := range pieces {
+		n += len(p)
+	}
+	out := make([]byte, 0, n)
+	for i := len(pieces) - 1; i >= 0; i-- {
+		out = append(out, pieces[i]...)
+	}
+	return out
+}
+
+// readChunk reads exactly n bytes of local at off.
+func (fs *FileSystem) readChunk(ctx context.Context, local string, off, n int64) ([]byte, error) {
+	body, err := fs.ReadFileRange(ctx, local, off, n)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		return nil, fmt.Errorf("read %s at %d: %w", local, off, err)
+	}
+	return buf, nil
+}
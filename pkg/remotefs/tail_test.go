@@ -0,0 +1,71 @@
+package remotefs
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+func TestTail(t *testing.T) {
+	long := strings.Repeat("x", tailChunkSize+10)
+	ctx := context.Background()
+	tail := func(content string, lines int) (string, int) {
+		t.Helper()
+		store := &rangeStore{contentStore: contentStore{
+			statTestStore: statTestStore{head: map[string]objectstore.FileMeta{
+				"app.log": {Path: "app.log", Size: int64(len(content))},
+			}},
+			data: []byte(content),
+		}}
+		fs, err := New(store, Config{NoCache: true})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		r, err := fs.Tail(ctx, "/app.log", lines)
+		if err != nil {
+			t.Fatalf("tail: %v", err)
+		}
+		defer r.Close()
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read tail: %v", err)
+		}
+		return string(got), len(store.ranges)
+	}
+
+	if got, _ := tail("a\nb\nc\n", 2); got != "b\nc\n" {
+		t.Fatalf("tail 2 = %q", got)
+	}
+	if got, _ := tail("a\nb\nc", 1); got != "c" {
+		t.Fatalf("tail without trailing newline = %q", got)
+	}
+	if got, _ := tail("a\nb\n", 5); got != "a\nb\n" {
+		t.Fatalf("short object = %q", got)
+	}
+	if got, _ := tail("a\nb\n", 0); got != "" {
+		t.Fatalf("zero lines = %q", got)
+	}
+	if got, _ := tail("\n\na\n\n", 3); got != "\na\n\n" {
+		t.Fatalf("empty lines = %q", got)
+	}
+	var many strings.Builder
+	for i := 0; i < 30000; i++ {
+		many.WriteString(strings.Repeat("y", i%13) + "\n")
+	}
+	all := strings.SplitAfter(many.String(), "\n")
+	want := strings.Join(all[len(all)-1-20000:], "")
+	if got, _ := tail(many.String(), 20000); got != want {
+		t.Fatalf("tail across chunks has %d bytes, want %d", len(got), len(want))
+	}
+	content := strings.Repeat("old\n", tailChunkSize) + long + "\nlast\n"
+	got, reads := tail(content, 2)
+	if got != long+"\nlast\n" {
+		t.Fatalf("long line tail has %d bytes", len(got))
+	}
+	if reads != 2 {
+		t.Fatalf("expected 2 range reads, got %d", reads)
+	}
+}
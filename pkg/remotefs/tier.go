@@ -0,0 +1,114 @@
+package remotefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// fetchSecondary fills dst from Config.SecondaryStore when it holds a current
+// copy of rel, checked against the origin metadata, which it also returns.
+// It reports false when the origin has to be read instead; dst may then hold
+// part of the copy, which the origin download overwrites.
+func (fs *FileSystem) fetchSecondary(ctx context.Context, rel string, dst io.WriterAt) (*extentWriter, objectstore.FileMeta, bool) {
+	tier := fs.cfg.SecondaryStore
+	if tier == nil {
+		return nil, objectstore.FileMeta{}, false
+	}
+	meta := fs.knownMeta(ctx, rel)
+	if meta.Size < 0 {
+		// Without origin metadata there is nothing to check the copy
+		// against, and the object may be gone.
+		return nil, objectstore.FileMeta{}, false
+	}
+	copyMeta, err := tier.Head(ctx, rel)
+	if err != nil || copyMeta.IsDir || copyMeta.Size != meta.Size || copyMeta.LastModified.Before(meta.LastModified) {
+		return nil, objectstore.FileMeta{}, false
+	}
+	extent := &extentWriter{w: dst}
+	if err := tier.Download(ctx, rel, extent); err != nil || extent.end != meta.Size {
+		return nil, objectstore.FileMeta{}, false
+	}
+	return extent, meta, true
+}
+
+// secondaryQueueSize bounds the copies to Config.SecondaryStore waiting for
+// upload. Misses beyond it are not copied.
+const secondaryQueueSize = 64
+
+// secondaryCopy is a queued upload of a cache file to Config.SecondaryStore.
+// file is a descriptor of its own, so the copy survives eviction of the
+// cache entry.
+type secondaryCopy struct {
+	rel  string
+	file *os.File
+	size int64
+}
+
+// startSecondaryCopies starts the goroutine uploading queued copies.
+func (fs *FileSystem) startSecondaryCopies() {
+	fs.tierQueue = make(chan secondaryCopy, secondaryQueueSize)
+	fs.tierDone = make(chan struct{})
+	go func() {
+		defer close(fs.tierDone)
+		for job := range fs.tierQueue {
+			fs.uploadSecondary(job)
+			job.file.Close()
+		}
+	}()
+}
+
+// stopSecondaryCopies stops accepting copies and waits for the queued ones.
+func (fs *FileSystem) stopSecondaryCopies() {
+	if fs.tierQueue == nil {
+		return
+	}
+	fs.tierMu.Lock()
+	if !fs.tierClosed {
+		fs.tierClosed = true
+		close(fs.tierQueue)
+	}
+	fs.tierMu.Unlock()
+	<-fs.tierDone
+}
+
+// copySecondary queues the size bytes of the freshly downloaded cache file f
+// for upload to Config.SecondaryStore under rel. The upload runs in the
+// background, detached from the read that caused the miss; when the queue is
+// full the copy is skipped.
+func (fs *FileSystem) copySecondary(rel string, f *os.File, size int64) {
+	if fs.tierQueue == nil {
+		return
+	}
+	file, err := os.Open(f.Name())
+	if err != nil {
+		fs.emit(Event{Type: EventError, Path: rel, Error: fmt.Sprintf("copy to secondary store: %v", err)})
+		return
+	}
+	fs.tierMu.RLock()
+	defer fs.tierMu.RUnlock()
+	if !fs.tierClosed {
+		select {
+		case fs.tierQueue <- secondaryCopy{rel: rel, file: file, size: size}:
+			return
+		default:
+			fs.emit(Event{Type: EventError, Path: rel, Error: "copy to secondary store: queue full"})
+		}
+	}
+	file.Close()
+}
+
+// uploadSecondary uploads one queued copy.
+func (fs *FileSystem) uploadSecondary(job secondaryCopy) {
+	err := objectstore.UploadWith(context.Background(), fs.cfg.SecondaryStore, job.rel, io.NewSectionReader(job.file, 0, job.size), job.size, objectstore.UploadOptions{
+		ContentType: mime.TypeByExtension(path.Ext(job.rel)),
+	})
+	if err != nil {
+		fs.emit(Event{Type: EventError, Path: job.rel, Error: fmt.Sprintf("copy to secondary store: %v", err)})
+	}
+}
@@ -0,0 +1,113 @@
+package remotefs
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// tierStore is a filesStore that accepts uploads and stamps them with the
+// upload time.
+type tierStore struct {
+	*filesStore
+}
+
+func (s *tierStore) Upload(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.data[key] = string(data)
+	s.head[key] = objectstore.FileMeta{Path: key, Size: int64(len(data)), LastModified: time.Now()}
+	return nil
+}
+
+func TestSecondaryStoreTier(t *testing.T) {
+	origin := newFilesStore(map[string]string{"a.txt": "origin"})
+	origin.head["a.txt"] = objectstore.FileMeta{Path: "a.txt", Size: 6, LastModified: time.Now().Add(-time.Hour)}
+	tier := &tierStore{newFilesStore(map[string]string{})}
+	read := func() string {
+		t.Helper()
+		// A fresh cache directory forces every read to miss.
+		fs, err := New(origin, Config{CacheDir: t.TempDir(), SecondaryStore: tier})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		defer fs.Close()
+		h, err := fs.ReadFile(context.Background(), "/a.txt")
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		defer h.Close()
+		data, err := io.ReadAll(h)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return string(data)
+	}
+
+	if got := read(); got != "origin" {
+		t.Fatalf("first read = %q", got)
+	}
+	if tier.data["a.txt"] != "origin" {
+		t.Fatalf("miss did not copy to the tier: %q", tier.data["a.txt"])
+	}
+
+	// The copy is as new as the origin object, so it is served from the tier.
+	origin.data["a.txt"] = "ORIGIN"
+	if got := read(); got != "origin" {
+		t.Fatalf("read with current copy = %q, want the tier's content", got)
+	}
+
+	// A newer origin object makes the copy stale: read the origin and
+	// refresh the tier.
+	origin.head["a.txt"] = objectstore.FileMeta{Path: "a.txt", Size: 6, LastModified: time.Now().Add(time.Hour)}
+	if got := read(); got != "ORIGIN" {
+		t.Fatalf("read with stale copy = %q", got)
+	}
+	if tier.data["a.txt"] != "ORIGIN" {
+		t.Fatalf("tier not refreshed: %q", tier.data["a.txt"])
+	}
+}
+
+// blockingTierStore holds every upload until release is closed.
+type blockingTierStore struct {
+	*tierStore
+	release chan struct{}
+}
+
+func (s *blockingTierStore) Upload(ctx context.Context, key string, r io.Reader, size int64) error {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.tierStore.Upload(ctx, key, r, size)
+}
+
+func TestSecondaryCopyDoesNotBlockReads(t *testing.T) {
+	origin := newFilesStore(map[string]string{"a.txt": "origin"})
+	tier := &blockingTierStore{tierStore: &tierStore{newFilesStore(map[string]string{})}, release: make(chan struct{})}
+	fs, err := New(origin, Config{CacheDir: t.TempDir(), SecondaryStore: tier})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h, err := fs.ReadFile(ctx, "/a.txt")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	h.Close()
+	// The copy outlives the request that caused the miss.
+	cancel()
+	close(tier.release)
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if tier.data["a.txt"] != "origin" {
+		t.Fatalf("queued copy was not uploaded: %q", tier.data["a.txt"])
+	}
+}
@@ -0,0 +1,107 @@
+package remotefs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTokenTTL is the lifetime of a /token link when none is asked
+	// for.
+	defaultTokenTTL = 5 * time.Minute
+	// maxTokenTTL caps the lifetime /token grants.
+	maxTokenTTL = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidToken is returned for a download token that is malformed or
+	// was not signed with the server's key. It wraps ErrPermissionDenied, so
+	// the IPC server answers 403.
+	ErrInvalidToken = fmt.Errorf("%w: invalid download token", ErrPermissionDenied)
+	// ErrExpiredToken is returned for a correctly signed download token past
+	// its expiry. It wraps ErrPermissionDenied as well.
+	ErrExpiredToken = fmt.Errorf("%w: download token expired", ErrPermissionDenied)
+)
+
+// archiveTokenScope marks tokens minted for /archive. Download tokens carry
+// no scope, so neither kind is accepted in place of the other.
+const archiveTokenScope = "archive"
+
+// SignDownloadToken returns a token granting read access to local until
+// expires. The token carries the path and expiry in the clear, followed by
+// an HMAC-SHA256 of both under key, and is safe to put in a URL.
+func SignDownloadToken(key []byte, local string, expires time.Time) string {
+	return signToken(key, "", local, expires)
+}
+
+// SignArchiveToken returns a token granting /archive access to the subtree
+// of prefix until expires. It is not valid for /cat, and download tokens are
+// not valid for /archive.
+func SignArchiveToken(key []byte, prefix string, expires time.Time) string {
+	return signToken(key, archiveTokenScope, prefix, expires)
+}
+
+// VerifyDownloadToken checks the signature and expiry of token and returns
+// the local path it grants access to.
+func VerifyDownloadToken(key []byte, token string, now time.Time) (string, error) {
+	return verifyToken(key, token, "", now)
+}
+
+// VerifyArchiveToken is VerifyDownloadToken for tokens signed with
+// SignArchiveToken. It returns the prefix the token grants access to.
+func VerifyArchiveToken(key []byte, token string, now time.Time) (string, error) {
+	return verifyToken(key, token, archiveTokenScope, now)
+}
+
+// signToken signs the payload "expiry[,scope]:local".
+func signToken(key []byte, scope, local string, expires time.Time) string {
+	head := strconv.FormatInt(expires.Unix(), 10)
+	if scope != "" {
+		head += "," + scope
+	}
+	payload := head + ":" + local
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(tokenMAC(key, payload))
+}
+
+func verifyToken(key []byte, token, scope string, now time.Time) (string, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, tokenMAC(key, string(payload))) {
+		return "", ErrInvalidToken
+	}
+	head, local, ok := strings.Cut(string(payload), ":")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	expiry, tokenScope, _ := strings.Cut(head, ",")
+	if tokenScope != scope {
+		return "", ErrInvalidToken
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if !now.Before(time.Unix(unix, 0)) {
+		return "", ErrExpiredToken
+	}
+	return local, nil
+}
+
+func tokenMAC(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
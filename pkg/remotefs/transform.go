@@ -0,0 +1,204 @@
+package remotefs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// Transform wraps a file's content stream, for example to decompress,
+// decrypt, or filter it. Errors from the returned reader surface from the
+// read of the handle.
+type Transform func(r io.Reader) (io.Reader, error)
+
+// TransformRule applies the named transforms from Config.Transforms, in
+// order, to files whose store-relative path matches Pattern, in path.Match
+// syntax. A "/" in a path is only matched by a "/" in the pattern.
+type TransformRule struct {
+	Pattern    string
+	Transforms []string
+}
+
+// ErrUnknownTransform is returned for transform names missing from
+// Config.Transforms.
+var ErrUnknownTransform = errors.New("unknown transform")
+
+// GunzipTransform decompresses gzip content.
+func GunzipTransform(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// GrepTransform keeps the lines that match re, like grep(1). Kept lines
+// retain their newline; a final line without one is passed on as is.
+func GrepTransform(re *regexp.Regexp) Transform {
+	return func(r io.Reader) (io.Reader, error) {
+		return &grepReader{src: bufio.NewReader(r), re: re}, nil
+	}
+}
+
+// grepReader yields the matching lines of src.
+type grepReader struct {
+	src  *bufio.Reader
+	re   *regexp.Regexp
+	line []byte // unread rest of the current matching line
+	err  error
+}
+
+func (g *grepReader) Read(p []byte) (int, error) {
+	for len(g.line) == 0 {
+		if g.err != nil {
+			return 0, g.err
+		}
+		line, err := g.src.ReadBytes('\n')
+		g.err = err
+		if len(line) > 0 && g.re.Match(line) {
+			g.line = line
+		}
+	}
+	n := copy(p, g.line)
+	g.line = g.line[n:]
+	return n, nil
+}
+
+// validateTransforms checks that every rule has a valid pattern and names
+// registered transforms.
+func validateTransforms(cfg Config) error {
+	for _, rule := range cfg.TransformRules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("transform rule %q: %w", rule.Pattern, err)
+		}
+		if _, err := cfg.transformChain(rule.Transforms); err != nil {
+			return fmt.Errorf("transform rule %q: %w", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// transformChain resolves names against Config.Transforms.
+func (cfg Config) transformChain(names []string) ([]Transform, error) {
+	chain := make([]Transform, 0, len(names))
+	for _, name := range names {
+		t, ok := cfg.Transforms[name]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownTransform, name)
+		}
+		chain = append(chain, t)
+	}
+	return chain, nil
+}
+
+// ruleTransforms returns the chain of the first rule matching rel.
+func (fs *FileSystem) ruleTransforms(rel string) []Transform {
+	for _, rule := range fs.cfg.TransformRules {
+		if ok, _ := path.Match(rule.Pattern, rel); ok {
+			chain, _ := fs.cfg.transformChain(rule.Transforms)
+			return chain
+		}
+	}
+	return nil
+}
+
+// cachedTransforms returns the rule chain of rel when the cache holds its
+// transformed content.
+func (fs *FileSystem) cachedTransforms(rel string) []Transform {
+	if fs.cache == nil || !fs.cfg.CacheTransformed {
+		return nil
+	}
+	return fs.ruleTransforms(rel)
+}
+
+// readTransforms returns the rule chain ReadFile applies to rel on top of
+// what it opened.
+func (fs *FileSystem) readTransforms(rel string) []Transform {
+	if fs.cache != nil && fs.cfg.CacheTransformed {
+		return nil
+	}
+	return fs.ruleTransforms(rel)
+}
+
+// ReadFileTransformed is ReadFile followed by the named transforms from
+// Config.Transforms, applied in order after any TransformRules. Their output
+// is never cached.
+func (fs *FileSystem) ReadFileTransformed(ctx context.Context, local string, names ...string) (*ReadHandle, error) {
+	chain, err := fs.cfg.transformChain(names)
+	if err != nil {
+		return nil, err
+	}
+	h, err := fs.ReadFile(ctx, local)
+	if err != nil {
+		return nil, err
+	}
+	return applyTransforms(h, chain)
+}
+
+// applyTransforms wraps h in chain. The result is a stream that closes h,
+// which is closed right away if a transform fails.
+func applyTransforms(h *ReadHandle, chain []Transform) (*ReadHandle, error) {
+	if len(chain) == 0 {
+		return h, nil
+	}
+	var r io.Reader = h
+	for _, t := range chain {
+		var err error
+		if r, err = t(r); err != nil {
+			h.Close()
+			return nil, fmt.Errorf("transform: %w", err)
+		}
+	}
+	return &ReadHandle{stream: &limitedReadCloser{Reader: r, Closer: h}}, nil
+}
+
+// fetchTransformed fills the cache file f with the transformed content of
+// rel and returns its size along with the source it was derived from: the
+// size and ETag of the remote object.
+func (fs *FileSystem) fetchTransformed(ctx context.Context, rel string, f *os.File, chain []Transform) (int64, objectstore.FileMeta, error) {
+	source := fs.knownMeta(ctx, rel)
+	h, err := fs.openStream(ctx, rel)
+	var nf NotFoundError
+	if errors.As(err, &nf) {
+		return 0, source, err
+	}
+	var size int64
+	if err == nil {
+		raw := &countingReader{r: h}
+		var r io.Reader = raw
+		for _, t := range chain {
+			if r, err = t(r); err != nil {
+				err = fmt.Errorf("transform: %w", err)
+				break
+			}
+		}
+		if err == nil {
+			size, err = io.Copy(f, r)
+		}
+		if err == nil {
+			// Transforms may stop before the end of the object; read the
+			// rest so the source size is the size of what was fetched.
+			_, err = io.Copy(io.Discard, raw)
+		}
+		h.Close()
+		source.Size = raw.n
+	}
+	fs.emitDownload(ctx, rel, size, err)
+	return size, source, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,92 @@
+package remotefs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.String()
+}
+
+func TestTransformRules(t *testing.T) {
+	const log = "ok\nERROR disk\nok\nERROR net"
+	transforms := map[string]Transform{
+		"gunzip": GunzipTransform,
+		"errors": GrepTransform(regexp.MustCompile("^ERROR")),
+	}
+	rules := []TransformRule{{Pattern: "logs/*.gz", Transforms: []string{"gunzip", "errors"}}}
+	for _, cached := range []bool{false, true} {
+		store := newFilesStore(map[string]string{"logs/app.gz": gzipped(t, log), "logs/app.txt": log})
+		fs, err := New(store, Config{
+			CacheDir:         t.TempDir(),
+			CacheSize:        1 << 20,
+			Transforms:       transforms,
+			TransformRules:   rules,
+			CacheTransformed: cached,
+		})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		ctx := context.Background()
+		read := func(h *ReadHandle, err error) (string, bool) {
+			t.Helper()
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			defer h.Close()
+			data, err := io.ReadAll(h)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			return string(data), h.Seekable()
+		}
+		for i := 0; i < 2; i++ {
+			got, seekable := read(fs.ReadFile(ctx, "/logs/app.gz"))
+			if got != "ERROR disk\nERROR net" || seekable != cached {
+				t.Fatalf("cached=%v read %d = %q, seekable %v", cached, i, got, seekable)
+			}
+		}
+		if entry, _ := fs.cache.Lookup("logs/app.gz"); cached != (entry.Size == int64(len("ERROR disk\nERROR net"))) {
+			t.Fatalf("cached=%v cache entry holds %d bytes", cached, entry.Size)
+		}
+		if got, _ := read(fs.ReadFile(ctx, "/logs/app.txt")); got != log {
+			t.Fatalf("unmatched path read %q", got)
+		}
+		if got, _ := read(fs.ReadFileTransformed(ctx, "/logs/app.txt", "errors")); got != "ERROR disk\nERROR net" {
+			t.Fatalf("per-request transform read %q", got)
+		}
+		if _, err := fs.ReadFileTransformed(ctx, "/logs/app.txt", "rot13"); !errors.Is(err, ErrUnknownTransform) {
+			t.Fatalf("unknown transform err = %v", err)
+		}
+		// Transformed entries are checked against the object they came
+		// from, so none of them is stale.
+		report, err := fs.VerifyCache(ctx, true)
+		if err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+		if report.Matched != 2 || len(report.Stale) != 0 || report.Pruned != 0 {
+			t.Fatalf("cached=%v verify = %+v", cached, report)
+		}
+		fs.Close()
+	}
+
+	bad := []TransformRule{{Pattern: "*", Transforms: []string{"rot13"}}}
+	if _, err := New(newFilesStore(nil), Config{NoCache: true, TransformRules: bad}); !errors.Is(err, ErrUnknownTransform) {
+		t.Fatalf("new with unknown transform err = %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package remotefs
+
+import (
+	"context"
+	"path"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// TreeNode is one entry of a directory tree returned by Tree.
+type TreeNode struct {
+	objectstore.FileMeta
+	// Children holds the entries of a directory that was expanded. It is nil
+	// for files and for directories below the requested depth.
+	Children []*TreeNode
+}
+
+// Tree lists local like ReadDirRecursive and shapes the entries as nested
+// nodes. The root node describes local itself; depth counts the levels below
+// its children that are expanded, so 0 returns only the direct children and a
+// negative depth expands the whole subtree.
+func (fs *FileSystem) Tree(ctx context.Context, local string, depth int) (*TreeNode, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return nil, err
+	}
+	res, err := fs.readDirRecursive(ctx, local, depth, 0)
+	if err != nil {
+		return nil, err
+	}
+	root := &TreeNode{FileMeta: objectstore.FileMeta{Path: rel, IsDir: true}, Children: []*TreeNode{}}
+	dirs := map[string]*TreeNode{rel: root}
+	// readDirRecursive walks breadth-first, so every parent is placed
+	// before its children.
+	for _, item := range res.Entries {
+		node := &TreeNode{FileMeta: item}
+		parent := path.Dir(item.Path)
+		if parent == "." {
+			parent = ""
+		}
+		if p, ok := dirs[parent]; ok {
+			if p.Children == nil {
+				p.Children = []*TreeNode{}
+			}
+			p.Children = append(p.Children, node)
+		}
+		if item.IsDir {
+			dirs[item.Path] = node
+		}
+	}
+	return root, nil
+}
@@ -0,0 +1,162 @@
+package remotefs
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"strings"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// defaultUsageTop is the number of largest objects Usage reports.
+const defaultUsageTop = 10
+
+// DirUsage totals the objects below one top-level directory.
+type DirUsage struct {
+	Path    string `json:"Path"`
+	Objects int64  `json:"Objects"`
+	Bytes   int64  `json:"Bytes"`
+}
+
+// UsageReport summarizes the objects below a directory.
+type UsageReport struct {
+	Path    string `json:"Path"`
+	Objects int64  `json:"Objects"`
+	Bytes   int64  `json:"Bytes"`
+	// Dirs breaks the totals down by the directories directly below Path,
+	// largest first. Files directly in Path count only towards the totals.
+	Dirs []DirUsage `json:"Dirs"`
+	// Largest lists the biggest objects, largest first.
+	Largest []objectstore.FileMeta `json:"Largest"`
+	// Truncated reports that Config.MaxDirEntries cut a listing short, so
+	// the figures are a lower bound.
+	Truncated bool `json:"Truncated"`
+	// SkippedMarkers counts the directory marker objects left out of the
+	// figures with Config.UsageSkipDirMarkers.
+	SkippedMarkers int64 `json:"SkippedMarkers,omitempty"`
+}
+
+// Usage walks the subtree of local and reports its object count, total size,
+// a per-directory breakdown, and the defaultUsageTop largest objects. The
+// subtree is read with one flat listing, which on S3 costs a request per
+// thousand objects rather than one per directory.
+func (fs *FileSystem) Usage(ctx context.Context, local string) (UsageReport, error) {
+	return fs.UsageTop(ctx, local, defaultUsageTop)
+}
+
+// UsageTop is Usage reporting the top largest objects; zero skips them.
+func (fs *FileSystem) UsageTop(ctx context.Context, local string, top int) (UsageReport, error) {
+	rel, err := fs.sanitize(local)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	res, err := fs.readDirFlat(ctx, local, 0)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	report := UsageReport{
+		Path:      rel,
+		Dirs:      []DirUsage{},
+		Largest:   []objectstore.FileMeta{},
+		Truncated: res.Truncated,
+	}
+	prefix := ""
+	if rel != "" {
+		prefix = rel + "/"
+	}
+	dirs := make(map[string]*DirUsage)
+	largest := &largestHeap{}
+	for seq, item := range res.Entries {
+		if fs.cfg.UsageSkipDirMarkers && isDirMarker(item) {
+			report.SkippedMarkers++
+			continue
+		}
+		report.Objects++
+		report.Bytes += item.Size
+		if first, _, ok := strings.Cut(strings.TrimPrefix(item.Path, prefix), "/"); ok {
+			dir := dirs[first]
+			if dir == nil {
+				dir = &DirUsage{Path: prefix + first}
+				dirs[first] = dir
+			}
+			dir.Objects++
+			dir.Bytes += item.Size
+		}
+		if top > 0 {
+			largest.offer(rankedMeta{FileMeta: item, seq: seq}, top)
+		}
+	}
+	for _, dir := range dirs {
+		report.Dirs = append(report.Dirs, *dir)
+	}
+	sort.Slice(report.Dirs, func(i, j int) bool {
+		a, b := report.Dirs[i], report.Dirs[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Path < b.Path
+	})
+	report.Largest = largest.drain()
+	return report, nil
+}
+
+// rankedMeta is an object competing for UsageReport.Largest. seq is its
+// position in the listing, which breaks ties between equal sizes.
+type rankedMeta struct {
+	objectstore.FileMeta
+	seq int
+}
+
+// outranks reports whether m belongs before other in UsageReport.Largest.
+func (m rankedMeta) outranks(other rankedMeta) bool {
+	if m.Size != other.Size {
+		return m.Size > other.Size
+	}
+	return m.seq < other.seq
+}
+
+// largestHeap keeps the biggest objects seen so far as a min-heap, so the
+// smallest of them is the one a bigger object replaces. Of two objects with
+// the same size the one listed first ranks higher.
+type largestHeap []rankedMeta
+
+func (h largestHeap) Len() int           { return len(h) }
+func (h largestHeap) Less(i, j int) bool { return h[j].outranks(h[i]) }
+func (h largestHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *largestHeap) Push(x any)        { *h = append(*h, x.(rankedMeta)) }
+func (h *largestHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// offer adds item when fewer than top objects are kept or it outranks the
+// smallest of them, so memory stays bounded by top.
+func (h *largestHeap) offer(item rankedMeta, top int) {
+	if h.Len() < top {
+		heap.Push(h, item)
+		return
+	}
+	if item.outranks((*h)[0]) {
+		(*h)[0] = item
+		heap.Fix(h, 0)
+	}
+}
+
+// drain empties h and returns its objects largest first.
+func (h *largestHeap) drain() []objectstore.FileMeta {
+	out := make([]objectstore.FileMeta, h.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(h).(rankedMeta).FileMeta
+	}
+	return out
+}
+
+// isDirMarker reports whether item is a zero-byte placeholder whose key ends
+// in "/", as created by consoles and tools to represent a directory. Only flat
+// listings return them; delimited ones fold them into their directory.
+func isDirMarker(item objectstore.FileMeta) bool {
+	return item.Size == 0 && strings.HasSuffix(item.Path, "/")
+}
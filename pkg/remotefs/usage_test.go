@@ -0,0 +1,112 @@
+package remotefs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+func TestUsage(t *testing.T) {
+	store := &statTestStore{
+		listing: map[string][]objectstore.FileMeta{
+			"d":          {{Path: "d/logs", IsDir: true}, {Path: "d/img", IsDir: true}, {Path: "d/readme.txt", Size: 5}},
+			"d/logs":     {{Path: "d/logs/a.log", Size: 100}, {Path: "d/logs/old", IsDir: true}},
+			"d/logs/old": {{Path: "d/logs/old/b.log", Size: 300}},
+			"d/img":      {{Path: "d/img/c.png", Size: 200}, {Path: "d/img/d.png", Size: 200}},
+		},
+	}
+	fs := &FileSystem{store: store}
+	report, err := fs.UsageTop(context.Background(), filepath.Join(string(filepath.Separator), "d"), 2)
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	if report.Path != "d" || report.Objects != 5 || report.Bytes != 805 || report.Truncated {
+		t.Fatalf("totals = %+v", report)
+	}
+	want := []DirUsage{{Path: "d/img", Objects: 2, Bytes: 400}, {Path: "d/logs", Objects: 2, Bytes: 400}}
+	if len(report.Dirs) != len(want) || report.Dirs[0] != want[0] || report.Dirs[1] != want[1] {
+		t.Fatalf("dirs = %+v, want %+v", report.Dirs, want)
+	}
+	if len(report.Largest) != 2 || report.Largest[0].Path != "d/logs/old/b.log" || report.Largest[1].Size != 200 {
+		t.Fatalf("largest = %+v", report.Largest)
+	}
+
+	report, err = fs.UsageTop(context.Background(), filepath.Join(string(filepath.Separator), "d"), 0)
+	if err != nil || len(report.Largest) != 0 {
+		t.Fatalf("top 0 = %+v, %v", report.Largest, err)
+	}
+}
+
+func TestUsageSkipsDirMarkers(t *testing.T) {
+	// As on S3, directory markers only appear in the flat listing; the
+	// delimited one folds them into their directories.
+	store := &flatStore{
+		statTestStore: statTestStore{
+			listing: map[string][]objectstore.FileMeta{
+				"m":      {{Path: "m/logs", IsDir: true}, {Path: "m/empty.txt"}, {Path: "m/odd", IsDir: true}},
+				"m/logs": {{Path: "m/logs/a.log", Size: 10}, {Path: "m/logs/old", IsDir: true}},
+			},
+		},
+		keys: []objectstore.FileMeta{
+			{Path: "m/empty.txt", Size: 0},
+			{Path: "m/logs/", Size: 0},
+			{Path: "m/logs/a.log", Size: 10},
+			{Path: "m/logs/old/", Size: 0},
+			{Path: "m/odd/", Size: 3},
+		},
+	}
+	local := filepath.Join(string(filepath.Separator), "m")
+	fs := &FileSystem{store: store}
+	report, err := fs.Usage(context.Background(), local)
+	if err != nil || report.Objects != 5 || report.SkippedMarkers != 0 {
+		t.Fatalf("default usage = %+v, %v", report, err)
+	}
+
+	fs.cfg.UsageSkipDirMarkers = true
+	report, err = fs.Usage(context.Background(), local)
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	// Empty real files and non-empty keys ending in "/" still count.
+	if report.Objects != 3 || report.Bytes != 13 || report.SkippedMarkers != 2 {
+		t.Fatalf("totals = %+v", report)
+	}
+	want := []DirUsage{{Path: "m/logs", Objects: 1, Bytes: 10}, {Path: "m/odd", Objects: 1, Bytes: 3}}
+	if len(report.Dirs) != len(want) || report.Dirs[0] != want[0] || report.Dirs[1] != want[1] {
+		t.Fatalf("dirs = %+v, want %+v", report.Dirs, want)
+	}
+}
+
+func TestUsageTopMatchesFullSort(t *testing.T) {
+	var keys []objectstore.FileMeta
+	for i := 0; i < 500; i++ {
+		// Sizes repeat, so ties must keep listing order as a stable sort does.
+		keys = append(keys, objectstore.FileMeta{Path: fmt.Sprintf("t/%03d", i), Size: int64(i * 7919 % 97)})
+	}
+	store := &flatStore{
+		statTestStore: statTestStore{listing: map[string][]objectstore.FileMeta{"t": keys}},
+		keys:          keys,
+	}
+	fs := &FileSystem{store: store}
+	want := append([]objectstore.FileMeta(nil), keys...)
+	sort.SliceStable(want, func(i, j int) bool { return want[i].Size > want[j].Size })
+	for _, top := range []int{1, 10, 500, 600} {
+		report, err := fs.UsageTop(context.Background(), filepath.Join(string(filepath.Separator), "t"), top)
+		if err != nil {
+			t.Fatalf("top %d: %v", top, err)
+		}
+		n := min(top, len(want))
+		if len(report.Largest) != n {
+			t.Fatalf("top %d: %d entries, want %d", top, len(report.Largest), n)
+		}
+		for i, got := range report.Largest {
+			if got.Path != want[i].Path {
+				t.Fatalf("top %d: entry %d = %s (%d bytes), want %s (%d bytes)", top, i, got.Path, got.Size, want[i].Path, want[i].Size)
+			}
+		}
+	}
+}
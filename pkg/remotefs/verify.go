@@ -0,0 +1,70 @@
+package remotefs
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// CacheMismatch describes a cached file that no longer matches its remote
+// object.
+type CacheMismatch struct {
+	Path       string `json:"Path"`
+	CachedSize int64  `json:"CachedSize"`
+	RemoteSize int64  `json:"RemoteSize"`
+	CachedETag string `json:"CachedETag,omitempty"`
+	RemoteETag string `json:"RemoteETag,omitempty"`
+}
+
+// CacheVerifyReport summarizes a VerifyCache run.
+type CacheVerifyReport struct {
+	Matched int             `json:"Matched"`
+	Stale   []CacheMismatch `json:"Stale"`
+	// Missing lists cached paths whose remote object no longer exists.
+	Missing []string `json:"Missing"`
+	// Pruned counts the stale and missing entries evicted from the cache.
+	Pruned int `json:"Pruned"`
+}
+
+// VerifyCache compares every cached file with a Head of its remote object,
+// dropping any Head result the store cached first so the origin answers. An
+// entry is stale when the sizes differ or when both sides carry an ETag and
+// the ETags differ. Content cached transformed is compared by the size and
+// ETag of the object it was derived from. With prune set, stale and missing
+// entries are evicted. Errors other than a missing object abort the run.
+func (fs *FileSystem) VerifyCache(ctx context.Context, prune bool) (CacheVerifyReport, error) {
+	report := CacheVerifyReport{Stale: []CacheMismatch{}, Missing: []string{}}
+	if fs.cache == nil {
+		return report, nil
+	}
+	for _, entry := range fs.cache.Entries() {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		objectstore.Invalidate(fs.store, entry.Key)
+		meta, err := fs.store.Head(ctx, entry.Key)
+		switch {
+		case objectstore.IsNotFound(err):
+			report.Missing = append(report.Missing, fs.joinLocal(entry.Key))
+		case err != nil:
+			return report, fmt.Errorf("verify %s: %w", fs.joinLocal(entry.Key), err)
+		case meta.Size != entry.RemoteSize || (entry.ETag != "" && meta.ETag != "" && meta.ETag != entry.ETag):
+			report.Stale = append(report.Stale, CacheMismatch{
+				Path:       fs.joinLocal(entry.Key),
+				CachedSize: entry.RemoteSize,
+				RemoteSize: meta.Size,
+				CachedETag: entry.ETag,
+				RemoteETag: meta.ETag,
+			})
+		default:
+			report.Matched++
+			continue
+		}
+		if prune {
+			fs.cache.Remove(entry.Key)
+			report.Pruned++
+		}
+	}
+	return report, nil
+}
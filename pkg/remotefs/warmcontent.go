@@ -0,0 +1,143 @@
+package remotefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// DefaultContentConcurrency is the number of downloads WarmContent runs at
+// once when Config.ContentConcurrency is zero.
+const DefaultContentConcurrency = 4
+
+// contentConcurrency returns the number of concurrent content downloads.
+func (fs *FileSystem) contentConcurrency() int {
+	if fs.cfg.ContentConcurrency > 0 {
+		return fs.cfg.ContentConcurrency
+	}
+	return DefaultContentConcurrency
+}
+
+// warmRetryDelay is how long WarmContent waits before retrying a download
+// refused with ErrDownloadQueueFull.
+const warmRetryDelay = 50 * time.Millisecond
+
+// WarmContent downloads the files below local into the content cache ahead of
+// demand. Files are taken in listing order, breadth first, until maxBytes is
+// spent; a file that does not fit in what remains is skipped and later,
+// smaller ones are still tried. Files already cached count towards the budget
+// and are only marked as recently used. Files not cached yet must also fit in
+// the cache's free bytes and entries, so a warm never evicts anything, warmed
+// or not. A non-positive maxBytes uses the whole cache. Downloads refused
+// because the download queue is full are retried once other downloads drain.
+// Files that vanish during the warm are skipped; any other download error
+// stops it.
+func (fs *FileSystem) WarmContent(ctx context.Context, local string, maxBytes int64) error {
+	if fs.cache == nil {
+		return fmt.Errorf("content cache is disabled")
+	}
+	if _, err := fs.sanitize(local); err != nil {
+		return err
+	}
+	res, err := fs.readDirRecursive(ctx, local, -1, 0)
+	if err != nil {
+		return err
+	}
+	stats := fs.cache.Stats()
+	if stats.MaxBytes > 0 && (maxBytes <= 0 || maxBytes > stats.MaxBytes) {
+		maxBytes = stats.MaxBytes
+	}
+	freeBytes := stats.MaxBytes - stats.Used
+	freeEntries := stats.MaxEntries - stats.Entries
+	var picked []objectstore.FileMeta
+	for _, item := range res.Entries {
+		if item.IsDir || (maxBytes > 0 && item.Size > maxBytes) {
+			continue
+		}
+		if _, cached := fs.cache.PathFor(item.Path); !cached {
+			if (stats.MaxBytes > 0 && item.Size > freeBytes) || (stats.MaxEntries > 0 && freeEntries <= 0) {
+				continue
+			}
+			freeBytes -= item.Size
+			freeEntries--
+		}
+		picked = append(picked, item)
+		if maxBytes > 0 {
+			maxBytes -= item.Size
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, fs.contentConcurrency())
+	for _, item := range picked {
+		if _, ok := fs.cache.PathFor(item.Path); ok {
+			fs.cache.Touch(item.Path)
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(rel string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fs.warmFileQueued(ctx, rel); err != nil && !objectstore.IsNotFound(err) {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("warm %s: %w", fs.joinLocal(rel), err)
+					cancel()
+				})
+			}
+		}(item.Path)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// warmFileQueued is warmFile that waits and tries again while the download
+// queue is full.
+func (fs *FileSystem) warmFileQueued(ctx context.Context, rel string) error {
+	for {
+		err := fs.warmFile(ctx, rel)
+		if !errors.Is(err, ErrDownloadQueueFull) {
+			return err
+		}
+		select {
+		case <-time.After(warmRetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// warmFile loads rel into the content cache unless it is already there.
+func (fs *FileSystem) warmFile(ctx context.Context, rel string) error {
+	var version objectstore.FileMeta
+	_, err := fs.cache.LoadOrCreate(rel, func(f *os.File) (int64, error) {
+		var size int64
+		var err error
+		size, version, err = fs.downloadTo(ctx, rel, f, f)
+		return size, err
+	})
+	if err == nil {
+		fs.recordVersion(rel, version)
+	}
+	return err
+}
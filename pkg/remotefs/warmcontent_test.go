@@ -0,0 +1,177 @@
+package remotefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/s3rofs/pkg/objectstore"
+)
+
+// lockedFilesStore serializes Head so concurrent downloads can share the
+// statTestStore counters.
+type lockedFilesStore struct {
+	*filesStore
+	mu sync.Mutex
+}
+
+func (s *lockedFilesStore) Head(ctx context.Context, key string) (objectstore.FileMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filesStore.Head(ctx, key)
+}
+
+func TestWarmContent(t *testing.T) {
+	files := newFilesStore(map[string]string{
+		"d/a.bin":     "aaaa",
+		"d/big.bin":   "bbbbbbbbbbbbbbbbbbbb",
+		"d/sub/c.bin": "cc",
+		"d/sub/e.bin": "eeee",
+		"other.bin":   "o",
+	})
+	files.listing = map[string][]objectstore.FileMeta{
+		"d":     {files.head["d/a.bin"], files.head["d/big.bin"], {Path: "d/sub", IsDir: true}},
+		"d/sub": {files.head["d/sub/c.bin"], files.head["d/sub/e.bin"]},
+	}
+	store := &lockedFilesStore{filesStore: files}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	local := filepath.Join(string(filepath.Separator), "d")
+
+	// The 20 byte file does not fit in 8 bytes and is skipped; c.bin still
+	// fits after a.bin, and e.bin would overrun the budget.
+	if err := fs.WarmContent(ctx, local, 8); err != nil {
+		t.Fatalf("warm: %v", err)
+	}
+	var cached []string
+	for _, entry := range fs.CacheEntries() {
+		cached = append(cached, entry.Key)
+	}
+	sort.Strings(cached)
+	if got := strings.Join(cached, ","); got != "d/a.bin,d/sub/c.bin" {
+		t.Fatalf("cached = %s", got)
+	}
+
+	noCache, err := New(store, Config{NoCache: true})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err := noCache.WarmContent(ctx, local, 0); err == nil {
+		t.Fatalf("expected an error without a content cache")
+	}
+}
+
+func TestWarmContentDoesNotEvict(t *testing.T) {
+	files := newFilesStore(map[string]string{
+		"d/a.bin":   "aaaa",
+		"d/c.bin":   "cc",
+		"other.bin": "oooo",
+	})
+	files.listing = map[string][]objectstore.FileMeta{
+		"d": {files.head["d/a.bin"], files.head["d/c.bin"]},
+	}
+	store := &lockedFilesStore{filesStore: files}
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 8})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	h, err := fs.ReadFile(ctx, filepath.Join(string(filepath.Separator), "other.bin"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	h.Close()
+
+	// Only 4 of the 8 bytes are free, so c.bin is left out rather than
+	// evicting other.bin.
+	if err := fs.WarmContent(ctx, filepath.Join(string(filepath.Separator), "d"), 0); err != nil {
+		t.Fatalf("warm: %v", err)
+	}
+	var cached []string
+	for _, entry := range fs.CacheEntries() {
+		cached = append(cached, entry.Key)
+	}
+	sort.Strings(cached)
+	if got := strings.Join(cached, ","); got != "d/a.bin,other.bin" {
+		t.Fatalf("cached = %s", got)
+	}
+}
+
+func TestWarmContentWaitsForFullQueue(t *testing.T) {
+	data := make(map[string]string)
+	var listing []objectstore.FileMeta
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("d/%d.bin", i)
+		data[key] = "x"
+		listing = append(listing, objectstore.FileMeta{Path: key, Size: 1})
+	}
+	files := newFilesStore(data)
+	files.listing = map[string][]objectstore.FileMeta{"d": listing}
+	store := &slowFilesStore{lockedFilesStore: &lockedFilesStore{filesStore: files}, latency: 5 * time.Millisecond}
+	fs, err := New(store, Config{
+		CacheDir:               t.TempDir(),
+		CacheSize:              1 << 20,
+		MaxConcurrentDownloads: 1,
+		MaxQueuedDownloads:     1,
+		ContentConcurrency:     4,
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err := fs.WarmContent(context.Background(), filepath.Join(string(filepath.Separator), "d"), 0); err != nil {
+		t.Fatalf("warm with a full queue: %v", err)
+	}
+	if n := len(fs.CacheEntries()); n != 8 {
+		t.Fatalf("cached %d files, want 8", n)
+	}
+}
+
+// slowFilesStore adds a fixed latency to every download, like a round trip
+// to S3.
+type slowFilesStore struct {
+	*lockedFilesStore
+	latency time.Duration
+}
+
+func (s *slowFilesStore) Download(ctx context.Context, key string, dst io.WriterAt) error {
+	time.Sleep(s.latency)
+	return s.lockedFilesStore.Download(ctx, key, dst)
+}
+
+func BenchmarkWarmContent(b *testing.B) {
+	data := make(map[string]string)
+	var listing []objectstore.FileMeta
+	for i := 0; i < 32; i++ {
+		key := fmt.Sprintf("d/%02d.bin", i)
+		data[key] = strings.Repeat("x", 1024)
+		listing = append(listing, objectstore.FileMeta{Path: key, Size: 1024})
+	}
+	files := newFilesStore(data)
+	files.listing = map[string][]objectstore.FileMeta{"d": listing}
+	store := &slowFilesStore{lockedFilesStore: &lockedFilesStore{filesStore: files}, latency: 2 * time.Millisecond}
+	local := filepath.Join(string(filepath.Separator), "d")
+
+	for _, n := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fs, err := New(store, Config{CacheDir: b.TempDir(), CacheSize: 1 << 20, ContentConcurrency: n})
+				if err != nil {
+					b.Fatalf("new: %v", err)
+				}
+				if err := fs.WarmContent(context.Background(), local, 0); err != nil {
+					b.Fatalf("warm: %v", err)
+				}
+				fs.Close()
+			}
+		})
+	}
+}
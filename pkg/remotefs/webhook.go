@@ -0,0 +1,170 @@
+package remotefs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultWebhookQueue is the number of events a WebhookSink buffers when
+	// WebhookOptions.QueueSize is zero.
+	defaultWebhookQueue = 256
+	// defaultWebhookTimeout bounds each POST made by the default client.
+	defaultWebhookTimeout = 5 * time.Second
+)
+
+// WebhookOptions tunes a WebhookSink.
+type WebhookOptions struct {
+	// Events limits delivery to these types. Empty sends every type.
+	Events []EventType
+	// QueueSize is the number of events buffered while the webhook is slow.
+	// Zero uses defaultWebhookQueue.
+	QueueSize int
+	// Block makes Send wait for room when the queue is full. By default the
+	// event is dropped and counted instead, so a slow webhook never stalls
+	// the caller.
+	Block bool
+	// Client makes the requests. Nil uses a client with a 5s timeout.
+	Client *http.Client
+	// OnError is called from the delivery goroutine for every event that
+	// could not be delivered.
+	OnError func(Event, error)
+}
+
+// WebhookStats counts the events a WebhookSink handled.
+type WebhookStats struct {
+	Sent    int64 `json:"Sent"`
+	Dropped int64 `json:"Dropped"`
+	Failed  int64 `json:"Failed"`
+}
+
+// WebhookSink POSTs each Event as JSON to a URL from a background goroutine.
+// Its Send method fits Config.OnEvent.
+type WebhookSink struct {
+	url    string
+	opts   WebhookOptions
+	filter map[EventType]bool
+	queue  chan Event
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	sent, dropped, failed atomic.Int64
+}
+
+// NewWebhookSink starts a sink delivering to rawURL, which must be an http
+// or https URL. Close stops it.
+func NewWebhookSink(rawURL string, opts WebhookOptions) (*WebhookSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	if opts.QueueSize < 0 {
+		return nil, fmt.Errorf("invalid webhook queue size %d", opts.QueueSize)
+	}
+	if opts.QueueSize == 0 {
+		opts.QueueSize = defaultWebhookQueue
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+	s := &WebhookSink{
+		url:   rawURL,
+		opts:  opts,
+		queue: make(chan Event, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+	if len(opts.Events) > 0 {
+		s.filter = make(map[EventType]bool, len(opts.Events))
+		for _, t := range opts.Events {
+			s.filter[t] = true
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+// Send queues ev for delivery unless the event filter excludes it. A full
+// queue drops ev, or waits for room with WebhookOptions.Block. Events sent
+// after Close are dropped.
+func (s *WebhookSink) Send(ev Event) {
+	if s.filter != nil && !s.filter[ev.Type] {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+	if s.opts.Block {
+		s.queue <- ev
+		return
+	}
+	select {
+	case s.queue <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Stats returns the delivery counters.
+func (s *WebhookSink) Stats() WebhookStats {
+	return WebhookStats{
+		Sent:    s.sent.Load(),
+		Dropped: s.dropped.Load(),
+		Failed:  s.failed.Load(),
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to be
+// delivered.
+func (s *WebhookSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+func (s *WebhookSink) run() {
+	defer close(s.done)
+	for ev := range s.queue {
+		if err := s.post(ev); err != nil {
+			s.failed.Add(1)
+			if s.opts.OnError != nil {
+				s.opts.OnError(ev, err)
+			}
+			continue
+		}
+		s.sent.Add(1)
+	}
+}
+
+func (s *WebhookSink) post(ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := s.opts.Client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package remotefs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWebhookSinkReportsCacheEvents(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("decode event: %v", err)
+		}
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	sink, err := NewWebhookSink(srv.URL, WebhookOptions{Events: []EventType{EventMiss, EventEvict}})
+	if err != nil {
+		t.Fatalf("new sink: %v", err)
+	}
+
+	store := newFilesStore(map[string]string{"a.txt": "aaaa", "b.txt": "bbbb"})
+	fs, err := New(store, Config{CacheDir: t.TempDir(), CacheSize: 4, OnEvent: sink.Send})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer fs.Close()
+	ctx := context.Background()
+	for _, p := range []string{"/a.txt", "/b.txt", "/missing.txt"} {
+		if h, err := fs.ReadFile(ctx, p); err == nil {
+			h.Close()
+		}
+	}
+	sink.Close()
+
+	// b.txt evicts a.txt when its finished download joins the cache. The
+	// missing file is neither a miss nor an error.
+	want := []Event{
+		{Type: EventMiss, Path: "a.txt", Size: 4},
+		{Type: EventMiss, Path: "b.txt", Size: 4},
+		{Type: EventEvict, Path: "a.txt", Size: 4},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %+v", events, want)
+	}
+	for i, ev := range events {
+		if ev.Type != want[i].Type || ev.Path != want[i].Path || ev.Size != want[i].Size || ev.Time.IsZero() {
+			t.Fatalf("event %d = %+v, want %+v", i, ev, want[i])
+		}
+	}
+	if stats := sink.Stats(); stats != (WebhookStats{Sent: 3}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestWebhookSinkDropsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	sink, err := NewWebhookSink(srv.URL, WebhookOptions{QueueSize: 1})
+	if err != nil {
+		t.Fatalf("new sink: %v", err)
+	}
+	// The first event may already be in flight, so at least one of the
+	// remaining three finds the queue full.
+	for i := 0; i < 4; i++ {
+		sink.Send(Event{Type: EventMiss, Path: "x"})
+	}
+	close(release)
+	sink.Close()
+	stats := sink.Stats()
+	if stats.Dropped == 0 || stats.Sent+stats.Dropped != 4 {
+		t.Fatalf("stats = %+v, want drops and every event accounted for", stats)
+	}
+
+	if _, err := NewWebhookSink("ftp://example.com/hook", WebhookOptions{}); err == nil {
+		t.Fatalf("expected an error for a non-http URL")
+	}
+}
@@ -70,6 +70,7 @@ struct remote_meta {
     int uid;
     int gid;
     int is_dir;
+    unsigned long long ino;
 };
 
 // dir_entry_pair keeps dirent32/dirent64 siblings so both readdir variants can
@@ -139,6 +140,7 @@ static bool canonicalize_path(const char *path, char *out, size_t out_len);
 static bool resolve_absolute(int dirfd, const char *path, char *out, size_t out_len);
 static bool path_within_root(const char *path, char *abs_out, size_t abs_len, char *rel_out, size_t rel_len);
 static uint64_t hash_path(const char *path);
+static uint64_t meta_inode(const char *abs_path, const struct remote_meta *meta);
 static char *url_encode(const char *input);
 static int perform_http_request(const char *endpoint, const char *abs_path, curl_write_callback write_cb, void *userdata, long *status);
 static int fetch_json(const char *endpoint, const char *abs_path, struct buffer *buf, long *status);
@@ -419,6 +421,16 @@ static uint64_t hash_path(const char *path) {
     return hash;
 }
 
+// meta_inode prefers the inode number reported by the daemon so stat and
+// readdir agree with other IPC clients, falling back to hash_path for daemons
+// that do not send one.
+static uint64_t meta_inode(const char *abs_path, const struct remote_meta *meta) {
+    if (meta != NULL && meta->ino != 0) {
+        return (uint64_t)meta->ino;
+    }
+    return hash_path(abs_path);
+}
+
 // url_encode converts arbitrary paths into safe query parameters for the Unix
 // domain socket HTTP transport.
 static char *url_encode(const char *input) {
@@ -582,6 +594,18 @@ static long long parse_long(const char *json, jsmntok_t *tok) {
     return strtoll(tmp, NULL, 10);
 }
 
+// parse_ulong is parse_long for unsigned 64-bit values such as inode numbers.
+static unsigned long long parse_ulong(const char *json, jsmntok_t *tok) {
+    char tmp[64];
+    size_t len = (size_t)(tok->end - tok->start);
+    if (len >= sizeof(tmp)) {
+        len = sizeof(tmp) - 1;
+    }
+    memcpy(tmp, json + tok->start, len);
+    tmp[len] = '\0';
+    return strtoull(tmp, NULL, 10);
+}
+
 // parse_object_at walks a single POSIXEntry object and copies interesting
 // fields into remote_meta.
 static int parse_object_at(const char *json, jsmntok_t *tokens, int idx, struct remote_meta *meta, int *next_idx) {
@@ -614,6 +638,8 @@ static int parse_object_at(const char *json, jsmntok_t *tokens, int idx, struct
             tmp.uid = (int)parse_long(json, val);
         } else if (token_equals(json, key, "GID")) {
             tmp.gid = (int)parse_long(json, val);
+        } else if (token_equals(json, key, "Inode")) {
+            tmp.ino = parse_ulong(json, val);
         } else if (token_equals(json, key, "IsDir")) {
             tmp.is_dir = (json[val->start] == 't' || json[val->start] == 'T');
         }
@@ -657,7 +683,7 @@ static int hydrate_stat(const char *abs_path, const struct remote_meta *meta, st
     st->st_blksize = 4096;
     st->st_blocks = (st->st_size + 511) / 512;
     st->st_dev = 0;
-    st->st_ino = (ino_t)(hash_path(abs_path));
+    st->st_ino = (ino_t)(meta_inode(abs_path, meta));
     struct timespec now;
     fill_time(&now);
 #if defined(__APPLE__)
@@ -684,7 +710,7 @@ static int hydrate_stat64(const char *abs_path, const struct remote_meta *meta,
     st->st_blksize = 4096;
     st->st_blocks = (st->st_size + 511) / 512;
     st->st_dev = 0;
-    st->st_ino = (ino64_t)(hash_path(abs_path));
+    st->st_ino = (ino64_t)(meta_inode(abs_path, meta));
     struct timespec now;
     fill_time(&now);
     st->st_atim = now;
@@ -726,7 +752,7 @@ static int hydrate_statx(const char *abs_path, const struct remote_meta *meta, s
     stx->stx_dev_minor = 0;
     stx->stx_rdev_major = 0;
     stx->stx_rdev_minor = 0;
-    stx->stx_ino = hash_path(abs_path);
+    stx->stx_ino = meta_inode(abs_path, meta);
     fill_statx_timestamp(&stx->stx_atime);
     fill_statx_timestamp(&stx->stx_mtime);
     fill_statx_timestamp(&stx->stx_ctime);
@@ -943,8 +969,8 @@ static struct dirent64 *alloc_dirent64(const char *name, unsigned char dtype, in
 #endif
 
 // add_entry materializes both dirent flavors so readdir/readdir64/r variants
-// can share the same cached listing.
-static int add_entry(struct shim_dir *dir, size_t index, const char *name, bool is_dir) {
+// can share the same cached listing. A zero ino falls back to hash_path.
+static int add_entry(struct shim_dir *dir, size_t index, const char *name, bool is_dir, uint64_t ino_hint) {
     unsigned char dtype = is_dir ? DT_DIR : DT_REG;
     char full_path[PATH_MAX];
     if (dir->abs_path && name && strcmp(name, ".") != 0 && strcmp(name, "..") != 0) {
@@ -957,7 +983,7 @@ static int add_entry(struct shim_dir *dir, size_t index, const char *name, bool
         strncpy(full_path, dir->abs_path ? dir->abs_path : "/", sizeof(full_path) - 1);
         full_path[sizeof(full_path) - 1] = '\0';
     }
-    ino_t ino = (ino_t)hash_path(full_path);
+    ino_t ino = (ino_t)(ino_hint != 0 ? ino_hint : hash_path(full_path));
     dir->entries[index].d32 = alloc_dirent(name, dtype, ino, (long)index);
     if (!dir->entries[index].d32) {
         return -1;
@@ -990,10 +1016,44 @@ static void free_dir(struct shim_dir *dir) {
     free(dir);
 }
 
+// path_inode returns the inode number stat reports for abs_path, so the "."
+// and ".." entries of a listing match stat on the same directories. Paths
+// outside REMOTEFS_ROOT, such as the parent of the root, are asked of the real
+// filesystem. Failures fall back to hash_path and leave errno untouched.
+static uint64_t path_inode(const char *abs_path) {
+    int saved_errno = errno;
+    uint64_t ino = 0;
+    if (path_within_root(abs_path, NULL, 0, NULL, 0)) {
+        struct remote_meta meta = {0};
+        if (fetch_remote_meta(abs_path, &meta) == 0) {
+            ino = meta_inode(abs_path, &meta);
+        }
+    } else if (real_stat_fn) {
+        struct stat st;
+        if (real_stat_fn(abs_path, &st) == 0) {
+            ino = (uint64_t)st.st_ino;
+        }
+    }
+    errno = saved_errno;
+    return ino != 0 ? ino : meta_inode(abs_path, NULL);
+}
+
+// parent_path writes the directory holding abs_path into out; the parent of
+// "/" is "/" itself.
+static void parent_path(const char *abs_path, char *out, size_t out_len) {
+    strncpy(out, abs_path, out_len - 1);
+    out[out_len - 1] = '\0';
+    char *slash = strrchr(out, '/');
+    if (slash == NULL || slash == out) {
+        strncpy(out, "/", out_len);
+        return;
+    }
+    *slash = '\0';
+}
+
 // populate_directory parses the /ls response into a shim_dir containing "."/".."
 // and every remote child.
 static int populate_directory(const char *abs_path, const char *rel_path, struct shim_dir *dir, const char *json) {
-    (void)abs_path;
     jsmntok_t *tokens = NULL;
     int tok_count = parse_json_document(json, &tokens);
     if (tok_count < 1) {
@@ -1014,11 +1074,13 @@ static int populate_directory(const char *abs_path, const char *rel_path, struct
     }
     dir->count = total;
     dir->index = 0;
-    if (add_entry(dir, 0, ".", true) != 0) {
+    char parent[PATH_MAX];
+    parent_path(abs_path, parent, sizeof(parent));
+    if (add_entry(dir, 0, ".", true, path_inode(abs_path)) != 0) {
         free(tokens);
         return -1;
     }
-    if (add_entry(dir, 1, "..", true) != 0) {
+    if (add_entry(dir, 1, "..", true, path_inode(parent)) != 0) {
         free(tokens);
         return -1;
     }
@@ -1034,7 +1096,7 @@ static int populate_directory(const char *abs_path, const char *rel_path, struct
             free(name);
             continue;
         }
-        if (add_entry(dir, slot, name, meta.is_dir) != 0) {
+        if (add_entry(dir, slot, name, meta.is_dir, (uint64_t)meta.ino) != 0) {
             free(name);
             free(tokens);
             return -1;